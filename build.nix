@@ -46,7 +46,7 @@ buildGoModule {
 
   src = ./.;
 
-  vendorHash = "sha256-6wRhQnD8v5ruAjqzPN7dmeYmubfv3taYvM1CL3rxZGg=";
+  vendorHash = "sha256-UK7QBY+vjFSShq0w0Oi4luqanqZvGBfp1cj0Ny9m6DQ=";
 
   prePatch = ''
     cp -r ${frontend} ./public/dist
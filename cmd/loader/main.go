@@ -23,6 +23,14 @@ func main() {
 		&db.InviteCodes{},
 		&db.SessionTokens{},
 		&db.UploadTokens{},
+		&db.UploadTokenUses{},
+		&db.AccessTokens{},
+		&db.RateLimits{},
+		&db.RecoveryCodes{},
+		&db.Passkeys{},
+		&db.LinkedIdentities{},
+		&db.AuditLogs{},
+		&db.InviteCodeRedemptions{},
 	)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to load gorm schema: %v\n", err)
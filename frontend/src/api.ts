@@ -45,13 +45,20 @@ export interface MutationResult {
   error?: string;
 }
 
+// Sent with every mutation, see csrf.go
+function csrfHeaders(): Record<string, string> {
+  const token = document.querySelector<HTMLMetaElement>('meta[name="csrf-token"]')?.content;
+
+  return token ? { 'X-CSRF-Token': token } : {};
+}
+
 async function mutate(url: string, method: string, fields: Record<string, string>): Promise<MutationResult> {
   const formData = new FormData();
   for (const [k, v] of Object.entries(fields)) formData.append(k, v);
 
   let response: Response;
   try {
-    response = await fetch(url, { method, body: formData });
+    response = await fetch(url, { method, body: formData, headers: csrfHeaders() });
   } catch (err) {
     return { ok: false, error: (err as Error)?.message || 'Network error' };
   }
@@ -0,0 +1,388 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+type accessScope string
+
+const (
+	scopeFilesRead  accessScope = "files:read"  // List files and stats
+	scopeFilesWrite accessScope = "files:write" // Upload, delete and change visibility of files
+	scopeTags       accessScope = "tags"        // Add and remove tags on files
+	scopeModerate   accessScope = "moderate"    // Moderation api, only for accounts that can moderate
+	scopeAdmin      accessScope = "admin"       // Admin api, only for admin accounts
+)
+
+var accessScopes = []accessScope{
+	scopeFilesRead,
+	scopeFilesWrite,
+	scopeTags,
+	scopeModerate,
+	scopeAdmin,
+}
+
+// Permission the account needs before it can create a token with the scope
+var scopePermissions = map[accessScope]permission{
+	scopeFilesWrite: permUpload,
+	scopeModerate:   permModerateFiles,
+	scopeAdmin:      permManageAccounts,
+}
+
+// Scopes the account is allowed to put on its tokens
+func grantableScopes(account db.Accounts) (scopes []accessScope) {
+	for _, scope := range accessScopes {
+		if p, ok := scopePermissions[scope]; !ok || can(account, p) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return
+}
+
+// Scope an access token needs for each route. Routes missing from here
+// (account deletion, token management...) only work with a browser session.
+var accessTokenRouteScopes = map[string]accessScope{
+	"POST /api/file/upload":      scopeFilesWrite,
+	"PUT /api/file/upload/:name": scopeFilesWrite,
+
+	"GET /api/account/files":        scopeFilesRead,
+	"GET /api/account/files/stats":  scopeFilesRead,
+	"DELETE /api/account/files":     scopeFilesWrite,
+	"DELETE /api/account/file":      scopeFilesWrite,
+	"POST /api/account/file/public": scopeFilesWrite,
+	"POST /api/account/file/tag":    scopeTags,
+	"DELETE /api/account/file/tag":  scopeTags,
+
+	"GET /api/moderation/files":   scopeModerate,
+	"DELETE /api/moderation/file": scopeModerate,
+
+	"DELETE /api/admin/user":           scopeAdmin,
+	"DELETE /api/admin/files":          scopeAdmin,
+	"DELETE /api/admin/sessions":       scopeAdmin,
+	"DELETE /api/admin/upload_tokens":  scopeAdmin,
+	"POST /api/admin/give_invite_code": scopeAdmin,
+	"POST /api/admin/quota":            scopeAdmin,
+	"DELETE /api/admin/totp":           scopeAdmin,
+	"GET /api/admin/audit_log":         scopeAdmin,
+	"POST /api/admin/suspension":       scopeAdmin,
+	"DELETE /api/admin/suspension":     scopeAdmin,
+	"POST /api/admin/account_type":     scopeAdmin,
+	"POST /api/admin/approval":         scopeAdmin,
+	"DELETE /api/admin/approval":       scopeAdmin,
+}
+
+// Prefix lets us tell access tokens apart from other bearer tokens at a glance
+const accessTokenPrefix = "hlpat_"
+
+const (
+	maxAccessTokensPerAccount = 100
+	maxAccessTokenAllowedIPs  = 20
+)
+
+var (
+	ErrInvalidAccessToken      = errors.New("invalid or expired access token")
+	ErrAccessTokenScope        = errors.New("access token is missing the required scope")
+	ErrAccessTokenIP           = errors.New("access token can't be used from this address")
+	ErrAccessTokenNotPermitted = errors.New("your account can't create tokens with that scope")
+)
+
+func bearerToken(c *gin.Context) (token string, ok bool) {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+
+	return token, token != ""
+}
+
+func getAccessToken(c *gin.Context) (db.AccessTokens, bool) {
+	v, ok := c.Get("accessToken")
+	if !ok {
+		return db.AccessTokens{}, false
+	}
+	token, ok := v.(db.AccessTokens)
+
+	return token, ok
+}
+
+func ipAllowed(allowed []string, clientIP string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, entry := range allowed {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			if prefix.Contains(addr) {
+				return true
+			}
+
+			continue
+		}
+		if allowedAddr, err := netip.ParseAddr(entry); err == nil && allowedAddr.Unmap() == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks the access token is valid, usable from this IP and has the scope
+// the current route needs.
+func (app *Application) validateAccessToken(
+	c *gin.Context,
+	rawToken string,
+) (token db.AccessTokens, account db.Accounts, err error) {
+	if !strings.HasPrefix(rawToken, accessTokenPrefix) {
+		err = ErrInvalidAccessToken
+
+		return
+	}
+
+	token, account, err = app.db.GetAccountByAccessToken(rawToken)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = ErrInvalidAccessToken
+
+		return
+	} else if err != nil {
+		return
+	}
+
+	if account.Suspended() {
+		err = ErrAccountSuspended
+
+		return
+	}
+
+	if !ipAllowed(token.AllowedIPList(), c.ClientIP()) {
+		err = ErrAccessTokenIP
+
+		return
+	}
+
+	scope, ok := accessTokenRouteScopes[c.Request.Method+" "+c.FullPath()]
+	if !ok || !token.HasScope(string(scope)) {
+		err = ErrAccessTokenScope
+
+		return
+	}
+
+	return
+}
+
+// Attaches the token and its owner to the request when the token is valid
+// and has the scope of the route. Unknown tokens get 401, tokens without the
+// scope, from a disallowed IP or of a suspended account get 403.
+func (app *Application) authenticateAccessToken(c *gin.Context, rawToken string) bool {
+	token, account, err := app.validateAccessToken(c, rawToken)
+	switch {
+	case errors.Is(err, ErrInvalidAccessToken):
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return false
+	case errors.Is(err, ErrAccessTokenScope), errors.Is(err, ErrAccessTokenIP), errors.Is(err, ErrAccountSuspended):
+		c.String(http.StatusForbidden, err.Error())
+		c.Abort()
+
+		return false
+	case err != nil:
+		log.Err(err).Msg("Failed to validate access token")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return false
+	}
+
+	c.Set("accessToken", token)
+	c.Set("account", account)
+
+	return true
+}
+
+type newAccessTokenInput struct {
+	Nickname   string   `form:"nickname"`
+	Scopes     []string `form:"scope"`
+	ExpiryDate string   `form:"expiry_date"` // YYYY-MM-DD, empty never expires
+	AllowedIPs string   `form:"allowed_ips"` // Comma separated IPs or CIDRs
+}
+
+func parseAllowedIPs(raw string) (ips []string, err error) {
+	for entry := range strings.SplitSeq(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if prefix, parseErr := netip.ParsePrefix(entry); parseErr == nil {
+			ips = append(ips, prefix.Masked().String())
+
+			continue
+		}
+		addr, parseErr := netip.ParseAddr(entry)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		ips = append(ips, addr.String())
+	}
+	slices.Sort(ips)
+	ips = slices.Compact(ips)
+
+	return
+}
+
+func (app *Application) newAccessTokenAPI(c *gin.Context) {
+	account, _ := getAccount(c)
+
+	var input newAccessTokenInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	if len(input.Nickname) > maxUploadTokenNickname {
+		c.String(http.StatusBadRequest, "Nickname too long")
+
+		return
+	}
+
+	var scopes []string
+	for _, raw := range input.Scopes {
+		scope := accessScope(strings.TrimSpace(raw))
+		if !slices.Contains(accessScopes, scope) {
+			c.String(http.StatusBadRequest, "Unknown scope: "+raw)
+
+			return
+		}
+		if p, ok := scopePermissions[scope]; ok && !can(account, p) {
+			c.String(http.StatusForbidden, ErrAccessTokenNotPermitted.Error())
+
+			return
+		}
+		scopes = append(scopes, string(scope))
+	}
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+	if len(scopes) == 0 {
+		c.String(http.StatusBadRequest, "Pick at least one scope")
+
+		return
+	}
+
+	var expiryDate *time.Time
+	if input.ExpiryDate != "" {
+		parsed, err := time.Parse("2006-01-02", input.ExpiryDate)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid expiry_date (want YYYY-MM-DD)")
+
+			return
+		}
+		parsed = parsed.Add(24*time.Hour - time.Second)
+		if parsed.Before(time.Now()) {
+			c.String(http.StatusBadRequest, "Can't specify expiry in the past, sorry.")
+
+			return
+		}
+		expiryDate = &parsed
+	}
+
+	allowedIPs, err := parseAllowedIPs(input.AllowedIPs)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid allowed_ips: "+err.Error())
+
+		return
+	}
+	if len(allowedIPs) > maxAccessTokenAllowedIPs {
+		c.String(http.StatusBadRequest, "Too many allowed IPs")
+
+		return
+	}
+
+	count, err := app.db.GetAccessTokensCount(account.ID)
+	if err != nil {
+		log.Err(err).Msg("Failed to count access tokens")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	if count >= maxAccessTokensPerAccount {
+		c.String(http.StatusBadRequest, "Access token limit reached")
+
+		return
+	}
+
+	rawToken := accessTokenPrefix + randomString()
+	if _, err = app.db.CreateAccessToken(db.CreateAccessTokenInput{
+		AccountID:  account.ID,
+		Token:      rawToken,
+		Nickname:   input.Nickname,
+		Scopes:     scopes,
+		ExpiryDate: expiryDate,
+		AllowedIPs: allowedIPs,
+	}); err != nil {
+		log.Err(err).Msg("Failed to create access token")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	app.audit(c, db.AuditLogs{
+		Action:   auditCreateAccessToken,
+		TargetID: account.ID,
+		Detail:   strings.TrimSpace(input.Nickname + " (" + strings.Join(scopes, " ") + ")"),
+	})
+
+	// Only the hash is stored, this is the one time the token is shown
+	c.String(http.StatusOK, rawToken)
+}
+
+type deleteAccessTokenInput struct {
+	ID uint `form:"id" binding:"required"`
+}
+
+func (app *Application) deleteAccessTokenAPI(c *gin.Context) {
+	account, _ := getAccount(c)
+
+	var input deleteAccessTokenInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	err := app.db.DeleteAccessToken(account.ID, input.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Access token not found")
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to delete access token")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	app.audit(c, db.AuditLogs{
+		Action:   auditDeleteAccessToken,
+		TargetID: account.ID,
+		Detail:   fmt.Sprintf("Access token %d", input.ID),
+	})
+
+	c.String(http.StatusOK, "Access token deleted successfully")
+}
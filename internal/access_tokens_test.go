@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func newAccessToken(t *testing.T, client *testClient, form map[string]string) string {
+	t.Helper()
+
+	w := client.do(http.MethodPost, "/api/account/access_token", form)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating token: got %d: %s", w.Code, w.Body)
+	}
+	token := w.Body.String()
+	if !strings.HasPrefix(token, accessTokenPrefix) {
+		t.Fatalf("token %q is missing the prefix", token)
+	}
+
+	return token
+}
+
+// Client sending only the access token, no session cookie or CSRF header
+func bearerClient(app *Application, token string) *testClient {
+	client := newTestClient(app)
+	client.header.Set("Authorization", "Bearer "+token)
+
+	return client
+}
+
+func TestAccessTokenScopes(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	newTestFile(t, app, account, "a.txt")
+
+	read := bearerClient(app, newAccessToken(t, newSessionClient(t, app, account), map[string]string{"scope": "files:read"}))
+	if w := read.do(http.MethodGet, "/api/account/files", nil); w.Code != http.StatusOK {
+		t.Fatalf("files:read listing files: got %d", w.Code)
+	}
+	if w := read.do(http.MethodDelete, "/api/account/file", map[string]string{"file_name": "a.txt"}); w.Code != http.StatusForbidden {
+		t.Fatalf("files:read deleting a file: got %d, want 403", w.Code)
+	}
+
+	// Routes without a scope only take sessions
+	for _, route := range []struct{ method, path string }{
+		{http.MethodDelete, "/api/account/"},
+		{http.MethodPost, "/api/account/access_token"},
+		{http.MethodPost, "/api/account/password"},
+		{http.MethodDelete, "/api/admin/user"},
+	} {
+		if w := read.do(route.method, route.path, map[string]string{"scope": "admin", "id": "1"}); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: got %d, want 403", route.method, route.path, w.Code)
+		}
+	}
+
+	// Tokens aren't cookies, so they don't need a CSRF token
+	write := bearerClient(app, newAccessToken(t, newSessionClient(t, app, account), map[string]string{"scope": "files:write"}))
+	if w := write.do(http.MethodDelete, "/api/account/file", map[string]string{"file_name": "a.txt"}); w.Code != http.StatusOK {
+		t.Fatalf("files:write deleting a file: got %d", w.Code)
+	}
+}
+
+func TestAccessTokenScopesNeedPermission(t *testing.T) {
+	app := newTestApp(t)
+	user := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeUser))
+
+	for _, scope := range []string{"admin", "moderate"} {
+		if w := user.do(http.MethodPost, "/api/account/access_token", map[string]string{"scope": scope}); w.Code != http.StatusForbidden {
+			t.Errorf("user creating a %s token: got %d, want 403", scope, w.Code)
+		}
+	}
+	if w := user.do(http.MethodPost, "/api/account/access_token", map[string]string{"scope": "everything"}); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown scope: got %d, want 400", w.Code)
+	}
+	if w := user.do(http.MethodPost, "/api/account/access_token", map[string]string{"nickname": "none"}); w.Code != http.StatusBadRequest {
+		t.Errorf("no scope: got %d, want 400", w.Code)
+	}
+
+	// An admin token stops working once the account isn't an admin anymore
+	admin := newTestAccount(t, app, db.AccountTypeAdmin)
+	newTestAccount(t, app, db.AccountTypeAdmin)
+	token := bearerClient(app, newAccessToken(t, newSessionClient(t, app, admin), map[string]string{"scope": "admin"}))
+	if w := token.do(http.MethodGet, "/api/admin/audit_log", nil); w.Code != http.StatusOK {
+		t.Fatalf("admin token: got %d", w.Code)
+	}
+	if err := app.db.SetAccountType(admin.ID, db.AccountTypeUser); err != nil {
+		t.Fatal(err)
+	}
+	if w := token.do(http.MethodGet, "/api/admin/audit_log", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("admin token of a demoted account: got %d, want 403", w.Code)
+	}
+}
+
+func TestAccessTokenAllowedIPs(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	token := newAccessToken(t, newSessionClient(t, app, account), map[string]string{
+		"scope":       "files:read",
+		"allowed_ips": "198.51.100.0/24, 203.0.113.9",
+	})
+
+	for addr, want := range map[string]int{
+		"198.51.100.7:1234": http.StatusOK,
+		"203.0.113.9:1234":  http.StatusOK,
+		"203.0.113.10:1234": http.StatusForbidden,
+		"192.0.2.1:1234":    http.StatusForbidden,
+	} {
+		client := bearerClient(app, token)
+		client.remoteAddr = addr
+		if w := client.do(http.MethodGet, "/api/account/files", nil); w.Code != want {
+			t.Errorf("%s: got %d, want %d", addr, w.Code, want)
+		}
+	}
+
+	if w := newSessionClient(t, app, account).do(http.MethodPost, "/api/account/access_token", map[string]string{
+		"scope":       "files:read",
+		"allowed_ips": "not an ip",
+	}); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid allowed_ips: got %d, want 400", w.Code)
+	}
+}
+
+func TestAccessTokenRevoked(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+
+	if w := bearerClient(app, accessTokenPrefix+"nonexistent").do(http.MethodGet, "/api/account/files", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown token: got %d, want 401", w.Code)
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	if _, err := app.db.CreateAccessToken(db.CreateAccessTokenInput{
+		AccountID:  account.ID,
+		Token:      accessTokenPrefix + "expired",
+		Scopes:     []string{"files:read"},
+		ExpiryDate: &expired,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if w := bearerClient(app, accessTokenPrefix+"expired").do(http.MethodGet, "/api/account/files", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expired token: got %d, want 401", w.Code)
+	}
+
+	session := newSessionClient(t, app, account)
+	token := bearerClient(app, newAccessToken(t, session, map[string]string{"scope": "files:read"}))
+	tokens, err := app.db.GetAccessTokens(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = app.db.SuspendAccount(account.ID, db.SuspendAccountInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if w := token.do(http.MethodGet, "/api/account/files", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("token of a suspended account: got %d, want 403", w.Code)
+	}
+	if err = app.db.LiftSuspension(account.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	session = newSessionClient(t, app, account)
+	for _, accessToken := range tokens {
+		if w := session.do(http.MethodDelete, "/api/account/access_token", map[string]string{"id": strconv.Itoa(int(accessToken.ID))}); w.Code != http.StatusOK {
+			t.Fatalf("deleting token: got %d", w.Code)
+		}
+	}
+	if w := token.do(http.MethodGet, "/api/account/files", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("deleted token: got %d, want 401", w.Code)
+	}
+}
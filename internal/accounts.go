@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+// Name shown in authenticator apps and passkey prompts
+func (app *Application) accountLabel(account db.Accounts) string {
+	identities, err := app.db.GetLinkedIdentities(account.ID)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load linked identities for account label")
+	}
+
+	return accountLabel(account, identities)
+}
+
+// The local username, or the first username from a login provider
+func accountLabel(account db.Accounts, identities []db.LinkedIdentities) string {
+	if account.Username != "" {
+		return account.Username
+	}
+	for _, identity := range identities {
+		if identity.Username != "" {
+			return identity.Username
+		}
+	}
+
+	return fmt.Sprintf("account-%d", account.ID)
+}
+
+// "label (id)" of the given accounts for pages naming several of them,
+// deleted accounts are left out
+func (app *Application) accountLabels(accountIDs []uint) (labels map[uint]string, err error) {
+	slices.Sort(accountIDs)
+	accountIDs = slices.Compact(accountIDs)
+
+	accounts, err := app.db.GetAccountsByIDs(accountIDs)
+	if err != nil {
+		return
+	}
+	identities, err := app.db.LinkedIdentitiesOf(accountIDs)
+	if err != nil {
+		return
+	}
+
+	labels = make(map[uint]string, len(accounts))
+	for _, a := range accounts {
+		labels[a.ID] = fmt.Sprintf("%s (%d)", accountLabel(a, identities[a.ID]), a.ID)
+	}
+
+	return
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/BatteredBunny/hostling/internal/db"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/rs/zerolog/log"
@@ -33,12 +35,17 @@ func (app *Application) adminDeleteAccount(c *gin.Context) {
 		return
 	}
 
-	if err := app.deleteAccount(c.Request.Context(), input.ID); err != nil {
+	if err := app.deleteAccount(c.Request.Context(), input.ID); errors.Is(err, db.ErrLastAdmin) {
+		c.String(http.StatusConflict, err.Error())
+
+		return
+	} else if err != nil {
 		log.Err(err).Msg("Failed to delete account")
 		c.AbortWithStatus(http.StatusInternalServerError)
 
 		return
 	}
+	app.audit(c, db.AuditLogs{Action: auditAdminDeleteAccount, TargetID: input.ID})
 
 	c.String(http.StatusOK, fmt.Sprintf("Account %d deleted", input.ID))
 }
@@ -65,6 +72,7 @@ func (app *Application) adminDeleteFiles(c *gin.Context) {
 
 		return
 	}
+	app.audit(c, db.AuditLogs{Action: auditAdminDeleteFiles, TargetID: input.ID})
 
 	c.String(http.StatusOK, "Files deleted")
 }
@@ -91,6 +99,7 @@ func (app *Application) adminDeleteSessions(c *gin.Context) {
 
 		return
 	}
+	app.audit(c, db.AuditLogs{Action: auditAdminDeleteSession, TargetID: input.ID})
 
 	c.String(http.StatusOK, "Sessions deleted")
 }
@@ -117,16 +126,39 @@ func (app *Application) adminDeleteUploadTokens(c *gin.Context) {
 
 		return
 	}
+	app.audit(c, db.AuditLogs{Action: auditAdminDeleteTokens, TargetID: input.ID})
 
 	c.String(http.StatusOK, "Upload tokens deleted")
 }
 
+const (
+	maxInviteCodeUses = 1000
+	maxInviteCodeNote = 200
+)
+
 type adminGiveInviteCodeInput struct {
-	ID   uint `form:"id"             binding:"required"`
-	Uses uint `form:"uses,default=5"` // How many uses the invite code has
+	ID          uint   `form:"id"                        binding:"required"`
+	Uses        uint   `form:"uses,default=5"`            // How many uses the invite code has
+	AccountType string `form:"account_type,default=USER"` // Account type people registering with it get
+	ExpiryDate  string `form:"expiry_date"`               // YYYY-MM-DD, a week from now when empty
+	Note        string `form:"note"`                      // Label shown next to the code
+	QuotaPreset string `form:"quota_preset"`              // Name from quota_presets, empty keeps the instance default
+}
+
+// What the admin asked for, as it goes into the audit log
+func (input adminGiveInviteCodeInput) summary(expiryDate time.Time) string {
+	summary := fmt.Sprintf("%s invite code with %d uses, expires %s",
+		input.AccountType, input.Uses, formatTimeDate(expiryDate))
+	if input.QuotaPreset != "" {
+		summary += ", quota preset " + input.QuotaPreset
+	}
+	if input.Note != "" {
+		summary += ": " + input.Note
+	}
+
+	return summary
 }
 
-// TODO: allow giving admin account invites
 func (app *Application) adminGiveInviteCode(c *gin.Context) {
 	var (
 		input adminGiveInviteCodeInput
@@ -139,6 +171,64 @@ func (app *Application) adminGiveInviteCode(c *gin.Context) {
 		return
 	}
 
+	if !db.ValidAccountType(input.AccountType) {
+		c.String(http.StatusBadRequest, db.ErrInvalidAccountType.Error())
+
+		return
+	}
+
+	if input.Uses == 0 || input.Uses > maxInviteCodeUses {
+		c.String(http.StatusBadRequest, fmt.Sprintf("uses has to be between 1 and %d", maxInviteCodeUses))
+
+		return
+	}
+
+	if len(input.Note) > maxInviteCodeNote {
+		c.String(http.StatusBadRequest, "Note too long")
+
+		return
+	}
+
+	inviteInput := db.CreateInviteCodeInput{
+		Uses:            input.Uses,
+		AccountType:     input.AccountType,
+		InviteCreatorID: input.ID,
+		ExpiryDate:      time.Now().Add(db.DefaultInviteCodeExpiry),
+		Note:            input.Note,
+	}
+
+	if input.ExpiryDate != "" {
+		expiryDate, parseErr := time.Parse(time.DateOnly, input.ExpiryDate)
+		if parseErr != nil {
+			c.String(http.StatusBadRequest, "Invalid expiry_date (want YYYY-MM-DD)")
+
+			return
+		}
+		expiryDate = expiryDate.Add(24*time.Hour - time.Second)
+		if expiryDate.Before(time.Now()) {
+			c.String(http.StatusBadRequest, "Can't specify expiry in the past, sorry.")
+
+			return
+		}
+		if time.Until(expiryDate) > maxExpiryDuration {
+			c.String(http.StatusBadRequest, "expiry_date too far in the future")
+
+			return
+		}
+		inviteInput.ExpiryDate = expiryDate
+	}
+
+	if input.QuotaPreset != "" {
+		preset, ok := app.config.QuotaPresets[input.QuotaPreset]
+		if !ok {
+			c.String(http.StatusBadRequest, "Unknown quota preset: "+input.QuotaPreset)
+
+			return
+		}
+		inviteInput.QuotaPreset = input.QuotaPreset
+		inviteInput.Quota = preset.input()
+	}
+
 	if _, err = app.db.GetAccountByID(input.ID); errors.Is(err, gorm.ErrRecordNotFound) {
 		c.String(http.StatusNotFound, "Account not found")
 
@@ -150,13 +240,75 @@ func (app *Application) adminGiveInviteCode(c *gin.Context) {
 		return
 	}
 
-	inviteCode, err := app.db.CreateInviteCode(input.Uses, "USER", input.ID)
+	inviteCode, err := app.db.CreateInviteCode(inviteInput)
 	if err != nil {
 		log.Err(err).Msg("Failed to create invite code")
 		c.AbortWithStatus(http.StatusInternalServerError)
 
 		return
 	}
+	app.audit(c, db.AuditLogs{
+		Action:   auditAdminGiveInvite,
+		TargetID: input.ID,
+		Detail:   input.summary(inviteCode.ExpiryDate),
+	})
 
 	c.String(http.StatusOK, inviteCode.Code)
 }
+
+type adminSetAccountTypeInput struct {
+	ID          uint   `form:"id"           binding:"required"`
+	AccountType string `form:"account_type" binding:"required"` // One of db.AccountTypes
+}
+
+// Changes the role of an account, the last admin can't be demoted
+func (app *Application) adminSetAccountType(c *gin.Context) {
+	var input adminSetAccountTypeInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	target, err := app.db.GetAccountByID(input.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Account not found")
+
+		return
+	} else if err != nil {
+		log.Err(err).Uint("account_id", input.ID).Msg("Failed to look up account")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	err = app.db.SetAccountType(input.ID, input.AccountType)
+	if errors.Is(err, db.ErrInvalidAccountType) {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	} else if errors.Is(err, db.ErrLastAdmin) {
+		c.String(http.StatusConflict, err.Error())
+
+		return
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Account not found")
+
+		return
+	} else if err != nil {
+		log.Err(err).Uint("account_id", input.ID).Msg("Failed to set account type")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	if target.AccountType != input.AccountType {
+		app.audit(c, db.AuditLogs{
+			Action:   auditAdminSetAccountType,
+			TargetID: input.ID,
+			Detail:   target.AccountType + " to " + input.AccountType,
+		})
+	}
+
+	c.String(http.StatusOK, fmt.Sprintf("Account %d is now %s", input.ID, input.AccountType))
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func setAccountType(client *testClient, id uint, accountType string) int {
+	return client.do(http.MethodPost, "/api/admin/account_type", map[string]string{
+		"id":           fmt.Sprint(id),
+		"account_type": accountType,
+	}).Code
+}
+
+func TestAdminSetAccountType(t *testing.T) {
+	app := newTestApp(t)
+	self := newTestAccount(t, app, db.AccountTypeAdmin)
+	admin := newSessionClient(t, app, self)
+	user := newTestAccount(t, app, db.AccountTypeUser)
+
+	if code := setAccountType(admin, user.ID, db.AccountTypeModerator); code != http.StatusOK {
+		t.Fatalf("promoting: got %d, want 200", code)
+	}
+	if account, _ := app.db.GetAccountByID(user.ID); account.AccountType != db.AccountTypeModerator {
+		t.Fatalf("account is %s, want MODERATOR", account.AccountType)
+	}
+	if code := setAccountType(admin, user.ID, "OWNER"); code != http.StatusBadRequest {
+		t.Fatalf("unknown type: got %d, want 400", code)
+	}
+	if code := setAccountType(admin, 9999, db.AccountTypeUser); code != http.StatusNotFound {
+		t.Fatalf("missing account: got %d, want 404", code)
+	}
+	if code := setAccountType(admin, self.ID, db.AccountTypeUser); code != http.StatusConflict {
+		t.Fatalf("demoting the last admin: got %d, want 409", code)
+	}
+
+	moderator := newSessionClient(t, app, user)
+	if code := setAccountType(moderator, user.ID, db.AccountTypeAdmin); code != http.StatusForbidden {
+		t.Fatalf("moderator promoting themselves: got %d, want 403", code)
+	}
+}
+
+func TestLastAdminCantDeleteThemselves(t *testing.T) {
+	app := newTestApp(t)
+	admin := newTestAccount(t, app, db.AccountTypeAdmin)
+	client := newSessionClient(t, app, admin)
+
+	if code := client.do(http.MethodDelete, "/api/account/", nil).Code; code != http.StatusConflict {
+		t.Fatalf("got %d, want 409", code)
+	}
+
+	newTestAccount(t, app, db.AccountTypeAdmin)
+	if code := client.do(http.MethodDelete, "/api/account/", nil).Code; code != http.StatusOK {
+		t.Fatalf("with another admin: got %d, want 200", code)
+	}
+}
@@ -2,11 +2,11 @@ package internal
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"errors"
 	"io"
 	"net/http"
-	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
@@ -37,12 +37,30 @@ func (app *Application) accountDeleteAPI(c *gin.Context) {
 		return
 	}
 
-	if err := app.deleteAccount(c.Request.Context(), account.ID); err != nil {
+	last, err := app.db.IsLastAdmin(account.ID)
+	if err != nil {
+		log.Err(err).Msg("Failed to check if account is the last admin")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	if last {
+		c.String(http.StatusConflict, db.ErrLastAdmin.Error())
+
+		return
+	}
+
+	if err = app.deleteAccount(c.Request.Context(), account.ID); errors.Is(err, db.ErrLastAdmin) {
+		c.String(http.StatusConflict, err.Error())
+
+		return
+	} else if err != nil {
 		log.Err(err).Msg("Failed to delete own account")
 		c.AbortWithStatus(http.StatusInternalServerError)
 
 		return
 	}
+	app.audit(c, db.AuditLogs{Action: auditDeleteAccount, TargetID: account.ID})
 
 	c.String(http.StatusOK, "Account deleted successfully")
 }
@@ -109,6 +127,7 @@ func (app *Application) deleteFileAPI(c *gin.Context) {
 
 		return
 	}
+	app.audit(c, db.AuditLogs{Action: auditDeleteFile, TargetID: account.ID, Detail: input.FileName})
 
 	c.String(http.StatusOK, "Successfully deleted the file")
 }
@@ -160,6 +179,7 @@ func (app *Application) toggleFilePublicAPI(c *gin.Context) {
 /*
 Api for uploading file
 curl -F 'upload_token=1234567890' -F 'file=@yourfile.png'
+curl -H 'X-Upload-Token: 1234567890' -F 'file=@yourfile.png'
 
 Additional inputs:
 expiry_timestamp: unix timestamp in seconds
@@ -168,53 +188,149 @@ plain: if set to true, api will return plain url instead of redirecting
 tag: tags to add to the file
 */
 func (app *Application) uploadFileAPI(c *gin.Context) {
-	var expiryDate time.Time
-
 	plainRedirect := c.PostForm("plain") == "true"
 
-	rawTags, tags_exists := c.GetPostFormArray("tag")
+	tags, err := parseUploadTags(c.PostFormArray("tag"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		c.Abort()
+
+		return
+	}
+
+	expiryDate, err := parseUploadExpiry(c.PostForm("expiry_date"), c.PostForm("expiry_timestamp"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	fileRaw, fileHeader, err := c.Request.FormFile("file")
+	if err != nil {
+		c.String(http.StatusBadRequest, "No file provided")
+		c.Abort()
+
+		return
+	}
+	defer fileRaw.Close()
+
+	fullFileName, ok := app.processUpload(c, uploadRequest{
+		body:         fileRaw,
+		size:         fileHeader.Size,
+		originalName: fileHeader.Filename,
+		tags:         tags,
+		expiryDate:   expiryDate,
+	})
+	if !ok {
+		return
+	}
+
+	if plainRedirect {
+		c.String(http.StatusOK, "/"+fullFileName)
+	} else {
+		c.Redirect(http.StatusTemporaryRedirect, "/"+fullFileName)
+	}
+}
+
+/*
+Api for uploading the request body as a file, for clients that don't speak multipart
+curl -H 'X-Upload-Token: 1234567890' -T yourfile.png https://example.com/api/file/upload/yourfile.png
+
+Additional inputs, as query parameters or headers:
+expiry_timestamp / X-Expiry-Timestamp: unix timestamp in seconds
+expiry_date / X-Expiry-Date: YYYY-MM-DD in string, expiry_timestamp gets priority
+tag / X-Tags: tags to add to the file, the header is comma separated
+
+Responds with the plain url of the file.
+*/
+func (app *Application) uploadRawFileAPI(c *gin.Context) {
+	rawTags := c.QueryArray("tag")
+	if header := c.GetHeader("X-Tags"); header != "" {
+		rawTags = append(rawTags, strings.Split(header, ",")...)
+	}
+	tags, err := parseUploadTags(rawTags)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	expiryDate, err := parseUploadExpiry(
+		cmp.Or(c.Query("expiry_date"), c.GetHeader("X-Expiry-Date")),
+		cmp.Or(c.Query("expiry_timestamp"), c.GetHeader("X-Expiry-Timestamp")),
+	)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	originalName := strings.TrimSpace(c.Param("name"))
+	if originalName == "" {
+		c.String(http.StatusBadRequest, "No file name provided")
+
+		return
+	}
+
+	// Streamed straight to storage, an unknown length (chunked body) is passed on as -1
+	fullFileName, ok := app.processUpload(c, uploadRequest{
+		body:         c.Request.Body,
+		size:         c.Request.ContentLength,
+		originalName: originalName,
+		tags:         tags,
+		expiryDate:   expiryDate,
+	})
+	if !ok {
+		return
+	}
+
+	c.String(http.StatusCreated, "/"+fullFileName)
+}
+
+var (
+	ErrExpiryDateInvalid      = errors.New("invalid expiry_date (want YYYY-MM-DD)")
+	ErrExpiryTimestampInvalid = errors.New("invalid expiry_timestamp (want unix seconds)")
+	ErrExpiryInPast           = errors.New("can't specify expiry in the past, sorry")
+	ErrExpiryTooFar           = errors.New("expiry too far in the future")
+)
 
-	tags := make([]string, 0, len(rawTags))
+// Lowercases, trims and deduplicates tags
+func parseUploadTags(rawTags []string) (tags []string, err error) {
+	tags = make([]string, 0, len(rawTags))
 	for _, t := range rawTags {
 		t = strings.ToLower(strings.TrimSpace(t))
 		if t == "" {
 			continue
 		}
 		if len(t) > db.TagMaxLength {
-			c.String(http.StatusBadRequest, db.ErrTagTooLong.Error())
-			c.Abort()
-
-			return
+			return nil, db.ErrTagTooLong
 		}
 		tags = append(tags, t)
 	}
 	slices.Sort(tags)
 	tags = slices.Compact(tags)
-	tags_exists = tags_exists && len(tags) > 0
 
 	if len(tags) > db.MaxTagsPerFile {
-		c.String(http.StatusBadRequest, db.ErrTooManyTags.Error())
-		c.Abort()
-
-		return
+		return nil, db.ErrTooManyTags
 	}
 
-	if date, exists := c.GetPostForm("expiry_date"); exists && date != "" {
+	return
+}
+
+// Both are optional, the timestamp wins when both are given
+func parseUploadExpiry(date string, timestamp string) (expiryDate time.Time, err error) {
+	if date != "" {
 		parsed, parseErr := time.Parse("2006-01-02", date)
 		if parseErr != nil {
-			c.String(http.StatusBadRequest, "Invalid expiry_date (want YYYY-MM-DD)")
-
-			return
+			return time.Time{}, ErrExpiryDateInvalid
 		}
 		expiryDate = parsed.Add(24*time.Hour - time.Second)
 	}
 
-	if timestamp, exists := c.GetPostForm("expiry_timestamp"); exists && timestamp != "" {
+	if timestamp != "" {
 		unixSecs, parseErr := strconv.ParseInt(timestamp, 10, 64)
 		if parseErr != nil {
-			c.String(http.StatusBadRequest, "Invalid expiry_timestamp (want unix seconds)")
-
-			return
+			return time.Time{}, ErrExpiryTimestampInvalid
 		}
 		expiryDate = time.Unix(unixSecs, 0)
 	}
@@ -222,32 +338,32 @@ func (app *Application) uploadFileAPI(c *gin.Context) {
 	if !expiryDate.IsZero() {
 		now := time.Now()
 		if expiryDate.Before(now) {
-			c.String(http.StatusBadRequest, "Can't specify expiry in the past, sorry.")
-
-			return
+			return time.Time{}, ErrExpiryInPast
 		}
 		if expiryDate.After(now.Add(maxExpiryDuration)) {
-			c.String(http.StatusBadRequest, "Expiry too far in the future.")
-
-			return
+			return time.Time{}, ErrExpiryTooFar
 		}
 	}
 
-	fileRaw, fileHeader, err := c.Request.FormFile("file")
-	if err != nil {
-		c.String(http.StatusBadRequest, "No file provided")
-		c.Abort()
-
-		return
-	}
-	defer fileRaw.Close()
+	return
+}
 
-	originalFileName := fileHeader.Filename
+type uploadRequest struct {
+	body         io.Reader
+	size         int64 // -1 when unknown
+	originalName string
+	tags         []string
+	expiryDate   time.Time
+}
 
+// Shared by the upload apis, sniffs the MIME type, applies upload token
+// restrictions, stores the blob and creates the file entry. Writes the error
+// response itself, reports whether the upload went through.
+func (app *Application) processUpload(c *gin.Context, upload uploadRequest) (fullFileName string, stored bool) {
 	// Peek at the first few KB for MIME detection without consuming the
 	// stream, then hand the buffered reader straight to storage.
-	body := bufio.NewReaderSize(fileRaw, mimeSniffSize)
-	header, err := body.Peek(mimeSniffSize)
+	buffered := bufio.NewReaderSize(upload.body, mimeSniffSize)
+	header, err := buffered.Peek(mimeSniffSize)
 	if err != nil && !errors.Is(err, io.EOF) {
 		if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
 			c.String(http.StatusRequestEntityTooLarge, "Too big file")
@@ -260,21 +376,95 @@ func (app *Application) uploadFileAPI(c *gin.Context) {
 
 		return
 	}
+	var body io.Reader = buffered
 
 	mime := mimetype.Detect(header)
-	fullFileName := app.generateFullFileName(mime)
 
-	var written int64
-	switch app.config.FileStorageMethod {
-	case fileStorageS3:
-		written, err = app.uploadFileS3(c.Request.Context(), body, fileHeader.Size, fullFileName)
-	case fileStorageLocal:
-		written, err = writeLocalFile(filepath.Join(app.config.DataFolder, fullFileName), body)
-	default:
-		err = ErrUnknownStorageMethod
+	tags := upload.tags
+	expiryDate := upload.expiryDate
+	public := true
+	var maxFileSize int64 // Only enforced while streaming when the size isn't known up front
+	strategy := nameStrategy(app.config.FileNames.Strategy)
+	if uid, ok := getUploadToken(c); ok {
+		token, tokenErr := app.db.GetUploadToken(uid)
+		if errors.Is(tokenErr, gorm.ErrRecordNotFound) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+
+			return
+		} else if tokenErr != nil {
+			log.Err(tokenErr).Msg("Failed to look up upload token")
+			c.AbortWithStatus(http.StatusInternalServerError)
+
+			return
+		}
+
+		if restrictErr := app.checkUploadTokenRestrictions(token, upload.size, mime); restrictErr != nil {
+			if status, ok := uploadTokenRestrictionStatus(restrictErr); ok {
+				c.String(status, restrictErr.Error())
+				c.Abort()
+
+				return
+			}
+			log.Err(restrictErr).Msg("Failed to check upload token restrictions")
+			c.AbortWithStatus(http.StatusInternalServerError)
+
+			return
+		}
+
+		maxFileSize = token.MaxFileSize
+
+		tags, expiryDate, public = applyUploadTokenDefaults(token, tags, expiryDate, public)
+		if len(tags) > db.MaxTagsPerFile {
+			c.String(http.StatusBadRequest, db.ErrTooManyTags.Error())
+			c.Abort()
+
+			return
+		}
+
+		if token.NameStrategy != "" {
+			strategy = nameStrategy(token.NameStrategy)
+		}
 	}
 
-	if err != nil {
+	quota, err := app.checkAccountQuota(c, upload.size)
+	if status, ok := quotaErrorStatus(err); ok {
+		c.String(status, err.Error())
+		c.Abort()
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to check account quota")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	if quota.MaxFileSize > 0 && (maxFileSize == 0 || quota.MaxFileSize < maxFileSize) {
+		maxFileSize = quota.MaxFileSize
+	}
+
+	// Unknown length, cut the stream off once it goes over the limit instead
+	if maxFileSize > 0 && upload.size < 0 {
+		body = http.MaxBytesReader(c.Writer, io.NopCloser(body), maxFileSize)
+	}
+
+	fullFileName, written, err := app.storeUpload(
+		c.Request.Context(),
+		body,
+		upload.size,
+		strategy,
+		mime,
+		upload.originalName,
+	)
+	if errors.Is(err, ErrFileNameTaken) {
+		c.String(http.StatusConflict, "Couldn't find a free file name, please try again")
+
+		return
+	} else if _, tooBig := errors.AsType[*http.MaxBytesError](err); tooBig {
+		c.String(http.StatusRequestEntityTooLarge, "Too big file")
+		c.Abort()
+
+		return
+	} else if err != nil {
 		log.Err(err).Msg("Upload issue")
 		c.AbortWithStatus(http.StatusInternalServerError)
 
@@ -284,24 +474,25 @@ func (app *Application) uploadFileAPI(c *gin.Context) {
 	input := db.CreateFileEntryInput{
 		Files: db.Files{
 			FileName:         fullFileName,
-			OriginalFileName: originalFileName,
+			OriginalFileName: upload.originalName,
 			FileSize:         uint(written),
 			MimeType:         mime.String(),
 			ExpiryDate:       expiryDate,
-			Public:           true,
+			Public:           public,
 		},
+		QuotaDefaults: app.config.Quotas.defaults(),
 	}
 
-	if tags_exists {
-		for _, tag := range tags {
-			input.Files.Tags = append(input.Files.Tags, db.Tag{Name: tag})
-		}
+	for _, tag := range tags {
+		input.Files.Tags = append(input.Files.Tags, db.Tag{Name: tag})
 	}
 
 	if sid, ok := getSessionToken(c); ok {
 		input.SessionToken = uuid.NullUUID{UUID: sid, Valid: true}
 	} else if uid, ok := getUploadToken(c); ok {
 		input.UploadToken = uuid.NullUUID{UUID: uid, Valid: true}
+	} else if token, ok := getAccessToken(c); ok {
+		input.AccessTokenID = token.ID
 	} else {
 		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), 30*time.Second)
 		defer cancel()
@@ -322,6 +513,11 @@ func (app *Application) uploadFileAPI(c *gin.Context) {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.AbortWithStatus(http.StatusUnauthorized)
 
+			return
+		} else if status, ok := quotaErrorStatus(err); ok {
+			c.String(status, err.Error())
+			c.Abort()
+
 			return
 		}
 		log.Err(err).Msg("Failed to create file entry")
@@ -329,12 +525,9 @@ func (app *Application) uploadFileAPI(c *gin.Context) {
 
 		return
 	}
+	c.Set(uploadedBytesKey, int64(written))
 
-	if plainRedirect {
-		c.String(http.StatusOK, "/"+fullFileName)
-	} else {
-		c.Redirect(http.StatusTemporaryRedirect, "/"+fullFileName)
-	}
+	return fullFileName, true
 }
 
 type TagInput struct {
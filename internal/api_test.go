@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func uploadRaw(client *testClient, path string, content []byte) *httptest.ResponseRecorder {
+	return client.send(http.MethodPut, path, "", bytes.NewReader(content))
+}
+
+func TestRawUpload(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	client := newTestClient(app)
+	client.header.Set(uploadTokenHeader, newUploadToken(t, app, db.CreateUploadTokenInput{AccountID: account.ID}))
+	client.header.Set("X-Tags", "a, b")
+	client.header.Set("X-Expiry-Date", time.Now().AddDate(0, 0, 2).Format("2006-01-02"))
+
+	w := uploadRaw(client, "/api/file/upload/notes.txt?tag=c", []byte("hello"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got %d, want 201: %s", w.Code, w.Body)
+	}
+	file := uploadedFile(t, app, w)
+	if file.OriginalFileName != "notes.txt" || file.UploaderID != account.ID || file.FileSize != 5 {
+		t.Fatalf("stored %q of %d bytes for %d", file.OriginalFileName, file.FileSize, file.UploaderID)
+	}
+	if file.ExpiryDate.IsZero() {
+		t.Error("expiry header was ignored")
+	}
+	var tags []string
+	for _, tag := range file.Tags {
+		tags = append(tags, tag.Name)
+	}
+	slices.Sort(tags)
+	if !slices.Equal(tags, []string{"a", "b", "c"}) {
+		t.Errorf("tags %v, want a, b and c", tags)
+	}
+
+	content, err := os.ReadFile(filepath.Join(app.config.DataFolder, file.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello" {
+		t.Fatalf("stored %q, want the request body", content)
+	}
+}
+
+func TestRawUploadRefused(t *testing.T) {
+	app := newTestApp(t, func(c *Config) { c.MaxUploadSize = 16 })
+	account := newTestAccount(t, app, db.AccountTypeUser)
+
+	if w := uploadRaw(newTestClient(app), "/api/file/upload/a.txt", []byte("hello")); w.Code != http.StatusUnauthorized {
+		t.Fatalf("without a token: got %d, want 401", w.Code)
+	}
+
+	client := newTestClient(app)
+	client.header.Set(uploadTokenHeader, newUploadToken(t, app, db.CreateUploadTokenInput{
+		AccountID:        account.ID,
+		AllowedMimeTypes: []string{"image/png"},
+	}))
+	if w := uploadRaw(client, "/api/file/upload/a.png", []byte("hello")); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("text with an image only token: got %d, want 415", w.Code)
+	}
+	if w := uploadRaw(client, "/api/file/upload/a.png", append(slices.Clone(testPNG), make([]byte, 64)...)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("body over max_upload_size: got %d, want 413", w.Code)
+	}
+
+	// Uploads with the session cookie still need the CSRF token
+	session := newSessionClient(t, app, account)
+	session.header.Del(csrfHeader)
+	if w := uploadRaw(session, "/api/file/upload/a.txt", []byte("hello")); w.Code != http.StatusForbidden {
+		t.Fatalf("session without a CSRF token: got %d, want 403", w.Code)
+	}
+}
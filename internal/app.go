@@ -107,6 +107,44 @@ func initializeConfig() (c Config) {
 		c.MaxUploadSize = 100 * 1024 * 1024 // 100 MB
 	}
 
+	if err = c.FileNames.validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid file_names config")
+	}
+
+	if err = c.Quotas.validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid quotas config")
+	}
+
+	if err = c.QuotaPresets.validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid quota_presets config")
+	}
+
+	if err = c.RateLimits.validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid rate limits config")
+	}
+
+	if err = c.Sessions.validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid sessions config")
+	}
+
+	c.LoginProviders = c.LoginProviders.withEnv()
+	if err = c.LoginProviders.validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid login_providers config")
+	}
+
+	if err = c.Registration.validate(c.LoginProviders); err != nil {
+		log.Fatal().Err(err).Msg("Invalid registration config")
+	}
+
+	if err = c.ProxyAuth.validate(c.TrustedProxy, c.LoginProviders); err != nil {
+		log.Fatal().Err(err).Msg("Invalid proxy_auth config")
+	}
+
+	c.LDAP = c.LDAP.withEnv()
+	if err = c.LDAP.validate(c.LoginProviders); err != nil {
+		log.Fatal().Err(err).Msg("Invalid ldap config")
+	}
+
 	if c.BehindReverseProxy && c.TrustedProxy == "" {
 		log.Fatal().
 			Msg("behind_reverse_proxy is enabled but trusted_proxy is not set; refusing to start to avoid X-Forwarded-For spoofing")
@@ -206,12 +244,12 @@ func prepareDB(c Config) (database db.Database) {
 	}
 
 	if accountAmount == 0 && inviteCodeAmount == 0 {
-		var inviteCode db.InviteCodes
-		if initialToken := os.Getenv("INITIAL_REGISTER_TOKEN"); initialToken != "" {
-			inviteCode, err = database.CreateInviteCodeWithCode(initialToken, 1, "ADMIN", 0)
-		} else {
-			inviteCode, err = database.CreateInviteCode(1, "ADMIN", 0)
-		}
+		inviteCode, err := database.CreateInviteCode(db.CreateInviteCodeInput{
+			Code:        os.Getenv("INITIAL_REGISTER_TOKEN"),
+			Uses:        1,
+			AccountType: db.AccountTypeAdmin,
+			Note:        "Initial admin",
+		})
 		if err != nil {
 			log.Fatal().Err(err).Msg("Failed to create initial invite")
 		}
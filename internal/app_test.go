@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	os.Exit(m.Run())
+}
+
+// App on a fresh sqlite database in a temporary folder. configure runs on the
+// config before anything is set up.
+func newTestApp(t *testing.T, configure ...func(c *Config)) *Application {
+	t.Helper()
+
+	dir := t.TempDir()
+	c := Config{
+		DataFolder:            dir,
+		MaxUploadSize:         10 * 1024 * 1024,
+		DatabaseType:          "sqlite",
+		DatabaseConnectionUrl: filepath.Join(dir, "hostling.db"),
+		FileStorageMethod:     fileStorageLocal,
+		RateLimit:             1000,
+	}
+	for _, f := range configure {
+		f(&c)
+	}
+	if err := c.FileNames.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	database := prepareDB(c)
+	app := setupRouter(&uninitializedApplication{
+		config:       c,
+		db:           database,
+		RateLimiter:  setupRatelimiting(c),
+		limiterStore: setupLimiterStore(c, database),
+	}, c)
+
+	t.Cleanup(func() {
+		app.Shutdown()
+		if sqlDB, err := app.db.DB.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	return app
+}
+
+func newTestAccount(t *testing.T, app *Application, accountType string) db.Accounts {
+	t.Helper()
+
+	account, err := app.db.CreateAccount(accountType, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return account
+}
+
+// Browser-like client keeping cookies between requests
+type testClient struct {
+	app        *Application
+	cookies    map[string]*http.Cookie
+	header     http.Header
+	remoteAddr string
+}
+
+func newTestClient(app *Application) *testClient {
+	return &testClient{
+		app:        app,
+		cookies:    make(map[string]*http.Cookie),
+		header:     make(http.Header),
+		remoteAddr: "192.0.2.1:1234",
+	}
+}
+
+// Client with a session for the account and its CSRF token already set
+func newSessionClient(t *testing.T, app *Application, account db.Accounts) *testClient {
+	t.Helper()
+
+	sessionToken, sessionID, err := app.db.CreateSessionToken(account.ID, db.SessionInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(app)
+	client.cookies[AUTH_COOKIE] = &http.Cookie{Name: AUTH_COOKIE, Value: sessionToken.String()}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("sessionID", sessionID)
+	client.header.Set(csrfHeader, app.csrfToken(c))
+
+	return client
+}
+
+// Sends the form as multipart, which every method including DELETE parses
+func (tc *testClient) do(method string, path string, form map[string]string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	contentType := ""
+	if form != nil {
+		writer := multipart.NewWriter(&body)
+		for k, v := range form {
+			_ = writer.WriteField(k, v)
+		}
+		_ = writer.Close()
+		contentType = writer.FormDataContentType()
+	}
+
+	return tc.send(method, path, contentType, &body)
+}
+
+func (tc *testClient) send(method string, path string, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	req.RemoteAddr = tc.remoteAddr
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range tc.header {
+		req.Header[k] = v
+	}
+	for _, cookie := range tc.cookies {
+		req.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	tc.app.Router.ServeHTTP(w, req)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 || cookie.Value == "" {
+			delete(tc.cookies, cookie.Name)
+		} else {
+			tc.cookies[cookie.Name] = cookie
+		}
+	}
+
+	return w
+}
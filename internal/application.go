@@ -16,6 +16,7 @@ import (
 	"github.com/BatteredBunny/hostling/internal/db"
 	"github.com/didip/tollbooth/v8/limiter"
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/minio/minio-go/v7"
 	"github.com/rs/zerolog/log"
 )
@@ -35,8 +36,12 @@ type Application struct {
 
 	appSecret []byte // HMAC key
 
+	limiterStore limiterStore // Per account and token limits, per IP too unless it's the memory store
+
+	webAuthn *webauthn.WebAuthn // nil when passkeys are disabled
+
 	providersMutex      sync.RWMutex
-	configuredProviders []string // provider names that are configured (env vars set), even if not yet initialized or configured wrong
+	configuredProviders []string // provider names that are configured (credentials set), even if not yet initialized or configured wrong
 	failedProviders     []string // provider names that are configured but failed to initialize
 }
 
@@ -67,10 +72,22 @@ type Config struct {
 	PublicUrl          string `toml:"public_url"` // URL to use for github callback and cookies, e.g http://cdn.example.com
 	CookieSecure       bool   // true when PublicUrl is https
 
+	LoginProviders loginProvidersConfig `toml:"login_providers"` // OAuth and OpenID Connect providers by name
+	PasswordLogin  bool                 `toml:"password_login"`  // Lets accounts log in with a username and password
+	ProxyAuth      proxyAuthConfig      `toml:"proxy_auth"`      // Trusts the user a reverse proxy sends in headers
+	LDAP           ldapConfig           `toml:"ldap"`            // Logs in against a directory
+	Sessions       sessionConfig        `toml:"sessions"`        // How long people stay logged in
+	Registration   registrationConfig   `toml:"registration"`    // Who gets an account on their first provider login
+
 	Branding string `toml:"branding"` // Branding text for toolbar (max 20 characters)
 	Tagline  string `toml:"tagline"`  // Used for meta description and text on index page (max 100 characters)
 
-	RateLimit float64 `toml:"rate_limit"` // Requests/sec per client IP for rate-limited routes (default 10)
+	RateLimit  float64          `toml:"rate_limit"`  // Requests/sec per client IP for rate-limited routes (default 10)
+	RateLimits rateLimitsConfig `toml:"rate_limits"` // Per account and token limits for each route group
+
+	FileNames    fileNameConfig     `toml:"file_names"`    // How uploaded files get named
+	Quotas       quotaConfig        `toml:"quotas"`        // Default per-account quotas
+	QuotaPresets quotaPresetsConfig `toml:"quota_presets"` // Named quotas invite codes can give
 
 	FileStorageMethod fileStorageMethod
 	S3                s3Config `toml:"s3"`
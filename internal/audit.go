@@ -0,0 +1,335 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+)
+
+// Actions recorded in the audit log
+const (
+	auditLogin              = "login"
+	auditRegister           = "register"
+	auditDeleteAccount      = "account.delete"
+	auditCreateUploadToken  = "upload_token.create"
+	auditDeleteUploadToken  = "upload_token.delete"
+	auditCreateAccessToken  = "access_token.create"
+	auditDeleteAccessToken  = "access_token.delete"
+	auditLinkProvider       = "provider.link"
+	auditUnlinkProvider     = "provider.unlink"
+	auditDeleteFile         = "file.delete"
+	auditDeleteFiles        = "files.delete"
+	auditAdminDeleteAccount = "admin.delete_account"
+	auditAdminDeleteFiles   = "admin.delete_files"
+	auditAdminDeleteSession = "admin.delete_sessions"
+	auditAdminDeleteTokens  = "admin.delete_upload_tokens"
+	auditAdminGiveInvite    = "admin.give_invite_code"
+	auditAdminSetQuota      = "admin.set_quota"
+	auditAdminResetTOTP     = "admin.reset_totp"
+
+	auditRevokeSession       = "session.revoke"
+	auditRevokeOtherSessions = "sessions.revoke_others"
+
+	auditAdminSuspend        = "admin.suspend"
+	auditAdminLiftSuspension = "admin.lift_suspension"
+	auditAdminSetAccountType = "admin.set_account_type"
+	auditAdminApproveAccount = "admin.approve_account"
+	auditAdminRejectAccount  = "admin.reject_account"
+
+	auditModerationDeleteFile = "moderation.delete_file"
+)
+
+type auditAction struct {
+	Action string
+	Label  string
+}
+
+// In the order the filter on the audit page lists them
+var auditActions = []auditAction{
+	{auditLogin, "Login"},
+	{auditRegister, "Registration"},
+	{auditDeleteAccount, "Account deleted"},
+	{auditCreateUploadToken, "Upload token created"},
+	{auditDeleteUploadToken, "Upload token deleted"},
+	{auditCreateAccessToken, "Access token created"},
+	{auditDeleteAccessToken, "Access token deleted"},
+	{auditLinkProvider, "Provider linked"},
+	{auditUnlinkProvider, "Provider unlinked"},
+	{auditRevokeSession, "Session revoked"},
+	{auditRevokeOtherSessions, "Other sessions revoked"},
+	{auditDeleteFile, "File deleted"},
+	{auditDeleteFiles, "Files deleted"},
+	{auditAdminDeleteAccount, "Admin deleted account"},
+	{auditAdminDeleteFiles, "Admin deleted files"},
+	{auditAdminDeleteSession, "Admin deleted sessions"},
+	{auditAdminDeleteTokens, "Admin deleted upload tokens"},
+	{auditAdminGiveInvite, "Admin gave invite code"},
+	{auditAdminSetQuota, "Admin set quota"},
+	{auditAdminResetTOTP, "Admin reset 2FA"},
+	{auditAdminSuspend, "Admin suspended account"},
+	{auditAdminLiftSuspension, "Admin lifted suspension"},
+	{auditAdminSetAccountType, "Admin changed account type"},
+	{auditAdminApproveAccount, "Admin approved account"},
+	{auditAdminRejectAccount, "Admin rejected account"},
+	{auditModerationDeleteFile, "Moderator removed file"},
+}
+
+func auditActionLabel(action string) string {
+	for _, a := range auditActions {
+		if a.Action == action {
+			return a.Label
+		}
+	}
+
+	return action
+}
+
+// Records an action of the logged in account unless the entry names its own
+// actor. Failing to write the entry doesn't fail the request.
+func (app *Application) audit(c *gin.Context, entry db.AuditLogs) {
+	if entry.ActorID == 0 {
+		if account, ok := getAccount(c); ok {
+			entry.ActorID = account.ID
+		}
+	}
+	entry.IPHash = app.ipHash(c)
+
+	if err := app.db.CreateAuditLog(entry); err != nil {
+		log.Err(err).Str("action", entry.Action).Msg("Failed to write audit log")
+	}
+}
+
+func (app *Application) auditLink(c *gin.Context, account db.Accounts, provider string, username string) {
+	detail := provider
+	if username != "" {
+		detail += " as " + username
+	}
+
+	app.audit(c, db.AuditLogs{ActorID: account.ID, TargetID: account.ID, Action: auditLinkProvider, Detail: detail})
+}
+
+const auditPageSize = 50
+
+var ErrInvalidAuditFilter = errors.New("invalid audit log filter")
+
+type auditLogInput struct {
+	Action    string `form:"action"`
+	AccountID uint   `form:"account"`
+	Since     string `form:"since"` // YYYY-MM-DD
+	Until     string `form:"until"` // YYYY-MM-DD, the whole day is included
+	Skip      uint   `form:"skip,default=0"`
+}
+
+func (input auditLogInput) filter() (filter db.AuditLogFilter, err error) {
+	if input.Action != "" && !slices.ContainsFunc(auditActions, func(a auditAction) bool {
+		return a.Action == input.Action
+	}) {
+		return filter, fmt.Errorf("%w: unknown action %q", ErrInvalidAuditFilter, input.Action)
+	}
+	filter.Action = input.Action
+	filter.AccountID = input.AccountID
+
+	if input.Since != "" {
+		since, parseErr := time.Parse(time.DateOnly, input.Since)
+		if parseErr != nil {
+			return filter, fmt.Errorf("%w: since must look like 2006-01-02", ErrInvalidAuditFilter)
+		}
+		filter.Since = &since
+	}
+	if input.Until != "" {
+		until, parseErr := time.Parse(time.DateOnly, input.Until)
+		if parseErr != nil {
+			return filter, fmt.Errorf("%w: until must look like 2006-01-02", ErrInvalidAuditFilter)
+		}
+		until = until.AddDate(0, 0, 1)
+		filter.Until = &until
+	}
+
+	return
+}
+
+// Query string of the same filter starting at skip
+func (input auditLogInput) query(skip uint) string {
+	values := url.Values{}
+	if input.Action != "" {
+		values.Set("action", input.Action)
+	}
+	if input.AccountID != 0 {
+		values.Set("account", strconv.FormatUint(uint64(input.AccountID), 10))
+	}
+	if input.Since != "" {
+		values.Set("since", input.Since)
+	}
+	if input.Until != "" {
+		values.Set("until", input.Until)
+	}
+	if skip > 0 {
+		values.Set("skip", strconv.FormatUint(uint64(skip), 10))
+	}
+
+	return values.Encode()
+}
+
+// An audit log entry as shown on the admin page
+type AuditEntry struct {
+	db.AuditLogs
+	ActionLabel string
+	Actor       string
+	Target      string
+	IPHashShort string
+}
+
+func (app *Application) auditLogPage(c *gin.Context) {
+	account, ok := app.requireAuth(c)
+	if !ok {
+		return
+	}
+
+	if !can(account, permManageAccounts) {
+		c.Redirect(http.StatusTemporaryRedirect, "/")
+
+		return
+	}
+
+	var input auditLogInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+	if input.Skip > maxPaginationSkip {
+		c.AbortWithStatus(http.StatusBadRequest)
+
+		return
+	}
+
+	filter, err := input.filter()
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	rows, count, err := app.db.GetAuditLogsPaginated(filter, input.Skip, auditPageSize)
+	if err != nil {
+		log.Err(err).Msg("Failed to get audit log")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	accountIDs := make([]uint, 0, 2*len(rows))
+	for _, row := range rows {
+		accountIDs = append(accountIDs, row.ActorID, row.TargetID)
+	}
+	labels, err := app.accountLabels(accountIDs)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	accountName := func(id uint) string {
+		if id == 0 {
+			return "-"
+		}
+		if label, ok := labels[id]; ok {
+			return label
+		}
+
+		return fmt.Sprintf("Deleted account (%d)", id)
+	}
+
+	entries := make([]AuditEntry, 0, len(rows))
+	for _, row := range rows {
+		ipHash := row.IPHash
+		if len(ipHash) > sessionIPHashLength {
+			ipHash = ipHash[:sessionIPHashLength]
+		}
+
+		entries = append(entries, AuditEntry{
+			AuditLogs:   row,
+			ActionLabel: auditActionLabel(row.Action),
+			Actor:       accountName(row.ActorID),
+			Target:      accountName(row.TargetID),
+			IPHashShort: ipHash,
+		})
+	}
+
+	templateInput := gin.H{
+		"CurrentPage": "admin",
+		"Branding":    app.config.Branding,
+		"Tagline":     app.config.Tagline,
+		"Actions":     auditActions,
+		"Filter":      input,
+		"Entries":     entries,
+		"Count":       count,
+		"ExportQuery": input.query(0),
+	}
+	app.accountTemplateInput(c, account, templateInput)
+	if input.Skip > 0 {
+		templateInput["PreviousQuery"] = input.query(input.Skip - min(input.Skip, auditPageSize))
+	}
+	if int64(input.Skip+auditPageSize) < count {
+		templateInput["NextQuery"] = input.query(input.Skip + auditPageSize)
+	}
+
+	c.HTML(http.StatusOK, "audit.gohtml", templateInput)
+}
+
+// Every entry matching the filter as a JSON download
+func (app *Application) adminAuditLogAPI(c *gin.Context) {
+	var input auditLogInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+
+		return
+	}
+
+	filter, err := input.filter()
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="audit-log.json"`)
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	// Streamed as one JSON array, a failure halfway leaves it unterminated
+	encoder := json.NewEncoder(c.Writer)
+	separator := "["
+	err = app.db.EachAuditLogBatch(filter, func(entries []db.AuditLogs) error {
+		for _, entry := range entries {
+			if _, err := c.Writer.WriteString(separator); err != nil {
+				return err
+			}
+			separator = ","
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msg("Failed to export audit log")
+
+		return
+	}
+	if separator == "[" {
+		_, _ = c.Writer.WriteString("[")
+	}
+	_, _ = c.Writer.WriteString("]\n")
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func TestAuditLogExport(t *testing.T) {
+	app := newTestApp(t)
+	admin := newTestAccount(t, app, db.AccountTypeAdmin)
+	user := newTestAccount(t, app, db.AccountTypeUser)
+
+	for range 3 {
+		if err := app.db.CreateAuditLog(db.AuditLogs{ActorID: user.ID, Action: auditLogin}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.db.CreateAuditLog(db.AuditLogs{ActorID: admin.ID, Action: auditRegister}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newSessionClient(t, app, admin)
+	w := client.do(http.MethodGet, "/api/admin/audit_log?action="+auditLogin, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", w.Code)
+	}
+	var entries []db.AuditLogs
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("export isn't valid JSON: %v\n%s", err, w.Body)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	w = client.do(http.MethodGet, "/api/admin/audit_log?action="+auditDeleteAccount+"", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("empty export: got %d, want 200", w.Code)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil || len(entries) != 0 {
+		t.Fatalf("empty export: got %s", w.Body)
+	}
+}
+
+func TestAuditLogExportNeedsAdmin(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestAccount(t, app, db.AccountTypeUser)
+
+	w := newSessionClient(t, app, user).do(http.MethodGet, "/api/admin/audit_log", nil)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got %d, want 403", w.Code)
+	}
+}
+
+func auditEntries(t *testing.T, app *Application, action string) (entries []db.AuditLogs) {
+	t.Helper()
+
+	if err := app.db.Where("action = ?", action).Order("id").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestAuditLogRecordsActions(t *testing.T) {
+	app, account := newPasswordTestApp(t)
+	admin := newTestAccount(t, app, db.AccountTypeAdmin)
+
+	if code := passwordLogin(newTestClient(app), "correct horse"); code != http.StatusSeeOther {
+		t.Fatalf("login: got %d", code)
+	}
+	logins := auditEntries(t, app, auditLogin)
+	if len(logins) != 1 || logins[0].ActorID != account.ID {
+		t.Fatalf("login entries %+v, want one by %d", logins, account.ID)
+	}
+	if logins[0].IPHash == "" || strings.Contains(logins[0].IPHash, "192.0.2.1") {
+		t.Errorf("IP hash %q, want a hash of the address", logins[0].IPHash)
+	}
+
+	// Tokens are named in the log, never written into it
+	token := newAccessToken(t, newSessionClient(t, app, account), map[string]string{"scope": "files:read", "name": "backup"})
+	created := auditEntries(t, app, auditCreateAccessToken)
+	if len(created) != 1 || created[0].ActorID != account.ID {
+		t.Fatalf("access token entries %+v, want one by %d", created, account.ID)
+	}
+	if strings.Contains(created[0].Detail, token) {
+		t.Error("access token was written into the audit log")
+	}
+
+	if code := setAccountType(newSessionClient(t, app, admin), account.ID, db.AccountTypeModerator); code != http.StatusOK {
+		t.Fatalf("changing the account type: got %d", code)
+	}
+	changed := auditEntries(t, app, auditAdminSetAccountType)
+	if len(changed) != 1 || changed[0].ActorID != admin.ID || changed[0].TargetID != account.ID {
+		t.Fatalf("account type entries %+v, want one by %d on %d", changed, admin.ID, account.ID)
+	}
+
+	// Refused requests leave nothing behind
+	if code := setAccountType(newSessionClient(t, app, account), admin.ID, db.AccountTypeUser); code != http.StatusForbidden {
+		t.Fatalf("moderator demoting an admin: got %d, want 403", code)
+	}
+	if changed = auditEntries(t, app, auditAdminSetAccountType); len(changed) != 1 {
+		t.Fatalf("%d account type entries after a refused change, want 1", len(changed))
+	}
+}
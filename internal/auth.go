@@ -2,12 +2,15 @@ package internal
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"slices"
 	"strings"
 	"sync"
@@ -19,8 +22,6 @@ import (
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
-	"github.com/markbates/goth/providers/github"
-	"github.com/markbates/goth/providers/openidConnect"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
@@ -38,9 +39,48 @@ func deriveKey(secret []byte, label string) []byte {
 	return mac.Sum(nil)
 }
 
-type providerInit struct {
-	gothName string // the name goth uses for this provider (e.g. "openid-connect")
-	init     func() (bool, error)
+var ErrInvalidSealedValue = errors.New("invalid sealed value")
+
+// Encrypts and authenticates plain with AES-GCM, key comes from deriveKey
+func sealValue(key []byte, plain []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawStdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plain, nil)), nil
+}
+
+func openValue(key []byte, sealed string) (plain []byte, err error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return
+	}
+
+	raw, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < gcm.NonceSize() {
+		return nil, ErrInvalidSealedValue
+	}
+
+	if plain, err = gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil); err != nil {
+		return nil, ErrInvalidSealedValue
+	}
+
+	return
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
 }
 
 func (app *Application) setupSocialLogin() {
@@ -55,32 +95,28 @@ func (app *Application) setupSocialLogin() {
 	}
 	gothic.Store = store
 
-	providers := []providerInit{
-		{gothName: "github", init: app.initGithubProvider},
-		{gothName: "openid-connect", init: app.initOIDCProvider},
-	}
-
 	hasEnabledProvider := false
-	for _, p := range providers {
-		enabled, err := p.init()
+	for _, name := range app.config.LoginProviders.names() {
+		enabled, err := app.initProvider(name)
 		if enabled {
-			app.addConfiguredProvider(p.gothName)
+			app.addConfiguredProvider(name)
 			hasEnabledProvider = true
 		}
 		if err != nil {
-			log.Warn().Err(err).Str("provider", p.gothName).Msg("Failed to initialize provider, retrying in background")
-			app.addFailedProvider(p.gothName)
+			log.Warn().Err(err).Str("provider", name).Msg("Failed to initialize provider, retrying in background")
+			app.addFailedProvider(name)
 			app.backgroundWg.Go(func() {
-				app.retryProviderInit(app.shutdownCtx, p.gothName, p.init)
+				app.retryProviderInit(app.shutdownCtx, name)
 			})
 		}
 	}
 
 	if !hasEnabledProvider {
-		names := make([]string, len(providers))
-		for i, p := range providers {
-			names[i] = p.gothName
+		names := make([]string, 0, len(providerKinds))
+		for t := range providerKinds {
+			names = append(names, string(t))
 		}
+		slices.Sort(names)
 		log.Warn().Msgf("No authentication providers enabled, configure at least one (%s)", strings.Join(names, ", "))
 	}
 }
@@ -115,7 +151,7 @@ func (app *Application) removeFailedProvider(name string) {
 	}
 }
 
-func (app *Application) retryProviderInit(ctx context.Context, name string, init func() (bool, error)) {
+func (app *Application) retryProviderInit(ctx context.Context, name string) {
 	const (
 		maxRetries = 10
 		maxDelay   = 5 * time.Minute
@@ -138,7 +174,7 @@ func (app *Application) retryProviderInit(ctx context.Context, name string, init
 		case <-timer.C:
 		}
 
-		enabled, err := init()
+		enabled, err := app.initProvider(name)
 		if err == nil {
 			log.Info().Str("provider", name).Msg("Provider initialized successfully after retry")
 			app.removeFailedProvider(name)
@@ -154,61 +190,39 @@ func (app *Application) retryProviderInit(ctx context.Context, name string, init
 	log.Error().Str("provider", name).Msg("Provider initialization failed after all retries, will retry on login")
 }
 
-func (app *Application) initGithubProvider() (enabled bool, err error) {
-	clientID := os.Getenv("GITHUB_CLIENT_ID")
-	secret := os.Getenv("GITHUB_SECRET")
-	enabled = clientID != "" && secret != ""
-	if !enabled {
-		return
-	}
-
-	gothRegistryMutex.Lock()
-	defer gothRegistryMutex.Unlock()
-
-	if _, getErr := goth.GetProvider("github"); getErr == nil {
-		return
-	}
-
-	goth.UseProviders(github.New(
-		clientID,
-		secret,
-		fmt.Sprintf("%s/api/auth/login/github/callback", app.config.PublicUrl),
-	))
-	log.Info().Msg("GitHub authentication enabled")
-
-	return
-}
-
-func (app *Application) initOIDCProvider() (enabled bool, err error) {
-	clientID := os.Getenv("OPENID_CONNECT_CLIENT_ID")
-	clientSecret := os.Getenv("OPENID_CONNECT_CLIENT_SECRET")
-	discoveryURL := os.Getenv("OPENID_CONNECT_DISCOVERY_URL")
-	enabled = clientID != "" && clientSecret != "" && discoveryURL != ""
-	if !enabled {
-		return
+// Registers the provider with goth, does nothing if it's already registered
+// or missing credentials. OpenID Connect can fail here as it fetches the
+// discovery document.
+func (app *Application) initProvider(name string) (enabled bool, err error) {
+	p, err := app.providerConfig(name)
+	if errors.Is(err, ErrProviderNotConfigured) {
+		return false, nil
 	}
+	enabled = true
 
 	gothRegistryMutex.Lock()
 	defer gothRegistryMutex.Unlock()
 
-	if _, getErr := goth.GetProvider("openid-connect"); getErr == nil {
+	if _, getErr := goth.GetProvider(name); getErr == nil {
 		return
 	}
 
-	callback := fmt.Sprintf("%s/api/auth/login/openid-connect/callback", app.config.PublicUrl)
-	oidcProvider, err := openidConnect.New(clientID, clientSecret, callback, discoveryURL)
+	callbackURL := fmt.Sprintf("%s/api/auth/login/%s/callback", app.config.PublicUrl, name)
+	provider, err := p.kind().new(p, callbackURL, app.config.Registration.checksEmail())
 	if err != nil {
 		return
 	}
+	provider.SetName(name)
 
-	goth.UseProviders(oidcProvider)
-	log.Info().Msg("OpenID Connect authentication enabled")
+	goth.UseProviders(provider)
+	log.Info().Str("provider", name).Msgf("%s authentication enabled", p.name())
 
 	return
 }
 
 func (app *Application) setupAuth(api *gin.RouterGroup) {
 	app.setupSocialLogin()
+	app.setupPasskeys()
 
 	auth := api.Group("/auth")
 	auth.Use(app.ratelimitMiddleware())
@@ -217,9 +231,14 @@ func (app *Application) setupAuth(api *gin.RouterGroup) {
 	auth.GET("/login/:provider", app.loginApi)
 
 	auth.POST("/register", app.registerApi)
-
-	auth.POST("/link/:provider", app.linkApi)
-	auth.POST("/unlink/:provider", app.unlinkApi)
+	auth.POST("/2fa", app.apiMiddleware(), app.twoFactorLoginApi)
+	auth.POST("/password", app.apiMiddleware(), app.passwordLoginApi)
+	auth.POST("/ldap", app.apiMiddleware(), app.ldapLoginApi)
+	auth.POST("/passkey/begin", app.passkeyLoginBeginAPI)
+	auth.POST("/passkey/finish", app.passkeyLoginFinishAPI)
+
+	auth.POST("/link/:provider", app.verifyCSRF(), app.linkApi)
+	auth.POST("/unlink/:provider", app.verifyCSRF(), app.unlinkApi)
 }
 
 func (app *Application) unlinkApi(c *gin.Context) {
@@ -236,43 +255,38 @@ func (app *Application) unlinkApi(c *gin.Context) {
 		return
 	}
 
-	var unlinkErr error
-	switch provider {
-	case "github":
-		if account.GithubID == 0 {
-			c.Redirect(http.StatusSeeOther, "/settings")
+	_, err = app.db.GetLinkedIdentity(account.ID, provider)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.Redirect(http.StatusSeeOther, "/settings")
 
-			return
-		}
-		if linkedIdentityCount(account) <= 1 {
-			c.String(http.StatusConflict, ErrLastLinkedIdent.Error())
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to look up linked identity")
+		c.AbortWithStatus(http.StatusInternalServerError)
 
-			return
-		}
-		unlinkErr = app.db.UnlinkGithub(account.ID)
-	case "openid-connect":
-		if account.OIDCID == "" {
-			c.Redirect(http.StatusSeeOther, "/settings")
+		return
+	}
 
-			return
-		}
-		if linkedIdentityCount(account) <= 1 {
-			c.String(http.StatusConflict, ErrLastLinkedIdent.Error())
+	identities, err := app.linkedIdentityCount(account)
+	if err != nil {
+		log.Err(err).Msg("Failed to count linked identities")
+		c.AbortWithStatus(http.StatusInternalServerError)
 
-			return
-		}
-		unlinkErr = app.db.UnlinkOIDC(account.ID)
-	default:
-		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if identities <= 1 {
+		c.String(http.StatusConflict, ErrLastLinkedIdent.Error())
 
 		return
 	}
-	if unlinkErr != nil {
-		log.Err(unlinkErr).Str("provider", provider).Msg("Failed to unlink provider")
+
+	if err = app.db.UnlinkIdentity(account.ID, provider); err != nil {
+		log.Err(err).Str("provider", provider).Msg("Failed to unlink provider")
 		c.AbortWithStatus(http.StatusInternalServerError)
 
 		return
 	}
+	app.audit(c, db.AuditLogs{ActorID: account.ID, TargetID: account.ID, Action: auditUnlinkProvider, Detail: provider})
 
 	c.Redirect(http.StatusSeeOther, "/settings")
 }
@@ -281,10 +295,7 @@ func (app *Application) loginApi(c *gin.Context) {
 	provider := c.Param("provider")
 	c.Request = contextWithProviderName(c, provider)
 
-	if err := app.ensureProvider(provider); err != nil {
-		log.Error().Err(err).Str("provider", provider).Msg("Provider unavailable")
-		c.String(http.StatusServiceUnavailable, "Login provider is temporarily unavailable")
-
+	if !app.ensureProviderOrAbort(c, provider) {
 		return
 	}
 
@@ -292,41 +303,56 @@ func (app *Application) loginApi(c *gin.Context) {
 }
 
 func (app *Application) ensureProvider(provider string) error {
-	var init func() (bool, error)
-	switch provider {
-	case "github":
-		init = app.initGithubProvider
-	case "openid-connect":
-		init = app.initOIDCProvider
-	default:
-		return nil
-	}
-
-	enabled, err := init()
+	enabled, err := app.initProvider(provider)
 	if err != nil {
 		return err
 	}
-	if enabled {
-		app.addConfiguredProvider(provider)
-		app.removeFailedProvider(provider)
+	if !enabled {
+		return ErrProviderNotConfigured
 	}
 
+	app.addConfiguredProvider(provider)
+	app.removeFailedProvider(provider)
+
 	return nil
 }
 
+// Writes the error response when the provider can't be used right now
+func (app *Application) ensureProviderOrAbort(c *gin.Context, provider string) bool {
+	err := app.ensureProvider(provider)
+	if errors.Is(err, ErrProviderNotConfigured) {
+		c.String(http.StatusNotFound, err.Error())
+
+		return false
+	} else if err != nil {
+		log.Error().Err(err).Str("provider", provider).Msg("Provider unavailable")
+		c.String(http.StatusServiceUnavailable, "Login provider is temporarily unavailable")
+
+		return false
+	}
+
+	return true
+}
+
 var (
-	ErrUnknownProvider = errors.New("unknown provider")
 	ErrLastLinkedIdent = errors.New("can't unlink the only remaining login provider")
+	ErrLastLoginMethod = errors.New("can't remove the only remaining way to log in")
 )
 
-// reports how many auth identities the account has linked.
-func linkedIdentityCount(account db.Accounts) (n int) {
-	if account.GithubID != 0 {
+// reports how many auth identities the account has linked, a password counts as one and passkeys count one each.
+func (app *Application) linkedIdentityCount(account db.Accounts) (n int, err error) {
+	if account.PasswordHash != "" {
 		n++
 	}
-	if account.OIDCID != "" {
-		n++
+
+	identities, err := app.db.LinkedIdentityCount(account.ID)
+	if err != nil {
+		return
 	}
+	n += int(identities)
+
+	passkeys, err := app.db.PasskeyCount(account.ID)
+	n += int(passkeys)
 
 	return
 }
@@ -336,22 +362,7 @@ func (app *Application) linkProvider(
 	account db.Accounts,
 	user goth.User,
 ) (alreadyTaken bool, err error) {
-	switch provider {
-	case "github":
-		if account.GithubID != 0 {
-			return
-		}
-		err = app.db.LinkGithub(account.ID, user.NickName, user.UserID)
-	case "openid-connect":
-		if account.OIDCID != "" {
-			return
-		}
-		err = app.db.LinkOIDC(account.ID, oidcUsername(user), user.UserID)
-	default:
-		err = ErrUnknownProvider
-
-		return
-	}
+	err = app.db.LinkIdentity(account.ID, provider, user.UserID, providerUsername(user))
 	if errors.Is(err, db.ErrProviderAlreadyLinked) {
 		alreadyTaken = true
 		err = nil
@@ -362,23 +373,11 @@ func (app *Application) linkProvider(
 
 // find account for provider & refresh username
 func (app *Application) findAccountForProvider(provider string, user goth.User) (account db.Accounts, err error) {
-	switch provider {
-	case "github":
-		if account, err = app.db.FindAccountByGithubID(user.UserID); err != nil {
-			return
-		}
-		if updateErr := app.db.UpdateGithubUsername(account.ID, user.NickName); updateErr != nil {
-			log.Warn().Err(updateErr).Msg("Failed to update github username")
-		}
-	case "openid-connect":
-		if account, err = app.db.FindAccountByOIDCID(user.UserID); err != nil {
-			return
-		}
-		if updateErr := app.db.UpdateOIDCUsername(account.ID, oidcUsername(user)); updateErr != nil {
-			log.Warn().Err(updateErr).Msg("Failed to update OIDC username")
-		}
-	default:
-		err = gorm.ErrRecordNotFound
+	if account, err = app.db.FindAccountByIdentity(provider, user.UserID); err != nil {
+		return
+	}
+	if updateErr := app.db.UpdateIdentityUsername(provider, user.UserID, providerUsername(user)); updateErr != nil {
+		log.Warn().Err(updateErr).Str("provider", provider).Msg("Failed to update linked username")
 	}
 
 	return
@@ -403,33 +402,34 @@ func (app *Application) loginCallback(c *gin.Context) {
 		app.clearLinkingCookie(c)
 	}
 
+	p := app.config.LoginProviders[provider]
+	groups := claimGroups(user.RawData, p.GroupsClaim)
+
 	account, err := app.findAccountForProvider(provider, user)
+	if errors.Is(err, gorm.ErrRecordNotFound) && p.AutoProvision && user.UserID != "" {
+		account, err = app.provisionAccount(c, p.groupMapping, groups, provider, user.UserID, providerUsername(user))
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		account, err = app.registerAccount(c, p.groupMapping, groups, provider, user)
+	} else if err == nil {
+		account, err = app.applyGroupMapping(account, p.groupMapping, groups)
+	}
+
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.Redirect(http.StatusSeeOther, "/login")
 
 		return
-	} else if err != nil {
-		log.Err(err).Str("provider", provider).Msg("Failed to find account by provider id")
-		c.AbortWithStatus(http.StatusInternalServerError)
+	} else if errors.Is(err, ErrNotInAllowedGroup) || errors.Is(err, ErrEmailDomainNotAllowed) || errors.Is(err, ErrEmailNotVerified) {
+		c.String(http.StatusForbidden, err.Error())
 
 		return
-	}
-
-	sessionToken, err := app.db.CreateSessionToken(account.ID)
-	if err != nil {
+	} else if err != nil {
+		log.Err(err).Str("provider", provider).Msg("Failed to find account by provider id")
 		c.AbortWithStatus(http.StatusInternalServerError)
 
 		return
 	}
 
-	if prev, parseErr := app.parseAuthCookie(c); parseErr == nil {
-		if delErr := app.db.DeleteSessionForAccount(prev, account.ID); delErr != nil {
-			log.Warn().Err(delErr).Msg("Failed to delete prior session on re-login")
-		}
-	}
-
-	app.setAuthCookie(sessionToken, c)
-	c.Redirect(http.StatusSeeOther, "/gallery")
+	app.completeLogin(c, account, provider)
 }
 
 func (app *Application) handleLinkCallback(c *gin.Context, provider string, user goth.User, linkingAccountID uint) {
@@ -470,6 +470,7 @@ func (app *Application) handleLinkCallback(c *gin.Context, provider string, user
 
 		return
 	}
+	app.auditLink(c, account, provider, providerUsername(user))
 
 	c.Redirect(http.StatusSeeOther, "/settings")
 }
@@ -491,32 +492,38 @@ func (app *Application) linkApi(c *gin.Context) {
 		return
 	}
 
-	alreadyLinked := false
-	switch provider {
-	case "github":
-		alreadyLinked = account.GithubID > 0
-	case "openid-connect":
-		alreadyLinked = account.OIDCID != ""
-	}
-
 	if !loggedIn {
 		c.Redirect(http.StatusSeeOther, "/login")
 
 		return
 	}
-	if alreadyLinked {
+
+	if _, err = app.db.GetLinkedIdentity(account.ID, provider); err == nil {
 		c.Redirect(http.StatusSeeOther, "/settings")
 
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Err(err).Msg("Failed to look up linked identity")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
 		return
 	}
 
-	if err := app.ensureProvider(provider); err != nil {
-		log.Error().Err(err).Str("provider", provider).Msg("Provider unavailable")
-		c.String(http.StatusServiceUnavailable, "Login provider is temporarily unavailable")
+	if provider == proxyAuthProvider && app.config.ProxyAuth.Enabled {
+		app.linkProxyUser(c, account)
+
+		return
+	}
+	if provider == ldapProvider && app.config.LDAP.enabled() {
+		app.linkLDAPUser(c, account)
 
 		return
 	}
 
+	if !app.ensureProviderOrAbort(c, provider) {
+		return
+	}
+
 	app.setLinkingCookie(c, account.ID)
 
 	url, err := gothic.GetAuthURL(c.Writer, c.Request)
@@ -543,7 +550,7 @@ func (app *Application) registerApi(c *gin.Context) {
 		return
 	}
 
-	_, token, err := app.db.RegisterWithInviteCode(input.Code)
+	account, token, err := app.db.RegisterWithInviteCode(input.Code, app.sessionInfo(c, loginMethodInvite))
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.String(http.StatusBadRequest, "Invalid code")
 
@@ -554,12 +561,18 @@ func (app *Application) registerApi(c *gin.Context) {
 
 		return
 	}
+	detail := "Invite code"
+	if account.InvitedBy != 0 {
+		detail = fmt.Sprintf("Invite code from account %d", account.InvitedBy)
+	}
+	app.audit(c, db.AuditLogs{ActorID: account.ID, TargetID: account.ID, Action: auditRegister, Detail: detail})
 
 	app.setAuthCookie(token, c)
 	c.Redirect(http.StatusSeeOther, "/gallery")
 }
 
-func oidcUsername(user goth.User) string {
+// Falls back through what providers fill in, not all have a username
+func providerUsername(user goth.User) string {
 	for _, v := range []string{user.NickName, user.Name, user.Email, user.UserID} {
 		v = strings.TrimSpace(v)
 		if v != "" {
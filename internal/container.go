@@ -13,6 +13,7 @@ func InitializeApplication() *Application {
 	panic(wire.Build(wire.NewSet(
 		initializeConfig,
 		setupRatelimiting,
+		setupLimiterStore,
 		prepareDB,
 		prepareStorage,
 
@@ -22,6 +23,7 @@ func InitializeApplication() *Application {
 			"db",
 			"s3client",
 			"RateLimiter",
+			"limiterStore",
 		),
 
 		setupRouter, // Finishes the setup
@@ -20,9 +20,10 @@ const (
 	AUTH_COOKIE         = "auth"
 	LINKING_COOKIE      = "linking"
 	linkingCookieMaxAge = 500 // seconds
-)
 
-var authCookieMaxAge = int(db.SessionTokenDuration.Seconds())
+	TWO_FACTOR_COOKIE     = "two_factor" // Account waiting for its two-factor code
+	twoFactorCookieMaxAge = 300          // seconds
+)
 
 func (app *Application) setCookie(c *gin.Context, name, value string, maxAge int) {
 	c.SetSameSite(http.SameSiteLaxMode)
@@ -104,8 +105,72 @@ func (app *Application) clearLinkingCookie(c *gin.Context) {
 	app.setCookie(c, LINKING_COOKIE, "", -1)
 }
 
+// Same signed format as the linking cookie, with its own key so one can't stand in for the other.
+// The login method is in front and part of the key, so it can't be swapped either.
+func (app *Application) setTwoFactorCookie(c *gin.Context, accountID uint, loginMethod string) {
+	value := loginMethod + "|" + signLinkingValue(
+		accountID,
+		deriveKey(app.appSecret, "two-factor-cookie:"+loginMethod),
+		time.Now().Add(twoFactorCookieMaxAge*time.Second),
+	)
+	app.setCookie(c, TWO_FACTOR_COOKIE, value, twoFactorCookieMaxAge)
+}
+
+func (app *Application) parseTwoFactorCookie(c *gin.Context) (accountID uint, loginMethod string, err error) {
+	raw, err := c.Cookie(TWO_FACTOR_COOKIE)
+	if err != nil {
+		return
+	}
+
+	loginMethod, signed, found := strings.Cut(raw, "|")
+	if !found {
+		return 0, "", ErrInvalidLinkingCookie
+	}
+
+	accountID, err = parseLinkingValue(signed, deriveKey(app.appSecret, "two-factor-cookie:"+loginMethod), time.Now())
+
+	return
+}
+
+func (app *Application) clearTwoFactorCookie(c *gin.Context) {
+	app.setCookie(c, TWO_FACTOR_COOKIE, "", -1)
+}
+
+// For new sessions, which expire when they'd first go idle
 func (app *Application) setAuthCookie(sessionToken uuid.UUID, c *gin.Context) {
-	app.setCookie(c, AUTH_COOKIE, sessionToken.String(), authCookieMaxAge)
+	app.setCookie(c, AUTH_COOKIE, sessionToken.String(), int(app.config.Sessions.firstExpiry().Seconds()))
+}
+
+// Keeps the cookie around for as long as the renewed session lasts
+func (app *Application) setRenewedAuthCookie(renewed db.RenewedSession, c *gin.Context) {
+	app.setCookie(c, AUTH_COOKIE, renewed.Token.String(), int(time.Until(renewed.ExpiryDate).Seconds()))
+}
+
+// Looks up the account of a session, handing the cookie the new token when
+// using the session renewed it. The session ID is kept for csrfToken.
+func (app *Application) sessionAccount(
+	c *gin.Context,
+	sessionToken uuid.UUID,
+) (currentToken uuid.UUID, account db.Accounts, err error) {
+	account, sessionID, renewed, err := app.db.GetAccountBySessionToken(sessionToken)
+	if err != nil {
+		return
+	}
+	if account.Suspended() {
+		// Treated like a session that doesn't exist, the cookie gets cleared
+		err = gorm.ErrRecordNotFound
+
+		return
+	}
+	c.Set("sessionID", sessionID)
+
+	if renewed.Renewed() {
+		app.setRenewedAuthCookie(renewed, c)
+
+		return renewed.Token, account, nil
+	}
+
+	return sessionToken, account, nil
 }
 
 func (app *Application) clearAuthCookie(c *gin.Context) {
@@ -123,8 +188,19 @@ func (app *Application) parseAuthCookie(c *gin.Context) (sessionToken uuid.UUID,
 	return uuid.Parse(rawSessionToken)
 }
 
+// Checks the session cookie, or the user the reverse proxy sent when proxy auth is on
 func (app *Application) validateAuthCookie(
 	c *gin.Context,
+) (sessionToken uuid.UUID, account db.Accounts, loggedIn bool, err error) {
+	if user, groups, ok := app.proxyUser(c); ok {
+		return app.validateProxyUser(c, user, groups)
+	}
+
+	return app.validateSessionCookie(c)
+}
+
+func (app *Application) validateSessionCookie(
+	c *gin.Context,
 ) (sessionToken uuid.UUID, account db.Accounts, loggedIn bool, err error) {
 	sessionToken, err = app.parseAuthCookie(c)
 	if err != nil {
@@ -134,7 +210,7 @@ func (app *Application) validateAuthCookie(
 		return
 	}
 
-	if account, err = app.db.GetAccountBySessionToken(sessionToken); errors.Is(err, gorm.ErrRecordNotFound) {
+	if sessionToken, account, err = app.sessionAccount(c, sessionToken); errors.Is(err, gorm.ErrRecordNotFound) {
 		err = ErrInvalidAuthCookie
 		app.clearAuthCookie(c)
 
@@ -39,6 +39,13 @@ func (app *Application) StartJobScheduler() {
 }
 
 func (app *Application) CleanUpJob(ctx context.Context) {
+	if err := app.limiterStore.removeExpired(ctx); err != nil {
+		log.Err(err).Msg("Failed to remove expired rate limits")
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
 	if err := app.db.DeleteExpiredSessionTokens(); err != nil {
 		log.Err(err).Msg("Failed to delete expired session tokens")
 	}
@@ -46,6 +53,27 @@ func (app *Application) CleanUpJob(ctx context.Context) {
 		return
 	}
 
+	if err := app.db.DeleteExpiredUploadTokens(); err != nil {
+		log.Err(err).Msg("Failed to delete expired upload tokens")
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err := app.db.DeleteOldUploadTokenUses(); err != nil {
+		log.Err(err).Msg("Failed to delete old upload token uses")
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err := app.db.DeleteExpiredAccessTokens(); err != nil {
+		log.Err(err).Msg("Failed to delete expired access tokens")
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
 	if err := app.db.DeleteExpiredInviteCodes(); err != nil {
 		log.Err(err).Msg("Failed to delete expired invite codes")
 	}
@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	csrfHeader    = "X-CSRF-Token"
+	csrfFormField = "csrf_token"
+)
+
+var ErrInvalidCSRFToken = errors.New("invalid or missing CSRF token, reload the page and try again")
+
+func getSessionID(c *gin.Context) (uint, bool) {
+	v, ok := c.Get("sessionID")
+	if !ok {
+		return 0, false
+	}
+	id, ok := v.(uint)
+
+	return id, ok
+}
+
+// Pages put this in their forms and the csrf-token meta tag. It's tied to the
+// session, so it outlives token rotation but not logging out.
+func (app *Application) csrfToken(c *gin.Context) string {
+	sessionID, ok := getSessionID(c)
+	if !ok {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, deriveKey(app.appSecret, "csrf"))
+	mac.Write([]byte(strconv.FormatUint(uint64(sessionID), 10)))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Turns away requests that change something using the session cookie unless
+// they carry the csrf token of the session, in the X-CSRF-Token header or the
+// csrf_token form field. Requests with upload or access tokens don't need it,
+// other sites can't make browsers send those.
+func (app *Application) verifyCSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+
+			return
+		}
+
+		if _, ok := getAccessToken(c); ok {
+			c.Next()
+
+			return
+		}
+		if _, ok := getUploadToken(c); ok {
+			c.Next()
+
+			return
+		}
+
+		// Routes that check the cookie themselves haven't looked it up yet
+		if _, ok := getSessionID(c); !ok {
+			_, _, loggedIn, err := app.validateAuthCookie(c)
+			if err != nil && !errors.Is(err, ErrInvalidAuthCookie) {
+				log.Err(err).Msg("validateAuthCookie failed")
+				c.AbortWithStatus(http.StatusInternalServerError)
+
+				return
+			}
+			if !loggedIn {
+				c.Next()
+
+				return
+			}
+		}
+
+		sent := c.GetHeader(csrfHeader)
+		if sent == "" && (c.ContentType() == binding.MIMEPOSTForm || c.ContentType() == binding.MIMEMultipartPOSTForm) {
+			if !parseForm(c) {
+				return
+			}
+			sent = c.PostForm(csrfFormField)
+		}
+
+		if expected := app.csrfToken(c); sent == "" || !hmac.Equal([]byte(sent), []byte(expected)) {
+			c.String(http.StatusForbidden, ErrInvalidCSRFToken.Error())
+			c.Abort()
+
+			return
+		}
+
+		c.Next()
+	}
+}
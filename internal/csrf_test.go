@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func TestCSRFTokenRequired(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	form := map[string]string{"scope": "files:read"}
+
+	client := newSessionClient(t, app, account)
+	token := client.header.Get(csrfHeader)
+
+	client.header.Del(csrfHeader)
+	if w := client.do(http.MethodPost, "/api/account/access_token", form); w.Code != http.StatusForbidden {
+		t.Fatalf("without a token: got %d, want 403", w.Code)
+	}
+
+	client.header.Set(csrfHeader, "not-the-token")
+	if w := client.do(http.MethodPost, "/api/account/access_token", form); w.Code != http.StatusForbidden {
+		t.Fatalf("wrong token: got %d, want 403", w.Code)
+	}
+
+	// The token of another session of the same account doesn't work either
+	client.header.Set(csrfHeader, newSessionClient(t, app, account).header.Get(csrfHeader))
+	if w := client.do(http.MethodPost, "/api/account/access_token", form); w.Code != http.StatusForbidden {
+		t.Fatalf("another session's token: got %d, want 403", w.Code)
+	}
+
+	client.header.Set(csrfHeader, token)
+	if w := client.do(http.MethodPost, "/api/account/access_token", form); w.Code != http.StatusOK {
+		t.Fatalf("header: got %d, want 200", w.Code)
+	}
+
+	// Plain forms send it as a field
+	client.header.Del(csrfHeader)
+	if w := client.do(http.MethodPost, "/api/account/access_token", map[string]string{"scope": "files:read", csrfFormField: token}); w.Code != http.StatusOK {
+		t.Fatalf("form field: got %d, want 200", w.Code)
+	}
+
+	// Reading doesn't need it
+	if w := client.do(http.MethodGet, "/api/account/files", nil); w.Code != http.StatusOK {
+		t.Fatalf("GET without a token: got %d, want 200", w.Code)
+	}
+}
+
+// Other sites can't log people out either
+func TestCSRFLogout(t *testing.T) {
+	app := newTestApp(t)
+	client := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeUser))
+	token := client.header.Get(csrfHeader)
+
+	client.header.Del(csrfHeader)
+	if w := client.do(http.MethodPost, "/logout", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("logout without a token: got %d, want 403", w.Code)
+	}
+	if !loggedIn(client) {
+		t.Fatal("logged out without a token")
+	}
+
+	client.header.Set(csrfHeader, token)
+	if w := client.do(http.MethodPost, "/logout", nil); w.Code != http.StatusSeeOther {
+		t.Fatalf("logout: got %d, want 303", w.Code)
+	}
+	if loggedIn(client) {
+		t.Fatal("still logged in")
+	}
+}
+
+func TestCSRFAdminAPI(t *testing.T) {
+	app := newTestApp(t)
+	admin := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeAdmin))
+	user := newTestAccount(t, app, db.AccountTypeUser)
+
+	admin.header.Del(csrfHeader)
+	if code := setAccountType(admin, user.ID, db.AccountTypeAdmin); code != http.StatusForbidden {
+		t.Fatalf("got %d, want 403", code)
+	}
+	if account, err := app.db.GetAccountByID(user.ID); err != nil || account.AccountType != db.AccountTypeUser {
+		t.Fatalf("account type changed without a token: %s %v", account.AccountType, err)
+	}
+}
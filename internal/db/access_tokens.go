@@ -0,0 +1,141 @@
+package db
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Personal access tokens, unlike upload tokens these can be used on the rest
+// of the api depending on their scopes.
+type AccessTokens struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	LastUsed *time.Time
+	Nickname string
+
+	TokenHash   string `gorm:"uniqueIndex"` // See Database.hashToken
+	TokenPrefix string
+
+	Scopes     string     // Space separated list of scopes
+	ExpiryDate *time.Time `gorm:"default:null;index"` // nil never expires
+	AllowedIPs string     // Comma separated IPs or CIDRs the token can be used from, empty allows any
+
+	AccountID uint     `gorm:"index"`
+	Account   Accounts `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+}
+
+func (t AccessTokens) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+func (t AccessTokens) HasScope(scope string) bool {
+	return slices.Contains(t.ScopeList(), scope)
+}
+
+func (t AccessTokens) AllowedIPList() (ips []string) {
+	for ip := range strings.SplitSeq(t.AllowedIPs, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+
+	return
+}
+
+type CreateAccessTokenInput struct {
+	AccountID  uint
+	Token      string
+	Nickname   string
+	Scopes     []string
+	ExpiryDate *time.Time
+	AllowedIPs []string
+}
+
+func (db *Database) CreateAccessToken(input CreateAccessTokenInput) (token AccessTokens, err error) {
+	token = AccessTokens{
+		AccountID:   input.AccountID,
+		TokenHash:   db.hashToken(input.Token),
+		TokenPrefix: tokenPrefix(input.Token),
+		Nickname:    input.Nickname,
+		Scopes:      strings.Join(input.Scopes, " "),
+		ExpiryDate:  input.ExpiryDate,
+		AllowedIPs:  strings.Join(input.AllowedIPs, ","),
+	}
+
+	err = db.Model(&AccessTokens{}).Create(&token).Error
+
+	return
+}
+
+func (db *Database) GetAccessTokens(accountID uint) (tokens []AccessTokens, err error) {
+	err = db.Model(&AccessTokens{}).
+		Where("account_id = ?", accountID).
+		Order("created_at DESC").
+		Find(&tokens).Error
+
+	return
+}
+
+func (db *Database) GetAccessTokensCount(accountID uint) (count int64, err error) {
+	err = db.Model(&AccessTokens{}).
+		Where("account_id = ?", accountID).
+		Count(&count).Error
+
+	return
+}
+
+func (db *Database) DeleteAccessToken(accountID uint, tokenID uint) (err error) {
+	result := db.Where("account_id = ? AND id = ?", accountID, tokenID).
+		Delete(&AccessTokens{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (db *Database) DeleteAccessTokensFromAccount(accountID uint) (err error) {
+	return db.Where("account_id = ?", accountID).
+		Delete(&AccessTokens{}).Error
+}
+
+func (db *Database) DeleteExpiredAccessTokens() (err error) {
+	return db.Where("expiry_date IS NOT NULL AND expiry_date < ?", time.Now()).
+		Delete(&AccessTokens{}).Error
+}
+
+// Looks up a non-expired access token and the account it belongs to
+func (db *Database) GetAccountByAccessToken(rawToken string) (token AccessTokens, account Accounts, err error) {
+	now := time.Now()
+
+	if err = db.Model(&AccessTokens{}).
+		Where("token_hash = ?", db.hashToken(rawToken)).
+		Where("expiry_date IS NULL OR expiry_date > ?", now).
+		First(&token).Error; err != nil {
+		return
+	}
+
+	if err = db.Model(&Accounts{}).
+		Where("id = ?", token.AccountID).
+		First(&account).Error; err != nil {
+		return
+	}
+
+	if updErr := db.Model(&AccessTokens{}).
+		Where("id = ?", token.ID).
+		Where("last_used IS NULL OR last_used < ?", now.Add(-lastUsedDebounce)).
+		Update("last_used", now).Error; updErr != nil {
+		log.Err(updErr).Msg("Failed to update last used time for access token")
+	}
+
+	return
+}
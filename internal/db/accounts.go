@@ -3,12 +3,13 @@ package db
 import (
 	"database/sql"
 	"errors"
-	"strconv"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Accounts struct {
@@ -16,18 +17,38 @@ type Accounts struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 
-	// GithubID is 0 when unlinked, otherwise the account's GitHub user id.
-	// Partial unique index prevents two accounts from linking the same GitHub identity.
-	GithubID       uint `gorm:"uniqueIndex:idx_accounts_github_id,where:github_id <> 0"`
-	GithubUsername string
+	// Login provider identities are kept in LinkedIdentities
 
-	// OIDCID is empty when unlinked, same uniqueness guarantee as above.
-	OIDCID       string `gorm:"column:oidc_id;uniqueIndex:idx_accounts_oidc_id,where:oidc_id <> ''"`
-	OIDCUsername string `gorm:"column:oidc_username"`
+	// Local login, Username is empty when the account has no password
+	Username     string `gorm:"uniqueIndex:idx_accounts_username,where:username <> ''"`
+	PasswordHash string `json:"-"` // Argon2id in the PHC string format
 
 	InvitedBy uint // Account ID of the user who invited this account
 
-	AccountType string // Either "USER" or "ADMIN"
+	AccountType string // One of AccountTypes
+
+	// Quota overrides set by admins, nil uses the instance default and 0 is unlimited
+	QuotaBytes         *int64
+	QuotaFiles         *int64
+	QuotaMaxFileSize   *int64
+	QuotaMaxExpiryDays *int64
+
+	// Two-factor authentication, the secret is encrypted by the app and is
+	// only asked for once the first code has been confirmed.
+	TOTPSecret   string `gorm:"column:totp_secret" json:"-"`
+	TOTPEnabled  bool   `gorm:"column:totp_enabled"`
+	TOTPLastStep int64  `gorm:"column:totp_last_step"` // Last accepted time step, stops codes from being reused
+
+	// Set by admins, see Suspended
+	SuspendedAt          *time.Time
+	SuspendedUntil       *time.Time // nil lasts until an admin lifts it
+	SuspensionReason     string
+	SuspensionHidesFiles bool // Public files stop being served while suspended
+
+	// Accounts that registered themselves while registration needs approval
+	// can't log in until an admin approves them, see PendingApproval
+	PendingApproval   bool
+	RegistrationEmail string // Email the login provider gave when it registered
 }
 
 // Returns number of accounts in the database
@@ -38,125 +59,6 @@ func (db *Database) AccountAmount() (count int64, err error) {
 	return
 }
 
-func (db *Database) FindAccountByGithubID(rawID string) (account Accounts, err error) {
-	id, err := strconv.ParseUint(rawID, 10, 0)
-	if err != nil {
-		return
-	}
-	if id == 0 {
-		err = gorm.ErrRecordNotFound
-
-		return
-	}
-
-	if err = db.Model(&Accounts{}).
-		Where("github_id = ?", uint(id)).
-		First(&account).Error; err != nil {
-		return
-	}
-
-	return
-}
-
-func (db *Database) UpdateGithubUsername(accountID uint, username string) (err error) {
-	return db.Model(&Accounts{}).
-		Where("id = ?", accountID).
-		Update("github_username", username).Error
-}
-
-var ErrProviderAlreadyLinked = errors.New("provider identity already linked to another account")
-
-func (db *Database) LinkGithub(accountID uint, username string, rawGithubID string) (err error) {
-	githubID, err := strconv.ParseUint(rawGithubID, 10, 0)
-	if err != nil {
-		return
-	}
-
-	return db.Transaction(func(tx *gorm.DB) error {
-		var existingID uint
-		lookupErr := tx.Model(&Accounts{}).
-			Where("github_id = ?", uint(githubID)).
-			Select("id").
-			First(&existingID).Error
-		if lookupErr == nil && existingID != accountID {
-			return ErrProviderAlreadyLinked
-		}
-		if lookupErr != nil && !errors.Is(lookupErr, gorm.ErrRecordNotFound) {
-			return lookupErr
-		}
-
-		return tx.Model(&Accounts{}).
-			Where("id = ?", accountID).
-			Updates(map[string]interface{}{
-				"github_username": username,
-				"github_id":       uint(githubID),
-			}).Error
-	})
-}
-
-func (db *Database) FindAccountByOIDCID(oidcID string) (account Accounts, err error) {
-	if oidcID == "" {
-		err = gorm.ErrRecordNotFound
-
-		return
-	}
-
-	if err = db.Model(&Accounts{}).
-		Where("oidc_id = ?", oidcID).
-		First(&account).Error; err != nil {
-		return
-	}
-
-	return
-}
-
-func (db *Database) UpdateOIDCUsername(accountID uint, username string) (err error) {
-	return db.Model(&Accounts{}).
-		Where("id = ?", accountID).
-		Update("oidc_username", username).Error
-}
-
-func (db *Database) UnlinkGithub(accountID uint) error {
-	return db.Model(&Accounts{}).
-		Where("id = ?", accountID).
-		Updates(map[string]interface{}{
-			"github_id":       0,
-			"github_username": "",
-		}).Error
-}
-
-func (db *Database) UnlinkOIDC(accountID uint) error {
-	return db.Model(&Accounts{}).
-		Where("id = ?", accountID).
-		Updates(map[string]interface{}{
-			"oidc_id":       "",
-			"oidc_username": "",
-		}).Error
-}
-
-func (db *Database) LinkOIDC(accountID uint, username string, oidcID string) (err error) {
-	return db.Transaction(func(tx *gorm.DB) error {
-		var existingID uint
-		lookupErr := tx.Model(&Accounts{}).
-			Where("oidc_id = ?", oidcID).
-			Select("id").
-			First(&existingID).Error
-		if lookupErr == nil && existingID != accountID {
-			return ErrProviderAlreadyLinked
-		}
-		if lookupErr != nil && !errors.Is(lookupErr, gorm.ErrRecordNotFound) {
-			return lookupErr
-		}
-
-		return tx.Model(&Accounts{}).
-			Where("id = ?", accountID).
-			Updates(map[string]interface{}{
-				"oidc_username": username,
-				"oidc_id":       oidcID,
-			}).Error
-	})
-}
-
 type AccountStats struct {
 	FilesUploaded     int64
 	SpaceUsed         uint
@@ -328,29 +230,36 @@ func (db *Database) LastAccountActivity(accountID uint) (time.Time, error) {
 // write on every authenticated request.
 const lastUsedDebounce = time.Minute
 
-func (db *Database) GetAccountBySessionToken(sessionToken uuid.UUID) (account Accounts, err error) {
+// Refreshing last_used also renews the session, which rotates its token.
+// renewed tells the caller about the new token and expiry.
+func (db *Database) GetAccountBySessionToken(
+	sessionToken uuid.UUID,
+) (account Accounts, sessionID uint, renewed RenewedSession, err error) {
 	now := time.Now()
 
-	var accountID uint
+	var session SessionTokens
 	if err = db.Model(&SessionTokens{}).
-		Where("token = ?", sessionToken).
+		Where(db.whereSessionToken(sessionToken, now)).
 		Where("expiry_date > ?", now).
-		Select("account_id").
-		First(&accountID).Error; err != nil {
+		First(&session).Error; err != nil {
 		return
 	}
 
 	if err = db.Model(&Accounts{}).
-		Where("id = ?", accountID).
+		Where("id = ?", session.AccountID).
 		First(&account).Error; err != nil {
 		return
 	}
+	sessionID = session.ID
 
-	if updErr := db.Model(&SessionTokens{}).
-		Where("token = ?", sessionToken).
-		Where("last_used < ?", now.Add(-lastUsedDebounce)).
-		Update("last_used", now).Error; updErr != nil {
-		log.Err(updErr).Msg("Failed to update last used time for session token")
+	// Requests still using the previous token don't get to rotate it again
+	if session.TokenHash != db.hashToken(sessionToken.String()) || !session.LastUsed.Before(now.Add(-lastUsedDebounce)) {
+		return
+	}
+
+	if renewed, err = db.renewSession(session, now); err != nil {
+		log.Err(err).Msg("Failed to renew session")
+		err = nil
 	}
 
 	return
@@ -358,10 +267,12 @@ func (db *Database) GetAccountBySessionToken(sessionToken uuid.UUID) (account Ac
 
 func (db *Database) GetAccountByUploadToken(uploadToken uuid.UUID) (account Accounts, err error) {
 	now := time.Now()
+	tokenHash := db.hashToken(uploadToken.String())
 
 	var accountID uint
 	if err = db.Model(&UploadTokens{}).
-		Where("token = ?", uploadToken).
+		Where("token_hash = ?", tokenHash).
+		Where("expiry_date IS NULL OR expiry_date > ?", now).
 		Select("account_id").
 		First(&accountID).Error; err != nil {
 		return
@@ -374,7 +285,7 @@ func (db *Database) GetAccountByUploadToken(uploadToken uuid.UUID) (account Acco
 	}
 
 	if updErr := db.Model(&UploadTokens{}).
-		Where("token = ?", uploadToken).
+		Where("token_hash = ?", tokenHash).
 		Where("last_used IS NULL OR last_used < ?", now.Add(-lastUsedDebounce)).
 		Update("last_used", now).Error; updErr != nil {
 		log.Err(updErr).Msg("Failed to update last used time for upload token")
@@ -383,8 +294,19 @@ func (db *Database) GetAccountByUploadToken(uploadToken uuid.UUID) (account Acco
 	return
 }
 
+// Returns ErrLastAdmin instead of deleting the only working admin left
 func (db *Database) DeleteAccount(accountID uint) error {
-	return db.Delete(&Accounts{}, accountID).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		last, err := lastAdminLocked(tx, accountID)
+		if err != nil {
+			return err
+		}
+		if last {
+			return ErrLastAdmin
+		}
+
+		return tx.Delete(&Accounts{}, accountID).Error
+	})
 }
 
 func (db *Database) GetAccounts() (accounts []Accounts, err error) {
@@ -394,6 +316,18 @@ func (db *Database) GetAccounts() (accounts []Accounts, err error) {
 	return
 }
 
+func (db *Database) GetAccountsByIDs(accountIDs []uint) (accounts []Accounts, err error) {
+	if len(accountIDs) == 0 {
+		return
+	}
+
+	err = db.Model(&Accounts{}).
+		Where("id IN ?", accountIDs).
+		Scan(&accounts).Error
+
+	return
+}
+
 func (db *Database) GetAccountByID(accountID uint) (account Accounts, err error) {
 	err = db.Model(&Accounts{}).
 		Where("id = ?", accountID).
@@ -402,10 +336,28 @@ func (db *Database) GetAccountByID(accountID uint) (account Accounts, err error)
 	return
 }
 
-var ErrInvalidAccountType = errors.New("invalid account type specified")
+// Account types, what each one is allowed to do is up to the app
+const (
+	AccountTypeAdmin     = "ADMIN"
+	AccountTypeModerator = "MODERATOR"
+	AccountTypeUser      = "USER"
+	AccountTypeGuest     = "GUEST"
+)
+
+// From the most to the least allowed
+var AccountTypes = []string{AccountTypeAdmin, AccountTypeModerator, AccountTypeUser, AccountTypeGuest}
+
+func ValidAccountType(accountType string) bool {
+	return slices.Contains(AccountTypes, accountType)
+}
+
+var (
+	ErrInvalidAccountType = errors.New("invalid account type specified")
+	ErrLastAdmin          = errors.New("the last admin can't be removed, make someone else an admin first")
+)
 
 func (db *Database) CreateAccount(accountType string, invitedBy uint) (account Accounts, err error) {
-	if accountType == "ADMIN" || accountType == "USER" {
+	if ValidAccountType(accountType) {
 		account = Accounts{
 			AccountType: accountType,
 			InvitedBy:   invitedBy,
@@ -419,21 +371,106 @@ func (db *Database) CreateAccount(accountType string, invitedBy uint) (account A
 	return
 }
 
-func (db *Database) RegisterWithInviteCode(code string) (account Accounts, sessionToken uuid.UUID, err error) {
+// Returns ErrLastAdmin instead of demoting the only working admin left
+func (db *Database) SetAccountType(accountID uint, accountType string) error {
+	if !ValidAccountType(accountType) {
+		return ErrInvalidAccountType
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if accountType != AccountTypeAdmin {
+			last, err := lastAdminLocked(tx, accountID)
+			if err != nil {
+				return err
+			}
+			if last {
+				return ErrLastAdmin
+			}
+		}
+
+		result := tx.Model(&Accounts{}).
+			Where("id = ?", accountID).
+			Update("account_type", accountType)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// Whether the account is the only admin that isn't suspended, removing it
+// would leave nobody to run the instance. A quick check before doing
+// anything, DeleteAccount and SetAccountType check again under a lock.
+func (db *Database) IsLastAdmin(accountID uint) (last bool, err error) {
 	err = db.Transaction(func(tx *gorm.DB) error {
-		txDB := &Database{DB: tx}
+		last, err = lastAdminLocked(tx, accountID)
+
+		return err
+	})
+
+	return
+}
 
-		accountType, invitedBy, useErr := txDB.UseCode(code)
+// Locks every admin row until the transaction ends, so two admins demoting
+// or deleting each other at once can't both get through. Suspended admins
+// can't run the instance, so they don't count.
+func lastAdminLocked(tx *gorm.DB, accountID uint) (last bool, err error) {
+	var admins []Accounts
+	if err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("account_type = ?", AccountTypeAdmin).
+		Order("id").
+		Find(&admins).Error; err != nil {
+		return
+	}
+
+	isAdmin := false
+	for _, admin := range admins {
+		if admin.ID == accountID {
+			isAdmin = true
+		} else if !admin.Suspended() {
+			return false, nil
+		}
+	}
+
+	return isAdmin, nil
+}
+
+func (db *Database) RegisterWithInviteCode(
+	code string,
+	info SessionInfo,
+) (account Accounts, sessionToken uuid.UUID, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		txDB := db.withTx(tx)
+
+		inviteCode, useErr := txDB.UseCode(code)
 		if useErr != nil {
 			return useErr
 		}
 
-		acc, createErr := txDB.CreateAccount(accountType, invitedBy)
+		acc, createErr := txDB.CreateAccount(inviteCode.AccountType, inviteCode.InviteCreatorID)
 		if createErr != nil {
 			return createErr
 		}
 
-		token, tokenErr := txDB.CreateSessionToken(acc.ID)
+		if quota := inviteCode.Quota(); quota != (SetAccountQuotaInput{}) {
+			if quotaErr := txDB.SetAccountQuota(acc.ID, quota); quotaErr != nil {
+				return quotaErr
+			}
+			acc.QuotaBytes = quota.Bytes
+			acc.QuotaFiles = quota.Files
+			acc.QuotaMaxFileSize = quota.MaxFileSize
+			acc.QuotaMaxExpiryDays = quota.MaxExpiryDays
+		}
+
+		if redeemErr := txDB.CreateInviteCodeRedemption(inviteCode.ID, acc.ID); redeemErr != nil {
+			return redeemErr
+		}
+
+		token, _, tokenErr := txDB.CreateSessionToken(acc.ID, info)
 		if tokenErr != nil {
 			return tokenErr
 		}
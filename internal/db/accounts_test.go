@@ -0,0 +1,102 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestLastAdminCantBeDemotedOrDeleted(t *testing.T) {
+	database := newTestDB(t)
+	admin := newTestAccount(t, database, AccountTypeAdmin)
+
+	if err := database.SetAccountType(admin.ID, AccountTypeUser); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("demoting the last admin: got %v, want ErrLastAdmin", err)
+	}
+	if err := database.DeleteAccount(admin.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("deleting the last admin: got %v, want ErrLastAdmin", err)
+	}
+	if err := database.SetAccountType(admin.ID, AccountTypeAdmin); err != nil {
+		t.Fatalf("keeping the last admin an admin: %v", err)
+	}
+
+	other := newTestAccount(t, database, AccountTypeAdmin)
+	if err := database.SetAccountType(admin.ID, AccountTypeModerator); err != nil {
+		t.Fatalf("demoting with another admin left: %v", err)
+	}
+	if err := database.DeleteAccount(other.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("deleting the admin left: got %v, want ErrLastAdmin", err)
+	}
+
+	if err := database.SetAccountType(9999, AccountTypeUser); err == nil {
+		t.Fatal("changed the type of an account that doesn't exist")
+	}
+}
+
+// A suspended admin can't log in, so it doesn't keep the instance running
+func TestSuspendedAdminsDontCount(t *testing.T) {
+	database := newTestDB(t)
+	admin := newTestAccount(t, database, AccountTypeAdmin)
+	suspended := newTestAccount(t, database, AccountTypeModerator)
+	if err := database.SuspendAccount(suspended.ID, SuspendAccountInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SetAccountType(suspended.ID, AccountTypeAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := database.IsLastAdmin(admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last {
+		t.Fatal("suspended admin counted as another admin")
+	}
+	if err = database.SetAccountType(admin.ID, AccountTypeUser); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("demoting the last working admin: got %v, want ErrLastAdmin", err)
+	}
+}
+
+func TestAdminsCantBeSuspended(t *testing.T) {
+	database := newTestDB(t)
+	admin := newTestAccount(t, database, AccountTypeAdmin)
+
+	if err := database.SuspendAccount(admin.ID, SuspendAccountInput{}); !errors.Is(err, ErrCantSuspendAdmin) {
+		t.Fatalf("got %v, want ErrCantSuspendAdmin", err)
+	}
+}
+
+// Two admins demoting each other at once mustn't both get through
+func TestAdminsDemotingEachOther(t *testing.T) {
+	database := newTestDB(t)
+
+	for range 10 {
+		first := newTestAccount(t, database, AccountTypeAdmin)
+		second := newTestAccount(t, database, AccountTypeAdmin)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i, id := range []uint{first.ID, second.ID} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = database.SetAccountType(id, AccountTypeUser)
+			}()
+		}
+		wg.Wait()
+
+		var admins int64
+		if err := database.Model(&Accounts{}).Where("account_type = ?", AccountTypeAdmin).Count(&admins).Error; err != nil {
+			t.Fatal(err)
+		}
+		if admins != 1 {
+			t.Fatalf("%d admins left after demoting each other (%v), want 1", admins, errs)
+		}
+
+		// Start the next round with no admins
+		if err := database.Model(&Accounts{}).Where("account_type = ?", AccountTypeAdmin).
+			Update("account_type", AccountTypeUser).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
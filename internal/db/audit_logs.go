@@ -0,0 +1,103 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Record of who did what, hostling only ever adds rows. Nothing stops
+// someone with access to the database from changing them. Accounts aren't
+// foreign keys so the entries outlive the accounts they mention.
+type AuditLogs struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+
+	ActorID  uint   `gorm:"index"` // Account doing it, 0 when nobody was logged in
+	TargetID uint   `gorm:"index"` // Account it was done to, 0 when there's none
+	Action   string `gorm:"index"`
+	Detail   string // Token names, file names, providers and such
+	IPHash   string // Same hash as on sessions
+}
+
+func (db *Database) CreateAuditLog(entry AuditLogs) error {
+	return db.Model(&AuditLogs{}).Create(&entry).Error
+}
+
+type AuditLogFilter struct {
+	Action    string
+	AccountID uint       // Entries where the account is the actor or the target
+	Since     *time.Time // Inclusive
+	Until     *time.Time // Exclusive
+}
+
+func (db *Database) auditLogQuery(filter AuditLogFilter) *gorm.DB {
+	q := db.Model(&AuditLogs{})
+
+	if filter.Action != "" {
+		q = q.Where("action = ?", filter.Action)
+	}
+	if filter.AccountID != 0 {
+		q = q.Where("actor_id = ? OR target_id = ?", filter.AccountID, filter.AccountID)
+	}
+	if filter.Since != nil {
+		q = q.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		q = q.Where("created_at < ?", *filter.Until)
+	}
+
+	return q
+}
+
+// Newest first
+func (db *Database) GetAuditLogsPaginated(
+	filter AuditLogFilter,
+	skip, limit uint,
+) (entries []AuditLogs, totalCount int64, err error) {
+	if limit > MaxPaginationLimit {
+		limit = MaxPaginationLimit
+	}
+
+	if err = db.auditLogQuery(filter).Count(&totalCount).Error; err != nil {
+		return
+	}
+
+	err = db.auditLogQuery(filter).
+		Order("id DESC").
+		Offset(int(skip)).
+		Limit(int(limit)).
+		Find(&entries).Error
+
+	return
+}
+
+// Entries the export reads at a time
+const auditLogBatchSize = 500
+
+// Calls fn with every matching entry, newest first, a batch at a time so
+// exporting a big log doesn't load all of it at once
+func (db *Database) EachAuditLogBatch(filter AuditLogFilter, fn func(entries []AuditLogs) error) error {
+	var beforeID uint
+	for {
+		q := db.auditLogQuery(filter)
+		if beforeID != 0 {
+			q = q.Where("id < ?", beforeID)
+		}
+
+		var entries []AuditLogs
+		if err := q.Order("id DESC").Limit(auditLogBatchSize).Find(&entries).Error; err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		if err := fn(entries); err != nil {
+			return err
+		}
+		if len(entries) < auditLogBatchSize {
+			return nil
+		}
+		beforeID = entries[len(entries)-1].ID
+	}
+}
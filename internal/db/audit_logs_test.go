@@ -0,0 +1,65 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestEachAuditLogBatch(t *testing.T) {
+	database := newTestDB(t)
+
+	total := auditLogBatchSize*2 + 7
+	for i := range total {
+		action := "login"
+		if i%2 == 0 {
+			action = "logout"
+		}
+		if err := database.CreateAuditLog(AuditLogs{ActorID: uint(i%3 + 1), Action: action}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []uint
+	batches := 0
+	if err := database.EachAuditLogBatch(AuditLogFilter{}, func(entries []AuditLogs) error {
+		batches++
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != total || batches != 3 {
+		t.Fatalf("got %d entries in %d batches, want %d in 3", len(ids), batches, total)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] >= ids[i-1] {
+			t.Fatalf("entry %d came after %d, want newest first", ids[i], ids[i-1])
+		}
+	}
+
+	// Filters hold across batches, the OR of the account filter included
+	count := 0
+	if err := database.EachAuditLogBatch(AuditLogFilter{Action: "login", AccountID: 1}, func(entries []AuditLogs) error {
+		for _, entry := range entries {
+			if entry.Action != "login" || entry.ActorID != 1 {
+				t.Fatalf("entry %+v doesn't match the filter", entry)
+			}
+		}
+		count += len(entries)
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := 0
+	for i := range total {
+		if i%2 != 0 && i%3 == 0 {
+			want++
+		}
+	}
+	if count != want {
+		t.Fatalf("got %d filtered entries, want %d", count, want)
+	}
+}
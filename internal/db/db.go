@@ -1,9 +1,63 @@
 package db
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type Database struct {
 	*gorm.DB
+
+	tokenKey []byte // HMAC key tokens are hashed with before they hit the database
+
+	sessionIdleTimeout time.Duration // Sessions unused this long expire
+	sessionLifetime    time.Duration // Longest a session lasts however much it's used
+}
+
+func (db *Database) SetTokenKey(key []byte) {
+	db.tokenKey = key
+}
+
+// Same settings, running on the transaction
+func (db *Database) withTx(tx *gorm.DB) *Database {
+	txDB := *db
+	txDB.DB = tx
+
+	return &txDB
+}
+
+func (db *Database) SetSessionLifetime(idleTimeout time.Duration, lifetime time.Duration) {
+	db.sessionIdleTimeout = idleTimeout
+	db.sessionLifetime = lifetime
+}
+
+// Upload, session and access tokens are only stored as a keyed hash so a
+// leaked database doesn't hand out working credentials.
+func (db *Database) hashToken(rawToken string) string {
+	if len(db.tokenKey) == 0 {
+		panic("db: token key not set")
+	}
+
+	mac := hmac.New(sha256.New, db.tokenKey)
+	mac.Write([]byte(rawToken))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+const tokenPrefixLength = 8
+
+// Start of the token kept in plain text so users can tell their tokens apart,
+// a leading "xxx_" type marker doesn't count towards the length.
+func tokenPrefix(rawToken string) string {
+	n := tokenPrefixLength
+	if i := strings.IndexByte(rawToken, '_'); i >= 0 && i < n {
+		n += i + 1
+	}
+
+	return rawToken[:min(n, len(rawToken))]
 }
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	hostling "github.com/BatteredBunny/hostling"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Fresh sqlite database with every migration applied, opened with the same
+// options the app uses
+func newTestDB(t *testing.T) *Database {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "hostling.db") + "?_foreign_keys=on&_txlock=immediate&_busy_timeout=5000"
+	gormDB, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := gormDB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	migrations, err := fs.Glob(hostling.MigrationFiles, "migrations/sqlite/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range migrations {
+		migration, err := fs.ReadFile(hostling.MigrationFiles, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = gormDB.Exec(string(migration)).Error; err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	database := &Database{DB: gormDB}
+	database.SetTokenKey([]byte("test-token-key-test-token-key-00"))
+	database.SetSessionLifetime(time.Hour, 24*time.Hour)
+
+	return database
+}
+
+func newTestAccount(t *testing.T, database *Database, accountType string) Accounts {
+	t.Helper()
+
+	account, err := database.CreateAccount(accountType, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return account
+}
@@ -27,6 +27,8 @@ type Files struct {
 
 	ExpiryDate time.Time `gorm:"default:null;index"` // Time when the file will be deleted
 
+	UploadTokenID *uint `json:"-" gorm:"index"` // Upload token the file came from, used for token quotas
+
 	UploaderID uint     `json:"-" gorm:"index"`
 	Uploader   Accounts `json:"-" gorm:"foreignKey:UploaderID;constraint:OnDelete:CASCADE"`
 
@@ -44,6 +46,17 @@ func (db *Database) AccountOwnsFile(fileName string, accountID uint) (owned bool
 	return
 }
 
+// Reports whether any file, expired or not, already uses the name.
+func (db *Database) FileNameTaken(fileName string) (taken bool, err error) {
+	var count int64
+	err = db.Model(&Files{}).
+		Where("file_name = ?", fileName).
+		Count(&count).Error
+	taken = count > 0
+
+	return
+}
+
 // Deletes file entry from database
 func (db *Database) DeleteFileEntry(fileName string, accountID uint) error {
 	return db.Where("file_name = ? AND uploader_id = ?", fileName, accountID).
@@ -53,8 +66,11 @@ func (db *Database) DeleteFileEntry(fileName string, accountID uint) error {
 type CreateFileEntryInput struct {
 	Files Files
 
-	UploadToken  uuid.NullUUID
-	SessionToken uuid.NullUUID
+	UploadToken   uuid.NullUUID
+	SessionToken  uuid.NullUUID
+	AccessTokenID uint
+
+	QuotaDefaults AccountQuota // Instance quota for accounts without their own
 }
 
 var ErrNotAuthenticated = errors.New("not authenticated")
@@ -68,15 +84,27 @@ func (db *Database) CreateFileEntry(input CreateFileEntryInput) error {
 		switch {
 		case input.SessionToken.Valid:
 			if err := tx.Model(&SessionTokens{}).
-				Where(&SessionTokens{Token: input.SessionToken.UUID}).
+				Where(db.whereSessionToken(input.SessionToken.UUID, now)).
 				Where("expiry_date > ?", now).
 				Select("account_id").
 				First(&accountID).Error; err != nil {
 				return err
 			}
 		case input.UploadToken.Valid:
+			var token UploadTokens
 			if err := tx.Model(&UploadTokens{}).
-				Where(&UploadTokens{Token: input.UploadToken.UUID}).
+				Where("token_hash = ?", db.hashToken(input.UploadToken.UUID.String())).
+				Where("expiry_date IS NULL OR expiry_date > ?", now).
+				Select("id, account_id").
+				First(&token).Error; err != nil {
+				return err
+			}
+			accountID = token.AccountID
+			input.Files.UploadTokenID = &token.ID
+		case input.AccessTokenID != 0:
+			if err := tx.Model(&AccessTokens{}).
+				Where("id = ?", input.AccessTokenID).
+				Where("expiry_date IS NULL OR expiry_date > ?", now).
 				Select("account_id").
 				First(&accountID).Error; err != nil {
 				return err
@@ -87,6 +115,15 @@ func (db *Database) CreateFileEntry(input CreateFileEntryInput) error {
 
 		input.Files.UploaderID = accountID
 
+		if err := checkQuotaLocked(tx, accountID, input.QuotaDefaults, &input.Files); err != nil {
+			return err
+		}
+		if input.Files.UploadTokenID != nil {
+			if err := useUploadTokenLocked(tx, *input.Files.UploadTokenID, int64(input.Files.FileSize)); err != nil {
+				return err
+			}
+		}
+
 		tags := input.Files.Tags
 		input.Files.Tags = nil
 
@@ -105,14 +142,19 @@ func (db *Database) CreateFileEntry(input CreateFileEntryInput) error {
 			}
 		}
 
-		if input.SessionToken.Valid {
+		switch {
+		case input.SessionToken.Valid:
 			return tx.Model(&SessionTokens{}).
-				Where(&SessionTokens{Token: input.SessionToken.UUID}).
+				Where(db.whereSessionToken(input.SessionToken.UUID, now)).
+				Update("last_used", now).Error
+		case input.AccessTokenID != 0:
+			return tx.Model(&AccessTokens{}).
+				Where("id = ?", input.AccessTokenID).
 				Update("last_used", now).Error
 		}
 
 		return tx.Model(&UploadTokens{}).
-			Where(&UploadTokens{Token: input.UploadToken.UUID}).
+			Where("token_hash = ?", db.hashToken(input.UploadToken.UUID.String())).
 			Update("last_used", now).Error
 	})
 }
@@ -155,10 +197,15 @@ func (db *Database) GetFileStats(accountID uint) (totalFiles uint, totalStorage
 	return
 }
 
+// Uploader only has its suspension loaded, enough to tell whether the file
+// is hidden without another query on every view. The join makes gorm list
+// every column, so views_count has to be left out.
 func (db *Database) GetFileByName(fileName string) (file Files, err error) {
 	err = db.Model(&Files{}).
-		Where("file_name = ?", fileName).
-		Where("(expiry_date is not null AND expiry_date > ?) OR expiry_date is null", time.Now()).
+		Omit("views_count").
+		Joins("Uploader", db.Select("id", "suspended_at", "suspended_until", "suspension_hides_files")).
+		Where("files.file_name = ?", fileName).
+		Where("(files.expiry_date is not null AND files.expiry_date > ?) OR files.expiry_date is null", time.Now()).
 		First(&file).Error
 
 	return
@@ -240,6 +287,31 @@ func (db *Database) GetFilesPaginatedFromAccount(
 	return
 }
 
+// Public files of every account for moderation, newest first
+func (db *Database) GetPublicFilesPaginated(skip, limit uint) (files []Files, totalCount int64, err error) {
+	if limit > MaxPaginationLimit {
+		limit = MaxPaginationLimit
+	}
+
+	baseQuery := func() *gorm.DB {
+		return db.Model(&Files{}).
+			Where("public = ?", true).
+			Where("expiry_date IS NULL OR expiry_date > ?", time.Now())
+	}
+
+	if err = baseQuery().Count(&totalCount).Error; err != nil {
+		return
+	}
+
+	err = baseQuery().
+		Order("id DESC").
+		Offset(int(skip)).
+		Limit(int(limit)).
+		Find(&files).Error
+
+	return
+}
+
 func (db *Database) ToggleFilePublic(fileName string, accountID uint) (newPublicStatus bool, err error) {
 	err = db.Transaction(func(tx *gorm.DB) error {
 		result := tx.Model(&Files{}).
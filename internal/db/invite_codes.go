@@ -16,10 +16,47 @@ type InviteCodes struct {
 	Code        string `gorm:"uniqueIndex"`
 	Uses        uint   // How many usages of this code is left
 	ExpiryDate  time.Time
-	AccountType string // Either registers normal or admin users
-
+	AccountType string // Account type people registering with it get
+	Note        string // Label the creator gave it, e.g who it was meant for
+
+	// Quota the registered accounts get, nil fields keep the instance default.
+	// Copied from the preset when the code is made so config changes don't
+	// change what a handed out code gives.
+	QuotaPreset        string // Name of the preset, empty when there's none
+	QuotaBytes         *int64
+	QuotaFiles         *int64
+	QuotaMaxFileSize   *int64
+	QuotaMaxExpiryDays *int64
+
+	// Left empty when the creator is deleted, the code stays for its redemptions
 	InviteCreatorID uint     `gorm:"default:null;index"`
-	InviteCreator   Accounts `gorm:"foreignKey:InviteCreatorID;constraint:OnDelete:CASCADE"`
+	InviteCreator   Accounts `gorm:"foreignKey:InviteCreatorID;constraint:OnDelete:SET NULL"`
+
+	Redemptions []InviteCodeRedemptions `gorm:"foreignKey:InviteCodeID;constraint:OnDelete:CASCADE"`
+}
+
+// Who registered with an invite code and when. The account isn't a foreign
+// key so deleting it leaves the entry behind. Codes somebody registered
+// with are never deleted, see DeleteInviteCode.
+type InviteCodeRedemptions struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	InviteCodeID uint `gorm:"index"`
+	AccountID    uint `gorm:"index"`
+}
+
+func (code InviteCodes) Quota() SetAccountQuotaInput {
+	return SetAccountQuotaInput{
+		Bytes:         code.QuotaBytes,
+		Files:         code.QuotaFiles,
+		MaxFileSize:   code.QuotaMaxFileSize,
+		MaxExpiryDays: code.QuotaMaxExpiryDays,
+	}
+}
+
+func (code InviteCodes) Expired() bool {
+	return !code.ExpiryDate.After(time.Now())
 }
 
 func (db *Database) InviteCodeAmount() (count int64, err error) {
@@ -31,26 +68,43 @@ func (db *Database) InviteCodeAmount() (count int64, err error) {
 	return
 }
 
-func (db *Database) CreateInviteCode(
-	uses uint,
-	accountType string,
-	inviteCreatorID uint,
-) (inviteCode InviteCodes, err error) {
-	return db.CreateInviteCodeWithCode(rand.Text(), uses, accountType, inviteCreatorID)
+// How long invite codes last when the creator doesn't say
+const DefaultInviteCodeExpiry = 7 * 24 * time.Hour
+
+type CreateInviteCodeInput struct {
+	Code            string // Random when empty
+	Uses            uint
+	AccountType     string
+	InviteCreatorID uint
+	ExpiryDate      time.Time // DefaultInviteCodeExpiry from now when zero
+	Note            string
+	QuotaPreset     string
+	Quota           SetAccountQuotaInput
 }
 
-func (db *Database) CreateInviteCodeWithCode(
-	code string,
-	uses uint,
-	accountType string,
-	inviteCreatorID uint,
-) (inviteCode InviteCodes, err error) {
+func (db *Database) CreateInviteCode(input CreateInviteCodeInput) (inviteCode InviteCodes, err error) {
+	if !ValidAccountType(input.AccountType) {
+		return inviteCode, ErrInvalidAccountType
+	}
+	if input.Code == "" {
+		input.Code = rand.Text()
+	}
+	if input.ExpiryDate.IsZero() {
+		input.ExpiryDate = time.Now().Add(DefaultInviteCodeExpiry)
+	}
+
 	inviteCode = InviteCodes{
-		Code:            code,
-		Uses:            uses,
-		AccountType:     accountType,
-		InviteCreatorID: inviteCreatorID,
-		ExpiryDate:      time.Now().Add(time.Hour * 24 * 7), // A week from now
+		Code:               input.Code,
+		Uses:               input.Uses,
+		AccountType:        input.AccountType,
+		Note:               input.Note,
+		InviteCreatorID:    input.InviteCreatorID,
+		ExpiryDate:         input.ExpiryDate,
+		QuotaPreset:        input.QuotaPreset,
+		QuotaBytes:         input.Quota.Bytes,
+		QuotaFiles:         input.Quota.Files,
+		QuotaMaxFileSize:   input.Quota.MaxFileSize,
+		QuotaMaxExpiryDays: input.Quota.MaxExpiryDays,
 	}
 
 	err = db.Create(&inviteCode).Error
@@ -60,18 +114,36 @@ func (db *Database) CreateInviteCodeWithCode(
 
 var ErrEmptyInviteCode = errors.New("invite code is empty")
 
+// Codes somebody registered with only have their uses taken away, so the
+// redemptions stay around
 func (db *Database) DeleteInviteCode(code string, accountID uint) (err error) {
 	if code == "" {
 		return ErrEmptyInviteCode
 	}
 
-	return db.Where("code = ? AND invite_creator_id = ?", code, accountID).
-		Delete(&InviteCodes{}).Error
+	return db.deleteInviteCodes(db.Where("code = ? AND invite_creator_id = ?", code, accountID))
 }
 
-func (db *Database) UseCode(code string) (accountType string, invitedBy uint, err error) {
-	var inviteCode InviteCodes
+func (db *Database) deleteInviteCodes(where *gorm.DB) error {
+	redeemed := db.Model(&InviteCodeRedemptions{}).Select("invite_code_id")
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&InviteCodes{}).
+			Where(where).
+			Where("id IN (?)", redeemed).
+			Update("uses", 0).Error; err != nil {
+			return err
+		}
+
+		return tx.Where(where).
+			Where("id NOT IN (?)", redeemed).
+			Delete(&InviteCodes{}).Error
+	})
+}
 
+// Takes one use off the code, the caller records the redemption once the
+// account exists
+func (db *Database) UseCode(code string) (inviteCode InviteCodes, err error) {
 	err = db.Transaction(func(tx *gorm.DB) error {
 		result := tx.Model(&InviteCodes{}).
 			Where("code = ? AND uses > 0 AND expiry_date > ?", code, time.Now()).
@@ -87,32 +159,39 @@ func (db *Database) UseCode(code string) (accountType string, invitedBy uint, er
 			Where("code = ?", code).
 			First(&inviteCode).Error
 	})
-	if err != nil {
-		return
-	}
-
-	accountType = inviteCode.AccountType
-	invitedBy = inviteCode.InviteCreatorID
 
 	return
 }
 
+func (db *Database) CreateInviteCodeRedemption(inviteCodeID uint, accountID uint) error {
+	return db.Create(&InviteCodeRedemptions{InviteCodeID: inviteCodeID, AccountID: accountID}).Error
+}
+
+// Same as DeleteInviteCode for every code the account made
 func (db *Database) DeleteInviteCodesFromAccount(accountID uint) (err error) {
-	return db.Where("invite_creator_id = ?", accountID).
-		Delete(&InviteCodes{}).Error
+	return db.deleteInviteCodes(db.Where("invite_creator_id = ?", accountID))
 }
 
+// Codes that can still be used and ones somebody registered with, newest first
 func (db *Database) InviteCodesByAccount(accountID uint) (inviteCodes []InviteCodes, err error) {
 	err = db.Model(&InviteCodes{}).
-		Where("expiry_date > ?", time.Now()).
-		Where("uses > 0").
 		Where("invite_creator_id = ?", accountID).
-		Scan(&inviteCodes).Error
+		Where("(expiry_date > ? AND uses > 0) OR id IN (?)",
+			time.Now(),
+			db.Model(&InviteCodeRedemptions{}).Select("invite_code_id"),
+		).
+		Preload("Redemptions", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("id")
+		}).
+		Order("id DESC").
+		Find(&inviteCodes).Error
 
 	return
 }
 
+// Codes somebody registered with are kept for their redemption history
 func (db *Database) DeleteExpiredInviteCodes() (err error) {
 	return db.Where("expiry_date < ?", time.Now()).
+		Where("id NOT IN (?)", db.Model(&InviteCodeRedemptions{}).Select("invite_code_id")).
 		Delete(&InviteCodes{}).Error
 }
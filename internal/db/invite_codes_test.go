@@ -0,0 +1,131 @@
+package db
+
+import (
+	"testing"
+)
+
+func redeemedInviteCode(t *testing.T, database *Database, creator Accounts, code string) (inviteCode InviteCodes, redeemer Accounts) {
+	t.Helper()
+
+	inviteCode, err := database.CreateInviteCode(CreateInviteCodeInput{
+		Code:            code,
+		Uses:            2,
+		AccountType:     AccountTypeUser,
+		InviteCreatorID: creator.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redeemer, _, err = database.RegisterWithInviteCode(code, SessionInfo{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func redemptionCount(t *testing.T, database *Database, inviteCodeID uint) (count int64) {
+	t.Helper()
+
+	if err := database.Model(&InviteCodeRedemptions{}).
+		Where("invite_code_id = ?", inviteCodeID).
+		Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestRegisterWithInviteCode(t *testing.T) {
+	database := newTestDB(t)
+	creator := newTestAccount(t, database, AccountTypeUser)
+	inviteCode, redeemer := redeemedInviteCode(t, database, creator, "invite")
+
+	if redeemer.InvitedBy != creator.ID {
+		t.Fatalf("invited by %d, want %d", redeemer.InvitedBy, creator.ID)
+	}
+	if n := redemptionCount(t, database, inviteCode.ID); n != 1 {
+		t.Fatalf("%d redemptions, want 1", n)
+	}
+
+	if _, _, err := database.RegisterWithInviteCode("invite", SessionInfo{}); err != nil {
+		t.Fatalf("second use: %v", err)
+	}
+	if _, _, err := database.RegisterWithInviteCode("invite", SessionInfo{}); err == nil {
+		t.Fatal("code was used more times than it had uses")
+	}
+	if _, _, err := database.RegisterWithInviteCode("nonexistent", SessionInfo{}); err == nil {
+		t.Fatal("unknown code was accepted")
+	}
+}
+
+func TestDeleteInviteCodeKeepsRedemptions(t *testing.T) {
+	database := newTestDB(t)
+	creator := newTestAccount(t, database, AccountTypeUser)
+	redeemed, _ := redeemedInviteCode(t, database, creator, "redeemed")
+	unused, err := database.CreateInviteCode(CreateInviteCodeInput{Code: "unused", Uses: 1, AccountType: AccountTypeUser, InviteCreatorID: creator.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = database.DeleteInviteCode("redeemed", creator.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = database.DeleteInviteCode("unused", creator.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var code InviteCodes
+	if err = database.First(&code, redeemed.ID).Error; err != nil {
+		t.Fatalf("redeemed code was deleted: %v", err)
+	}
+	if code.Uses != 0 {
+		t.Fatalf("redeemed code has %d uses left, want 0", code.Uses)
+	}
+	if _, err = database.UseCode("redeemed"); err == nil {
+		t.Fatal("deleted code could still be used")
+	}
+	if n := redemptionCount(t, database, redeemed.ID); n != 1 {
+		t.Fatalf("%d redemptions, want 1", n)
+	}
+
+	if err = database.First(&InviteCodes{}, unused.ID).Error; err == nil {
+		t.Fatal("unused code wasn't deleted")
+	}
+}
+
+func TestDeleteInviteCodesFromAccountKeepsRedemptions(t *testing.T) {
+	database := newTestDB(t)
+	creator := newTestAccount(t, database, AccountTypeUser)
+	redeemed, _ := redeemedInviteCode(t, database, creator, "redeemed")
+
+	if err := database.DeleteInviteCodesFromAccount(creator.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := redemptionCount(t, database, redeemed.ID); n != 1 {
+		t.Fatalf("%d redemptions, want 1", n)
+	}
+}
+
+func TestDeletingAccountsKeepsRedemptions(t *testing.T) {
+	database := newTestDB(t)
+	creator := newTestAccount(t, database, AccountTypeUser)
+	redeemed, redeemer := redeemedInviteCode(t, database, creator, "redeemed")
+
+	if err := database.DeleteAccount(creator.ID); err != nil {
+		t.Fatal(err)
+	}
+	var code InviteCodes
+	if err := database.First(&code, redeemed.ID).Error; err != nil {
+		t.Fatalf("code was deleted with its creator: %v", err)
+	}
+	if code.InviteCreatorID != 0 {
+		t.Fatalf("creator is still %d", code.InviteCreatorID)
+	}
+
+	if err := database.DeleteAccount(redeemer.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := redemptionCount(t, database, redeemed.ID); n != 1 {
+		t.Fatalf("%d redemptions after deleting both accounts, want 1", n)
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrProviderAlreadyLinked = errors.New("provider identity already linked to another account")
+
+// An account's identity at a login provider, an account can link each
+// provider once and an identity can only belong to one account.
+type LinkedIdentities struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Provider string `gorm:"uniqueIndex:idx_linked_identities_provider_subject;uniqueIndex:idx_linked_identities_account_provider,priority:2"` // Name of the provider in the config
+	Subject  string `gorm:"uniqueIndex:idx_linked_identities_provider_subject"`                                                               // User ID at the provider
+	Username string // Refreshed on every login
+
+	AccountID uint     `gorm:"uniqueIndex:idx_linked_identities_account_provider,priority:1"`
+	Account   Accounts `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+}
+
+func (db *Database) FindAccountByIdentity(provider string, subject string) (account Accounts, err error) {
+	if subject == "" {
+		err = gorm.ErrRecordNotFound
+
+		return
+	}
+
+	err = db.Model(&Accounts{}).
+		Joins("JOIN linked_identities ON linked_identities.account_id = accounts.id").
+		Where("linked_identities.provider = ? AND linked_identities.subject = ?", provider, subject).
+		First(&account).Error
+
+	return
+}
+
+func (db *Database) UpdateIdentityUsername(provider string, subject string, username string) error {
+	return db.Model(&LinkedIdentities{}).
+		Where("provider = ? AND subject = ?", provider, subject).
+		Update("username", username).Error
+}
+
+func (db *Database) GetLinkedIdentity(accountID uint, provider string) (identity LinkedIdentities, err error) {
+	err = db.Model(&LinkedIdentities{}).
+		Where("account_id = ? AND provider = ?", accountID, provider).
+		First(&identity).Error
+
+	return
+}
+
+func (db *Database) GetLinkedIdentities(accountID uint) (identities []LinkedIdentities, err error) {
+	err = db.Model(&LinkedIdentities{}).
+		Where("account_id = ?", accountID).
+		Order("provider ASC").
+		Find(&identities).Error
+
+	return
+}
+
+// Every linked identity, grouped by account
+func (db *Database) AllLinkedIdentities() (identities map[uint][]LinkedIdentities, err error) {
+	var rows []LinkedIdentities
+	if err = db.Model(&LinkedIdentities{}).
+		Order("provider ASC").
+		Find(&rows).Error; err != nil {
+		return
+	}
+
+	identities = make(map[uint][]LinkedIdentities)
+	for _, row := range rows {
+		identities[row.AccountID] = append(identities[row.AccountID], row)
+	}
+
+	return
+}
+
+// Linked identities of the given accounts, grouped by account
+func (db *Database) LinkedIdentitiesOf(accountIDs []uint) (identities map[uint][]LinkedIdentities, err error) {
+	identities = make(map[uint][]LinkedIdentities)
+	if len(accountIDs) == 0 {
+		return
+	}
+
+	var rows []LinkedIdentities
+	if err = db.Model(&LinkedIdentities{}).
+		Where("account_id IN ?", accountIDs).
+		Order("provider ASC").
+		Find(&rows).Error; err != nil {
+		return
+	}
+
+	for _, row := range rows {
+		identities[row.AccountID] = append(identities[row.AccountID], row)
+	}
+
+	return
+}
+
+func (db *Database) LinkedIdentityCount(accountID uint) (count int64, err error) {
+	err = db.Model(&LinkedIdentities{}).
+		Where("account_id = ?", accountID).
+		Count(&count).Error
+
+	return
+}
+
+func (db *Database) LinkIdentity(accountID uint, provider string, subject string, username string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing LinkedIdentities
+		lookupErr := tx.Model(&LinkedIdentities{}).
+			Where("provider = ? AND subject = ?", provider, subject).
+			First(&existing).Error
+		if lookupErr == nil {
+			if existing.AccountID != accountID {
+				return ErrProviderAlreadyLinked
+			}
+
+			return nil
+		}
+		if !errors.Is(lookupErr, gorm.ErrRecordNotFound) {
+			return lookupErr
+		}
+
+		return tx.Create(&LinkedIdentities{
+			Provider:  provider,
+			Subject:   subject,
+			Username:  username,
+			AccountID: accountID,
+		}).Error
+	})
+}
+
+func (db *Database) UnlinkIdentity(accountID uint, provider string) error {
+	return db.Where("account_id = ? AND provider = ?", accountID, provider).
+		Delete(&LinkedIdentities{}).Error
+}
+
+type ProvisionAccountInput struct {
+	AccountType     string
+	Provider        string
+	Subject         string
+	Username        string
+	Email           string
+	PendingApproval bool
+}
+
+// Creates an account with the identity already linked, for logins that don't
+// need an invite code
+func (db *Database) ProvisionAccount(input ProvisionAccountInput) (account Accounts, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		txDB := db.withTx(tx)
+
+		acc, createErr := txDB.CreateAccount(input.AccountType, 0)
+		if createErr != nil {
+			return createErr
+		}
+
+		if input.PendingApproval || input.Email != "" {
+			acc.PendingApproval = input.PendingApproval
+			acc.RegistrationEmail = input.Email
+			if updateErr := tx.Model(&acc).Updates(map[string]any{
+				"pending_approval":   input.PendingApproval,
+				"registration_email": input.Email,
+			}).Error; updateErr != nil {
+				return updateErr
+			}
+		}
+
+		if linkErr := txDB.LinkIdentity(acc.ID, input.Provider, input.Subject, input.Username); linkErr != nil {
+			return linkErr
+		}
+
+		account = acc
+
+		return nil
+	})
+
+	return
+}
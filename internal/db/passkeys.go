@@ -0,0 +1,79 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WebAuthn credentials, each one is a passkey the account can log in with
+type Passkeys struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	LastUsed *time.Time
+	Nickname string
+
+	CredentialID string `gorm:"uniqueIndex"` // Base64url encoded
+	Credential   string // webauthn.Credential as JSON, holds the public key and sign count
+
+	AccountID uint     `gorm:"index"`
+	Account   Accounts `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+}
+
+func (db *Database) CreatePasskey(accountID uint, nickname string, credentialID string, credential string) error {
+	return db.Create(&Passkeys{
+		Nickname:     nickname,
+		CredentialID: credentialID,
+		Credential:   credential,
+		AccountID:    accountID,
+	}).Error
+}
+
+func (db *Database) GetPasskeys(accountID uint) (passkeys []Passkeys, err error) {
+	err = db.Model(&Passkeys{}).
+		Where("account_id = ?", accountID).
+		Order("created_at ASC").
+		Find(&passkeys).Error
+
+	return
+}
+
+func (db *Database) GetPasskeyByCredentialID(credentialID string) (passkey Passkeys, err error) {
+	err = db.Model(&Passkeys{}).
+		Where("credential_id = ?", credentialID).
+		First(&passkey).Error
+
+	return
+}
+
+// Stores the credential after a login, the sign count in it changes every use
+func (db *Database) UpdatePasskeyCredential(passkeyID uint, credential string) error {
+	return db.Model(&Passkeys{}).
+		Where("id = ?", passkeyID).
+		Updates(map[string]any{
+			"credential": credential,
+			"last_used":  time.Now(),
+		}).Error
+}
+
+func (db *Database) DeletePasskey(accountID uint, passkeyID uint) error {
+	result := db.Where("account_id = ?", accountID).Delete(&Passkeys{}, passkeyID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (db *Database) PasskeyCount(accountID uint) (count int64, err error) {
+	err = db.Model(&Passkeys{}).
+		Where("account_id = ?", accountID).
+		Count(&count).Error
+
+	return
+}
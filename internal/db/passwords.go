@@ -0,0 +1,63 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var ErrUsernameTaken = errors.New("username is already taken")
+
+func (db *Database) FindAccountByUsername(username string) (account Accounts, err error) {
+	if username == "" {
+		err = gorm.ErrRecordNotFound
+
+		return
+	}
+
+	err = db.Model(&Accounts{}).
+		Where("username = ?", username).
+		First(&account).Error
+
+	return
+}
+
+// Sets the username and password hash together, the username has to be free
+func (db *Database) SetPassword(accountID uint, username string, passwordHash string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existingID uint
+		lookupErr := tx.Model(&Accounts{}).
+			Where("username = ?", username).
+			Select("id").
+			First(&existingID).Error
+		if lookupErr == nil && existingID != accountID {
+			return ErrUsernameTaken
+		}
+		if lookupErr != nil && !errors.Is(lookupErr, gorm.ErrRecordNotFound) {
+			return lookupErr
+		}
+
+		return tx.Model(&Accounts{}).
+			Where("id = ?", accountID).
+			Updates(map[string]any{
+				"username":      username,
+				"password_hash": passwordHash,
+			}).Error
+	})
+}
+
+// Used when logging in upgrades an old hash to the current parameters
+func (db *Database) UpdatePasswordHash(accountID uint, passwordHash string) error {
+	return db.Model(&Accounts{}).
+		Where("id = ?", accountID).
+		Update("password_hash", passwordHash).Error
+}
+
+func (db *Database) RemovePassword(accountID uint) error {
+	return db.Model(&Accounts{}).
+		Where("id = ?", accountID).
+		Updates(map[string]any{
+			"username":      "",
+			"password_hash": "",
+		}).Error
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Storage limits for an account, 0 is unlimited
+type AccountQuota struct {
+	Bytes         int64 `json:"bytes"`           // Total size of all files
+	Files         int64 `json:"files"`           // Number of files
+	MaxFileSize   int64 `json:"max_file_size"`   // Size of a single file
+	MaxExpiryDays int64 `json:"max_expiry_days"` // Files can't outlive this, files without an expiry get it too
+}
+
+var (
+	ErrQuotaBytes       = errors.New("storage quota exceeded")
+	ErrQuotaFiles       = errors.New("file count quota exceeded")
+	ErrQuotaMaxFileSize = errors.New("file is bigger than your account allows")
+)
+
+// Per account overrides win over the instance defaults
+func (a Accounts) Quota(defaults AccountQuota) (quota AccountQuota) {
+	quota = defaults
+	if a.QuotaBytes != nil {
+		quota.Bytes = *a.QuotaBytes
+	}
+	if a.QuotaFiles != nil {
+		quota.Files = *a.QuotaFiles
+	}
+	if a.QuotaMaxFileSize != nil {
+		quota.MaxFileSize = *a.QuotaMaxFileSize
+	}
+	if a.QuotaMaxExpiryDays != nil {
+		quota.MaxExpiryDays = *a.QuotaMaxExpiryDays
+	}
+
+	return
+}
+
+// Caps the expiry to the quota, a zero expiry means the file never expires
+func (q AccountQuota) CapExpiry(expiryDate time.Time, now time.Time) time.Time {
+	if q.MaxExpiryDays <= 0 {
+		return expiryDate
+	}
+
+	latest := now.AddDate(0, 0, int(q.MaxExpiryDays))
+	if expiryDate.IsZero() || expiryDate.After(latest) {
+		return latest
+	}
+
+	return expiryDate
+}
+
+// Checks whether one more file of the given size fits, size can be -1
+// when it isn't known yet.
+func (q AccountQuota) Check(usedBytes int64, usedFiles int64, size int64) error {
+	if q.MaxFileSize > 0 && size > q.MaxFileSize {
+		return ErrQuotaMaxFileSize
+	}
+	if q.Files > 0 && usedFiles+1 > q.Files {
+		return ErrQuotaFiles
+	}
+	if q.Bytes > 0 && usedBytes+max(size, 0) > q.Bytes {
+		return ErrQuotaBytes
+	}
+
+	return nil
+}
+
+// Same as GetFileStats, expired files waiting for cleanup don't count
+func (db *Database) GetQuotaUsage(accountID uint) (usedBytes int64, usedFiles int64, err error) {
+	var usage struct {
+		Bytes int64
+		Files int64
+	}
+	err = db.Model(&Files{}).
+		Select("COALESCE(SUM(file_size), 0) AS bytes, COUNT(*) AS files").
+		Where("uploader_id = ?", accountID).
+		Where("(expiry_date is not null AND expiry_date > ?) OR expiry_date is null", time.Now()).
+		Scan(&usage).Error
+
+	return usage.Bytes, usage.Files, err
+}
+
+// nil resets the quota back to the instance default
+type SetAccountQuotaInput struct {
+	Bytes         *int64
+	Files         *int64
+	MaxFileSize   *int64
+	MaxExpiryDays *int64
+}
+
+func (db *Database) SetAccountQuota(accountID uint, input SetAccountQuotaInput) error {
+	result := db.Model(&Accounts{}).
+		Where("id = ?", accountID).
+		Updates(map[string]any{
+			"quota_bytes":           input.Bytes,
+			"quota_files":           input.Files,
+			"quota_max_file_size":   input.MaxFileSize,
+			"quota_max_expiry_days": input.MaxExpiryDays,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Locks the uploader row so concurrent uploads from the same account can't
+// both squeeze past the quota, then checks the new file against it.
+func checkQuotaLocked(tx *gorm.DB, accountID uint, defaults AccountQuota, file *Files) error {
+	var account Accounts
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", accountID).
+		First(&account).Error; err != nil {
+		return err
+	}
+
+	txDB := &Database{DB: tx}
+	usedBytes, usedFiles, err := txDB.GetQuotaUsage(accountID)
+	if err != nil {
+		return err
+	}
+
+	quota := account.Quota(defaults)
+	if err = quota.Check(usedBytes, usedFiles, int64(file.FileSize)); err != nil {
+		return err
+	}
+	file.ExpiryDate = quota.CapExpiry(file.ExpiryDate, time.Now())
+
+	return nil
+}
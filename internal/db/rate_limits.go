@@ -0,0 +1,39 @@
+package db
+
+import (
+	"time"
+)
+
+// Fixed window rate limit counters, used when several instances share the
+// database instead of keeping the counters in memory.
+type RateLimits struct {
+	Bucket  string `gorm:"primaryKey"`
+	Count   int64
+	ResetAt int64 `gorm:"index"` // Unix milliseconds, the window restarts after this
+}
+
+// Adds n to the counter of bucket in a single statement so concurrent
+// instances can't lose updates, an expired window starts over. The count
+// never goes below 0.
+func (db *Database) AddRateLimit(bucket string, window time.Duration, n int64) (count int64, reset time.Time, err error) {
+	now := time.Now().UnixMilli()
+
+	var row RateLimits
+	err = db.Raw(`INSERT INTO rate_limits (bucket, count, reset_at) VALUES (?, ?, ?)
+		ON CONFLICT (bucket) DO UPDATE SET
+			count = CASE
+				WHEN rate_limits.reset_at <= ? THEN excluded.count
+				WHEN rate_limits.count + ? < 0 THEN 0
+				ELSE rate_limits.count + ?
+			END,
+			reset_at = CASE WHEN rate_limits.reset_at <= ? THEN excluded.reset_at ELSE rate_limits.reset_at END
+		RETURNING bucket, count, reset_at`,
+		bucket, max(n, 0), now+window.Milliseconds(), now, n, n, now,
+	).Scan(&row).Error
+
+	return row.Count, time.UnixMilli(row.ResetAt), err
+}
+
+func (db *Database) DeleteExpiredRateLimits() error {
+	return db.Where("reset_at <= ?", time.Now().UnixMilli()).Delete(&RateLimits{}).Error
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAddRateLimit(t *testing.T) {
+	database := newTestDB(t)
+
+	for i := range int64(3) {
+		count, _, err := database.AddRateLimit("a", time.Minute, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != (i+1)*2 {
+			t.Fatalf("add %d: count %d, want %d", i+1, count, (i+1)*2)
+		}
+	}
+	if count, reset, err := database.AddRateLimit("a", time.Minute, 0); err != nil || count != 6 || !reset.After(time.Now()) {
+		t.Fatalf("reading: count %d reset %s: %v", count, reset, err)
+	}
+	if count, _, err := database.AddRateLimit("b", time.Minute, 1); err != nil || count != 1 {
+		t.Fatalf("another bucket: count %d: %v", count, err)
+	}
+
+	// A window that ran out starts over
+	if _, _, err := database.AddRateLimit("short", time.Millisecond, 5); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if count, _, err := database.AddRateLimit("short", time.Minute, 1); err != nil || count != 1 {
+		t.Fatalf("after the window: count %d, want 1: %v", count, err)
+	}
+}
+
+func TestAddRateLimitConcurrent(t *testing.T) {
+	database := newTestDB(t)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, _, err := database.AddRateLimit("a", time.Minute, 1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count, _, err := database.AddRateLimit("a", time.Minute, 0); err != nil || count != 20 {
+		t.Fatalf("count %d, want 20: %v", count, err)
+	}
+}
+
+func TestDeleteExpiredRateLimits(t *testing.T) {
+	database := newTestDB(t)
+
+	if _, _, err := database.AddRateLimit("expired", time.Millisecond, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := database.AddRateLimit("current", time.Minute, 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if err := database.DeleteExpiredRateLimits(); err != nil {
+		t.Fatal(err)
+	}
+	var buckets []string
+	if err := database.Model(&RateLimits{}).Pluck("bucket", &buckets).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0] != "current" {
+		t.Fatalf("buckets left %v, want only current", buckets)
+	}
+}
+
+// Taking back more than was counted, or from a window that ran out, can't
+// leave room for extra attempts later
+func TestAddRateLimitNeverNegative(t *testing.T) {
+	database := newTestDB(t)
+
+	if count, _, err := database.AddRateLimit("new", time.Minute, -1); err != nil || count != 0 {
+		t.Fatalf("refund on a new bucket: count %d, want 0: %v", count, err)
+	}
+	if count, _, err := database.AddRateLimit("new", time.Minute, 1); err != nil || count != 1 {
+		t.Fatalf("after the refund: count %d, want 1: %v", count, err)
+	}
+	if count, _, err := database.AddRateLimit("new", time.Minute, -3); err != nil || count != 0 {
+		t.Fatalf("refunding too much: count %d, want 0: %v", count, err)
+	}
+
+	if _, _, err := database.AddRateLimit("expired", time.Millisecond, 5); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if count, _, err := database.AddRateLimit("expired", time.Minute, -1); err != nil || count != 0 {
+		t.Fatalf("refund on an expired window: count %d, want 0: %v", count, err)
+	}
+}
@@ -0,0 +1,19 @@
+package db
+
+import "gorm.io/gorm"
+
+// Lets an account that registered itself log in, gorm.ErrRecordNotFound when
+// it isn't waiting for approval
+func (db *Database) ApproveAccount(accountID uint) error {
+	result := db.Model(&Accounts{}).
+		Where("id = ? AND pending_approval = ?", accountID, true).
+		Update("pending_approval", false)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
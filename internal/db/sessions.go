@@ -1,34 +1,58 @@
 package db
 
 import (
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 )
 
-const SessionTokenDuration = 7 * 24 * time.Hour
+// How long the token a session had before being rotated keeps working, so
+// requests that were already on their way with it don't get logged out
+const sessionRotationGrace = time.Minute
 
 type SessionTokens struct {
 	ID        uint `gorm:"primaryKey"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
 
-	LastUsed   time.Time
-	ExpiryDate time.Time
-	Token      uuid.UUID `gorm:"uniqueIndex"`
+	LastUsed    time.Time
+	ExpiryDate  time.Time
+	TokenHash   string `gorm:"uniqueIndex"` // See Database.hashToken
+	TokenPrefix string
+
+	PreviousTokenHash string `gorm:"index"` // Token before the last rotation, see sessionRotationGrace
+	RotatedAt         *time.Time
+
+	// Where the session was started from, shown on the settings page
+	UserAgent     string
+	IPHash        string // HMAC of the IP, only good for telling IPs apart
+	LoginProvider string // Login provider name, or "password", "passkey" and such
 
 	AccountID uint     `gorm:"index"`
 	Account   Accounts `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
 }
 
+// Finds the session by its token, or by the token it had until a moment ago
+func (db *Database) whereSessionToken(sessionToken uuid.UUID, now time.Time) *gorm.DB {
+	tokenHash := db.hashToken(sessionToken.String())
+
+	return db.Where(
+		"token_hash = ? OR (previous_token_hash = ? AND rotated_at > ?)",
+		tokenHash, tokenHash, now.Add(-sessionRotationGrace),
+	)
+}
+
 func (db *Database) DeleteSession(sessionToken uuid.UUID) (err error) {
-	return db.Where("token = ?", sessionToken).
+	return db.Where(db.whereSessionToken(sessionToken, time.Now())).
 		Delete(&SessionTokens{}).Error
 }
 
 func (db *Database) DeleteSessionForAccount(sessionToken uuid.UUID, accountID uint) (err error) {
-	return db.Where("token = ? AND account_id = ?", sessionToken, accountID).
+	return db.Where(db.whereSessionToken(sessionToken, time.Now())).
+		Where("account_id = ?", accountID).
 		Delete(&SessionTokens{}).Error
 }
 
@@ -46,26 +70,178 @@ func (db *Database) GetSessionsCount(accountID uint) (count int64, err error) {
 	return
 }
 
-func (db *Database) CreateSessionToken(accountID uint) (sessionToken uuid.UUID, err error) {
+// Details about the device a session is started from
+type SessionInfo struct {
+	UserAgent     string
+	IPHash        string
+	LoginProvider string
+}
+
+func (db *Database) CreateSessionToken(
+	accountID uint,
+	info SessionInfo,
+) (sessionToken uuid.UUID, sessionID uint, err error) {
 	log.Debug().Msgf("Creating session token for account %d", accountID)
 
+	now := time.Now()
+	sessionToken = uuid.New()
 	session := SessionTokens{
-		AccountID:  accountID,
-		Token:      uuid.New(),
-		ExpiryDate: time.Now().Add(SessionTokenDuration),
-		LastUsed:   time.Now(),
+		AccountID:     accountID,
+		TokenHash:     db.hashToken(sessionToken.String()),
+		TokenPrefix:   tokenPrefix(sessionToken.String()),
+		ExpiryDate:    db.sessionExpiry(now, now),
+		LastUsed:      now,
+		UserAgent:     info.UserAgent,
+		IPHash:        info.IPHash,
+		LoginProvider: info.LoginProvider,
 	}
 
 	if err = db.Model(&SessionTokens{}).Create(&session).Error; err != nil {
+		return uuid.Nil, 0, err
+	}
+
+	return sessionToken, session.ID, nil
+}
+
+// Sessions expire after going unused for the idle timeout, but never later
+// than the lifetime after logging in
+func (db *Database) sessionExpiry(createdAt time.Time, lastUsed time.Time) time.Time {
+	expiry := lastUsed.Add(db.sessionIdleTimeout)
+	if latest := createdAt.Add(db.sessionLifetime); latest.Before(expiry) {
+		return latest
+	}
+
+	return expiry
+}
+
+// Set when using a session renewed it, the cookie needs the new token
+type RenewedSession struct {
+	Token      uuid.UUID
+	ExpiryDate time.Time
+}
+
+func (r RenewedSession) Renewed() bool {
+	return r.Token != uuid.Nil
+}
+
+// Pushes the expiry of the session forward and swaps its token for a new one.
+// Only the request that wins the update gets the new token, any others racing
+// it keep using the old one during sessionRotationGrace.
+func (db *Database) renewSession(session SessionTokens, now time.Time) (renewed RenewedSession, err error) {
+	token := uuid.New()
+	expiry := db.sessionExpiry(session.CreatedAt, now)
+
+	result := db.Model(&SessionTokens{}).
+		Where("id = ? AND token_hash = ?", session.ID, session.TokenHash).
+		Where("last_used < ?", now.Add(-lastUsedDebounce)).
+		Updates(map[string]any{
+			"last_used":           now,
+			"expiry_date":         expiry,
+			"token_hash":          db.hashToken(token.String()),
+			"token_prefix":        tokenPrefix(token.String()),
+			"previous_token_hash": session.TokenHash,
+			"rotated_at":          now,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return renewed, result.Error
+	}
+
+	return RenewedSession{Token: token, ExpiryDate: expiry}, nil
+}
+
+// Picks up the latest live session started from the same device with the same
+// login method and gives it a new token, for clients that don't keep cookies.
+// ok is false when there's none to pick up.
+func (db *Database) ResumeSession(
+	accountID uint,
+	info SessionInfo,
+) (sessionToken uuid.UUID, sessionID uint, ok bool, err error) {
+	now := time.Now()
+
+	var session SessionTokens
+	err = db.Model(&SessionTokens{}).
+		Where("account_id = ? AND login_provider = ?", accountID, info.LoginProvider).
+		Where("ip_hash = ? AND user_agent = ?", info.IPHash, info.UserAgent).
+		Where("expiry_date > ?", now).
+		Order("last_used DESC").
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return sessionToken, 0, false, nil
+	} else if err != nil {
 		return
 	}
 
-	sessionToken = session.Token
+	sessionToken = uuid.New()
+	result := db.Model(&SessionTokens{}).
+		Where("id = ? AND token_hash = ?", session.ID, session.TokenHash).
+		Updates(map[string]any{
+			"last_used":           now,
+			"expiry_date":         db.sessionExpiry(session.CreatedAt, now),
+			"token_hash":          db.hashToken(sessionToken.String()),
+			"token_prefix":        tokenPrefix(sessionToken.String()),
+			"previous_token_hash": session.TokenHash,
+			"rotated_at":          now,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return uuid.Nil, 0, false, result.Error
+	}
 
-	return
+	return sessionToken, session.ID, true, nil
 }
 
 func (db *Database) DeleteExpiredSessionTokens() (err error) {
 	return db.Where("expiry_date < ?", time.Now()).
 		Delete(&SessionTokens{}).Error
 }
+
+// Signs the account out everywhere except the session making the request,
+// revoked is how many sessions were signed out
+func (db *Database) DeleteOtherSessions(accountID uint, keep uuid.UUID) (revoked int64, err error) {
+	var session SessionTokens
+	if err = db.Model(&SessionTokens{}).
+		Where(db.whereSessionToken(keep, time.Now())).
+		Where("account_id = ?", accountID).
+		First(&session).Error; err != nil {
+		return
+	}
+
+	result := db.Where("account_id = ? AND id <> ?", accountID, session.ID).
+		Delete(&SessionTokens{})
+
+	return result.RowsAffected, result.Error
+}
+
+// Sessions that haven't expired, most recently used first
+func (db *Database) GetSessions(accountID uint) (sessions []SessionTokens, err error) {
+	err = db.Model(&SessionTokens{}).
+		Where("account_id = ?", accountID).
+		Where("expiry_date > ?", time.Now()).
+		Order("last_used DESC").
+		Find(&sessions).Error
+
+	return
+}
+
+// Reports whether the session is the one the token belongs to
+func (db *Database) IsSessionToken(session SessionTokens, sessionToken uuid.UUID) bool {
+	tokenHash := db.hashToken(sessionToken.String())
+	if session.TokenHash == tokenHash {
+		return true
+	}
+
+	return session.PreviousTokenHash == tokenHash &&
+		session.RotatedAt != nil &&
+		session.RotatedAt.After(time.Now().Add(-sessionRotationGrace))
+}
+
+func (db *Database) DeleteSessionByID(accountID uint, sessionID uint) error {
+	result := db.Where("account_id = ?", accountID).Delete(&SessionTokens{}, sessionID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
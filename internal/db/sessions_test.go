@@ -0,0 +1,119 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func TestSessionRenewalRotatesToken(t *testing.T) {
+	database := newTestDB(t)
+	account := newTestAccount(t, database, AccountTypeUser)
+
+	token, sessionID, err := database.CreateSessionToken(account.ID, SessionInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Used again right away, nothing changes
+	_, _, renewed, err := database.GetAccountBySessionToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Renewed() {
+		t.Fatal("session renewed within lastUsedDebounce")
+	}
+
+	if err = database.Model(&SessionTokens{}).Where("id = ?", sessionID).
+		Update("last_used", time.Now().Add(-2*lastUsedDebounce)).Error; err != nil {
+		t.Fatal(err)
+	}
+	_, _, renewed, err = database.GetAccountBySessionToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !renewed.Renewed() || renewed.Token == token {
+		t.Fatal("session wasn't given a new token")
+	}
+
+	// The old token keeps working for requests already on their way
+	if _, _, _, err = database.GetAccountBySessionToken(token); err != nil {
+		t.Fatalf("old token within the grace period: %v", err)
+	}
+	if err = database.Model(&SessionTokens{}).Where("id = ?", sessionID).
+		Update("rotated_at", time.Now().Add(-2*sessionRotationGrace)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = database.GetAccountBySessionToken(token); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("old token after the grace period: got %v", err)
+	}
+	if _, _, _, err = database.GetAccountBySessionToken(renewed.Token); err != nil {
+		t.Fatalf("new token: %v", err)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	database := newTestDB(t) // One hour idle timeout, one day lifetime
+	now := time.Now()
+
+	if got := database.sessionExpiry(now, now); !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("new session expires at %s, want in an hour", got)
+	}
+	createdAt := now.Add(-23*time.Hour - 30*time.Minute)
+	if got := database.sessionExpiry(createdAt, now); !got.Equal(createdAt.Add(24 * time.Hour)) {
+		t.Errorf("renewed session expires at %s, want a day after it was created", got)
+	}
+}
+
+func TestExpiredSessionIsRejected(t *testing.T) {
+	database := newTestDB(t)
+	account := newTestAccount(t, database, AccountTypeUser)
+
+	token, sessionID, err := database.CreateSessionToken(account.ID, SessionInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = database.Model(&SessionTokens{}).Where("id = ?", sessionID).
+		Update("expiry_date", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err = database.GetAccountBySessionToken(token); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("got %v, want gorm.ErrRecordNotFound", err)
+	}
+}
+
+// Uploads already on their way with the old token still find their session
+func TestCreateFileEntryWithRotatedSession(t *testing.T) {
+	database := newTestDB(t)
+	account := newTestAccount(t, database, AccountTypeUser)
+
+	token, sessionID, err := database.CreateSessionToken(account.ID, SessionInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = database.Model(&SessionTokens{}).Where("id = ?", sessionID).
+		Update("last_used", time.Now().Add(-2*lastUsedDebounce)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, _, renewed, err := database.GetAccountBySessionToken(token); err != nil || !renewed.Renewed() {
+		t.Fatalf("session wasn't rotated: %v", err)
+	}
+
+	if err = database.CreateFileEntry(CreateFileEntryInput{
+		Files:        Files{FileName: "a.txt", FileSize: 5},
+		SessionToken: uuid.NullUUID{UUID: token, Valid: true},
+	}); err != nil {
+		t.Fatalf("upload with the previous token: %v", err)
+	}
+	var file Files
+	if err = database.Where("file_name = ?", "a.txt").First(&file).Error; err != nil {
+		t.Fatal(err)
+	}
+	if file.UploaderID != account.ID {
+		t.Fatalf("uploaded by %d, want %d", file.UploaderID, account.ID)
+	}
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Suspensions with an end date lift themselves once it passes
+func (a Accounts) Suspended() bool {
+	return a.SuspendedAt != nil && (a.SuspendedUntil == nil || time.Now().Before(*a.SuspendedUntil))
+}
+
+func (a Accounts) FilesHidden() bool {
+	return a.Suspended() && a.SuspensionHidesFiles
+}
+
+type SuspendAccountInput struct {
+	Reason    string
+	Until     *time.Time // nil lasts until lifted
+	HideFiles bool
+}
+
+var ErrCantSuspendAdmin = errors.New("admins can't be suspended, change their account type first")
+
+// Suspends the account and signs out all of its sessions. Admins return
+// ErrCantSuspendAdmin, suspending them could leave nobody to run the instance.
+func (db *Database) SuspendAccount(accountID uint, input SuspendAccountInput) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var account Accounts
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", accountID).
+			First(&account).Error; err != nil {
+			return err
+		}
+		if account.AccountType == AccountTypeAdmin {
+			return ErrCantSuspendAdmin
+		}
+
+		if err := tx.Model(&Accounts{}).
+			Where("id = ?", accountID).
+			Updates(map[string]any{
+				"suspended_at":           time.Now(),
+				"suspended_until":        input.Until,
+				"suspension_reason":      input.Reason,
+				"suspension_hides_files": input.HideFiles,
+			}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("account_id = ?", accountID).Delete(&SessionTokens{}).Error
+	})
+}
+
+func (db *Database) LiftSuspension(accountID uint) error {
+	result := db.Model(&Accounts{}).
+		Where("id = ?", accountID).
+		Updates(map[string]any{
+			"suspended_at":           nil,
+			"suspended_until":        nil,
+			"suspension_reason":      "",
+			"suspension_hides_files": false,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Tokens created before they were hashed still hold the raw token in
+// token_hash and have no prefix. Hashing needs the app secret so this
+// can't live in the sql migration, it runs on startup instead and only
+// touches rows that haven't been converted yet.
+func (db *Database) RehashLegacyTokens() (err error) {
+	for _, model := range []any{&UploadTokens{}, &SessionTokens{}, &AccessTokens{}} {
+		if err = db.rehashLegacyTokens(model); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (db *Database) rehashLegacyTokens(model any) error {
+	var legacy []struct {
+		ID        uint
+		TokenHash string
+	}
+	if err := db.Model(model).
+		Where("token_prefix IS NULL OR token_prefix = ''").
+		Where("token_hash IS NOT NULL AND token_hash != ''").
+		Select("id, token_hash").
+		Scan(&legacy).Error; err != nil {
+		return err
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range legacy {
+			if err := tx.Model(model).
+				Where("id = ?", row.ID).
+				UpdateColumns(map[string]any{
+					"token_hash":   db.hashToken(row.TokenHash),
+					"token_prefix": tokenPrefix(row.TokenHash),
+				}).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err == nil {
+		log.Info().Msgf("Hashed %d legacy tokens in %T", len(legacy), model)
+	}
+
+	return err
+}
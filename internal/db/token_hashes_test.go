@@ -0,0 +1,112 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Every token column of the table, to check the raw token isn't in any of them
+func tokenColumns(t *testing.T, database *Database, table string) (values []string) {
+	t.Helper()
+
+	if err := database.Table(table).Pluck("token_hash || ' ' || token_prefix", &values).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestTokensStoredHashed(t *testing.T) {
+	database := newTestDB(t)
+	account := newTestAccount(t, database, AccountTypeUser)
+
+	sessionToken, _, err := database.CreateSessionToken(account.ID, SessionInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadToken, err := database.CreateUploadToken(CreateUploadTokenInput{AccountID: account.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken := "hlpat_" + strings.Repeat("a", 40)
+	if _, err = database.CreateAccessToken(CreateAccessTokenInput{AccountID: account.ID, Token: accessToken, Scopes: []string{"files:read"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for table, raw := range map[string]string{
+		"session_tokens": sessionToken.String(),
+		"upload_tokens":  uploadToken.String(),
+		"access_tokens":  accessToken,
+	} {
+		for _, stored := range tokenColumns(t, database, table) {
+			if strings.Contains(stored, raw) {
+				t.Errorf("%s stores the raw token", table)
+			}
+		}
+	}
+
+	if _, _, _, err = database.GetAccountBySessionToken(sessionToken); err != nil {
+		t.Errorf("session lookup: %v", err)
+	}
+	if _, err = database.GetAccountByUploadToken(uploadToken); err != nil {
+		t.Errorf("upload token lookup: %v", err)
+	}
+	if _, _, err = database.GetAccountByAccessToken(accessToken); err != nil {
+		t.Errorf("access token lookup: %v", err)
+	}
+
+	// A leaked hash can't be used as the token
+	var hash string
+	if err = database.Table("access_tokens").Pluck("token_hash", &hash).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = database.GetAccountByAccessToken(hash); err == nil {
+		t.Error("the stored hash worked as a token")
+	}
+
+	// Neither can the token once the key changes
+	database.SetTokenKey([]byte("another-token-key-another-key-00"))
+	if _, _, err = database.GetAccountByAccessToken(accessToken); err == nil {
+		t.Error("token worked with another key")
+	}
+}
+
+func TestRehashLegacyTokens(t *testing.T) {
+	database := newTestDB(t)
+	account := newTestAccount(t, database, AccountTypeUser)
+
+	// Rows from before hashing have the raw token and no prefix
+	uploadToken := uuid.New()
+	if err := database.Create(&UploadTokens{AccountID: account.ID, TokenHash: uploadToken.String()}).Error; err != nil {
+		t.Fatal(err)
+	}
+	sessionToken := uuid.New()
+	if err := database.Exec(
+		"INSERT INTO session_tokens (account_id, token_hash, expiry_date, last_used, created_at) VALUES (?, ?, datetime('now', '+1 day'), datetime('now'), datetime('now'))",
+		account.ID, sessionToken.String(),
+	).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 { // Already hashed rows are left alone
+		if err := database.RehashLegacyTokens(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := database.GetAccountByUploadToken(uploadToken); err != nil {
+		t.Errorf("legacy upload token: %v", err)
+	}
+	if _, _, _, err := database.GetAccountBySessionToken(sessionToken); err != nil {
+		t.Errorf("legacy session token: %v", err)
+	}
+	for _, table := range []string{"upload_tokens", "session_tokens"} {
+		for _, stored := range tokenColumns(t, database, table) {
+			if strings.Contains(stored, uploadToken.String()) || strings.Contains(stored, sessionToken.String()) {
+				t.Errorf("%s still has the raw token", table)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// One time codes for getting past two-factor authentication without the
+// authenticator, generated when it's enabled.
+type RecoveryCodes struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	CodeHash string `gorm:"index"` // See Database.hashToken
+	UsedAt   *time.Time
+
+	AccountID uint     `gorm:"index"`
+	Account   Accounts `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+}
+
+// Stores a new secret that waits for its first code, replaces any earlier pending one
+func (db *Database) SetPendingTOTPSecret(accountID uint, sealedSecret string) error {
+	result := db.Model(&Accounts{}).
+		Where("id = ?", accountID).
+		Where("totp_enabled IS NULL OR totp_enabled = ?", false).
+		Updates(map[string]any{
+			"totp_secret":    sealedSecret,
+			"totp_last_step": 0,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Turns two-factor authentication on once the pending secret has been confirmed
+func (db *Database) EnableTOTP(accountID uint, step int64, recoveryCodes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Accounts{}).
+			Where("id = ?", accountID).
+			Where("totp_secret <> ''").
+			Updates(map[string]any{
+				"totp_enabled":   true,
+				"totp_last_step": step,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return db.withTx(tx).ReplaceRecoveryCodes(accountID, recoveryCodes)
+	})
+}
+
+// Clears the secret and recovery codes, used when disabling and by admins
+func (db *Database) DisableTOTP(accountID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Accounts{}).
+			Where("id = ?", accountID).
+			Updates(map[string]any{
+				"totp_secret":    "",
+				"totp_enabled":   false,
+				"totp_last_step": 0,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Where("account_id = ?", accountID).Delete(&RecoveryCodes{}).Error
+	})
+}
+
+// Moves the last accepted time step forward, reports false when the step
+// has already been used so the same code can't log in twice.
+func (db *Database) UseTOTPStep(accountID uint, step int64) (ok bool, err error) {
+	result := db.Model(&Accounts{}).
+		Where("id = ?", accountID).
+		Where("totp_last_step < ?", step).
+		Update("totp_last_step", step)
+
+	return result.RowsAffected == 1, result.Error
+}
+
+func (db *Database) ReplaceRecoveryCodes(accountID uint, codes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("account_id = ?", accountID).Delete(&RecoveryCodes{}).Error; err != nil {
+			return err
+		}
+
+		rows := make([]RecoveryCodes, 0, len(codes))
+		for _, code := range codes {
+			rows = append(rows, RecoveryCodes{
+				CodeHash:  db.hashToken(code),
+				AccountID: accountID,
+			})
+		}
+
+		return tx.Create(&rows).Error
+	})
+}
+
+// Marks the code as used, reports false if it's wrong or already used
+func (db *Database) UseRecoveryCode(accountID uint, code string) (ok bool, err error) {
+	result := db.Model(&RecoveryCodes{}).
+		Where("account_id = ?", accountID).
+		Where("code_hash = ?", db.hashToken(code)).
+		Where("used_at IS NULL").
+		Update("used_at", time.Now())
+
+	return result.RowsAffected == 1, result.Error
+}
+
+func (db *Database) UnusedRecoveryCodeCount(accountID uint) (count int64, err error) {
+	err = db.Model(&RecoveryCodes{}).
+		Where("account_id = ?", accountID).
+		Where("used_at IS NULL").
+		Count(&count).Error
+
+	return
+}
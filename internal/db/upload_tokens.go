@@ -1,9 +1,13 @@
 package db
 
 import (
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UploadTokens struct {
@@ -14,12 +18,40 @@ type UploadTokens struct {
 	LastUsed *time.Time
 	Nickname string
 
-	Token uuid.UUID `gorm:"uniqueIndex"`
+	NameStrategy string // File name strategy for uploads with this token, empty uses the instance default
+
+	// Restrictions on what can be uploaded with this token, zero values don't restrict anything
+	ExpiryDate       *time.Time `gorm:"default:null;index"` // Token stops working after this
+	MaxFileSize      int64      // Bytes
+	AllowedMimeTypes string     // Comma separated patterns like "image/*" or "application/pdf"
+	DailyByteQuota   int64      // Bytes uploaded in the last 24 hours
+	DailyFileQuota   int64      // Files uploaded in the last 24 hours
+	ForcedTags       string     // Comma separated tags added to every upload
+	ForcedExpiryDays int        // Uploads expire after this many days at the latest
+	ForcePrivate     bool       // Uploads are never public
+
+	TokenHash   string `gorm:"uniqueIndex"` // See Database.hashToken
+	TokenPrefix string // Shown in the UI, the full token is only shown once on creation
 
 	AccountID uint     `gorm:"index"`
 	Account   Accounts `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
 }
 
+// An upload made with a token. Kept when the file is deleted, deleting
+// uploads shouldn't free up the daily quota again.
+type UploadTokenUses struct {
+	ID            uint      `gorm:"primaryKey"`
+	CreatedAt     time.Time `gorm:"index"`
+	FileSize      int64
+	UploadTokenID uint         `gorm:"index"`
+	UploadToken   UploadTokens `gorm:"foreignKey:UploadTokenID;constraint:OnDelete:CASCADE"`
+}
+
+// Window the daily byte and file quotas are counted over
+const UploadTokenQuotaWindow = 24 * time.Hour
+
+var ErrUploadTokenQuota = errors.New("upload token daily quota reached")
+
 func (db *Database) DeleteUploadTokensFromAccount(accountID uint) (err error) {
 	return db.Where("account_id = ?", accountID).
 		Delete(&UploadTokens{}).Error
@@ -33,36 +65,153 @@ func (db *Database) GetUploadTokensCount(accountID uint) (count int64, err error
 	return
 }
 
-type UiUploadToken struct {
-	Token    uuid.UUID
-	Nickname string
-	LastUsed *time.Time
+func (t UploadTokens) AllowedMimeTypeList() []string {
+	return splitList(t.AllowedMimeTypes)
 }
 
-func (db *Database) GetUploadTokens(accountID uint) (uploadTokens []UiUploadToken, err error) {
+func (t UploadTokens) ForcedTagList() []string {
+	return splitList(t.ForcedTags)
+}
+
+func splitList(raw string) (list []string) {
+	for entry := range strings.SplitSeq(raw, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+
+	return
+}
+
+func (db *Database) GetUploadTokens(accountID uint) (uploadTokens []UploadTokens, err error) {
 	err = db.Model(&UploadTokens{}).
 		Where("account_id = ?", accountID).
-		Select("token, nickname, last_used").
-		Scan(&uploadTokens).Error
+		Order("created_at DESC").
+		Find(&uploadTokens).Error
 
 	return
 }
 
-func (db *Database) CreateUploadToken(accountID uint, nickname string) (uploadToken uuid.UUID, err error) {
+type CreateUploadTokenInput struct {
+	AccountID    uint
+	Nickname     string
+	NameStrategy string
+
+	ExpiryDate       *time.Time
+	MaxFileSize      int64
+	AllowedMimeTypes []string
+	DailyByteQuota   int64
+	DailyFileQuota   int64
+	ForcedTags       []string
+	ForcedExpiryDays int
+	ForcePrivate     bool
+}
+
+func (db *Database) CreateUploadToken(input CreateUploadTokenInput) (uploadToken uuid.UUID, err error) {
 	uploadToken = uuid.New()
 
 	err = db.Model(&UploadTokens{}).
 		Create(&UploadTokens{
-			AccountID: accountID,
-			Token:     uploadToken,
-			LastUsed:  nil,
-			Nickname:  nickname,
+			AccountID:        input.AccountID,
+			TokenHash:        db.hashToken(uploadToken.String()),
+			TokenPrefix:      tokenPrefix(uploadToken.String()),
+			LastUsed:         nil,
+			Nickname:         input.Nickname,
+			NameStrategy:     input.NameStrategy,
+			ExpiryDate:       input.ExpiryDate,
+			MaxFileSize:      input.MaxFileSize,
+			AllowedMimeTypes: strings.Join(input.AllowedMimeTypes, ","),
+			DailyByteQuota:   input.DailyByteQuota,
+			DailyFileQuota:   input.DailyFileQuota,
+			ForcedTags:       strings.Join(input.ForcedTags, ","),
+			ForcedExpiryDays: input.ForcedExpiryDays,
+			ForcePrivate:     input.ForcePrivate,
 		}).Error
 
 	return
 }
 
-func (db *Database) DeleteUploadToken(accountID uint, uploadToken uuid.UUID) (err error) {
-	return db.Where("account_id = ? AND token = ?", accountID, uploadToken).
+// Looks up a non-expired upload token
+func (db *Database) GetUploadToken(uploadToken uuid.UUID) (token UploadTokens, err error) {
+	err = db.Model(&UploadTokens{}).
+		Where("token_hash = ?", db.hashToken(uploadToken.String())).
+		Where("expiry_date IS NULL OR expiry_date > ?", time.Now()).
+		First(&token).Error
+
+	return
+}
+
+// Bytes and files uploaded with the token within UploadTokenQuotaWindow,
+// files that have since been deleted still count.
+func (db *Database) GetUploadTokenUsage(tokenID uint) (bytes int64, files int64, err error) {
+	var usage struct {
+		Bytes int64
+		Files int64
+	}
+	err = db.Model(&UploadTokenUses{}).
+		Where("upload_token_id = ? AND created_at > ?", tokenID, time.Now().Add(-UploadTokenQuotaWindow)).
+		Select("COALESCE(SUM(file_size), 0) AS bytes, COUNT(*) AS files").
+		Scan(&usage).Error
+
+	return usage.Bytes, usage.Files, err
+}
+
+// Whether one more upload of the given size fits in the daily quotas
+func (t UploadTokens) CheckDailyQuota(usedBytes int64, usedFiles int64, size int64) error {
+	if t.DailyFileQuota > 0 && usedFiles >= t.DailyFileQuota {
+		return ErrUploadTokenQuota
+	}
+	if t.DailyByteQuota > 0 && usedBytes+max(size, 0) > t.DailyByteQuota {
+		return ErrUploadTokenQuota
+	}
+
+	return nil
+}
+
+// Locks the token row so concurrent uploads with the same token can't both
+// squeeze past its daily quota, then counts the upload towards it.
+func useUploadTokenLocked(tx *gorm.DB, tokenID uint, size int64) error {
+	var token UploadTokens
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", tokenID).
+		First(&token).Error; err != nil {
+		return err
+	}
+
+	if token.DailyByteQuota > 0 || token.DailyFileQuota > 0 {
+		txDB := &Database{DB: tx}
+		usedBytes, usedFiles, err := txDB.GetUploadTokenUsage(tokenID)
+		if err != nil {
+			return err
+		}
+		if err = token.CheckDailyQuota(usedBytes, usedFiles, size); err != nil {
+			return err
+		}
+	}
+
+	return tx.Create(&UploadTokenUses{UploadTokenID: tokenID, FileSize: size}).Error
+}
+
+// Uses older than the quota window don't count towards anything anymore
+func (db *Database) DeleteOldUploadTokenUses() error {
+	return db.Where("created_at < ?", time.Now().Add(-UploadTokenQuotaWindow)).
+		Delete(&UploadTokenUses{}).Error
+}
+
+func (db *Database) DeleteExpiredUploadTokens() (err error) {
+	return db.Where("expiry_date IS NOT NULL AND expiry_date < ?", time.Now()).
 		Delete(&UploadTokens{}).Error
 }
+
+func (db *Database) DeleteUploadToken(accountID uint, tokenID uint) (err error) {
+	result := db.Where("account_id = ? AND id = ?", accountID, tokenID).
+		Delete(&UploadTokens{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func uploadWithToken(database *Database, token uuid.UUID, name string, size uint) error {
+	return database.CreateFileEntry(CreateFileEntryInput{
+		Files:       Files{FileName: name, FileSize: size},
+		UploadToken: uuid.NullUUID{UUID: token, Valid: true},
+	})
+}
+
+func TestUploadTokenDailyQuotaSurvivesDeletes(t *testing.T) {
+	database := newTestDB(t)
+	account := newTestAccount(t, database, AccountTypeUser)
+	token, err := database.CreateUploadToken(CreateUploadTokenInput{AccountID: account.ID, DailyFileQuota: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.png", "b.png"} {
+		if err = uploadWithToken(database, token, name, 10); err != nil {
+			t.Fatalf("upload %s: %v", name, err)
+		}
+	}
+	if err = uploadWithToken(database, token, "c.png", 10); !errors.Is(err, ErrUploadTokenQuota) {
+		t.Fatalf("third upload: got %v, want ErrUploadTokenQuota", err)
+	}
+
+	for _, name := range []string{"a.png", "b.png"} {
+		if err = database.DeleteFileEntry(name, account.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = uploadWithToken(database, token, "c.png", 10); !errors.Is(err, ErrUploadTokenQuota) {
+		t.Fatalf("upload after deleting: got %v, want ErrUploadTokenQuota", err)
+	}
+}
+
+func TestUploadTokenDailyByteQuota(t *testing.T) {
+	database := newTestDB(t)
+	account := newTestAccount(t, database, AccountTypeUser)
+	token, err := database.CreateUploadToken(CreateUploadTokenInput{AccountID: account.ID, DailyByteQuota: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = uploadWithToken(database, token, "a.png", 60); err != nil {
+		t.Fatal(err)
+	}
+	if err = uploadWithToken(database, token, "b.png", 60); !errors.Is(err, ErrUploadTokenQuota) {
+		t.Fatalf("got %v, want ErrUploadTokenQuota", err)
+	}
+	if err = uploadWithToken(database, token, "b.png", 40); err != nil {
+		t.Fatalf("upload that fits: %v", err)
+	}
+}
+
+func TestUploadTokenDailyQuotaConcurrent(t *testing.T) {
+	database := newTestDB(t)
+	account := newTestAccount(t, database, AccountTypeUser)
+	token, err := database.CreateUploadToken(CreateUploadTokenInput{AccountID: account.ID, DailyFileQuota: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		uploaded int
+	)
+	for i := range 10 {
+		wg.Go(func() {
+			err := uploadWithToken(database, token, fmt.Sprintf("%d.png", i), 10)
+			if err == nil {
+				mu.Lock()
+				uploaded++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrUploadTokenQuota) {
+				t.Errorf("upload %d: %v", i, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if uploaded != 3 {
+		t.Fatalf("%d uploads went through, want 3", uploaded)
+	}
+}
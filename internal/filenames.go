@@ -0,0 +1,337 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/google/uuid"
+)
+
+type nameStrategy string
+
+const (
+	nameStrategyRandom    nameStrategy = "random"    // 26 character random string, the original behaviour
+	nameStrategyShort     nameStrategy = "short"     // Short base62 string, e.g "aZ3k9QxP"
+	nameStrategyWords     nameStrategy = "words"     // Adjective(s), a noun and a lowercase suffix, e.g "brave-otter-k7m2qx"
+	nameStrategyUUID      nameStrategy = "uuid"      // Random UUIDv4
+	nameStrategyTimestamp nameStrategy = "timestamp" // Upload time with a short random suffix
+	nameStrategyOriginal  nameStrategy = "original"  // Original file name under a short random prefix
+)
+
+var nameStrategies = []nameStrategy{
+	nameStrategyRandom,
+	nameStrategyShort,
+	nameStrategyWords,
+	nameStrategyUUID,
+	nameStrategyTimestamp,
+	nameStrategyOriginal,
+}
+
+var ErrUnknownNameStrategy = errors.New("unknown file name strategy")
+
+func parseNameStrategy(raw string) (nameStrategy, error) {
+	strategy := nameStrategy(strings.ToLower(strings.TrimSpace(raw)))
+	if !slices.Contains(nameStrategies, strategy) {
+		return "", ErrUnknownNameStrategy
+	}
+
+	return strategy, nil
+}
+
+type fileNameConfig struct {
+	Strategy string `toml:"strategy"` // One of nameStrategies, defaults to "random"
+	Length   int    `toml:"length"`   // Characters for "short" (default 8), words for "words" (default 2)
+	Attempts int    `toml:"attempts"` // How many names to try before giving up on collisions (default 5)
+}
+
+// Every strategy has at least this many random bits in its names, so file
+// URLs can't be enumerated
+const minNameBits = 40
+
+const (
+	defaultShortNameLength = 8
+	minShortNameLength     = 7 // 62^7 is about 41 bits
+	maxShortNameLength     = 32
+
+	defaultNameWords = 2
+	minNameWords     = 2
+	maxNameWords     = 5
+
+	defaultNameAttempts = 5
+	maxNameAttempts     = 20
+
+	// Random part of the names that are mostly guessable otherwise, enough
+	// that file URLs can't be enumerated
+	randomSuffixLength = 8
+
+	maxOriginalNameLength = 100
+)
+
+// Fills in defaults and rejects nonsensical values
+func (c *fileNameConfig) validate() error {
+	if c.Strategy == "" {
+		c.Strategy = string(nameStrategyRandom)
+	}
+	strategy, err := parseNameStrategy(c.Strategy)
+	if err != nil {
+		return fmt.Errorf("%w: %q", err, c.Strategy)
+	}
+	c.Strategy = string(strategy)
+
+	switch strategy {
+	case nameStrategyShort:
+		if c.Length == 0 {
+			c.Length = defaultShortNameLength
+		}
+		if c.Length < minShortNameLength || c.Length > maxShortNameLength {
+			return fmt.Errorf(
+				"file_names.length must be between %d and %d for short names",
+				minShortNameLength,
+				maxShortNameLength,
+			)
+		}
+	case nameStrategyWords:
+		if c.Length == 0 {
+			c.Length = defaultNameWords
+		}
+		if c.Length < minNameWords || c.Length > maxNameWords {
+			return fmt.Errorf("file_names.length must be between %d and %d for word names", minNameWords, maxNameWords)
+		}
+	}
+
+	if c.Attempts == 0 {
+		c.Attempts = defaultNameAttempts
+	}
+	if c.Attempts < 1 || c.Attempts > maxNameAttempts {
+		return fmt.Errorf("file_names.attempts must be between 1 and %d", maxNameAttempts)
+	}
+
+	return nil
+}
+
+// Length option for the given strategy, per-token strategies don't carry
+// their own length so the instance one is used when it applies.
+func (c fileNameConfig) lengthFor(strategy nameStrategy) int {
+	if nameStrategy(c.Strategy) == strategy && c.Length > 0 {
+		return c.Length
+	}
+
+	switch strategy {
+	case nameStrategyShort:
+		return defaultShortNameLength
+	case nameStrategyWords:
+		return defaultNameWords
+	default:
+		return 0
+	}
+}
+
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Crockford's base32 in lowercase, no letters that sound or look alike so
+// word names can still be read out loud
+const wordSuffixAlphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+
+func randomIndex(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+
+	return int(i.Int64())
+}
+
+func randomFrom(alphabet string, length int) string {
+	var b strings.Builder
+	b.Grow(length)
+	for range length {
+		b.WriteByte(alphabet[randomIndex(len(alphabet))])
+	}
+
+	return b.String()
+}
+
+func randomBase62(length int) string {
+	return randomFrom(base62Alphabet, length)
+}
+
+// Characters the suffix needs to bring word names up to minNameBits, fewer
+// the more words there are
+func wordSuffixLength(count int) int {
+	wordBits := float64(count-1)*math.Log2(float64(len(nameAdjectives))) + math.Log2(float64(len(nameNouns)))
+	missing := minNameBits - wordBits
+
+	return max(0, int(math.Ceil(missing/math.Log2(float64(len(wordSuffixAlphabet))))))
+}
+
+// The word lists alone only make a few thousand names, the suffix is what
+// keeps them from being guessed
+func randomWords(count int) string {
+	words := make([]string, 0, count+1)
+	for range count - 1 {
+		words = append(words, nameAdjectives[randomIndex(len(nameAdjectives))])
+	}
+	words = append(words, nameNouns[randomIndex(len(nameNouns))])
+	if length := wordSuffixLength(count); length > 0 {
+		words = append(words, randomFrom(wordSuffixAlphabet, length))
+	}
+
+	return strings.Join(words, "-")
+}
+
+// Keeps file names URL and filesystem safe, anything outside of
+// [A-Za-z0-9._-] becomes an underscore.
+func sanitizeOriginalName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+
+	sanitized := strings.TrimLeft(b.String(), ".")
+	if len(sanitized) > maxOriginalNameLength {
+		ext := filepath.Ext(sanitized)
+		if len(ext) > maxOriginalNameLength/2 {
+			ext = ""
+		}
+		sanitized = sanitized[:maxOriginalNameLength-len(ext)] + ext
+	}
+
+	return sanitized
+}
+
+func mimeExtension(mime *mimetype.MIME) string {
+	ext := mime.Extension()
+	if ext == "" {
+		ext = ".bin"
+	}
+
+	return ext
+}
+
+// Generates a single candidate name, callers are expected to retry on collision
+func (app *Application) generateFileName(strategy nameStrategy, mime *mimetype.MIME, originalName string) string {
+	ext := mimeExtension(mime)
+
+	switch strategy {
+	case nameStrategyShort:
+		return randomBase62(app.config.FileNames.lengthFor(strategy)) + ext
+	case nameStrategyWords:
+		return randomWords(app.config.FileNames.lengthFor(strategy)) + ext
+	case nameStrategyUUID:
+		return uuid.NewString() + ext
+	case nameStrategyTimestamp:
+		return time.Now().UTC().Format("20060102-150405") + "-" + randomBase62(randomSuffixLength) + ext
+	case nameStrategyOriginal:
+		sanitized := sanitizeOriginalName(originalName)
+		// The extension decides the Content-Type it's served with, so the
+		// uploader's one only stays when it agrees with the sniffed type
+		if strings.Trim(sanitized, "_") == "" {
+			sanitized = "file" + ext
+		} else if given := filepath.Ext(sanitized); !strings.EqualFold(given, ext) {
+			sanitized = strings.TrimSuffix(sanitized, given) + ext
+		}
+
+		return randomBase62(randomSuffixLength) + "-" + sanitized
+	default:
+		return randomString() + ext
+	}
+}
+
+var ErrFileNameTaken = errors.New("could not find a free file name")
+
+// Picks a free name with the given strategy and writes the upload under it.
+// The DB lookup skips names that are obviously in use and every backend is
+// asked before anything is written, so both retry with another name. O_EXCL
+// on local storage and If-None-Match on S3 catch whatever races past that,
+// the S3 body is gone by then so that upload fails with ErrFileNameTaken.
+// The unique index on files.file_name is the final guard.
+func (app *Application) storeUpload(
+	ctx context.Context,
+	body io.Reader,
+	size int64,
+	strategy nameStrategy,
+	mime *mimetype.MIME,
+	originalName string,
+) (fileName string, written int64, err error) {
+	for range app.config.FileNames.Attempts {
+		fileName = app.generateFileName(strategy, mime, originalName)
+
+		var taken bool
+		if taken, err = app.db.FileNameTaken(fileName); err != nil {
+			return
+		} else if taken {
+			continue
+		}
+
+		switch app.config.FileStorageMethod {
+		case fileStorageS3:
+			if taken, err = app.s3FileExists(ctx, fileName); err != nil {
+				return
+			} else if taken {
+				continue
+			}
+			written, err = app.uploadFileS3(ctx, body, size, fileName)
+		case fileStorageLocal:
+			written, err = writeLocalFile(filepath.Join(app.config.DataFolder, fileName), body)
+			// O_EXCL fails before anything is read, so the body can be reused
+			if errors.Is(err, os.ErrExist) {
+				continue
+			}
+		default:
+			err = ErrUnknownStorageMethod
+		}
+
+		return
+	}
+
+	err = ErrFileNameTaken
+
+	return
+}
+
+var nameAdjectives = []string{
+	"amber", "ancient", "autumn", "bold", "brave", "breezy", "bright", "brisk",
+	"calm", "clever", "cosmic", "cozy", "crimson", "crisp", "curious", "daring",
+	"dusty", "eager", "early", "electric", "fancy", "fierce", "fluffy", "fond",
+	"frosty", "fuzzy", "gentle", "giant", "glad", "golden", "grand", "happy",
+	"hidden", "hollow", "humble", "icy", "jolly", "keen", "kind", "lazy",
+	"little", "lively", "lucky", "lunar", "mellow", "merry", "misty", "modest",
+	"nimble", "noble", "odd", "olive", "patient", "plucky", "polite", "proud",
+	"quick", "quiet", "rapid", "rosy", "royal", "rustic", "shiny", "silent",
+	"silver", "sleepy", "sly", "smooth", "snowy", "solar", "spicy", "spry",
+	"steady", "stormy", "sunny", "swift", "tender", "tidy", "tiny", "velvet",
+	"vivid", "warm", "wild", "windy", "wise", "witty", "young", "zesty",
+}
+
+var nameNouns = []string{
+	"acorn", "badger", "beacon", "bear", "beetle", "birch", "bison", "breeze",
+	"brook", "bunny", "canyon", "cedar", "cloud", "comet", "coral", "crane",
+	"cricket", "dawn", "deer", "dolphin", "dune", "eagle", "ember", "falcon",
+	"fern", "ferret", "finch", "fox", "frog", "galaxy", "gecko", "glacier",
+	"harbor", "hare", "hawk", "hedgehog", "heron", "island", "koala", "lagoon",
+	"lantern", "lark", "lemur", "lily", "lynx", "maple", "meadow", "meteor",
+	"moose", "moth", "nebula", "newt", "oak", "ocean", "orchid", "otter",
+	"owl", "panda", "pebble", "pine", "planet", "pond", "puffin", "quail",
+	"rabbit", "raven", "reef", "river", "robin", "salmon", "seal", "sparrow",
+	"spruce", "squid", "star", "stone", "swan", "thistle", "tiger", "toad",
+	"tulip", "valley", "walrus", "willow", "wolf", "wombat", "wren", "yak",
+}
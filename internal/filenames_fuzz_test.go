@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzSanitizeOriginalName(f *testing.F) {
+	for _, s := range []string{
+		"",
+		".",
+		"..",
+		"holiday.png",
+		"../../etc/passwd",
+		"..\\..\\windows\\system32",
+		".hidden",
+		"name with spaces.tar.gz",
+		"日本語.txt",
+		"a\x00b.png",
+		strings.Repeat("a", 300) + ".png",
+		"x." + strings.Repeat("e", 300),
+	} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		name := sanitizeOriginalName(raw)
+
+		if len(name) > maxOriginalNameLength {
+			t.Fatalf("sanitized name too long: raw=%q len=%d", raw, len(name))
+		}
+		if strings.HasPrefix(name, ".") {
+			t.Fatalf("sanitized name starts with a dot: raw=%q name=%q", raw, name)
+		}
+		for _, r := range name {
+			if !strings.ContainsRune(base62Alphabet+"._-", r) {
+				t.Fatalf("sanitized name has unsafe rune %q: raw=%q name=%q", r, raw, name)
+			}
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+func TestGenerateFileNameFormats(t *testing.T) {
+	app := &Application{config: Config{FileNames: fileNameConfig{Strategy: string(nameStrategyWords), Length: 3}}}
+	png := mimetype.Lookup("image/png")
+
+	for strategy, pattern := range map[nameStrategy]string{
+		nameStrategyRandom:    `^[0-9A-Za-z]+\.png$`,
+		nameStrategyShort:     `^[0-9A-Za-z]{8}\.png$`,
+		nameStrategyWords:     `^[a-z]+-[a-z]+-[a-z]+-[0-9a-hjkmnp-tv-z]{5}\.png$`,
+		nameStrategyUUID:      `^[0-9a-f-]{36}\.png$`,
+		nameStrategyTimestamp: `^\d{8}-\d{6}-[0-9A-Za-z]{8}\.png$`,
+		nameStrategyOriginal:  `^[0-9A-Za-z]{8}-holiday\.png$`,
+	} {
+		name := app.generateFileName(strategy, png, "holiday.png")
+		if !regexp.MustCompile(pattern).MatchString(name) {
+			t.Errorf("%s: %q doesn't match %s", strategy, name, pattern)
+		}
+	}
+}
+
+// Short and word names are the ones people pick to read out, neither gets to
+// be easier to guess than the other
+func TestNamesHaveMinimumBits(t *testing.T) {
+	if bits := float64(minShortNameLength) * math.Log2(float64(len(base62Alphabet))); bits < minNameBits {
+		t.Errorf("shortest short names have %.1f bits, want %d", bits, minNameBits)
+	}
+
+	for count := minNameWords; count <= maxNameWords; count++ {
+		bits := float64(count-1)*math.Log2(float64(len(nameAdjectives))) +
+			math.Log2(float64(len(nameNouns))) +
+			float64(wordSuffixLength(count))*math.Log2(float64(len(wordSuffixAlphabet)))
+		if bits < minNameBits {
+			t.Errorf("%d word names have %.1f bits, want %d", count, bits, minNameBits)
+		}
+	}
+
+	if name := randomWords(defaultNameWords); strings.ToLower(name) != name {
+		t.Errorf("word name %q isn't all lowercase", name)
+	}
+}
+
+// The word lists alone give a few thousand names, a thousand of them would
+// collide dozens of times without the random suffix
+func TestWordNamesDontRepeat(t *testing.T) {
+	seen := make(map[string]bool)
+	for range 1000 {
+		name := randomWords(defaultNameWords)
+		if seen[name] {
+			t.Fatalf("%q generated twice", name)
+		}
+		seen[name] = true
+	}
+}
+
+// Local files are served with the type their extension says, which has to be
+// the sniffed one
+func TestOriginalNameExtension(t *testing.T) {
+	app := &Application{}
+	text := mimetype.Lookup("text/plain")
+	png := mimetype.Lookup("image/png")
+
+	for _, tc := range []struct {
+		mime *mimetype.MIME
+		name string
+		want string
+	}{
+		{text, "x.html", "-x.txt"},
+		{text, "notes", "-notes.txt"},
+		{text, "notes.", "-notes.txt"},
+		{png, "holiday.PNG", "-holiday.PNG"},
+		{png, "holiday.png", "-holiday.png"},
+		{png, "holiday.svg", "-holiday.png"},
+	} {
+		if got := app.generateFileName(nameStrategyOriginal, tc.mime, tc.name); !strings.HasSuffix(got, tc.want) {
+			t.Errorf("%s as %s: got %q, want it to end in %q", tc.name, tc.mime, got, tc.want)
+		}
+	}
+}
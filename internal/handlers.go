@@ -15,18 +15,21 @@ import (
 
 	embed "github.com/BatteredBunny/hostling"
 	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/dustin/go-humanize"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
-	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
 func (app *Application) hasAdminWarning() bool {
-	app.providersMutex.RLock()
-	defer app.providersMutex.RUnlock()
+	return app.noLoginMethods() || len(app.getFailedProviders()) > 0
+}
 
-	return len(app.configuredProviders) == 0 || len(app.failedProviders) > 0
+// Nothing but an existing session can get anyone in
+func (app *Application) noLoginMethods() bool {
+	return len(app.getConfiguredProviders()) == 0 && app.webAuthn == nil && !app.config.PasswordLogin && !app.config.ProxyAuth.Enabled &&
+		!app.config.LDAP.enabled()
 }
 
 func (app *Application) getFailedProviders() []string {
@@ -57,16 +60,7 @@ func (app *Application) indexPage(c *gin.Context) {
 	}
 
 	if loggedIn {
-		// For top bar
-		templateInput["LoggedIn"] = true
-		templateInput["AccountID"] = account.ID
-
-		isAdmin := account.AccountType == "ADMIN"
-		templateInput["IsAdmin"] = isAdmin
-
-		if isAdmin {
-			templateInput["HasAdminWarning"] = app.hasAdminWarning()
-		}
+		app.accountTemplateInput(c, account, templateInput)
 	}
 
 	c.HTML(http.StatusOK, "index.gohtml", templateInput)
@@ -82,6 +76,12 @@ type AccountStats struct {
 	SessionsCount     int64
 	UploadTokensCount int64
 	LastActivity      time.Time // Last session or upload token usage
+
+	Quota        db.AccountQuota // Effective quota, overrides on db.Accounts win over the instance default
+	QuotaSummary string
+	SpaceLimit   string // Humanized Quota.Bytes, empty when unlimited
+
+	Identities []ProviderInfo // Linked login providers
 }
 
 func (app *Application) adminPage(c *gin.Context) {
@@ -90,7 +90,7 @@ func (app *Application) adminPage(c *gin.Context) {
 		return
 	}
 
-	if account.AccountType != "ADMIN" {
+	if !can(account, permManageAccounts) {
 		c.Redirect(http.StatusTemporaryRedirect, "/")
 
 		return
@@ -110,12 +110,20 @@ func (app *Application) adminPage(c *gin.Context) {
 		return
 	}
 
+	identities, err := app.db.AllLinkedIdentities()
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
 	accountsByID := make(map[uint]db.Accounts, len(accounts))
 	for _, a := range accounts {
 		accountsByID[a.ID] = a
 	}
 
 	stats := make([]AccountStats, 0, len(accounts))
+	var pending int
 	for _, a := range accounts {
 		agg := statsMap[a.ID]
 		stat := AccountStats{
@@ -126,6 +134,14 @@ func (app *Application) adminPage(c *gin.Context) {
 			SessionsCount:     agg.SessionsCount,
 			UploadTokensCount: agg.UploadTokensCount,
 			LastActivity:      agg.LastActivity,
+			Quota:             a.Quota(app.config.Quotas.defaults()),
+		}
+		stat.QuotaSummary = quotaSummary(stat.Quota)
+		for _, identity := range identities[a.ID] {
+			stat.Identities = append(stat.Identities, app.linkedProviderInfo(identity))
+		}
+		if stat.Quota.Bytes > 0 {
+			stat.SpaceLimit = humanize.Bytes(uint64(stat.Quota.Bytes))
 		}
 
 		switch a.InvitedBy {
@@ -133,33 +149,51 @@ func (app *Application) adminPage(c *gin.Context) {
 			stat.InvitedBy = "system"
 		default:
 			inviter, ok := accountsByID[a.InvitedBy]
-			if ok && inviter.GithubUsername != "" {
-				stat.InvitedBy = fmt.Sprintf("%s (%d)", inviter.GithubUsername, inviter.ID)
+			if ok {
+				stat.InvitedBy = fmt.Sprintf("%s (%d)", accountLabel(inviter, identities[inviter.ID]), inviter.ID)
 			} else {
 				stat.InvitedBy = strconv.Itoa(int(a.InvitedBy))
 			}
 		}
 
 		stats = append(stats, stat)
+		if a.PendingApproval {
+			pending++
+		}
 	}
 
+	// Accounts waiting for approval go first so they aren't missed
+	slices.SortStableFunc(stats, func(a, b AccountStats) int {
+		switch {
+		case a.PendingApproval == b.PendingApproval:
+			return 0
+		case a.PendingApproval:
+			return -1
+		default:
+			return 1
+		}
+	})
+
 	configured := app.getConfiguredProviders()
-	c.HTML(http.StatusOK, "admin.gohtml", gin.H{
+	templateInput := gin.H{
 		"CurrentPage":           "admin",
 		"Branding":              app.config.Branding,
 		"Tagline":               app.config.Tagline,
-		"LoggedIn":              true,
-		"AccountID":             account.ID,
-		"IsAdmin":               true,
-		"HasAdminWarning":       app.hasAdminWarning(),
 		"Accounts":              stats,
+		"AccountTypes":          db.AccountTypes,
+		"QuotaPresets":          app.config.QuotaPresets.names(),
 		"MaxUploadSize":         uint(app.config.MaxUploadSize),
 		"Version":               Version,
-		"NoProvidersConfigured": len(configured) == 0,
+		"NoProvidersConfigured": app.noLoginMethods(),
 		"FailedProviders":       app.getFailedProviders(),
 		"FileStorageMethod":     string(app.config.FileStorageMethod),
 		"LoginProviders":        configured,
-	})
+		"RegistrationMode":      string(app.config.Registration.mode()),
+		"PendingCount":          pending,
+	}
+	app.accountTemplateInput(c, account, templateInput)
+
+	c.HTML(http.StatusOK, "admin.gohtml", templateInput)
 }
 
 func (app *Application) galleryPage(c *gin.Context) {
@@ -168,19 +202,12 @@ func (app *Application) galleryPage(c *gin.Context) {
 		return
 	}
 
-	isAdmin := account.AccountType == "ADMIN"
 	templateInput := gin.H{
 		"CurrentPage": "gallery",
 		"Branding":    app.config.Branding,
 		"Tagline":     app.config.Tagline,
-		"LoggedIn":    true,
-		"AccountID":   account.ID,
-		"IsAdmin":     isAdmin,
-	}
-
-	if isAdmin {
-		templateInput["HasAdminWarning"] = app.hasAdminWarning()
 	}
+	app.accountTemplateInput(c, account, templateInput)
 
 	c.HTML(http.StatusOK, "gallery.gohtml", templateInput)
 }
@@ -191,53 +218,81 @@ func (app *Application) settingsPage(c *gin.Context) {
 		return
 	}
 
-	isAdmin := account.AccountType == "ADMIN"
 	templateInput := gin.H{
 		"CurrentPage": "settings",
 		"Branding":    app.config.Branding,
 		"Tagline":     app.config.Tagline,
-		"LoggedIn":    true,
-		"AccountID":   account.ID,
-		"IsAdmin":     isAdmin,
 	}
+	app.accountTemplateInput(c, account, templateInput)
+
+	linked, err := app.db.GetLinkedIdentities(account.ID)
+	if err != nil {
+		log.Err(err).Msg("Failed to load linked identities")
+		c.AbortWithStatus(http.StatusInternalServerError)
 
-	if isAdmin {
-		templateInput["HasAdminWarning"] = app.hasAdminWarning()
+		return
+	}
+	linkedByProvider := make(map[string]db.LinkedIdentities, len(linked))
+	for _, identity := range linked {
+		linkedByProvider[identity.Provider] = identity
 	}
 
 	var providers []ProviderInfo
 	unlinkedAccount := true
 
 	configured := app.getConfiguredProviders()
+	if app.config.ProxyAuth.Enabled {
+		configured = append(configured, proxyAuthProvider)
+	}
+	if app.config.LDAP.enabled() {
+		configured = append(configured, ldapProvider)
+	}
 	for _, providerName := range configured {
-		info := ProviderInfo{
-			Name:        providerName,
-			Icon:        ProviderToIcon(providerName),
-			LinkingText: ProviderToLinkingText(providerName),
+		if identity, ok := linkedByProvider[providerName]; ok {
+			providers = append(providers, app.linkedProviderInfo(identity))
+			unlinkedAccount = false
+		} else {
+			providers = append(providers, app.providerInfo(providerName))
 		}
+	}
 
-		switch providerName {
-		case "github":
-			if account.GithubID > 0 {
-				info.IsLinked = true
-				info.Username = account.GithubUsername
-				info.ProfileURL = "https://github.com/" + account.GithubUsername
-				unlinkedAccount = false
-			}
-		case "openid-connect":
-			if account.OIDCID != "" {
-				info.IsLinked = true
-				info.Username = account.OIDCUsername
-				unlinkedAccount = false
-			}
-		}
+	passkeys, err := app.db.GetPasskeys(account.ID)
+	if err != nil {
+		log.Err(err).Msg("Failed to load passkeys")
+		c.AbortWithStatus(http.StatusInternalServerError)
 
-		providers = append(providers, info)
+		return
+	}
+	if len(passkeys) > 0 || account.PasswordHash != "" {
+		unlinkedAccount = false
 	}
 
 	templateInput["Providers"] = providers
-	templateInput["NoProvidersConfigured"] = len(configured) == 0
+	templateInput["NoProvidersConfigured"] = app.noLoginMethods()
 	templateInput["UnlinkedAccount"] = unlinkedAccount
+	templateInput["PasskeysEnabled"] = app.webAuthn != nil
+	templateInput["Passkeys"] = passkeys
+	templateInput["PasswordLogin"] = app.config.PasswordLogin
+	templateInput["Username"] = account.Username
+	templateInput["HasPassword"] = account.PasswordHash != ""
+
+	totpSettings, err := app.totpSettingsFor(account)
+	if err != nil {
+		log.Err(err).Msg("Failed to load two-factor settings")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	templateInput["TOTP"] = totpSettings
+
+	sessions, err := app.accountSessions(c, account.ID)
+	if err != nil {
+		log.Err(err).Msg("Failed to load sessions")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	templateInput["Sessions"] = sessions
 
 	c.HTML(http.StatusOK, "settings.gohtml", templateInput)
 }
@@ -248,19 +303,12 @@ func (app *Application) tokensPage(c *gin.Context) {
 		return
 	}
 
-	isAdmin := account.AccountType == "ADMIN"
 	templateInput := gin.H{
 		"CurrentPage": "tokens",
 		"Branding":    app.config.Branding,
 		"Tagline":     app.config.Tagline,
-		"LoggedIn":    true,
-		"AccountID":   account.ID,
-		"IsAdmin":     isAdmin,
-	}
-
-	if isAdmin {
-		templateInput["HasAdminWarning"] = app.hasAdminWarning()
 	}
+	app.accountTemplateInput(c, account, templateInput)
 
 	inviteCodes, err := app.db.InviteCodesByAccount(account.ID)
 	if err != nil {
@@ -268,7 +316,14 @@ func (app *Application) tokensPage(c *gin.Context) {
 
 		return
 	}
-	templateInput["InviteCodes"] = inviteCodes
+	inviteCodeViews, err := app.inviteCodeViews(inviteCodes)
+	if err != nil {
+		log.Err(err).Msg("Failed to look up invite code redemptions")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	templateInput["InviteCodes"] = inviteCodeViews
 
 	uploadTokens, err := app.db.GetUploadTokens(account.ID)
 	if err != nil {
@@ -279,45 +334,71 @@ func (app *Application) tokensPage(c *gin.Context) {
 
 	templateInput["UploadTokens"] = uploadTokens
 
+	accessTokens, err := app.db.GetAccessTokens(account.ID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	templateInput["AccessTokens"] = accessTokens
+	templateInput["AccessScopes"] = grantableScopes(account)
+	templateInput["NameStrategies"] = nameStrategies
+	templateInput["DefaultNameStrategy"] = app.config.FileNames.Strategy
+
 	c.HTML(http.StatusOK, "tokens.gohtml", templateInput)
 }
 
 type LoginProvider struct {
-	Name string
-	Icon string // lucide-icon name, should probably be replaced with simpleicons.org when supporting more login platforms
+	Name        string // Used in the login URL
+	DisplayName string
+	Icon        string // lucide-icon name, should probably be replaced with simpleicons.org when supporting more login platforms
 }
 
 type ProviderInfo struct {
-	Name        string
-	Icon        string // lucide-icon name, should probably be replaced with simpleicons.org when supporting more login platforms
+	LoginProvider
 	LinkingText string // e.g., "Link with GitHub"
+	Credentials bool   // Linking asks for a username and password instead of redirecting
 	IsLinked    bool   // whether the account is linked to this provider
 	Username    string // Used for displaying linked username
 	ProfileURL  string // Profile URL if the provider supports it, e.g for github you can open your profile
 }
 
-func ProviderToIcon(provider string) string {
-	switch provider {
-	case "github":
-		return "github"
-	case "openid-connect":
-		return "key-round"
-	default:
-		return "key-square"
+func (app *Application) loginProvider(name string) LoginProvider {
+	provider := LoginProvider{Name: name, DisplayName: name, Icon: "key-square"}
+	if p, ok := app.config.LoginProviders[name]; ok {
+		provider.DisplayName = p.name()
+		provider.Icon = p.kind().icon
+	} else if name == proxyAuthProvider {
+		provider.DisplayName = "Reverse proxy"
+		provider.Icon = "shield-user"
+	} else if name == ldapProvider {
+		provider.DisplayName = app.config.LDAP.DisplayName
+		provider.Icon = "building"
 	}
+
+	return provider
 }
 
-func ProviderToLinkingText(provider string) string {
-	switch provider {
-	case "github":
-		return "Link with GitHub"
-	case "openid-connect":
-		return "Link with OpenID Connect"
-	default:
-		return "Link with " + provider
+func (app *Application) providerInfo(name string) ProviderInfo {
+	provider := app.loginProvider(name)
+
+	return ProviderInfo{
+		LoginProvider: provider,
+		LinkingText:   "Link with " + provider.DisplayName,
+		Credentials:   name == ldapProvider,
 	}
 }
 
+func (app *Application) linkedProviderInfo(identity db.LinkedIdentities) ProviderInfo {
+	info := app.providerInfo(identity.Provider)
+	info.IsLinked = true
+	info.Username = identity.Username
+	info.ProfileURL = app.providerProfileURL(identity.Provider, identity.Username)
+
+	return info
+}
+
 func (app *Application) loginPage(c *gin.Context) {
 	_, loggedIn, ok := app.validateOrAbort(c)
 	if !ok {
@@ -327,10 +408,7 @@ func (app *Application) loginPage(c *gin.Context) {
 	configured := app.getConfiguredProviders()
 	var providers []LoginProvider
 	for _, name := range configured {
-		providers = append(providers, LoginProvider{
-			Name: name,
-			Icon: ProviderToIcon(name),
-		})
+		providers = append(providers, app.loginProvider(name))
 	}
 
 	if loggedIn {
@@ -338,7 +416,12 @@ func (app *Application) loginPage(c *gin.Context) {
 	} else {
 		c.HTML(http.StatusOK, "login.gohtml", gin.H{
 			"Providers":             providers,
-			"NoProvidersConfigured": len(configured) == 0,
+			"NoProvidersConfigured": app.noLoginMethods(),
+			"PasskeysEnabled":       app.webAuthn != nil,
+			"PasswordLogin":         app.config.PasswordLogin,
+			"LDAPLogin":             app.config.LDAP.enabled(),
+			"LDAPDisplayName":       app.config.LDAP.DisplayName,
+			"Registration":          string(app.config.Registration.mode()),
 			"CurrentPage":           "login",
 			"Branding":              app.config.Branding,
 			"Tagline":               app.config.Tagline,
@@ -346,6 +429,22 @@ func (app *Application) loginPage(c *gin.Context) {
 	}
 }
 
+// Second login step for accounts with two-factor authentication
+func (app *Application) twoFactorPage(c *gin.Context) {
+	if _, _, err := app.parseTwoFactorCookie(c); err != nil {
+		app.clearTwoFactorCookie(c)
+		c.Redirect(http.StatusTemporaryRedirect, "/login")
+
+		return
+	}
+
+	c.HTML(http.StatusOK, "login_2fa.gohtml", gin.H{
+		"CurrentPage": "login",
+		"Branding":    app.config.Branding,
+		"Tagline":     app.config.Tagline,
+	})
+}
+
 func (app *Application) registerPage(c *gin.Context) {
 	_, loggedIn, ok := app.validateOrAbort(c)
 	if !ok {
@@ -410,6 +509,16 @@ func (app *Application) indexFiles(c *gin.Context) {
 		}
 	}
 
+	if fileRecord.Uploader.FilesHidden() {
+		// Moderators can still look at them
+		_, account, loggedIn, err := app.validateAuthCookie(c)
+		if err != nil || !loggedIn || !can(account, permModerateFiles) {
+			c.Redirect(http.StatusTemporaryRedirect, "/")
+
+			return
+		}
+	}
+
 	fileName := fileRecord.FileName
 
 	// Skip view bumps on Range/HEAD probes — media players issue many.
@@ -493,6 +602,18 @@ func (app *Application) newUploadTokenApi(c *gin.Context) {
 		return
 	}
 
+	var strategy nameStrategy
+	if raw := c.PostForm("name_strategy"); raw != "" {
+		parsed, err := parseNameStrategy(raw)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			c.Abort()
+
+			return
+		}
+		strategy = parsed
+	}
+
 	count, err := app.db.GetUploadTokensCount(account.ID)
 	if err != nil {
 		log.Err(err).Msg("Failed to count upload tokens")
@@ -507,40 +628,67 @@ func (app *Application) newUploadTokenApi(c *gin.Context) {
 		return
 	}
 
-	uploadToken, err := app.db.CreateUploadToken(account.ID, nickname)
+	input := db.CreateUploadTokenInput{
+		AccountID:    account.ID,
+		Nickname:     nickname,
+		NameStrategy: string(strategy),
+	}
+
+	var restrictions uploadTokenRestrictionsInput
+	if err = c.ShouldBindWith(&restrictions, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+	if err = restrictions.apply(&input); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	uploadToken, err := app.db.CreateUploadToken(input)
 	if err != nil {
 		log.Err(err).Msg("Failed to create upload token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 
 		return
 	}
+	app.audit(c, db.AuditLogs{Action: auditCreateUploadToken, TargetID: account.ID, Detail: nickname})
 
+	// Only the hash is stored, this is the one time the token is shown
 	c.String(http.StatusOK, uploadToken.String())
 }
 
+type deleteUploadTokenInput struct {
+	ID uint `form:"id" binding:"required"`
+}
+
 func (app *Application) deleteUploadTokenAPI(c *gin.Context) {
 	account, _ := getAccount(c)
 
-	rawUploadToken := c.PostForm("upload_token")
-	if rawUploadToken == "" {
-		c.AbortWithStatus(http.StatusBadRequest)
+	var input deleteUploadTokenInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
 
 		return
 	}
 
-	uploadToken, err := uuid.Parse(rawUploadToken)
-	if err != nil {
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	err := app.db.DeleteUploadToken(account.ID, input.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Upload token not found")
 
 		return
-	}
-
-	if err = app.db.DeleteUploadToken(account.ID, uploadToken); err != nil {
+	} else if err != nil {
 		log.Err(err).Msg("Failed to delete upload token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 
 		return
 	}
+	app.audit(c, db.AuditLogs{
+		Action:   auditDeleteUploadToken,
+		TargetID: account.ID,
+		Detail:   fmt.Sprintf("Upload token %d", input.ID),
+	})
 
 	c.String(http.StatusOK, "Upload token deleted successfully")
 }
@@ -569,6 +717,7 @@ func (app *Application) deleteFilesAPI(c *gin.Context) {
 
 	err := app.deleteFilesFromAccount(c.Request.Context(), account.ID)
 	if errors.Is(err, ErrPartialDeleteFailed) {
+		app.audit(c, db.AuditLogs{Action: auditDeleteFiles, TargetID: account.ID, Detail: "Some files could not be deleted"})
 		c.String(http.StatusInternalServerError, "Some files could not be deleted")
 
 		return
@@ -579,6 +728,7 @@ func (app *Application) deleteFilesAPI(c *gin.Context) {
 
 		return
 	}
+	app.audit(c, db.AuditLogs{Action: auditDeleteFiles, TargetID: account.ID})
 
 	c.String(http.StatusOK, "Files deleted")
 }
@@ -613,9 +763,10 @@ func (app *Application) deleteFilesFromAccount(ctx context.Context, accountID ui
 }
 
 type FileStatsOutput struct {
-	Count     uint     `json:"count"`
-	SizeTotal uint     `json:"size_total"`
-	Tags      []string `json:"tags"`
+	Count     uint            `json:"count"`
+	SizeTotal uint            `json:"size_total"`
+	Tags      []string        `json:"tags"`
+	Quota     db.AccountQuota `json:"quota"` // Limits count and size_total count against, 0 is unlimited
 }
 
 func (app *Application) fileStatsAPI(c *gin.Context) {
@@ -633,6 +784,7 @@ func (app *Application) fileStatsAPI(c *gin.Context) {
 
 	output.Count = totalFiles
 	output.SizeTotal = totalStorage
+	output.Quota = account.Quota(app.config.Quotas.defaults())
 
 	output.Tags, err = app.db.GetAccountTags(account.ID)
 	if err != nil {
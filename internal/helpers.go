@@ -11,7 +11,6 @@ import (
 	"path/filepath"
 
 	"github.com/BatteredBunny/hostling/internal/db"
-	"github.com/gabriel-vasile/mimetype"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
@@ -20,7 +19,7 @@ import (
 )
 
 func (app *Application) validateOrAbort(c *gin.Context) (account db.Accounts, loggedIn, ok bool) {
-	_, account, loggedIn, err := app.validateAuthCookie(c)
+	sessionToken, account, loggedIn, err := app.validateAuthCookie(c)
 	if errors.Is(err, ErrInvalidAuthCookie) {
 		app.clearAuthCookie(c)
 	} else if err != nil {
@@ -28,6 +27,10 @@ func (app *Application) validateOrAbort(c *gin.Context) (account db.Accounts, lo
 
 		return
 	}
+	if loggedIn {
+		c.Set("sessionToken", sessionToken)
+		c.Set("account", account)
+	}
 	ok = true
 
 	return
@@ -58,6 +61,10 @@ func writeLocalFile(path string, body io.Reader) (written int64, err error) {
 		if cerr := f.Close(); cerr != nil && err == nil {
 			err = cerr
 		}
+		// Don't leave half written files behind from aborted uploads
+		if err != nil {
+			_ = os.Remove(path)
+		}
 	}()
 	if written, err = io.Copy(f, body); err != nil {
 		return
@@ -103,6 +110,10 @@ func writeFileExclusive(path string, data []byte, mode os.FileMode) (err error)
 		if cerr := f.Close(); cerr != nil && err == nil {
 			err = cerr
 		}
+		// Don't leave half written files behind from aborted uploads
+		if err != nil {
+			_ = os.Remove(path)
+		}
 	}()
 	if _, err = f.Write(data); err != nil {
 		return
@@ -150,22 +161,20 @@ func loadOrCreateAppSecret(dataFolder string) (secret []byte, err error) {
 	return
 }
 
-func (app *Application) generateFullFileName(mime *mimetype.MIME) string {
-	ext := mime.Extension()
-	if ext == "" {
-		ext = ".bin"
-	}
-
-	return randomString() + ext
-}
-
+// Tokens of suspended accounts return ErrAccountSuspended
 func (app *Application) isValidUploadToken(uploadToken uuid.UUID) (bool, error) {
-	_, err := app.db.GetAccountByUploadToken(uploadToken)
+	account, err := app.db.GetAccountByUploadToken(uploadToken)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return false, nil
 	} else if err != nil {
 		return false, err
 	}
+	if account.Suspended() {
+		return false, ErrAccountSuspended
+	}
+	if !can(account, permUpload) {
+		return false, ErrMissingPermission
+	}
 
 	return true, nil
 }
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+type InviteRedemption struct {
+	Account   string
+	CreatedAt time.Time
+}
+
+// An invite code as shown on the tokens page
+type InviteCodeView struct {
+	db.InviteCodes
+	QuotaSummary string // Empty when the code keeps the instance default
+	Redeemers    []InviteRedemption
+}
+
+func (app *Application) inviteCodeViews(codes []db.InviteCodes) (views []InviteCodeView, err error) {
+	var redeemerIDs []uint
+	for _, code := range codes {
+		for _, redemption := range code.Redemptions {
+			redeemerIDs = append(redeemerIDs, redemption.AccountID)
+		}
+	}
+	labels, err := app.accountLabels(redeemerIDs)
+	if err != nil {
+		return
+	}
+
+	for _, code := range codes {
+		view := InviteCodeView{InviteCodes: code}
+		if quota := code.Quota(); quota != (db.SetAccountQuotaInput{}) {
+			view.QuotaSummary = quotaSummary(db.Accounts{
+				QuotaBytes:         quota.Bytes,
+				QuotaFiles:         quota.Files,
+				QuotaMaxFileSize:   quota.MaxFileSize,
+				QuotaMaxExpiryDays: quota.MaxExpiryDays,
+			}.Quota(app.config.Quotas.defaults()))
+		}
+		for _, redemption := range code.Redemptions {
+			label, ok := labels[redemption.AccountID]
+			if !ok {
+				label = fmt.Sprintf("Deleted account (%d)", redemption.AccountID)
+			}
+			view.Redeemers = append(view.Redeemers, InviteRedemption{Account: label, CreatedAt: redemption.CreatedAt})
+		}
+		views = append(views, view)
+	}
+
+	return
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func TestInviteRedemptionsOutliveDeletion(t *testing.T) {
+	app := newTestApp(t)
+	creator := newTestAccount(t, app, db.AccountTypeUser)
+	client := newSessionClient(t, app, creator)
+
+	if _, err := app.db.CreateInviteCode(db.CreateInviteCodeInput{
+		Code:            "invite",
+		Uses:            2,
+		AccountType:     db.AccountTypeUser,
+		InviteCreatorID: creator.ID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	redeemer, _, err := app.db.RegisterWithInviteCode("invite", db.SessionInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := client.do(http.MethodDelete, "/api/account/invite_code", map[string]string{"invite_code": "invite"}); w.Code != http.StatusOK {
+		t.Fatalf("delete: got %d, want 200", w.Code)
+	}
+	if _, _, err = app.db.RegisterWithInviteCode("invite", db.SessionInfo{}); err == nil {
+		t.Fatal("deleted code could still be used")
+	}
+
+	used := fmt.Sprintf("Used by account-%d (%d)", redeemer.ID, redeemer.ID)
+	if w := client.do(http.MethodGet, "/tokens", nil); !strings.Contains(w.Body.String(), used) {
+		t.Fatalf("tokens page lost the redemption, want %q", used)
+	}
+
+	if err = app.db.DeleteAccount(redeemer.ID); err != nil {
+		t.Fatal(err)
+	}
+	deleted := fmt.Sprintf("Used by Deleted account (%d)", redeemer.ID)
+	if w := client.do(http.MethodGet, "/tokens", nil); !strings.Contains(w.Body.String(), deleted) {
+		t.Fatalf("tokens page lost the deleted redeemer, want %q", deleted)
+	}
+}
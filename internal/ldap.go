@@ -0,0 +1,335 @@
+package internal
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Provider name of identities linked through LDAP
+const ldapProvider = "ldap"
+
+const ldapTimeout = 10 * time.Second
+
+var (
+	ErrLDAPDisabled    = errors.New("LDAP login isn't enabled on this instance")
+	ErrLDAPUnavailable = errors.New("directory is unavailable, try again later")
+	ErrLDAPNotLinked   = errors.New("this directory account isn't linked to any account")
+)
+
+// Logs people in by binding to a directory as them. A service account looks
+// up the user's DN with UserFilter first, then the password is checked by
+// binding as that DN.
+type ldapConfig struct {
+	URL                string `toml:"url"`                  // ldap://host:389 or ldaps://host:636, LDAP is off without it
+	StartTLS           bool   `toml:"start_tls"`            // Upgrades ldap:// connections to TLS
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"` // Only for testing against self-signed certificates
+	BindDN             string `toml:"bind_dn"`              // Service account used to search, empty searches anonymously
+	BindPassword       string `toml:"bind_password"`        // Better given through LDAP_BIND_PASSWORD
+	BaseDN             string `toml:"base_dn"`              // Where users are searched from
+	UserFilter         string `toml:"user_filter"`          // %s is the escaped username, defaults to (uid=%s)
+	UsernameAttribute  string `toml:"username_attribute"`   // Defaults to uid, use sAMAccountName for Active Directory
+	IDAttribute        string `toml:"id_attribute"`         // Never changes for a user, defaults to entryUUID, use objectGUID for Active Directory
+	GroupsAttribute    string `toml:"groups_attribute"`     // Group DNs on the user, defaults to memberOf
+	DisplayName        string `toml:"display_name"`         // Shown on the login form, defaults to "LDAP"
+	groupMapping
+}
+
+func (c ldapConfig) enabled() bool {
+	return c.URL != ""
+}
+
+func (c ldapConfig) withEnv() ldapConfig {
+	if password := os.Getenv("LDAP_BIND_PASSWORD"); password != "" {
+		c.BindPassword = password
+	}
+
+	if c.UserFilter == "" {
+		c.UserFilter = "(uid=%s)"
+	}
+	if c.UsernameAttribute == "" {
+		c.UsernameAttribute = "uid"
+	}
+	if c.IDAttribute == "" {
+		c.IDAttribute = "entryUUID"
+	}
+	if c.GroupsAttribute == "" {
+		c.GroupsAttribute = "memberOf"
+	}
+	if c.DisplayName == "" {
+		c.DisplayName = "LDAP"
+	}
+
+	return c
+}
+
+func (c ldapConfig) validate(providers loginProvidersConfig) error {
+	if !c.enabled() {
+		return nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return fmt.Errorf("ldap url %q must look like ldap://host:389 or ldaps://host:636", c.URL)
+	}
+	if c.StartTLS && u.Scheme == "ldaps" {
+		return errors.New("ldap start_tls can't be used with ldaps://, it's already encrypted")
+	}
+	if !c.StartTLS && u.Scheme == "ldap" {
+		return errors.New("ldap:// without start_tls would send passwords in cleartext, use ldaps:// or start_tls")
+	}
+	if c.BaseDN == "" {
+		return errors.New("ldap needs base_dn")
+	}
+	if strings.Count(c.UserFilter, "%s") != 1 {
+		return fmt.Errorf("ldap user_filter %q needs exactly one %%s for the username", c.UserFilter)
+	}
+	if _, ok := providers[ldapProvider]; ok {
+		return fmt.Errorf("login provider can't be named %q while LDAP is enabled", ldapProvider)
+	}
+
+	return nil
+}
+
+type ldapUser struct {
+	ID       string   // What the identity is linked by, see ldapEntryID
+	Username string   // Value of the username attribute, as the directory spells it
+	Groups   []string // Group DNs, along with the common name of each
+}
+
+func (app *Application) dialLDAP() (conn *ldap.Conn, err error) {
+	config := app.config.LDAP
+
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if conn, err = ldap.DialURL(
+		config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	); err != nil {
+		return
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if config.StartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// Group DNs match the group mapping lists either whole or by their
+// common name, e.g "cn=admins,ou=groups,dc=example,dc=com" or "admins"
+func ldapGroups(values []string) (groups []string) {
+	for _, value := range values {
+		groups = append(groups, value)
+
+		dn, err := ldap.ParseDN(value)
+		if err != nil || len(dn.RDNs) == 0 {
+			continue
+		}
+		for _, attribute := range dn.RDNs[0].Attributes {
+			if strings.EqualFold(attribute.Type, "cn") {
+				groups = append(groups, attribute.Value)
+			}
+		}
+	}
+
+	return
+}
+
+// Usernames can be renamed and handed to someone else, so identities are
+// linked by the id attribute instead. Entries without one fall back to their
+// DN. objectGUID is binary and gets hex encoded.
+func ldapEntryID(entry *ldap.Entry, attribute string) string {
+	if strings.EqualFold(attribute, "objectGUID") {
+		if raw := entry.GetRawAttributeValue(attribute); len(raw) > 0 {
+			return hex.EncodeToString(raw)
+		}
+	} else if id := entry.GetAttributeValue(attribute); id != "" {
+		return id
+	}
+
+	return "dn:" + strings.ToLower(entry.DN)
+}
+
+func ldapUserFilter(filter string, username string) string {
+	return fmt.Sprintf(filter, ldap.EscapeFilter(username))
+}
+
+// Returns ErrInvalidCredentials when the user doesn't exist or the password
+// is wrong, anything else means the directory couldn't be asked
+func (app *Application) ldapAuthenticate(username string, password string) (user ldapUser, err error) {
+	// An empty password would be an unauthenticated bind, which servers let through
+	if username == "" || password == "" {
+		return user, ErrInvalidCredentials
+	}
+
+	config := app.config.LDAP
+
+	conn, err := app.dialLDAP()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if config.BindDN != "" {
+		if err = conn.Bind(config.BindDN, config.BindPassword); err != nil {
+			return
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		config.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // More than one match is treated like none
+		int(ldapTimeout.Seconds()),
+		false,
+		ldapUserFilter(config.UserFilter, username),
+		[]string{config.UsernameAttribute, config.GroupsAttribute, config.IDAttribute},
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return user, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err = conn.Bind(entry.DN, password); ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return user, ErrInvalidCredentials
+	} else if err != nil {
+		return
+	}
+
+	user.Username = entry.GetAttributeValue(config.UsernameAttribute)
+	if user.Username == "" {
+		return user, fmt.Errorf("directory entry %q has no %s attribute", entry.DN, config.UsernameAttribute)
+	}
+	user.ID = ldapEntryID(entry, config.IDAttribute)
+	user.Groups = ldapGroups(entry.GetAttributeValues(config.GroupsAttribute))
+
+	return
+}
+
+// Authenticates the username and password in the form, writes the error
+// response when it fails. Shares the lockout with password login.
+func (app *Application) ldapCheckForm(c *gin.Context) (user ldapUser, ok bool) {
+	if !app.config.LDAP.enabled() {
+		c.String(http.StatusNotFound, ErrLDAPDisabled.Error())
+
+		return
+	}
+
+	var input passwordLoginInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	lockoutKey := ldapProvider + ":" + normalizeUsername(input.Username)
+	if app.refusePasswordLocked(c, lockoutKey) {
+		return
+	}
+
+	user, err := app.ldapAuthenticate(strings.TrimSpace(input.Username), input.Password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		c.String(http.StatusUnauthorized, err.Error())
+
+		return
+	}
+	app.uncountPasswordAttempt(c, lockoutKey)
+	if err != nil {
+		log.Err(err).Msg("LDAP authentication failed")
+		c.String(http.StatusServiceUnavailable, ErrLDAPUnavailable.Error())
+
+		return
+	}
+
+	return user, true
+}
+
+func (app *Application) ldapLoginApi(c *gin.Context) {
+	user, ok := app.ldapCheckForm(c)
+	if !ok {
+		return
+	}
+
+	mapping := app.config.LDAP.groupMapping
+
+	account, err := app.db.FindAccountByIdentity(ldapProvider, user.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) && mapping.AutoProvision {
+		account, err = app.provisionAccount(c, mapping, user.Groups, ldapProvider, user.ID, user.Username)
+	} else if err == nil {
+		account, err = app.applyGroupMapping(account, mapping, user.Groups)
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusUnauthorized, ErrLDAPNotLinked.Error())
+
+		return
+	} else if errors.Is(err, ErrNotInAllowedGroup) {
+		c.String(http.StatusForbidden, err.Error())
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to find account by LDAP user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	app.completeLogin(c, account, ldapProvider)
+}
+
+// Links the directory user in the form to the logged in account
+func (app *Application) linkLDAPUser(c *gin.Context, account db.Accounts) {
+	user, ok := app.ldapCheckForm(c)
+	if !ok {
+		return
+	}
+
+	err := app.db.LinkIdentity(account.ID, ldapProvider, user.ID, user.Username)
+	if errors.Is(err, db.ErrProviderAlreadyLinked) {
+		c.String(http.StatusConflict, "This identity is already linked to another user")
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to link LDAP user")
+		c.String(http.StatusInternalServerError, "Failed to link provider")
+
+		return
+	}
+	app.auditLink(c, account, ldapProvider, user.Username)
+
+	c.Redirect(http.StatusSeeOther, "/settings")
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestLDAPUserFilterEscapes(t *testing.T) {
+	for username, want := range map[string]string{
+		"alice":            "(&(objectClass=person)(uid=alice))",
+		"*":                `(&(objectClass=person)(uid=\2a))`,
+		"alice)(uid=*":     `(&(objectClass=person)(uid=alice\29\28uid=\2a))`,
+		`admin\`:           `(&(objectClass=person)(uid=admin\5c))`,
+		"bob\x00":          `(&(objectClass=person)(uid=bob\00))`,
+		"*)(|(objectClass": `(&(objectClass=person)(uid=\2a\29\28|\28objectClass))`,
+	} {
+		if got := ldapUserFilter("(&(objectClass=person)(uid=%s))", username); got != want {
+			t.Errorf("%q: got %s, want %s", username, got, want)
+		}
+	}
+}
+
+func TestLDAPConfigValidate(t *testing.T) {
+	valid := ldapConfig{URL: "ldaps://ldap.example.com", BaseDN: "dc=example,dc=com"}.withEnv()
+	if err := valid.validate(nil); err != nil {
+		t.Fatalf("ldaps: %v", err)
+	}
+
+	startTLS := valid
+	startTLS.URL = "ldap://ldap.example.com"
+	startTLS.StartTLS = true
+	if err := startTLS.validate(nil); err != nil {
+		t.Fatalf("ldap:// with start_tls: %v", err)
+	}
+
+	cleartext := startTLS
+	cleartext.StartTLS = false
+	if err := cleartext.validate(nil); err == nil {
+		t.Fatal("ldap:// without start_tls was accepted")
+	}
+
+	noPlaceholder := valid
+	noPlaceholder.UserFilter = "(uid=alice)"
+	if err := noPlaceholder.validate(nil); err == nil {
+		t.Fatal("user_filter without a placeholder was accepted")
+	}
+}
+
+func TestLDAPEntryID(t *testing.T) {
+	entry := ldap.NewEntry("uid=alice,ou=People,dc=example,dc=com", map[string][]string{
+		"uid":       {"alice"},
+		"entryUUID": {"5a6e2d3c-0f0b-4d0e-9d4e-1f2a3b4c5d6e"},
+	})
+	if got := ldapEntryID(entry, "entryUUID"); got != "5a6e2d3c-0f0b-4d0e-9d4e-1f2a3b4c5d6e" {
+		t.Errorf("entryUUID: got %q", got)
+	}
+
+	// Without the attribute the DN is used, never the username
+	if got := ldapEntryID(entry, "missing"); got != "dn:uid=alice,ou=people,dc=example,dc=com" {
+		t.Errorf("fallback: got %q", got)
+	}
+
+	guid := &ldap.Entry{
+		DN: "CN=Alice,CN=Users,DC=example,DC=com",
+		Attributes: []*ldap.EntryAttribute{{
+			Name:       "objectGUID",
+			Values:     []string{"\x01\x02\xff"},
+			ByteValues: [][]byte{{0x01, 0x02, 0xff}},
+		}},
+	}
+	if got := ldapEntryID(guid, "objectGUID"); got != "0102ff" {
+		t.Errorf("objectGUID: got %q", got)
+	}
+}
+
+func TestLDAPGroups(t *testing.T) {
+	got := ldapGroups([]string{"cn=admins,ou=groups,dc=example,dc=com", "not a dn"})
+	want := []string{"cn=admins,ou=groups,dc=example,dc=com", "admins", "not a dn"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// Where rate limit counters are kept. Only the shared stores keep limits
+// accurate when several instances run behind a load balancer.
+type limiterStoreType string
+
+const (
+	limiterStoreMemory   limiterStoreType = "memory"   // Per process, the default
+	limiterStoreDatabase limiterStoreType = "database" // The configured database
+	limiterStoreRedis    limiterStoreType = "redis"    // Anything speaking the redis protocol, e.g Valkey
+)
+
+// Fixed window counters, a window starts on the first hit of a key
+type limiterStore interface {
+	// Adds n to the counter of key, n can be 0 to only read it or negative to
+	// take something back. Counters never go below 0, so taking back from a
+	// window that already ran out can't give extra room in the next one.
+	// Returns the count in the current window and when it resets.
+	add(ctx context.Context, key string, window time.Duration, n int64) (count int64, reset time.Time, err error)
+	removeExpired(ctx context.Context) error
+}
+
+func setupLimiterStore(c Config, database db.Database) limiterStore {
+	switch c.RateLimits.Store {
+	case limiterStoreDatabase:
+		log.Info().Msg("Keeping rate limits in the database")
+
+		return &databaseLimiterStore{db: database}
+	case limiterStoreRedis:
+		log.Info().Msg("Keeping rate limits in redis")
+
+		redisURL := c.RateLimits.RedisURL
+		if envRedisURL := os.Getenv("RATE_LIMIT_REDIS_URL"); envRedisURL != "" {
+			redisURL = envRedisURL
+		}
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid rate_limits.redis_url")
+		}
+
+		client := redis.NewClient(opts)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err = client.Ping(ctx).Err(); err != nil {
+			log.Fatal().Err(err).Msg("Failed to connect to redis")
+		}
+
+		return &redisLimiterStore{client: client}
+	default:
+		return newMemoryLimiterStore()
+	}
+}
+
+type memoryLimiterStore struct {
+	mu      sync.Mutex
+	windows map[string]*limitWindow
+}
+
+type limitWindow struct {
+	count int64
+	reset time.Time
+}
+
+func newMemoryLimiterStore() *memoryLimiterStore {
+	return &memoryLimiterStore{windows: make(map[string]*limitWindow)}
+}
+
+func (s *memoryLimiterStore) add(
+	_ context.Context,
+	key string,
+	window time.Duration,
+	n int64,
+) (count int64, reset time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	w, ok := s.windows[key]
+	if !ok || !now.Before(w.reset) {
+		w = &limitWindow{reset: now.Add(window)}
+		s.windows[key] = w
+	}
+	w.count = max(w.count+n, 0)
+
+	return w.count, w.reset, nil
+}
+
+func (s *memoryLimiterStore) removeExpired(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, w := range s.windows {
+		if !now.Before(w.reset) {
+			delete(s.windows, key)
+		}
+	}
+
+	return nil
+}
+
+type databaseLimiterStore struct {
+	db db.Database
+}
+
+func (s *databaseLimiterStore) add(
+	ctx context.Context,
+	key string,
+	window time.Duration,
+	n int64,
+) (count int64, reset time.Time, err error) {
+	return (&db.Database{DB: s.db.WithContext(ctx)}).AddRateLimit(key, window, n)
+}
+
+func (s *databaseLimiterStore) removeExpired(_ context.Context) error {
+	return s.db.DeleteExpiredRateLimits()
+}
+
+type redisLimiterStore struct {
+	client *redis.Client
+}
+
+// INCRBY and the expiry have to happen together, otherwise a crash in
+// between leaves a counter that never resets
+var redisAddScript = redis.NewScript(`
+local count = redis.call("INCRBY", KEYS[1], ARGV[1])
+if count < 0 then
+	count = redis.call("INCRBY", KEYS[1], -count)
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	ttl = tonumber(ARGV[2])
+end
+return {count, ttl}
+`)
+
+// Keeps the counters apart from anything else in the same redis database
+const redisKeyPrefix = "hostling:ratelimit:"
+
+func (s *redisLimiterStore) add(
+	ctx context.Context,
+	key string,
+	window time.Duration,
+	n int64,
+) (count int64, reset time.Time, err error) {
+	res, err := redisAddScript.Run(ctx, s.client, []string{redisKeyPrefix + key}, n, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return
+	}
+
+	return res[0], time.Now().Add(time.Duration(res[1]) * time.Millisecond), nil
+}
+
+// Redis expires the keys itself
+func (s *redisLimiterStore) removeExpired(_ context.Context) error {
+	return nil
+}
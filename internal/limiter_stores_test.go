@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+// Two instances behind a load balancer, both on the same database
+func newSharedTestApps(t *testing.T, configure func(c *Config)) (first *Application, second *Application) {
+	t.Helper()
+
+	first = newTestApp(t, func(c *Config) {
+		c.RateLimits.Store = limiterStoreDatabase
+		configure(c)
+	})
+	second = newTestApp(t, func(c *Config) {
+		c.DatabaseConnectionUrl = first.config.DatabaseConnectionUrl
+		c.RateLimits.Store = limiterStoreDatabase
+		configure(c)
+	})
+
+	return
+}
+
+func TestSharedAccountRateLimit(t *testing.T) {
+	first, second := newSharedTestApps(t, func(c *Config) {
+		c.RateLimits.Account = rateLimitConfig{RequestsPerMinute: 2}
+	})
+	account := newTestAccount(t, first, db.AccountTypeUser)
+
+	for i, app := range []*Application{first, second} {
+		if w := newSessionClient(t, app, account).do(http.MethodGet, "/api/account/files", nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i+1, w.Code)
+		}
+	}
+	for i, app := range []*Application{first, second} {
+		if w := newSessionClient(t, app, account).do(http.MethodGet, "/api/account/files", nil); w.Code != http.StatusTooManyRequests {
+			t.Fatalf("instance %d over the limit: got %d, want 429", i+1, w.Code)
+		}
+	}
+}
+
+func TestSharedIPRateLimit(t *testing.T) {
+	first, second := newSharedTestApps(t, func(c *Config) { c.RateLimit = 2 })
+	if _, ok := first.limiterStore.(*databaseLimiterStore); !ok {
+		t.Fatalf("limiter store is %T, want the database one", first.limiterStore)
+	}
+
+	for i, app := range []*Application{first, second} {
+		if w := newTestClient(app).do(http.MethodGet, "/api/auth/login/nowhere", nil); w.Code == http.StatusTooManyRequests {
+			t.Fatalf("request %d was limited", i+1)
+		}
+	}
+	w := newTestClient(second).do(http.MethodGet, "/api/auth/login/nowhere", nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit: got %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+
+	other := newTestClient(first)
+	other.remoteAddr = "198.51.100.7:1234"
+	if w := other.do(http.MethodGet, "/api/auth/login/nowhere", nil); w.Code == http.StatusTooManyRequests {
+		t.Fatal("another IP was limited")
+	}
+}
+
+func TestMemoryLimiterStoreNeverNegative(t *testing.T) {
+	store := newMemoryLimiterStore()
+	ctx := context.Background()
+
+	if count, _, _ := store.add(ctx, "new", time.Minute, -1); count != 0 {
+		t.Fatalf("refund on a new window: count %d, want 0", count)
+	}
+	if count, _, _ := store.add(ctx, "new", time.Minute, 1); count != 1 {
+		t.Fatalf("after the refund: count %d, want 1", count)
+	}
+	if count, _, _ := store.add(ctx, "new", time.Minute, -3); count != 0 {
+		t.Fatalf("refunding too much: count %d, want 0", count)
+	}
+
+	store.add(ctx, "expired", time.Millisecond, 5)
+	time.Sleep(5 * time.Millisecond)
+	if count, _, _ := store.add(ctx, "expired", time.Minute, -1); count != 0 {
+		t.Fatalf("refund on an expired window: count %d, want 0", count)
+	}
+}
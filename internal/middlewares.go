@@ -3,15 +3,45 @@ package internal
 import (
 	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/BatteredBunny/hostling/internal/db"
 	"github.com/didip/tollbooth/v8"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
+// Shared stores only have fixed windows, the closest one to the in-memory token bucket
+func ipRateWindow(rate float64) (limit int64, window time.Duration) {
+	if rate >= 1 {
+		return int64(rate), time.Second
+	}
+
+	return 1, time.Duration(float64(time.Second) / rate)
+}
+
 func (app *Application) ratelimitMiddleware() gin.HandlerFunc {
+	if _, inMemory := app.limiterStore.(*memoryLimiterStore); !inMemory {
+		limit, window := ipRateWindow(ipRateLimit(app.config))
+
+		return func(c *gin.Context) {
+			count, reset, err := app.limiterStore.add(c.Request.Context(), "ip:"+c.ClientIP(), window, 1)
+			if err != nil {
+				log.Err(err).Msg("Failed to count request against IP rate limit")
+			} else if count > limit {
+				c.Header("Retry-After", retryAfterSeconds(reset))
+				c.String(http.StatusTooManyRequests, "You have reached maximum request limit.")
+				c.Abort()
+
+				return
+			}
+			c.Next()
+		}
+	}
+
 	return func(c *gin.Context) {
 		httpError := tollbooth.LimitByKeys(app.RateLimiter, []string{c.ClientIP()})
 		if httpError != nil {
@@ -38,20 +68,27 @@ const multipartMaxMemory = 32 << 20 // 32 MiB
 // parses form
 func (app *Application) apiMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Request.ContentLength > 0 {
-			if err := c.Request.ParseMultipartForm(
-				multipartMaxMemory,
-			); err != nil &&
-				!errors.Is(err, http.ErrNotMultipart) {
-				c.String(http.StatusRequestEntityTooLarge, "Too big file")
-				c.Abort()
-
-				return
-			}
+		if parseForm(c) {
+			c.Next()
 		}
+	}
+}
 
-		c.Next()
+// Aborts if the body is too big, reports whether the request can continue
+func parseForm(c *gin.Context) bool {
+	if c.Request.ContentLength > 0 {
+		if err := c.Request.ParseMultipartForm(
+			multipartMaxMemory,
+		); err != nil &&
+			!errors.Is(err, http.ErrNotMultipart) {
+			c.String(http.StatusRequestEntityTooLarge, "Too big file")
+			c.Abort()
+
+			return false
+		}
 	}
+
+	return true
 }
 
 func getSessionToken(c *gin.Context) (uuid.UUID, bool) {
@@ -84,10 +121,19 @@ func getAccount(c *gin.Context) (db.Accounts, bool) {
 	return account, ok
 }
 
-// Makes sure request has session token and a valid one, attaches the
-// account to the context so future handlers can read it without
+// Lets the request through with a valid session cookie, or a personal access
+// token whose scopes cover the route. Either way the account is set on the
+// context, along with the session token or the access token.
 func (app *Application) verifySessionAuthentication() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if rawToken, ok := bearerToken(c); ok {
+			if app.authenticateAccessToken(c, rawToken) {
+				c.Next()
+			}
+
+			return
+		}
+
 		sessionToken, account, loggedIn, err := app.validateAuthCookie(c)
 		if err != nil && !errors.Is(err, ErrInvalidAuthCookie) {
 			log.Err(err).Msg("validateAuthCookie failed")
@@ -107,63 +153,110 @@ func (app *Application) verifySessionAuthentication() gin.HandlerFunc {
 	}
 }
 
-// Must run after verifySessionAuthentication.
-func (app *Application) isAdmin() gin.HandlerFunc {
+// Header scripts can send an upload token in instead of a form field
+const uploadTokenHeader = "X-Upload-Token"
+
+// Makes sure request has a valid upload, access or session token.
+// Headers and the session cookie are checked before touching the body so
+// unauthenticated uploads get rejected without reading them. An upload_token
+// form field still takes precedence over the session cookie.
+func (app *Application) hasUploadOrSessionTokenMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		account, ok := getAccount(c)
-		if !ok || account.AccountType != "ADMIN" {
-			c.AbortWithStatus(http.StatusUnauthorized)
+		if rawToken, ok := bearerToken(c); ok {
+			if strings.HasPrefix(rawToken, accessTokenPrefix) {
+				if app.authenticateAccessToken(c, rawToken) {
+					c.Next()
+				}
+			} else if app.authenticateUploadToken(c, rawToken) {
+				c.Next()
+			}
 
 			return
 		}
-		c.Next()
-	}
-}
 
-// Makes sure request has a valid upload or session token
-func (app *Application) hasUploadOrSessionTokenMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		rawUploadToken, uploadTokenExists := c.GetPostForm("upload_token")
+		if rawToken := strings.TrimSpace(c.GetHeader(uploadTokenHeader)); rawToken != "" {
+			if app.authenticateUploadToken(c, rawToken) {
+				c.Next()
+			}
 
-		if uploadTokenExists && rawUploadToken != "" {
-			var uploadToken uuid.UUID
-			var err error
-			if uploadToken, err = uuid.Parse(rawUploadToken); err != nil {
-				_ = c.AbortWithError(http.StatusUnauthorized, err)
+			return
+		}
 
-				return
-			}
+		sessionToken, account, loggedIn, err := app.validateAuthCookie(c)
+		if err != nil && !errors.Is(err, ErrInvalidAuthCookie) {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
 
-			valid, err := app.isValidUploadToken(uploadToken)
-			if err != nil { // Could be a database error
-				log.Err(err).Msg("Failed to check if upload token is valid")
-				c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		useSession := func() {
+			c.Set("sessionToken", sessionToken)
+			c.Set("account", account)
+			c.Next()
+		}
 
-				return
-			} else if !valid { // Wrong or expired token given
+		// Only form posts can carry the token, anything else can be turned away unread
+		if c.Request.Method != http.MethodPost ||
+			(c.ContentType() != binding.MIMEMultipartPOSTForm && c.ContentType() != binding.MIMEPOSTForm) {
+			if loggedIn {
+				useSession()
+			} else {
 				c.AbortWithStatus(http.StatusUnauthorized)
-
-				return
 			}
 
-			c.Set("uploadToken", uploadToken)
-		} else {
-			sessionToken, account, loggedIn, err := app.validateAuthCookie(c)
-			if err != nil && !errors.Is(err, ErrInvalidAuthCookie) {
-				_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
 
-				return
+		if !parseForm(c) {
+			return
+		}
+		// A token in the form wins over the session so its restrictions and
+		// defaults still apply when posted from a logged in browser
+		if rawToken := c.PostForm("upload_token"); rawToken != "" {
+			if app.authenticateUploadToken(c, rawToken) {
+				c.Next()
 			}
-			if !loggedIn {
-				c.AbortWithStatus(http.StatusUnauthorized)
 
-				return
-			}
+			return
+		}
+		if loggedIn {
+			useSession()
 
-			c.Set("sessionToken", sessionToken)
-			c.Set("account", account)
+			return
 		}
 
-		c.Next()
+		c.AbortWithStatus(http.StatusUnauthorized)
 	}
 }
+
+// Attaches the upload token to the request when it's a live token whose owner
+// can still upload. Malformed or unknown tokens get 401, tokens of suspended
+// accounts or accounts that lost the upload permission get 403.
+func (app *Application) authenticateUploadToken(c *gin.Context, rawToken string) bool {
+	uploadToken, err := uuid.Parse(rawToken)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return false
+	}
+
+	valid, err := app.isValidUploadToken(uploadToken)
+	if errors.Is(err, ErrAccountSuspended) || errors.Is(err, ErrMissingPermission) {
+		c.String(http.StatusForbidden, err.Error())
+		c.Abort()
+
+		return false
+	} else if err != nil { // Could be a database error
+		log.Err(err).Msg("Failed to check if upload token is valid")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return false
+	} else if !valid { // Wrong or expired token given
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return false
+	}
+
+	c.Set("uploadToken", uploadToken)
+
+	return true
+}
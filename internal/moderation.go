@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const moderationPageSize = 50
+
+// A public file as moderators see it, unlike db.Files it says who uploaded it
+type ModerationFile struct {
+	FileName         string    `json:"file_name"`
+	OriginalFileName string    `json:"original_file_name"`
+	FileSize         uint      `json:"file_size"`
+	MimeType         string    `json:"mime_type"`
+	CreatedAt        time.Time `json:"created_at"`
+	UploaderID       uint      `json:"uploader_id"`
+	Uploader         string    `json:"uploader"`
+}
+
+type moderationFilesInput struct {
+	Skip uint `form:"skip,default=0"`
+}
+
+type ModerationFilesOutput struct {
+	Files []ModerationFile `json:"files"`
+	Count int64            `json:"count"`
+}
+
+// Public files of every account with their uploaders, for the page and the api
+func (app *Application) moderationFiles(skip uint) (output ModerationFilesOutput, err error) {
+	files, count, err := app.db.GetPublicFilesPaginated(skip, moderationPageSize)
+	if err != nil {
+		return
+	}
+
+	uploaderIDs := make([]uint, 0, len(files))
+	for _, file := range files {
+		uploaderIDs = append(uploaderIDs, file.UploaderID)
+	}
+	labels, err := app.accountLabels(uploaderIDs)
+	if err != nil {
+		return
+	}
+
+	output.Count = count
+	output.Files = make([]ModerationFile, 0, len(files))
+	for _, file := range files {
+		output.Files = append(output.Files, ModerationFile{
+			FileName:         file.FileName,
+			OriginalFileName: file.OriginalFileName,
+			FileSize:         file.FileSize,
+			MimeType:         file.MimeType,
+			CreatedAt:        file.CreatedAt,
+			UploaderID:       file.UploaderID,
+			Uploader:         labels[file.UploaderID],
+		})
+	}
+
+	return
+}
+
+func (app *Application) moderationPage(c *gin.Context) {
+	account, ok := app.requireAuth(c)
+	if !ok {
+		return
+	}
+
+	if !can(account, permModerateFiles) {
+		c.Redirect(http.StatusTemporaryRedirect, "/")
+
+		return
+	}
+
+	var input moderationFilesInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+	if input.Skip > maxPaginationSkip {
+		c.AbortWithStatus(http.StatusBadRequest)
+
+		return
+	}
+
+	output, err := app.moderationFiles(input.Skip)
+	if err != nil {
+		log.Err(err).Msg("Failed to get public files")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	templateInput := gin.H{
+		"CurrentPage": "moderation",
+		"Branding":    app.config.Branding,
+		"Tagline":     app.config.Tagline,
+		"Files":       output.Files,
+		"Count":       output.Count,
+	}
+	app.accountTemplateInput(c, account, templateInput)
+	if input.Skip > 0 {
+		templateInput["PreviousSkip"] = strconv.FormatUint(uint64(input.Skip-min(input.Skip, moderationPageSize)), 10)
+	}
+	if int64(input.Skip+moderationPageSize) < output.Count {
+		templateInput["NextSkip"] = strconv.FormatUint(uint64(input.Skip+moderationPageSize), 10)
+	}
+
+	c.HTML(http.StatusOK, "moderation.gohtml", templateInput)
+}
+
+func (app *Application) moderationFilesAPI(c *gin.Context) {
+	var input moderationFilesInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+
+		return
+	}
+	if input.Skip > maxPaginationSkip {
+		c.AbortWithStatus(http.StatusBadRequest)
+
+		return
+	}
+
+	output, err := app.moderationFiles(input.Skip)
+	if err != nil {
+		log.Err(err).Msg("Failed to get public files")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, output)
+}
+
+type moderationDeleteFileInput struct {
+	FileName string `form:"file_name" binding:"required"`
+}
+
+// Removes someone's public file, private files are left to their owners
+func (app *Application) moderationDeleteFileAPI(c *gin.Context) {
+	var input moderationDeleteFileInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	file, err := app.db.GetFileByName(input.FileName)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !file.Public) {
+		c.AbortWithStatus(http.StatusNotFound)
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to get file details")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	if err = app.deleteFile(c.Request.Context(), file.FileName); err != nil {
+		log.Err(err).Str("file", file.FileName).Msg("Failed to delete file from storage; keeping DB row for retry")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	if err = app.db.DeleteFileEntry(file.FileName, file.UploaderID); err != nil {
+		log.Err(err).Msg("Failed to delete file entry")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	app.audit(c, db.AuditLogs{Action: auditModerationDeleteFile, TargetID: file.UploaderID, Detail: file.FileName})
+
+	c.String(http.StatusOK, "File removed")
+}
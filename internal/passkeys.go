@@ -0,0 +1,406 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	WEBAUTHN_COOKIE      = "webauthn" // Challenge between the begin and finish requests
+	webauthnCookieMaxAge = 300        // seconds
+
+	maxPasskeysPerAccount = 20
+	passkeyNicknameLength = 50
+)
+
+var (
+	ErrPasskeysDisabled = errors.New("passkeys aren't available on this instance")
+	ErrPasskeyNotFound  = errors.New("passkey isn't registered")
+	ErrTooManyPasskeys  = errors.New("too many passkeys on this account")
+	ErrInvalidPasskey   = errors.New("passkey verification failed")
+)
+
+// Passkeys are bound to the domain of public_url, without it they stay off
+func (app *Application) setupPasskeys() {
+	if app.config.PublicUrl == "" {
+		log.Warn().Msg("Passkeys disabled, public_url isn't set")
+
+		return
+	}
+
+	publicURL, err := url.Parse(app.config.PublicUrl)
+	if err != nil || publicURL.Hostname() == "" {
+		log.Warn().Err(err).Msg("Passkeys disabled, public_url isn't a valid URL")
+
+		return
+	}
+
+	app.webAuthn, err = webauthn.New(&webauthn.Config{
+		RPID:          publicURL.Hostname(),
+		RPDisplayName: app.config.Branding,
+		RPOrigins:     []string{publicURL.Scheme + "://" + publicURL.Host},
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Passkeys disabled, failed to set up webauthn")
+
+		return
+	}
+
+	log.Info().Msg("Passkey authentication enabled")
+}
+
+// Adapts an account to webauthn.User
+type passkeyUser struct {
+	account     db.Accounts
+	label       string
+	credentials []webauthn.Credential
+}
+
+// The account ID, which says nothing about the user
+func (u passkeyUser) WebAuthnID() []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(u.account.ID))
+}
+
+func (u passkeyUser) WebAuthnName() string {
+	return u.label
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	return u.label
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (app *Application) loadPasskeyUser(account db.Accounts) (user passkeyUser, passkeys []db.Passkeys, err error) {
+	user.account = account
+	user.label = app.accountLabel(account)
+	if passkeys, err = app.db.GetPasskeys(account.ID); err != nil {
+		return
+	}
+
+	for _, passkey := range passkeys {
+		var credential webauthn.Credential
+		if err = json.Unmarshal([]byte(passkey.Credential), &credential); err != nil {
+			return
+		}
+		user.credentials = append(user.credentials, credential)
+	}
+
+	return
+}
+
+// The challenge is kept in a sealed cookie, label keeps login and
+// registration challenges from being swapped for each other
+func (app *Application) setWebAuthnCookie(c *gin.Context, label string, session *webauthn.SessionData) error {
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := sealValue(deriveKey(app.appSecret, "webauthn-"+label), raw)
+	if err != nil {
+		return err
+	}
+	app.setCookie(c, WEBAUTHN_COOKIE, sealed, webauthnCookieMaxAge)
+
+	return nil
+}
+
+func (app *Application) parseWebAuthnCookie(c *gin.Context, label string) (session webauthn.SessionData, err error) {
+	sealed, err := c.Cookie(WEBAUTHN_COOKIE)
+	if err != nil {
+		return
+	}
+	app.setCookie(c, WEBAUTHN_COOKIE, "", -1) // Every challenge is only good for one try
+
+	raw, err := openValue(deriveKey(app.appSecret, "webauthn-"+label), sealed)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(raw, &session)
+
+	return
+}
+
+func (app *Application) passkeysEnabled(c *gin.Context) bool {
+	if app.webAuthn == nil {
+		c.String(http.StatusNotFound, ErrPasskeysDisabled.Error())
+
+		return false
+	}
+
+	return true
+}
+
+// Starts adding a passkey to the logged in account, responds with the
+// options for navigator.credentials.create()
+func (app *Application) passkeyRegisterBeginAPI(c *gin.Context) {
+	if !app.passkeysEnabled(c) {
+		return
+	}
+
+	account, ok := getAccount(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	user, passkeys, err := app.loadPasskeyUser(account)
+	if err != nil {
+		log.Err(err).Msg("Failed to load passkeys")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	if len(passkeys) >= maxPasskeysPerAccount {
+		c.String(http.StatusBadRequest, ErrTooManyPasskeys.Error())
+
+		return
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	creation, session, err := app.webAuthn.BeginRegistration(
+		user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		log.Err(err).Msg("Failed to begin passkey registration")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	if err = app.setWebAuthnCookie(c, "register", session); err != nil {
+		log.Err(err).Msg("Failed to store passkey registration challenge")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, creation)
+}
+
+// Body is the credential from navigator.credentials.create(), nickname goes in the query
+func (app *Application) passkeyRegisterFinishAPI(c *gin.Context) {
+	if !app.passkeysEnabled(c) {
+		return
+	}
+
+	account, ok := getAccount(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	session, err := app.parseWebAuthnCookie(c, "register")
+	if err != nil {
+		c.String(http.StatusBadRequest, ErrInvalidPasskey.Error())
+
+		return
+	}
+
+	user, _, err := app.loadPasskeyUser(account)
+	if err != nil {
+		log.Err(err).Msg("Failed to load passkeys")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	credential, err := app.webAuthn.FinishRegistration(user, session, c.Request)
+	if err != nil {
+		log.Debug().Err(err).Msg("Passkey registration failed")
+		c.String(http.StatusBadRequest, ErrInvalidPasskey.Error())
+
+		return
+	}
+
+	raw, err := json.Marshal(credential)
+	if err != nil {
+		log.Err(err).Msg("Failed to encode passkey")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	nickname := []rune(strings.TrimSpace(c.Query("nickname")))
+	if len(nickname) > passkeyNicknameLength {
+		nickname = nickname[:passkeyNicknameLength]
+	}
+	if len(nickname) == 0 {
+		nickname = []rune("Passkey")
+	}
+
+	if err = app.db.CreatePasskey(
+		account.ID,
+		string(nickname),
+		base64.RawURLEncoding.EncodeToString(credential.ID),
+		string(raw),
+	); err != nil {
+		log.Err(err).Msg("Failed to store passkey")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.String(http.StatusOK, "Passkey added")
+}
+
+type deletePasskeyInput struct {
+	ID uint `form:"id" binding:"required"`
+}
+
+func (app *Application) deletePasskeyAPI(c *gin.Context) {
+	account, ok := getAccount(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	var input deletePasskeyInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	identities, err := app.linkedIdentityCount(account)
+	if err != nil {
+		log.Err(err).Msg("Failed to count linked identities")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	if identities <= 1 {
+		c.String(http.StatusConflict, ErrLastLoginMethod.Error())
+
+		return
+	}
+
+	err = app.db.DeletePasskey(account.ID, input.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, ErrPasskeyNotFound.Error())
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to delete passkey")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.String(http.StatusOK, "Passkey removed")
+}
+
+// Responds with the options for navigator.credentials.get(), no username is
+// needed since the passkeys are resident
+func (app *Application) passkeyLoginBeginAPI(c *gin.Context) {
+	if !app.passkeysEnabled(c) {
+		return
+	}
+
+	assertion, session, err := app.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		log.Err(err).Msg("Failed to begin passkey login")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	if err = app.setWebAuthnCookie(c, "login", session); err != nil {
+		log.Err(err).Msg("Failed to store passkey login challenge")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, assertion)
+}
+
+// Body is the assertion from navigator.credentials.get(), logs in the same
+// way the login providers do
+func (app *Application) passkeyLoginFinishAPI(c *gin.Context) {
+	if !app.passkeysEnabled(c) {
+		return
+	}
+
+	session, err := app.parseWebAuthnCookie(c, "login")
+	if err != nil {
+		c.String(http.StatusBadRequest, ErrInvalidPasskey.Error())
+
+		return
+	}
+
+	var passkey db.Passkeys
+	// The library checks userHandle against the account the credential belongs to
+	handler := func(rawID, _ []byte) (webauthn.User, error) {
+		var lookupErr error
+		if passkey, lookupErr = app.db.GetPasskeyByCredentialID(base64.RawURLEncoding.EncodeToString(rawID)); lookupErr != nil {
+			return nil, lookupErr
+		}
+
+		account, accountErr := app.db.GetAccountByID(passkey.AccountID)
+		if accountErr != nil {
+			return nil, accountErr
+		}
+
+		user, _, loadErr := app.loadPasskeyUser(account)
+
+		return user, loadErr
+	}
+
+	user, credential, err := app.webAuthn.FinishPasskeyLogin(handler, session, c.Request)
+	if err != nil {
+		log.Debug().Err(err).Msg("Passkey login failed")
+		c.String(http.StatusUnauthorized, ErrInvalidPasskey.Error())
+
+		return
+	}
+	if credential.Authenticator.CloneWarning {
+		log.Warn().Uint("passkey_id", passkey.ID).Msg("Passkey sign count went backwards, it might have been cloned")
+		c.String(http.StatusUnauthorized, ErrInvalidPasskey.Error())
+
+		return
+	}
+
+	raw, err := json.Marshal(credential)
+	if err != nil {
+		log.Err(err).Msg("Failed to encode passkey")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	if err = app.db.UpdatePasskeyCredential(passkey.ID, string(raw)); err != nil {
+		log.Err(err).Msg("Failed to update passkey")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	app.completeLogin(c, user.(passkeyUser).account, loginMethodPasskey)
+}
@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const passkeyTestOrigin = "https://hostling.example.com"
+
+func newPasskeyTestApp(t *testing.T) *Application {
+	t.Helper()
+
+	return newTestApp(t, func(c *Config) {
+		c.PublicUrl = passkeyTestOrigin
+		c.Branding = "Hostling"
+	})
+}
+
+// Software authenticator holding a single resident ES256 passkey
+type testAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	_, _ = rand.Read(credentialID)
+
+	return &testAuthenticator{key: key, credentialID: credentialID}
+}
+
+func (a *testAuthenticator) authData(flags protocol.AuthenticatorFlags, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte("hostling.example.com"))
+	data := append(rpIDHash[:], byte(flags))
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	return append(data, attested...)
+}
+
+func clientData(t *testing.T, ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+
+	raw, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    passkeyTestOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return raw
+}
+
+func postJSON(client *testClient, path string, body any) *httptest.ResponseRecorder {
+	raw, _ := json.Marshal(body)
+
+	return client.send(http.MethodPost, path, "application/json", bytes.NewReader(raw))
+}
+
+// Runs navigator.credentials.create() against the begin and finish endpoints
+func (a *testAuthenticator) register(t *testing.T, client *testClient) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := client.do(http.MethodPost, "/api/account/passkey/begin", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("begin registration: got %d: %s", w.Code, w.Body)
+	}
+	var creation protocol.CredentialCreation
+	if err := json.Unmarshal(w.Body.Bytes(), &creation); err != nil {
+		t.Fatal(err)
+	}
+	userHandle, err := base64.RawURLEncoding.DecodeString(creation.Response.User.ID.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = userHandle
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // Zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(protocol.FlagUserPresent|protocol.FlagUserVerified|protocol.FlagAttestedCredentialData, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(a.credentialID)
+
+	return postJSON(client, "/api/account/passkey/finish?nickname=laptop", map[string]any{
+		"id":    id,
+		"rawId": id,
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData(t, "webauthn.create", creation.Response.Challenge)),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+		},
+	})
+}
+
+// Runs navigator.credentials.get() against the login endpoints
+func (a *testAuthenticator) login(t *testing.T, client *testClient) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := client.do(http.MethodPost, "/api/auth/passkey/begin", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("begin login: got %d: %s", w.Code, w.Body)
+	}
+	var assertion protocol.CredentialAssertion
+	if err := json.Unmarshal(w.Body.Bytes(), &assertion); err != nil {
+		t.Fatal(err)
+	}
+
+	authData := a.authData(protocol.FlagUserPresent|protocol.FlagUserVerified, nil)
+	data := clientData(t, "webauthn.get", assertion.Response.Challenge)
+	dataHash := sha256.Sum256(data)
+	digest := sha256.Sum256(append(bytes.Clone(authData), dataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(a.credentialID)
+
+	return postJSON(client, "/api/auth/passkey/finish", map[string]any{
+		"id":    id,
+		"rawId": id,
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(data),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(a.userHandle),
+		},
+	})
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	app := newPasskeyTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	authenticator := newTestAuthenticator(t)
+
+	if w := authenticator.register(t, newSessionClient(t, app, account)); w.Code != http.StatusOK {
+		t.Fatalf("registration: got %d: %s", w.Code, w.Body)
+	}
+
+	authenticator.signCount = 1
+	client := newTestClient(app)
+	if w := authenticator.login(t, client); w.Code != http.StatusSeeOther {
+		t.Fatalf("login: got %d: %s", w.Code, w.Body)
+	}
+	if !loggedIn(client) {
+		t.Fatal("not logged in after the passkey login")
+	}
+
+	// Some other key claiming the same credential
+	impostor := newTestAuthenticator(t)
+	impostor.credentialID = authenticator.credentialID
+	impostor.userHandle = authenticator.userHandle
+	impostor.signCount = 2
+	if w := impostor.login(t, newTestClient(app)); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong key: got %d, want 401", w.Code)
+	}
+}
+
+// A sign count going backwards means the key was copied
+func TestPasskeyCloneDetected(t *testing.T) {
+	app := newPasskeyTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	authenticator := newTestAuthenticator(t)
+	if w := authenticator.register(t, newSessionClient(t, app, account)); w.Code != http.StatusOK {
+		t.Fatalf("registration: got %d: %s", w.Code, w.Body)
+	}
+
+	authenticator.signCount = 5
+	if w := authenticator.login(t, newTestClient(app)); w.Code != http.StatusSeeOther {
+		t.Fatalf("login: got %d: %s", w.Code, w.Body)
+	}
+	authenticator.signCount = 3
+	if w := authenticator.login(t, newTestClient(app)); w.Code != http.StatusUnauthorized {
+		t.Fatalf("lower sign count: got %d, want 401", w.Code)
+	}
+}
+
+func TestPasskeyLoginRefusesSuspended(t *testing.T) {
+	app := newPasskeyTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	authenticator := newTestAuthenticator(t)
+	if w := authenticator.register(t, newSessionClient(t, app, account)); w.Code != http.StatusOK {
+		t.Fatalf("registration: got %d: %s", w.Code, w.Body)
+	}
+	if err := app.db.SuspendAccount(account.ID, db.SuspendAccountInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(app)
+	if w := authenticator.login(t, client); w.Code != http.StatusForbidden {
+		t.Fatalf("got %d, want 403", w.Code)
+	}
+	if loggedIn(client) {
+		t.Fatal("suspended account got a session")
+	}
+}
+
+func TestPasskeyLastLoginMethod(t *testing.T) {
+	app := newPasskeyTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	client := newSessionClient(t, app, account)
+	if w := newTestAuthenticator(t).register(t, client); w.Code != http.StatusOK {
+		t.Fatalf("registration: got %d: %s", w.Code, w.Body)
+	}
+
+	passkeys, err := app.db.GetPasskeys(account.ID)
+	if err != nil || len(passkeys) != 1 {
+		t.Fatalf("%d passkeys: %v", len(passkeys), err)
+	}
+	if passkeys[0].Nickname != "laptop" {
+		t.Errorf("nickname %q, want laptop", passkeys[0].Nickname)
+	}
+	id := strconv.Itoa(int(passkeys[0].ID))
+
+	if w := client.do(http.MethodDelete, "/api/account/passkey", map[string]string{"id": id}); w.Code != http.StatusConflict {
+		t.Fatalf("removing the only login method: got %d, want 409", w.Code)
+	}
+
+	if w := newTestAuthenticator(t).register(t, client); w.Code != http.StatusOK {
+		t.Fatalf("second registration: got %d: %s", w.Code, w.Body)
+	}
+	if w := client.do(http.MethodDelete, "/api/account/passkey", map[string]string{"id": id}); w.Code != http.StatusOK {
+		t.Fatalf("removing one of two passkeys: got %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,397 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/argon2"
+	"gorm.io/gorm"
+)
+
+// Argon2id parameters, the minimum OWASP recommends. Hashes made with older
+// parameters get upgraded the next time the account logs in.
+const (
+	argon2Time    = 2
+	argon2Memory  = 19 * 1024 // KiB
+	argon2Threads = 1
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+
+	minPasswordLength = 8   // Characters
+	maxPasswordLength = 256 // Bytes, bounds the work argon2 does per guess
+
+	// Failed logins allowed per username from one IP before that IP gets
+	// locked out of it, and from anywhere before the username is locked
+	passwordMaxAttempts     = 5
+	passwordMaxUserAttempts = 50
+	passwordLockoutWindow   = 15 * time.Minute
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_.-]{3,32}$`)
+
+var (
+	ErrPasswordLoginDisabled = errors.New("password login isn't enabled on this instance")
+	ErrInvalidCredentials    = errors.New("wrong username or password")
+	ErrWrongPassword         = errors.New("current password is wrong")
+	ErrPasswordLocked        = errors.New("too many failed logins, try again later")
+	ErrInvalidUsername       = errors.New("username must be 3 to 32 characters of a-z, 0-9, '_', '.' or '-'")
+	ErrPasswordTooShort      = fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	ErrPasswordTooLong       = fmt.Errorf("password can't be longer than %d bytes", maxPasswordLength)
+	ErrNoPassword            = errors.New("account doesn't have a password")
+	ErrInvalidPasswordHash   = errors.New("invalid password hash")
+)
+
+// Usernames are case insensitive
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func validatePassword(password string) error {
+	switch length := utf8.RuneCountInString(password); {
+	case length < minPasswordLength:
+		return ErrPasswordTooShort
+	case len(password) > maxPasswordLength:
+		return ErrPasswordTooLong
+	}
+
+	return nil
+}
+
+func argon2Params() string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", argon2Memory, argon2Time, argon2Threads)
+}
+
+// Formatted as $argon2id$v=19$m=19456,t=2,p=1$salt$hash
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s",
+		argon2.Version,
+		argon2Params(),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+// Checks the password with the parameters stored in the hash, rehash reports
+// whether they're older than the current ones
+func verifyPassword(encoded string, password string) (ok bool, rehash bool, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	var memory, iterations uint32
+	var threads uint8
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(expected)))
+	ok = subtle.ConstantTimeCompare(hash, expected) == 1
+
+	return ok, ok && parts[3] != argon2Params(), nil
+}
+
+// Checked against when the username doesn't exist, so the response takes
+// as long as a wrong password and doesn't reveal which usernames are taken
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := hashPassword("not a real password")
+	if err != nil {
+		log.Err(err).Msg("Failed to create dummy password hash")
+	}
+
+	return hash
+})
+
+func passwordLockoutKeys(c *gin.Context, username string) []string {
+	return []string{
+		"password:user:" + username + ":ip:" + c.ClientIP(),
+		"password:user:" + username,
+	}
+}
+
+// Counts a login attempt before the password is checked, so parallel guesses
+// can't all get in under the limit. Returns ErrPasswordLocked once the
+// username has used up its failed logins from this IP or from everywhere.
+func (app *Application) countPasswordAttempt(c *gin.Context, username string) (retryAfter time.Time, err error) {
+	limits := []int64{passwordMaxAttempts, passwordMaxUserAttempts}
+	for i, key := range passwordLockoutKeys(c, username) {
+		count, reset, addErr := app.limiterStore.add(c.Request.Context(), key, passwordLockoutWindow, 1)
+		if addErr != nil {
+			log.Err(addErr).Msg("Failed to count login attempt")
+
+			return time.Time{}, nil
+		}
+		if count > limits[i] {
+			return reset, ErrPasswordLocked
+		}
+	}
+
+	return
+}
+
+// Takes back the attempt counted by countPasswordAttempt, only failed logins
+// count towards the lockout
+func (app *Application) uncountPasswordAttempt(c *gin.Context, username string) {
+	for _, key := range passwordLockoutKeys(c, username) {
+		if _, _, err := app.limiterStore.add(c.Request.Context(), key, passwordLockoutWindow, -1); err != nil {
+			log.Err(err).Msg("Failed to uncount login attempt")
+		}
+	}
+}
+
+// Writes the response for a locked username, reports whether it was locked
+func (app *Application) refusePasswordLocked(c *gin.Context, username string) bool {
+	retryAfter, err := app.countPasswordAttempt(c, username)
+	if err == nil {
+		return false
+	}
+
+	c.Header("Retry-After", retryAfterSeconds(retryAfter))
+	c.String(http.StatusTooManyRequests, err.Error())
+
+	return true
+}
+
+type passwordLoginInput struct {
+	Username string `form:"username" binding:"required"`
+	Password string `form:"password" binding:"required"`
+}
+
+func (app *Application) passwordLoginApi(c *gin.Context) {
+	if !app.config.PasswordLogin {
+		c.String(http.StatusNotFound, ErrPasswordLoginDisabled.Error())
+
+		return
+	}
+
+	var input passwordLoginInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	username := normalizeUsername(input.Username)
+	if app.refusePasswordLocked(c, username) {
+		return
+	}
+
+	account, err := app.db.FindAccountByUsername(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		_, _, _ = verifyPassword(dummyPasswordHash(), input.Password)
+		c.String(http.StatusUnauthorized, ErrInvalidCredentials.Error())
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to look up account by username")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	ok, rehash, err := verifyPassword(account.PasswordHash, input.Password)
+	if err != nil {
+		log.Err(err).Uint("account_id", account.ID).Msg("Failed to verify password")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	if !ok {
+		c.String(http.StatusUnauthorized, ErrInvalidCredentials.Error())
+
+		return
+	}
+	app.uncountPasswordAttempt(c, username)
+
+	if rehash {
+		if hash, hashErr := hashPassword(input.Password); hashErr != nil {
+			log.Err(hashErr).Msg("Failed to rehash password")
+		} else if hashErr = app.db.UpdatePasswordHash(account.ID, hash); hashErr != nil {
+			log.Err(hashErr).Msg("Failed to store rehashed password")
+		}
+	}
+
+	app.completeLogin(c, account, loginMethodPassword)
+}
+
+type setPasswordInput struct {
+	Username        string `form:"username" binding:"required"`
+	CurrentPassword string `form:"current_password"` // Needed when the account already has a password
+	NewPassword     string `form:"new_password" binding:"required"`
+}
+
+// Sets up local login, or changes the username and password of it. Other
+// sessions are signed out when an existing password changes.
+func (app *Application) setPasswordAPI(c *gin.Context) {
+	if !app.config.PasswordLogin {
+		c.String(http.StatusNotFound, ErrPasswordLoginDisabled.Error())
+
+		return
+	}
+
+	account, ok := getAccount(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	var input setPasswordInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	username := normalizeUsername(input.Username)
+	if !usernamePattern.MatchString(username) {
+		c.String(http.StatusBadRequest, ErrInvalidUsername.Error())
+
+		return
+	}
+	if err := validatePassword(input.NewPassword); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	hadPassword := account.PasswordHash != ""
+	if hadPassword && !app.checkCurrentPassword(c, account, input.CurrentPassword) {
+		return
+	}
+
+	hash, err := hashPassword(input.NewPassword)
+	if err != nil {
+		log.Err(err).Msg("Failed to hash password")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	err = app.db.SetPassword(account.ID, username, hash)
+	if errors.Is(err, db.ErrUsernameTaken) {
+		c.String(http.StatusConflict, err.Error())
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to set password")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	if hadPassword {
+		if sessionToken, ok := getSessionToken(c); ok {
+			if _, err = app.db.DeleteOtherSessions(account.ID, sessionToken); err != nil {
+				log.Err(err).Msg("Failed to sign out other sessions")
+			}
+		}
+	}
+
+	c.String(http.StatusOK, "Password saved")
+}
+
+type removePasswordInput struct {
+	CurrentPassword string `form:"current_password" binding:"required"`
+}
+
+func (app *Application) removePasswordAPI(c *gin.Context) {
+	account, ok := getAccount(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	if account.PasswordHash == "" {
+		c.String(http.StatusBadRequest, ErrNoPassword.Error())
+
+		return
+	}
+
+	var input removePasswordInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	identities, err := app.linkedIdentityCount(account)
+	if err != nil {
+		log.Err(err).Msg("Failed to count linked identities")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	if identities <= 1 {
+		c.String(http.StatusConflict, ErrLastLoginMethod.Error())
+
+		return
+	}
+
+	if !app.checkCurrentPassword(c, account, input.CurrentPassword) {
+		return
+	}
+
+	if err = app.db.RemovePassword(account.ID); err != nil {
+		log.Err(err).Msg("Failed to remove password")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.String(http.StatusOK, "Password removed")
+}
+
+// Wrong guesses count towards the same lockout as the login form. Writes the
+// error response when the password doesn't match.
+func (app *Application) checkCurrentPassword(c *gin.Context, account db.Accounts, password string) bool {
+	if app.refusePasswordLocked(c, account.Username) {
+		return false
+	}
+
+	ok, _, err := verifyPassword(account.PasswordHash, password)
+	if err != nil {
+		log.Err(err).Uint("account_id", account.ID).Msg("Failed to verify password")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return false
+	}
+	if !ok {
+		c.String(http.StatusForbidden, ErrWrongPassword.Error())
+
+		return false
+	}
+	app.uncountPasswordAttempt(c, account.Username)
+
+	return true
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func newPasswordTestApp(t *testing.T) (*Application, db.Accounts) {
+	t.Helper()
+
+	app := newTestApp(t, func(c *Config) { c.PasswordLogin = true })
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = app.db.SetPassword(account.ID, "alice", hash); err != nil {
+		t.Fatal(err)
+	}
+
+	return app, account
+}
+
+func passwordLogin(client *testClient, password string) int {
+	return client.do(http.MethodPost, "/api/auth/password", map[string]string{
+		"username": "Alice",
+		"password": password,
+	}).Code
+}
+
+func TestPasswordLogin(t *testing.T) {
+	app, _ := newPasswordTestApp(t)
+	client := newTestClient(app)
+
+	if code := passwordLogin(client, "wrong horse"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: got %d, want 401", code)
+	}
+	if code := passwordLogin(client, "correct horse"); code != http.StatusSeeOther {
+		t.Fatalf("right password: got %d, want 303", code)
+	}
+	if _, ok := client.cookies[AUTH_COOKIE]; !ok {
+		t.Fatal("no session cookie after logging in")
+	}
+
+	unknown := newTestClient(app).do(http.MethodPost, "/api/auth/password", map[string]string{
+		"username": "nobody",
+		"password": "correct horse",
+	})
+	if unknown.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown username: got %d, want 401", unknown.Code)
+	}
+}
+
+// Only failed logins count, logging in over and over is fine
+func TestPasswordLoginSuccessesDontLock(t *testing.T) {
+	app, _ := newPasswordTestApp(t)
+
+	for i := range passwordMaxAttempts * 2 {
+		if code := passwordLogin(newTestClient(app), "correct horse"); code != http.StatusSeeOther {
+			t.Fatalf("login %d: got %d, want 303", i+1, code)
+		}
+	}
+}
+
+func TestPasswordLockoutPerIP(t *testing.T) {
+	app, _ := newPasswordTestApp(t)
+
+	attacker := newTestClient(app)
+	attacker.remoteAddr = "198.51.100.7:1234"
+	for i := range passwordMaxAttempts {
+		if code := passwordLogin(attacker, "wrong horse"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got %d, want 401", i+1, code)
+		}
+	}
+	if code := passwordLogin(attacker, "correct horse"); code != http.StatusTooManyRequests {
+		t.Fatalf("attempt over the limit: got %d, want 429", code)
+	}
+
+	if code := passwordLogin(newTestClient(app), "correct horse"); code != http.StatusSeeOther {
+		t.Fatalf("owner from another IP: got %d, want 303", code)
+	}
+}
+
+func TestPasswordLockoutPerUser(t *testing.T) {
+	app, _ := newPasswordTestApp(t)
+
+	for i := range passwordMaxUserAttempts {
+		client := newTestClient(app)
+		client.remoteAddr = fmt.Sprintf("198.51.100.%d:1234", i)
+		if code := passwordLogin(client, "wrong horse"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got %d, want 401", i+1, code)
+		}
+	}
+
+	if code := passwordLogin(newTestClient(app), "correct horse"); code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want 429", code)
+	}
+}
+
+// Guesses sent at the same time all count before any of them is checked
+func TestPasswordLockoutConcurrent(t *testing.T) {
+	app, _ := newPasswordTestApp(t)
+
+	var mu sync.Mutex
+	unauthorized := 0
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if passwordLogin(newTestClient(app), "wrong horse") == http.StatusUnauthorized {
+				mu.Lock()
+				unauthorized++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if unauthorized != passwordMaxAttempts {
+		t.Fatalf("%d guesses were checked, want %d", unauthorized, passwordMaxAttempts)
+	}
+}
+
+func TestChangePasswordCountsTowardsLockout(t *testing.T) {
+	app, account := newPasswordTestApp(t)
+	client := newSessionClient(t, app, account)
+
+	for i := range passwordMaxAttempts {
+		w := client.do(http.MethodPost, "/api/account/password", map[string]string{
+			"username":         "alice",
+			"current_password": "wrong horse",
+			"new_password":     "battery staple",
+		})
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("attempt %d: got %d, want 403", i+1, w.Code)
+		}
+	}
+
+	if code := passwordLogin(newTestClient(app), "correct horse"); code != http.StatusTooManyRequests {
+		t.Fatalf("login after wrong current passwords: got %d, want 429", code)
+	}
+}
+
+// The minimum counts characters, the maximum bytes
+func TestValidatePassword(t *testing.T) {
+	for password, want := range map[string]error{
+		strings.Repeat("é", 8):   nil,
+		strings.Repeat("é", 7):   ErrPasswordTooShort,
+		strings.Repeat("a", 256): nil,
+		strings.Repeat("a", 257): ErrPasswordTooLong,
+		strings.Repeat("é", 129): ErrPasswordTooLong,
+	} {
+		if err := validatePassword(password); err != want {
+			t.Errorf("%d characters, %d bytes: got %v, want %v", utf8.RuneCountInString(password), len(password), err, want)
+		}
+	}
+}
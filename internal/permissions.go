@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+)
+
+// Something an account type is allowed to do, handlers check these instead
+// of account types
+type permission string
+
+const (
+	permUpload         permission = "upload"          // Upload files and create upload tokens
+	permModerateFiles  permission = "moderate_files"  // Review and remove anyone's public files
+	permManageAccounts permission = "manage_accounts" // Admin panel, audit log and everything on them
+)
+
+var accountTypePermissions = map[string][]permission{
+	db.AccountTypeAdmin:     {permUpload, permModerateFiles, permManageAccounts},
+	db.AccountTypeModerator: {permUpload, permModerateFiles},
+	db.AccountTypeUser:      {permUpload},
+	db.AccountTypeGuest:     {},
+}
+
+var ErrMissingPermission = errors.New("your account isn't allowed to do that")
+
+func can(account db.Accounts, p permission) bool {
+	return slices.Contains(accountTypePermissions[account.AccountType], p)
+}
+
+// Must run after one of the authentication middlewares. Upload tokens don't
+// attach an account, isValidUploadToken checks their owner instead.
+func (app *Application) requirePermission(p permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := getUploadToken(c); ok {
+			c.Next()
+
+			return
+		}
+
+		account, ok := getAccount(c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+
+			return
+		}
+		if !can(account, p) {
+			c.String(http.StatusForbidden, ErrMissingPermission.Error())
+			c.Abort()
+
+			return
+		}
+		c.Next()
+	}
+}
+
+// Top bar values every logged in page needs
+func (app *Application) accountTemplateInput(c *gin.Context, account db.Accounts, templateInput gin.H) {
+	templateInput["LoggedIn"] = true
+	templateInput["AccountID"] = account.ID
+	templateInput["CSRFToken"] = app.csrfToken(c)
+	templateInput["IsAdmin"] = can(account, permManageAccounts)
+	templateInput["CanModerate"] = can(account, permModerateFiles)
+	templateInput["CanUpload"] = can(account, permUpload)
+
+	if can(account, permManageAccounts) {
+		templateInput["HasAdminWarning"] = app.hasAdminWarning()
+	}
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func TestPermissionsByAccountType(t *testing.T) {
+	app := newTestApp(t)
+
+	routes := []struct {
+		method, path string
+		form         map[string]string
+		allowed      []string
+	}{
+		{http.MethodPost, "/api/account/upload_token", map[string]string{}, []string{db.AccountTypeUser, db.AccountTypeModerator, db.AccountTypeAdmin}},
+		{http.MethodGet, "/api/moderation/files", nil, []string{db.AccountTypeModerator, db.AccountTypeAdmin}},
+		{http.MethodGet, "/api/admin/audit_log", nil, []string{db.AccountTypeAdmin}},
+	}
+
+	for _, accountType := range []string{db.AccountTypeGuest, db.AccountTypeUser, db.AccountTypeModerator, db.AccountTypeAdmin} {
+		client := newSessionClient(t, app, newTestAccount(t, app, accountType))
+		for _, route := range routes {
+			want := http.StatusForbidden
+			for _, allowed := range route.allowed {
+				if allowed == accountType {
+					want = http.StatusOK
+				}
+			}
+			if w := client.do(route.method, route.path, route.form); w.Code != want {
+				t.Errorf("%s %s %s: got %d, want %d", accountType, route.method, route.path, w.Code, want)
+			}
+		}
+	}
+}
+
+func TestModeratorRemovesPublicFiles(t *testing.T) {
+	app := newTestApp(t)
+	uploader := newTestAccount(t, app, db.AccountTypeUser)
+	newTestFile(t, app, uploader, "public.txt")
+	newTestFile(t, app, uploader, "private.txt")
+	if err := app.db.Model(&db.Files{}).Where("file_name = ?", "private.txt").Update("public", false).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	user := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeUser))
+	if w := user.do(http.MethodDelete, "/api/moderation/file", map[string]string{"file_name": "public.txt"}); w.Code != http.StatusForbidden {
+		t.Fatalf("user removing a file: got %d, want 403", w.Code)
+	}
+
+	moderator := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeModerator))
+	if w := moderator.do(http.MethodDelete, "/api/moderation/file", map[string]string{"file_name": "private.txt"}); w.Code != http.StatusNotFound {
+		t.Fatalf("moderator removing a private file: got %d, want 404", w.Code)
+	}
+	if w := moderator.do(http.MethodDelete, "/api/moderation/file", map[string]string{"file_name": "public.txt"}); w.Code != http.StatusOK {
+		t.Fatalf("moderator removing a public file: got %d, want 200", w.Code)
+	}
+	if _, err := app.db.GetFileByName("public.txt"); err == nil {
+		t.Fatal("file is still there")
+	}
+
+	// Moderators can't manage accounts
+	if code := setAccountType(moderator, uploader.ID, db.AccountTypeAdmin); code != http.StatusForbidden {
+		t.Fatalf("moderator changing account types: got %d, want 403", code)
+	}
+}
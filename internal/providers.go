@@ -0,0 +1,278 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/discord"
+	"github.com/markbates/goth/providers/gitea"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/gitlab"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/openidConnect"
+)
+
+// The kind of login provider, each one can be configured several times
+// under different names, e.g two GitLab instances
+type providerType string
+
+const (
+	providerGithub  providerType = "github"
+	providerGitlab  providerType = "gitlab"
+	providerGitea   providerType = "gitea" // Forgejo speaks the same API
+	providerDiscord providerType = "discord"
+	providerGoogle  providerType = "google"
+	providerOIDC    providerType = "openid-connect"
+)
+
+type providerKind struct {
+	displayName string
+	icon        string // lucide-icon name, should probably be replaced with simpleicons.org
+	defaultURL  string // Instance used when url isn't set, empty when it can't be self-hosted
+
+	// Where the user's profile lives, empty when the provider has no public profiles
+	profileURL func(baseURL string, username string) string
+
+	// Field in the user's data that's true once the email is verified, empty
+	// when the provider doesn't say so registration.email_domains can't trust it
+	emailVerifiedClaim string
+
+	// needEmail asks for the email scope when the provider doesn't send it by default
+	new func(p providerConfig, callbackURL string, needEmail bool) (goth.Provider, error)
+}
+
+func profileUnderBaseURL(baseURL string, username string) string {
+	return baseURL + "/" + username
+}
+
+var providerKinds = map[providerType]providerKind{
+	providerGithub: {
+		displayName: "GitHub",
+		icon:        "github",
+		profileURL: func(_ string, username string) string {
+			return "https://github.com/" + username
+		},
+		new: func(p providerConfig, callbackURL string, _ bool) (goth.Provider, error) {
+			return github.New(p.ClientID, p.ClientSecret, callbackURL), nil
+		},
+	},
+	providerGitlab: {
+		displayName: "GitLab",
+		icon:        "gitlab",
+		defaultURL:  "https://gitlab.com",
+		profileURL:  profileUnderBaseURL,
+		new: func(p providerConfig, callbackURL string, _ bool) (goth.Provider, error) {
+			return gitlab.NewCustomisedURL(
+				p.ClientID, p.ClientSecret, callbackURL,
+				p.URL+"/oauth/authorize", p.URL+"/oauth/token", p.URL+"/api/v4/user",
+				"read_user",
+			), nil
+		},
+	},
+	providerGitea: {
+		displayName: "Gitea",
+		icon:        "git-branch",
+		defaultURL:  "https://gitea.com",
+		profileURL:  profileUnderBaseURL,
+		new: func(p providerConfig, callbackURL string, _ bool) (goth.Provider, error) {
+			return gitea.NewCustomisedURL(
+				p.ClientID, p.ClientSecret, callbackURL,
+				p.URL+"/login/oauth/authorize", p.URL+"/login/oauth/access_token", p.URL+"/api/v1/user",
+			), nil
+		},
+	},
+	providerDiscord: {
+		displayName:        "Discord",
+		icon:               "message-circle",
+		emailVerifiedClaim: "verified",
+		new: func(p providerConfig, callbackURL string, needEmail bool) (goth.Provider, error) {
+			scopes := []string{discord.ScopeIdentify}
+			if needEmail {
+				scopes = append(scopes, discord.ScopeEmail)
+			}
+
+			return discord.New(p.ClientID, p.ClientSecret, callbackURL, scopes...), nil
+		},
+	},
+	providerGoogle: {
+		displayName:        "Google",
+		icon:               "globe",
+		emailVerifiedClaim: "verified_email",
+		new: func(p providerConfig, callbackURL string, needEmail bool) (goth.Provider, error) {
+			scopes := []string{"profile"}
+			if needEmail {
+				scopes = append(scopes, "email")
+			}
+
+			return google.New(p.ClientID, p.ClientSecret, callbackURL, scopes...), nil
+		},
+	},
+	providerOIDC: {
+		displayName:        "OpenID Connect",
+		icon:               "key-round",
+		emailVerifiedClaim: "email_verified",
+		new: func(p providerConfig, callbackURL string, needEmail bool) (goth.Provider, error) {
+			scopes := p.Scopes
+			if needEmail && !slices.Contains(scopes, "email") {
+				scopes = append(slices.Clone(scopes), "email")
+			}
+
+			return openidConnect.New(p.ClientID, p.ClientSecret, callbackURL, p.DiscoveryURL, scopes...)
+		},
+	},
+}
+
+// A login provider from the config, the name it's under is used in the
+// callback URL and stored with every identity linked through it, so it
+// shouldn't be changed once accounts have linked it.
+type providerConfig struct {
+	Type         providerType `toml:"type"`          // Defaults to the name, see providerType
+	DisplayName  string       `toml:"display_name"`  // Shown on the login buttons, e.g "Company SSO"
+	ClientID     string       `toml:"client_id"`     // Better given through <NAME>_CLIENT_ID
+	ClientSecret string       `toml:"client_secret"` // Better given through <NAME>_CLIENT_SECRET
+	URL          string       `toml:"url"`           // Self-hosted GitLab, Gitea or Forgejo instance
+	DiscoveryURL string       `toml:"discovery_url"` // OpenID Connect only
+
+	// OpenID Connect only, groups are read from GroupsClaim in the ID token
+	// and userinfo to pick the account type
+	Scopes      []string `toml:"scopes"`       // Extra scopes to ask for, some providers only send groups with a "groups" scope
+	GroupsClaim string   `toml:"groups_claim"` // Defaults to "groups"
+	groupMapping
+}
+
+func (p providerConfig) kind() providerKind {
+	return providerKinds[p.Type]
+}
+
+func (p providerConfig) name() string {
+	if p.DisplayName != "" {
+		return p.DisplayName
+	}
+
+	return p.kind().displayName
+}
+
+// Providers missing credentials stay off without an error, so the
+// environment can decide which ones are in use
+func (p providerConfig) enabled() bool {
+	if p.ClientID == "" || p.ClientSecret == "" {
+		return false
+	}
+	if p.Type == providerOIDC && p.DiscoveryURL == "" {
+		return false
+	}
+
+	return true
+}
+
+// Keyed by provider name, e.g [login_providers.gitlab]
+type loginProvidersConfig map[string]providerConfig
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// e.g GITLAB for "gitlab", WORK_GITLAB for "work-gitlab"
+func providerEnvPrefix(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Fills in settings from <NAME>_CLIENT_ID, <NAME>_CLIENT_SECRET, <NAME>_URL
+// and <NAME>_DISCOVERY_URL. Every provider type can also be turned on by its
+// environment variables alone, without a config section.
+func (c loginProvidersConfig) withEnv() loginProvidersConfig {
+	providers := make(loginProvidersConfig, len(c))
+	for name, p := range c {
+		providers[name] = p
+	}
+	for t := range providerKinds {
+		if _, ok := providers[string(t)]; !ok && os.Getenv(providerEnvPrefix(string(t))+"_CLIENT_ID") != "" {
+			providers[string(t)] = providerConfig{}
+		}
+	}
+
+	for name, p := range providers {
+		if p.Type == "" {
+			p.Type = providerType(name)
+		}
+
+		prefix := providerEnvPrefix(name)
+		for env, field := range map[string]*string{
+			"_CLIENT_ID":     &p.ClientID,
+			"_CLIENT_SECRET": &p.ClientSecret,
+			"_SECRET":        &p.ClientSecret, // GITHUB_SECRET from before client secrets had a common name
+			"_URL":           &p.URL,
+			"_DISCOVERY_URL": &p.DiscoveryURL,
+		} {
+			if value := os.Getenv(prefix + env); value != "" && (env != "_SECRET" || *field == "") {
+				*field = value
+			}
+		}
+
+		if p.URL == "" {
+			p.URL = p.kind().defaultURL
+		}
+		if p.GroupsClaim == "" {
+			p.GroupsClaim = "groups"
+		}
+		p.URL = strings.TrimSuffix(p.URL, "/")
+
+		providers[name] = p
+	}
+
+	return providers
+}
+
+func (c loginProvidersConfig) validate() error {
+	for name, p := range c {
+		if !providerNamePattern.MatchString(name) {
+			return fmt.Errorf("login provider name %q can only have a-z, 0-9 and '-'", name)
+		}
+		if _, ok := providerKinds[p.Type]; !ok {
+			return fmt.Errorf("login provider %q has unknown type %q", name, p.Type)
+		}
+		if p.URL != "" && p.kind().defaultURL == "" {
+			return fmt.Errorf("login provider %q can't have a url, only self-hosted types can", name)
+		}
+		if p.Type != providerOIDC && (p.groupMapping.enabled() || p.AutoProvision || len(p.Scopes) > 0) {
+			return fmt.Errorf("login provider %q can't map groups, only openid-connect can", name)
+		}
+	}
+
+	return nil
+}
+
+// Sorted so the login buttons keep their order
+func (c loginProvidersConfig) names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+var ErrProviderNotConfigured = errors.New("login provider isn't configured")
+
+func (app *Application) providerConfig(name string) (p providerConfig, err error) {
+	p, ok := app.config.LoginProviders[name]
+	if !ok || !p.enabled() {
+		return p, ErrProviderNotConfigured
+	}
+
+	return
+}
+
+// Link to the identity's profile on the provider, empty when there's none
+func (app *Application) providerProfileURL(name string, username string) string {
+	p, ok := app.config.LoginProviders[name]
+	if !ok || p.kind().profileURL == nil || username == "" {
+		return ""
+	}
+
+	return p.kind().profileURL(p.URL, username)
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/faux"
+)
+
+// Login provider that skips the round trip to a real one and hands back
+// whichever user is set
+type testOAuthProvider struct {
+	faux.Provider
+	name string
+	user goth.User
+}
+
+func (p *testOAuthProvider) Name() string {
+	return p.name
+}
+
+func (p *testOAuthProvider) FetchUser(goth.Session) (goth.User, error) {
+	return p.user, nil
+}
+
+// App with the providers registered with goth before it starts, so it
+// doesn't set up real ones under the same names
+func newOAuthTestApp(t *testing.T, providers []*testOAuthProvider, configure ...func(c *Config)) *Application {
+	t.Helper()
+
+	for _, p := range providers {
+		goth.UseProviders(p)
+	}
+
+	return newTestApp(t, append([]func(c *Config){func(c *Config) {
+		c.LoginProviders = loginProvidersConfig{}
+		for _, p := range providers {
+			c.LoginProviders[p.name] = providerConfig{Type: providerGithub, ClientID: "id", ClientSecret: "secret"}
+		}
+	}}, configure...)...)
+}
+
+// Comes back from the provider's authorize page to the callback with the
+// state it was sent off with
+func followOAuthRedirect(t *testing.T, client *testClient, provider string, w *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	t.Helper()
+
+	if w.Code != http.StatusTemporaryRedirect && w.Code != http.StatusSeeOther {
+		t.Fatalf("starting the login: got %d: %s", w.Code, w.Body)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := url.Values{"state": {location.Query().Get("state")}, "code": {"code"}}
+
+	return client.do(http.MethodGet, "/api/auth/login/"+provider+"/callback?"+query.Encode(), nil)
+}
+
+func oauthLogin(t *testing.T, client *testClient, provider string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return followOAuthRedirect(t, client, provider, client.do(http.MethodGet, "/api/auth/login/"+provider, nil))
+}
+
+func oauthLink(t *testing.T, client *testClient, provider string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return followOAuthRedirect(t, client, provider, client.do(http.MethodPost, "/api/auth/link/"+provider, nil))
+}
+
+func TestProviderLoginNeedsLinkedIdentity(t *testing.T) {
+	hub := &testOAuthProvider{name: "hub", user: goth.User{UserID: "1", NickName: "alice"}}
+	app := newOAuthTestApp(t, []*testOAuthProvider{hub})
+
+	client := newTestClient(app)
+	if w := oauthLogin(t, client, "hub"); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+		t.Fatalf("unknown identity: got %d to %q, want a redirect to /login", w.Code, w.Header().Get("Location"))
+	}
+	if loggedIn(client) {
+		t.Fatal("unknown identity got a session")
+	}
+}
+
+func TestProviderLinkAndLogin(t *testing.T) {
+	hub := &testOAuthProvider{name: "hub", user: goth.User{UserID: "1", NickName: "alice"}}
+	app := newOAuthTestApp(t, []*testOAuthProvider{hub})
+	account := newTestAccount(t, app, db.AccountTypeUser)
+
+	if w := oauthLink(t, newSessionClient(t, app, account), "hub"); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/settings" {
+		t.Fatalf("link: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// The username is refreshed on every login, the subject is what's matched
+	hub.user.NickName = "alice2"
+	client := newTestClient(app)
+	if w := oauthLogin(t, client, "hub"); w.Code != http.StatusSeeOther {
+		t.Fatalf("login: got %d: %s", w.Code, w.Body)
+	}
+	if !loggedIn(client) {
+		t.Fatal("linked identity didn't log in")
+	}
+	identity, err := app.db.GetLinkedIdentity(account.ID, "hub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "alice2" {
+		t.Errorf("username %q, want alice2", identity.Username)
+	}
+}
+
+func TestProviderIdentityLinkedOnce(t *testing.T) {
+	hub := &testOAuthProvider{name: "hub", user: goth.User{UserID: "1", NickName: "alice"}}
+	app := newOAuthTestApp(t, []*testOAuthProvider{hub})
+
+	owner := newTestAccount(t, app, db.AccountTypeUser)
+	if w := oauthLink(t, newSessionClient(t, app, owner), "hub"); w.Code != http.StatusSeeOther {
+		t.Fatalf("link: got %d", w.Code)
+	}
+
+	other := newTestAccount(t, app, db.AccountTypeUser)
+	if w := oauthLink(t, newSessionClient(t, app, other), "hub"); w.Code != http.StatusConflict {
+		t.Fatalf("linking someone else's identity: got %d, want 409", w.Code)
+	}
+	if account, err := app.db.FindAccountByIdentity("hub", "1"); err != nil || account.ID != owner.ID {
+		t.Fatalf("identity belongs to %d, want %d: %v", account.ID, owner.ID, err)
+	}
+}
+
+func TestProviderUnlinkKeepsALoginMethod(t *testing.T) {
+	hub := &testOAuthProvider{name: "hub", user: goth.User{UserID: "1", NickName: "alice"}}
+	lab := &testOAuthProvider{name: "lab", user: goth.User{UserID: "2", NickName: "alice"}}
+	app := newOAuthTestApp(t, []*testOAuthProvider{hub, lab})
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	client := newSessionClient(t, app, account)
+
+	if w := oauthLink(t, client, "hub"); w.Code != http.StatusSeeOther {
+		t.Fatalf("link: got %d", w.Code)
+	}
+	if w := client.do(http.MethodPost, "/api/auth/unlink/hub", nil); w.Code != http.StatusConflict {
+		t.Fatalf("unlinking the only provider: got %d, want 409", w.Code)
+	}
+
+	if w := oauthLink(t, client, "lab"); w.Code != http.StatusSeeOther {
+		t.Fatalf("second link: got %d", w.Code)
+	}
+	if w := client.do(http.MethodPost, "/api/auth/unlink/hub", nil); w.Code != http.StatusSeeOther {
+		t.Fatalf("unlinking one of two providers: got %d, want 303", w.Code)
+	}
+	if _, err := app.db.FindAccountByIdentity("hub", "1"); err == nil {
+		t.Fatal("identity is still linked")
+	}
+	if w := oauthLogin(t, newTestClient(app), "hub"); w.Header().Get("Location") != "/login" {
+		t.Fatalf("unlinked identity logging in: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Maps the groups someone is in at a login source to an account type. The
+// account type is set again on every login, so changing groups there takes
+// effect the next time they log in.
+type groupMapping struct {
+	AdminGroups     []string `toml:"admin_groups"`     // Members become ADMIN
+	ModeratorGroups []string `toml:"moderator_groups"` // Members become MODERATOR
+	UserGroups      []string `toml:"user_groups"`      // Members become USER, when empty everyone in no other group does
+	GuestGroups     []string `toml:"guest_groups"`     // Members become GUEST
+
+	// Creates an account on first login without an invite code for anyone
+	// who'd get an account type
+	AutoProvision bool `toml:"auto_provision"`
+}
+
+var ErrNotInAllowedGroup = errors.New("not a member of any group allowed on this instance")
+
+func (m groupMapping) enabled() bool {
+	return len(m.AdminGroups) > 0 || len(m.ModeratorGroups) > 0 || len(m.UserGroups) > 0 || len(m.GuestGroups) > 0
+}
+
+// The most allowed account type wins when someone is in several groups. ok is
+// false when the groups don't give any account type.
+func (m groupMapping) accountType(groups []string) (accountType string, ok bool) {
+	inAny := func(allowed []string) bool {
+		return slices.ContainsFunc(groups, func(group string) bool {
+			return slices.Contains(allowed, group)
+		})
+	}
+
+	switch {
+	case inAny(m.AdminGroups):
+		return db.AccountTypeAdmin, true
+	case inAny(m.ModeratorGroups):
+		return db.AccountTypeModerator, true
+	case inAny(m.UserGroups):
+		return db.AccountTypeUser, true
+	case inAny(m.GuestGroups):
+		return db.AccountTypeGuest, true
+	case len(m.UserGroups) == 0:
+		return db.AccountTypeUser, true
+	}
+
+	return "", false
+}
+
+// Reads a groups claim, dots reach into nested objects like Keycloak's
+// "realm_access.roles". The claim can be a list or a single string.
+func claimGroups(claims map[string]any, path string) (groups []string) {
+	var value any = claims
+	for key := range strings.SplitSeq(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return
+		}
+		value = object[key]
+	}
+
+	switch v := value.(type) {
+	case string:
+		groups = append(groups, v)
+	case []any:
+		for _, group := range v {
+			if s, ok := group.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+
+	return
+}
+
+// Sets the account type the groups map to, accounts without an allowed group
+// aren't let in
+func (app *Application) applyGroupMapping(account db.Accounts, mapping groupMapping, groups []string) (db.Accounts, error) {
+	if !mapping.enabled() {
+		return account, nil
+	}
+
+	accountType, ok := mapping.accountType(groups)
+	if !ok {
+		return account, ErrNotInAllowedGroup
+	}
+
+	if account.AccountType != accountType {
+		err := app.db.SetAccountType(account.ID, accountType)
+		if errors.Is(err, db.ErrLastAdmin) {
+			// Leaving nobody to fix the group mapping would be worse
+			log.Warn().
+				Uint("account_id", account.ID).
+				Msg("Group mapping would demote the last admin, keeping them an admin")
+
+			return account, nil
+		} else if err != nil {
+			return account, err
+		}
+		log.Info().
+			Uint("account_id", account.ID).
+			Str("from", account.AccountType).
+			Str("to", accountType).
+			Msg("Account type changed by group mapping")
+		account.AccountType = accountType
+	}
+
+	return account, nil
+}
+
+// Creates an account for an identity nobody has linked yet, when the mapping
+// allows it
+func (app *Application) provisionAccount(
+	c *gin.Context,
+	mapping groupMapping,
+	groups []string,
+	provider string,
+	subject string,
+	username string,
+) (account db.Accounts, err error) {
+	accountType := db.AccountTypeUser
+	if mapping.enabled() {
+		var ok bool
+		if accountType, ok = mapping.accountType(groups); !ok {
+			return account, ErrNotInAllowedGroup
+		}
+	}
+
+	account, err = app.db.ProvisionAccount(db.ProvisionAccountInput{
+		AccountType: accountType,
+		Provider:    provider,
+		Subject:     subject,
+		Username:    username,
+	})
+	if err != nil {
+		return
+	}
+	log.Info().
+		Uint("account_id", account.ID).
+		Str("provider", provider).
+		Str("account_type", accountType).
+		Msg("Provisioned account on first login")
+	app.audit(c, db.AuditLogs{
+		ActorID:  account.ID,
+		TargetID: account.ID,
+		Action:   auditRegister,
+		Detail:   "Provisioned by " + provider + " as " + username,
+	})
+
+	return
+}
@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/markbates/goth"
+)
+
+func TestClaimGroups(t *testing.T) {
+	claims := map[string]any{
+		"groups":       []any{"admins", 7, "users"},
+		"role":         "admins",
+		"realm_access": map[string]any{"roles": []any{"users"}},
+	}
+
+	for path, want := range map[string][]string{
+		"groups":             {"admins", "users"},
+		"role":               {"admins"},
+		"realm_access.roles": {"users"},
+		"realm_access.nope":  nil,
+		"role.nested":        nil,
+		"missing":            nil,
+	} {
+		if got := claimGroups(claims, path); !slices.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestGroupMappingAccountType(t *testing.T) {
+	mapping := groupMapping{
+		AdminGroups:     []string{"admins"},
+		ModeratorGroups: []string{"mods"},
+		UserGroups:      []string{"users"},
+		GuestGroups:     []string{"guests"},
+	}
+
+	for _, tc := range []struct {
+		groups []string
+		want   string
+		ok     bool
+	}{
+		{[]string{"guests", "admins"}, db.AccountTypeAdmin, true},
+		{[]string{"users", "mods"}, db.AccountTypeModerator, true},
+		{[]string{"users"}, db.AccountTypeUser, true},
+		{[]string{"guests"}, db.AccountTypeGuest, true},
+		{[]string{"other"}, "", false},
+		{nil, "", false},
+	} {
+		if got, ok := mapping.accountType(tc.groups); got != tc.want || ok != tc.ok {
+			t.Errorf("%v: got %q %v, want %q %v", tc.groups, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	// Without user groups everyone in no other group is a user
+	mapping.UserGroups = nil
+	if got, ok := mapping.accountType([]string{"other"}); got != db.AccountTypeUser || !ok {
+		t.Errorf("no user groups: got %q %v, want USER", got, ok)
+	}
+}
+
+func newGroupsTestApp(t *testing.T, provider *testOAuthProvider, mapping groupMapping) *Application {
+	t.Helper()
+
+	return newOAuthTestApp(t, []*testOAuthProvider{provider}, func(c *Config) {
+		c.LoginProviders[provider.name] = providerConfig{
+			Type:         providerOIDC,
+			ClientID:     "id",
+			ClientSecret: "secret",
+			DiscoveryURL: "https://sso.example.com/.well-known/openid-configuration",
+			GroupsClaim:  "groups",
+			groupMapping: mapping,
+		}
+	})
+}
+
+func providerAccount(t *testing.T, app *Application, provider string, subject string) db.Accounts {
+	t.Helper()
+
+	account, err := app.db.FindAccountByIdentity(provider, subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return account
+}
+
+func TestProviderGroupsProvision(t *testing.T) {
+	oidc := &testOAuthProvider{name: "oidc", user: goth.User{
+		UserID:   "1",
+		NickName: "alice",
+		RawData:  map[string]any{"groups": []any{"staff", "admins"}},
+	}}
+	app := newGroupsTestApp(t, oidc, groupMapping{
+		AdminGroups:   []string{"admins"},
+		UserGroups:    []string{"staff"},
+		AutoProvision: true,
+	})
+
+	client := newTestClient(app)
+	if w := oauthLogin(t, client, "oidc"); w.Code != http.StatusSeeOther {
+		t.Fatalf("login: got %d: %s", w.Code, w.Body)
+	}
+	if !loggedIn(client) {
+		t.Fatal("provisioned account wasn't logged in")
+	}
+	if account := providerAccount(t, app, "oidc", "1"); account.AccountType != db.AccountTypeAdmin {
+		t.Fatalf("provisioned as %s, want ADMIN", account.AccountType)
+	}
+
+	oidc.user = goth.User{UserID: "2", NickName: "mallory", RawData: map[string]any{"groups": []any{"contractors"}}}
+	outsider := newTestClient(app)
+	if w := oauthLogin(t, outsider, "oidc"); w.Code != http.StatusForbidden {
+		t.Fatalf("user in no allowed group: got %d, want 403", w.Code)
+	}
+	if loggedIn(outsider) {
+		t.Fatal("user in no allowed group got a session")
+	}
+	if _, err := app.db.FindAccountByIdentity("oidc", "2"); err == nil {
+		t.Fatal("account was created for a user in no allowed group")
+	}
+}
+
+// Without auto_provision the groups only decide the type of linked accounts
+func TestProviderGroupsWithoutProvisioning(t *testing.T) {
+	oidc := &testOAuthProvider{name: "oidc", user: goth.User{UserID: "1", NickName: "alice", RawData: map[string]any{"groups": []any{"staff"}}}}
+	app := newGroupsTestApp(t, oidc, groupMapping{UserGroups: []string{"staff"}})
+
+	if w := oauthLogin(t, newTestClient(app), "oidc"); w.Header().Get("Location") != "/login" {
+		t.Fatalf("unlinked identity: got %d to %q, want a redirect to /login", w.Code, w.Header().Get("Location"))
+	}
+	if _, err := app.db.FindAccountByIdentity("oidc", "1"); err == nil {
+		t.Fatal("account was provisioned without auto_provision")
+	}
+}
+
+func TestProviderGroupsUpdateOnLogin(t *testing.T) {
+	oidc := &testOAuthProvider{name: "oidc", user: goth.User{UserID: "1", NickName: "alice", RawData: map[string]any{"groups": []any{"staff"}}}}
+	app := newGroupsTestApp(t, oidc, groupMapping{
+		ModeratorGroups: []string{"mods"},
+		UserGroups:      []string{"staff"},
+	})
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	if w := oauthLink(t, newSessionClient(t, app, account), "oidc"); w.Code != http.StatusSeeOther {
+		t.Fatalf("link: got %d", w.Code)
+	}
+
+	oidc.user.RawData = map[string]any{"groups": []any{"staff", "mods"}}
+	if w := oauthLogin(t, newTestClient(app), "oidc"); w.Code != http.StatusSeeOther {
+		t.Fatalf("login: got %d: %s", w.Code, w.Body)
+	}
+	if got := providerAccount(t, app, "oidc", "1").AccountType; got != db.AccountTypeModerator {
+		t.Fatalf("after joining mods: %s, want MODERATOR", got)
+	}
+
+	oidc.user.RawData = map[string]any{"groups": []any{"staff"}}
+	if w := oauthLogin(t, newTestClient(app), "oidc"); w.Code != http.StatusSeeOther {
+		t.Fatalf("login: got %d: %s", w.Code, w.Body)
+	}
+	if got := providerAccount(t, app, "oidc", "1").AccountType; got != db.AccountTypeUser {
+		t.Fatalf("after leaving mods: %s, want USER", got)
+	}
+
+	oidc.user.RawData = map[string]any{"groups": []any{}}
+	client := newTestClient(app)
+	if w := oauthLogin(t, client, "oidc"); w.Code != http.StatusForbidden {
+		t.Fatalf("after leaving every group: got %d, want 403", w.Code)
+	}
+	if loggedIn(client) {
+		t.Fatal("user in no allowed group got a session")
+	}
+}
+
+// Group mapping never demotes the only admin, nobody would be left to fix it
+func TestProviderGroupsKeepLastAdmin(t *testing.T) {
+	oidc := &testOAuthProvider{name: "oidc", user: goth.User{UserID: "1", NickName: "alice", RawData: map[string]any{"groups": []any{"staff"}}}}
+	app := newGroupsTestApp(t, oidc, groupMapping{
+		AdminGroups: []string{"admins"},
+		UserGroups:  []string{"staff"},
+	})
+	admin := newTestAccount(t, app, db.AccountTypeAdmin)
+	if w := oauthLink(t, newSessionClient(t, app, admin), "oidc"); w.Code != http.StatusSeeOther {
+		t.Fatalf("link: got %d", w.Code)
+	}
+
+	if w := oauthLogin(t, newTestClient(app), "oidc"); w.Code != http.StatusSeeOther {
+		t.Fatalf("login: got %d: %s", w.Code, w.Body)
+	}
+	if got := providerAccount(t, app, "oidc", "1").AccountType; got != db.AccountTypeAdmin {
+		t.Fatalf("last admin became %s", got)
+	}
+}
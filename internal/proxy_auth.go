@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Provider name of identities linked through the reverse proxy
+const proxyAuthProvider = "proxy"
+
+const (
+	defaultProxyUserHeader   = "Remote-User"
+	defaultProxyGroupsHeader = "Remote-Groups"
+)
+
+// Logs people in as whoever a reverse proxy like Authelia or oauth2-proxy
+// says they are. The headers are only read from requests coming straight
+// from trusted_proxy.
+type proxyAuthConfig struct {
+	Enabled      bool   `toml:"enabled"`
+	UserHeader   string `toml:"user_header"`   // Defaults to Remote-User
+	GroupsHeader string `toml:"groups_header"` // Comma separated, defaults to Remote-Groups
+	groupMapping
+}
+
+// Only one header is ever read, a proxy that strips Remote-User but passes
+// X-Forwarded-User along mustn't let clients pick who they are
+func (c proxyAuthConfig) userHeader() string {
+	if c.UserHeader == "" {
+		return defaultProxyUserHeader
+	}
+
+	return c.UserHeader
+}
+
+func (c proxyAuthConfig) groupsHeader() string {
+	if c.GroupsHeader == "" {
+		return defaultProxyGroupsHeader
+	}
+
+	return c.GroupsHeader
+}
+
+func (c proxyAuthConfig) validate(trustedProxy string, providers loginProvidersConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if trustedProxy == "" {
+		return errors.New("proxy_auth needs trusted_proxy, otherwise anyone could send the headers")
+	}
+	if _, err := parseTrustedProxy(trustedProxy); err != nil {
+		return err
+	}
+	if _, ok := providers[proxyAuthProvider]; ok {
+		return fmt.Errorf("login provider can't be named %q while proxy_auth is enabled", proxyAuthProvider)
+	}
+
+	return nil
+}
+
+// trusted_proxy is either a single address or a CIDR range
+func parseTrustedProxy(trustedProxy string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(trustedProxy); err == nil {
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(trustedProxy)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("trusted_proxy %q isn't an IP address or CIDR range", trustedProxy)
+	}
+
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// Looks at the address the connection came from, not X-Forwarded-For
+func (app *Application) fromTrustedProxy(c *gin.Context) bool {
+	trusted, err := parseTrustedProxy(app.config.TrustedProxy)
+	if err != nil {
+		return false
+	}
+
+	remote, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return false
+	}
+
+	return trusted.Contains(remote.Unmap())
+}
+
+// ok is false unless proxy auth is on and the trusted proxy sent a user
+func (app *Application) proxyUser(c *gin.Context) (user string, groups []string, ok bool) {
+	config := app.config.ProxyAuth
+	if !config.Enabled || !app.fromTrustedProxy(c) {
+		return
+	}
+
+	if user = strings.TrimSpace(c.GetHeader(config.userHeader())); user == "" {
+		return
+	}
+
+	for group := range strings.SplitSeq(c.GetHeader(config.groupsHeader()), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+
+	return user, groups, true
+}
+
+// Finds the account linked to the proxy user and gives it a session, the
+// session cookie is reused while it belongs to the same account. The group
+// mapping is applied when a session starts, like the other login providers.
+// Proxy users nobody has linked yet fall back to the session cookie, so they
+// can log in some other way and link it from the settings. The proxy stands in
+// for the whole login, two-factor authentication included.
+func (app *Application) validateProxyUser(
+	c *gin.Context,
+	user string,
+	groups []string,
+) (sessionToken uuid.UUID, account db.Accounts, loggedIn bool, err error) {
+	mapping := app.config.ProxyAuth.groupMapping
+
+	account, err = app.db.FindAccountByIdentity(proxyAuthProvider, user)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if !mapping.AutoProvision {
+			return app.validateSessionCookie(c)
+		}
+		account, err = app.provisionAccount(c, mapping, groups, proxyAuthProvider, user, user)
+	} else if err == nil {
+		if previous, parseErr := app.parseAuthCookie(c); parseErr == nil {
+			current, sessionAccount, sessionErr := app.sessionAccount(c, previous)
+			if sessionErr == nil && sessionAccount.ID == account.ID {
+				return current, sessionAccount, true, nil
+			}
+
+			// The proxy switched users, the old session shouldn't outlive it
+			if delErr := app.db.DeleteSession(previous); delErr != nil {
+				log.Warn().Err(delErr).Msg("Failed to delete session of previous proxy user")
+			}
+		}
+
+		account, err = app.applyGroupMapping(account, mapping, groups)
+	}
+
+	if errors.Is(err, ErrNotInAllowedGroup) {
+		err = nil
+		app.clearAuthCookie(c)
+
+		return
+	} else if err != nil {
+		return
+	}
+
+	// Scripts behind the proxy don't keep cookies, they get their session from
+	// last time back instead of a new one on every request
+	if !account.Suspended() && !account.PendingApproval {
+		var (
+			sessionID uint
+			resumed   bool
+		)
+		sessionToken, sessionID, resumed, err = app.db.ResumeSession(account.ID, app.sessionInfo(c, proxyAuthProvider))
+		if err != nil {
+			return
+		} else if resumed {
+			c.Set("sessionID", sessionID)
+			app.setAuthCookie(sessionToken, c)
+			loggedIn = true
+
+			return
+		}
+	}
+
+	sessionToken, err = app.createLoginSession(c, account, proxyAuthProvider)
+	if errors.Is(err, ErrAccountSuspended) || errors.Is(err, ErrAccountPending) {
+		err = nil
+		app.clearAuthCookie(c)
+
+		return
+	} else if err != nil {
+		return
+	}
+	loggedIn = true
+
+	return
+}
+
+// Links the user the proxy sent to the logged in account
+func (app *Application) linkProxyUser(c *gin.Context, account db.Accounts) {
+	user, _, ok := app.proxyUser(c)
+	if !ok {
+		c.String(http.StatusBadRequest, "No user was sent by the reverse proxy")
+
+		return
+	}
+
+	err := app.db.LinkIdentity(account.ID, proxyAuthProvider, user, user)
+	if errors.Is(err, db.ErrProviderAlreadyLinked) {
+		c.String(http.StatusConflict, "This identity is already linked to another user")
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to link proxy user")
+		c.String(http.StatusInternalServerError, "Failed to link provider")
+
+		return
+	}
+	app.auditLink(c, account, proxyAuthProvider, user)
+
+	c.Redirect(http.StatusSeeOther, "/settings")
+}
@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func newProxyTestApp(t *testing.T, configure ...func(c *proxyAuthConfig)) *Application {
+	t.Helper()
+
+	return newTestApp(t, func(c *Config) {
+		c.TrustedProxy = "192.0.2.1"
+		c.ProxyAuth = proxyAuthConfig{
+			Enabled:      true,
+			groupMapping: groupMapping{AutoProvision: true},
+		}
+		for _, f := range configure {
+			f(&c.ProxyAuth)
+		}
+	})
+}
+
+func proxyClient(app *Application, header string, user string) *testClient {
+	client := newTestClient(app)
+	client.header.Set(header, user)
+
+	return client
+}
+
+func loggedIn(client *testClient) bool {
+	return client.do(http.MethodGet, "/settings", nil).Code == http.StatusOK
+}
+
+func proxyAccount(t *testing.T, app *Application, user string) db.Accounts {
+	t.Helper()
+
+	account, err := app.db.FindAccountByIdentity(proxyAuthProvider, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return account
+}
+
+func TestProxyAuthProvisions(t *testing.T) {
+	app := newProxyTestApp(t)
+
+	client := proxyClient(app, "Remote-User", "alice")
+	if !loggedIn(client) {
+		t.Fatal("proxy user wasn't logged in")
+	}
+	if _, ok := client.cookies[AUTH_COOKIE]; !ok {
+		t.Fatal("no session cookie")
+	}
+	proxyAccount(t, app, "alice")
+
+	// The session is reused instead of a new one per request
+	before, err := app.db.GetSessions(proxyAccount(t, app, "alice").ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loggedIn(client) {
+		t.Fatal("second request wasn't logged in")
+	}
+	after, err := app.db.GetSessions(proxyAccount(t, app, "alice").ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Fatalf("%d sessions after the second request, want %d", len(after), len(before))
+	}
+}
+
+// Other headers are never fallen back to, the proxy might only strip the one
+// it sets itself
+func TestProxyAuthReadsOnlyOneHeader(t *testing.T) {
+	app := newProxyTestApp(t)
+	if loggedIn(proxyClient(app, "X-Forwarded-User", "alice")) {
+		t.Fatal("X-Forwarded-User was read without being configured")
+	}
+
+	app = newProxyTestApp(t, func(c *proxyAuthConfig) { c.UserHeader = "X-Forwarded-User" })
+	if loggedIn(proxyClient(app, "Remote-User", "alice")) {
+		t.Fatal("Remote-User was read with user_header set to X-Forwarded-User")
+	}
+	if !loggedIn(proxyClient(app, "X-Forwarded-User", "alice")) {
+		t.Fatal("configured header wasn't read")
+	}
+}
+
+func TestProxyAuthIgnoresUntrustedAddresses(t *testing.T) {
+	app := newProxyTestApp(t)
+
+	client := proxyClient(app, "Remote-User", "alice")
+	client.remoteAddr = "198.51.100.7:1234"
+	if loggedIn(client) {
+		t.Fatal("headers from an untrusted address were read")
+	}
+}
+
+func TestProxyAuthGroupMappingOnSessionStart(t *testing.T) {
+	app := newProxyTestApp(t, func(c *proxyAuthConfig) {
+		c.AdminGroups = []string{"admins"}
+		c.UserGroups = []string{"users"}
+	})
+	newTestAccount(t, app, db.AccountTypeAdmin) // So alice isn't the last admin
+
+	client := proxyClient(app, "Remote-User", "alice")
+	client.header.Set("Remote-Groups", "admins")
+	if !loggedIn(client) {
+		t.Fatal("proxy user wasn't logged in")
+	}
+	if account := proxyAccount(t, app, "alice"); account.AccountType != db.AccountTypeAdmin {
+		t.Fatalf("provisioned as %s, want ADMIN", account.AccountType)
+	}
+
+	// Requests within the session don't touch the account
+	client.header.Set("Remote-Groups", "users")
+	if !loggedIn(client) {
+		t.Fatal("session wasn't reused")
+	}
+	if account := proxyAccount(t, app, "alice"); account.AccountType != db.AccountTypeAdmin {
+		t.Fatalf("changed to %s within the session", account.AccountType)
+	}
+
+	fresh := proxyClient(app, "Remote-User", "alice")
+	fresh.header.Set("Remote-Groups", "users")
+	if !loggedIn(fresh) {
+		t.Fatal("new session wasn't started")
+	}
+	if account := proxyAccount(t, app, "alice"); account.AccountType != db.AccountTypeUser {
+		t.Fatalf("new session left it %s, want USER", account.AccountType)
+	}
+
+	outsider := proxyClient(app, "Remote-User", "alice")
+	outsider.header.Set("Remote-Groups", "nobody")
+	if loggedIn(outsider) {
+		t.Fatal("user in no allowed group got a session")
+	}
+}
+
+func TestProxyAuthRefusesSuspendedAndPending(t *testing.T) {
+	app := newProxyTestApp(t)
+
+	client := proxyClient(app, "Remote-User", "alice")
+	if !loggedIn(client) {
+		t.Fatal("proxy user wasn't logged in")
+	}
+	if err := app.db.SuspendAccount(proxyAccount(t, app, "alice").ID, db.SuspendAccountInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if loggedIn(client) {
+		t.Fatal("suspended account kept its session")
+	}
+	if loggedIn(proxyClient(app, "Remote-User", "alice")) {
+		t.Fatal("suspended account got a new session")
+	}
+
+	if _, err := app.db.ProvisionAccount(db.ProvisionAccountInput{
+		AccountType:     db.AccountTypeUser,
+		Provider:        proxyAuthProvider,
+		Subject:         "bob",
+		Username:        "bob",
+		PendingApproval: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if loggedIn(proxyClient(app, "Remote-User", "bob")) {
+		t.Fatal("account waiting for approval got a session")
+	}
+}
+
+// Scripts that drop the cookie keep the same session instead of logging in
+// again on every request
+func TestProxyAuthCookielessRequestsShareSession(t *testing.T) {
+	app := newProxyTestApp(t)
+
+	for i := range 3 {
+		if !loggedIn(proxyClient(app, "Remote-User", "alice")) {
+			t.Fatalf("request %d wasn't logged in", i+1)
+		}
+	}
+	account := proxyAccount(t, app, "alice")
+	if sessions, err := app.db.GetSessions(account.ID); err != nil || len(sessions) != 1 {
+		t.Fatalf("%d sessions, want 1: %v", len(sessions), err)
+	}
+	if logins := auditEntries(t, app, auditLogin); len(logins) != 1 {
+		t.Fatalf("%d login entries, want 1", len(logins))
+	}
+
+	// Another device gets a session of its own
+	other := proxyClient(app, "Remote-User", "alice")
+	other.header.Set("User-Agent", "curl/8.0")
+	if !loggedIn(other) {
+		t.Fatal("other device wasn't logged in")
+	}
+	if sessions, err := app.db.GetSessions(account.ID); err != nil || len(sessions) != 2 {
+		t.Fatalf("%d sessions, want 2: %v", len(sessions), err)
+	}
+}
@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Instance wide quotas for accounts without their own, 0 is unlimited
+type quotaConfig struct {
+	Bytes         int64 `toml:"bytes"`           // Total size of all files of an account
+	Files         int64 `toml:"files"`           // Number of files an account can have
+	MaxFileSize   int64 `toml:"max_file_size"`   // Size of a single file, max_upload_size still applies on top
+	MaxExpiryDays int64 `toml:"max_expiry_days"` // Longest a file can be kept, files without expiry get this
+}
+
+func (c quotaConfig) validate() error {
+	if c.Bytes < 0 || c.Files < 0 || c.MaxFileSize < 0 || c.MaxExpiryDays < 0 {
+		return errors.New("quotas can't be negative")
+	}
+	if c.MaxExpiryDays > int64(maxExpiryDuration/(24*time.Hour)) {
+		return errors.New("quotas.max_expiry_days is too far in the future")
+	}
+
+	return nil
+}
+
+func (c quotaConfig) defaults() db.AccountQuota {
+	return db.AccountQuota{
+		Bytes:         c.Bytes,
+		Files:         c.Files,
+		MaxFileSize:   c.MaxFileSize,
+		MaxExpiryDays: c.MaxExpiryDays,
+	}
+}
+
+// A named quota invite codes can give to the accounts registering with them,
+// fields left out keep the instance default
+type quotaPreset struct {
+	Bytes         *int64 `toml:"bytes"`
+	Files         *int64 `toml:"files"`
+	MaxFileSize   *int64 `toml:"max_file_size"`
+	MaxExpiryDays *int64 `toml:"max_expiry_days"`
+}
+
+func (p quotaPreset) input() db.SetAccountQuotaInput {
+	return db.SetAccountQuotaInput{
+		Bytes:         p.Bytes,
+		Files:         p.Files,
+		MaxFileSize:   p.MaxFileSize,
+		MaxExpiryDays: p.MaxExpiryDays,
+	}
+}
+
+type quotaPresetsConfig map[string]quotaPreset
+
+func (c quotaPresetsConfig) validate() error {
+	for name, preset := range c {
+		for _, v := range []*int64{preset.Bytes, preset.Files, preset.MaxFileSize, preset.MaxExpiryDays} {
+			if v != nil && *v < 0 {
+				return fmt.Errorf("quota_presets.%s can't be negative", name)
+			}
+		}
+		if preset.MaxExpiryDays != nil && *preset.MaxExpiryDays > int64(maxExpiryDuration/(24*time.Hour)) {
+			return fmt.Errorf("quota_presets.%s.max_expiry_days is too far in the future", name)
+		}
+	}
+
+	return nil
+}
+
+// Sorted so the admin page lists them in the same order every time
+func (c quotaPresetsConfig) names() []string {
+	return slices.Sorted(maps.Keys(c))
+}
+
+// Status code for quota errors from CreateFileEntry and AccountQuota.Check
+func quotaErrorStatus(err error) (status int, ok bool) {
+	switch {
+	case errors.Is(err, db.ErrQuotaMaxFileSize), errors.Is(err, db.ErrQuotaBytes):
+		return http.StatusRequestEntityTooLarge, true
+	case errors.Is(err, db.ErrQuotaFiles):
+		return http.StatusForbidden, true
+	case errors.Is(err, db.ErrUploadTokenQuota):
+		return http.StatusTooManyRequests, true
+	default:
+		return 0, false
+	}
+}
+
+// Looks up the uploader of the request and checks the file fits in their
+// quota. This is only an early check so rejected uploads never touch storage,
+// CreateFileEntry checks again while holding a lock.
+func (app *Application) checkAccountQuota(c *gin.Context, size int64) (quota db.AccountQuota, err error) {
+	account, ok := getAccount(c)
+	if !ok {
+		uid, ok := getUploadToken(c)
+		if !ok {
+			return
+		}
+		if account, err = app.db.GetAccountByUploadToken(uid); err != nil {
+			return
+		}
+	}
+
+	usedBytes, usedFiles, err := app.db.GetQuotaUsage(account.ID)
+	if err != nil {
+		return
+	}
+
+	quota = account.Quota(app.config.Quotas.defaults())
+	err = quota.Check(usedBytes, usedFiles, size)
+
+	return
+}
+
+func quotaSummary(quota db.AccountQuota) string {
+	var parts []string
+	if quota.Bytes > 0 {
+		parts = append(parts, humanize.Bytes(uint64(quota.Bytes)))
+	}
+	if quota.Files > 0 {
+		parts = append(parts, fmt.Sprintf("%d files", quota.Files))
+	}
+	if quota.MaxFileSize > 0 {
+		parts = append(parts, humanize.Bytes(uint64(quota.MaxFileSize))+" per file")
+	}
+	if quota.MaxExpiryDays > 0 {
+		parts = append(parts, fmt.Sprintf("%d days max", quota.MaxExpiryDays))
+	}
+	if len(parts) == 0 {
+		return "Unlimited"
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Admin api for changing the quota of an account. Empty fields reset back to
+// the instance default, sizes are human readable ("10GB") and 0 is unlimited.
+type adminSetQuotaInput struct {
+	ID            uint   `form:"id" binding:"required"`
+	Bytes         string `form:"bytes"`
+	Files         string `form:"files"`
+	MaxFileSize   string `form:"max_file_size"`
+	MaxExpiryDays string `form:"max_expiry_days"`
+}
+
+// What the admin asked for, as it goes into the audit log
+func (input adminSetQuotaInput) summary() string {
+	orDefault := func(raw string) string {
+		if strings.TrimSpace(raw) == "" {
+			return "default"
+		}
+
+		return strings.TrimSpace(raw)
+	}
+
+	return fmt.Sprintf("Storage %s, files %s, max file size %s, max expiry days %s",
+		orDefault(input.Bytes), orDefault(input.Files), orDefault(input.MaxFileSize), orDefault(input.MaxExpiryDays))
+}
+
+func parseQuotaSize(field, raw string) (*int64, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	if raw == "0" {
+		var unlimited int64
+
+		return &unlimited, nil
+	}
+
+	size, err := parseOptionalSize(field, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &size, nil
+}
+
+func parseQuotaCount(field, raw string) (*int64, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	if raw == "0" {
+		var unlimited int64
+
+		return &unlimited, nil
+	}
+
+	count, err := parseOptionalCount(field, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &count, nil
+}
+
+func (app *Application) adminSetQuota(c *gin.Context) {
+	var input adminSetQuotaInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	var (
+		quota db.SetAccountQuotaInput
+		err   error
+	)
+	if quota.Bytes, err = parseQuotaSize("bytes", input.Bytes); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+	if quota.Files, err = parseQuotaCount("files", input.Files); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+	if quota.MaxFileSize, err = parseQuotaSize("max_file_size", input.MaxFileSize); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+	if quota.MaxExpiryDays, err = parseQuotaCount("max_expiry_days", input.MaxExpiryDays); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+	if quota.MaxExpiryDays != nil && *quota.MaxExpiryDays > int64(maxExpiryDuration/(24*time.Hour)) {
+		c.String(http.StatusBadRequest, "max_expiry_days too far in the future")
+
+		return
+	}
+
+	err = app.db.SetAccountQuota(input.ID, quota)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Account not found")
+
+		return
+	} else if err != nil {
+		log.Err(err).Uint("account_id", input.ID).Msg("Failed to set account quota")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	app.audit(c, db.AuditLogs{Action: auditAdminSetQuota, TargetID: input.ID, Detail: input.summary()})
+
+	c.String(http.StatusOK, "Quota updated")
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func setQuota(client *testClient, id uint, fields map[string]string) int {
+	form := map[string]string{"id": fmt.Sprint(id)}
+	for k, v := range fields {
+		form[k] = v
+	}
+
+	return client.do(http.MethodPost, "/api/admin/quota", form).Code
+}
+
+func TestAccountQuotaDefaults(t *testing.T) {
+	app := newTestApp(t, func(c *Config) {
+		c.Quotas = quotaConfig{Bytes: 10, Files: 2, MaxFileSize: 8}
+	})
+	client := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeUser))
+
+	if w := uploadForm(client, "a.txt", bytes.Repeat([]byte("a"), 9), nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("file over max_file_size: got %d, want 413", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", bytes.Repeat([]byte("a"), 6), nil); w.Code != http.StatusOK {
+		t.Fatalf("first file: got %d: %s", w.Code, w.Body)
+	}
+	if w := uploadForm(client, "a.txt", bytes.Repeat([]byte("a"), 5), nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("file over the storage quota: got %d, want 413", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", bytes.Repeat([]byte("a"), 4), nil); w.Code != http.StatusOK {
+		t.Fatalf("file filling the storage quota: got %d: %s", w.Code, w.Body)
+	}
+
+	// Empty files still count towards the number of files
+	app.config.Quotas.Bytes = 0
+	if w := uploadForm(client, "a.txt", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("file over the file quota: got %d, want 403", w.Code)
+	}
+}
+
+// Upload tokens upload into their owner's quota
+func TestAccountQuotaUploadToken(t *testing.T) {
+	app := newTestApp(t, func(c *Config) { c.Quotas = quotaConfig{Files: 1} })
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	token := newUploadToken(t, app, db.CreateUploadTokenInput{AccountID: account.ID})
+
+	if w := uploadForm(newSessionClient(t, app, account), "a.txt", []byte("hello"), nil); w.Code != http.StatusOK {
+		t.Fatalf("session upload: got %d: %s", w.Code, w.Body)
+	}
+	if w := uploadForm(newTestClient(app), "a.txt", []byte("hello"), map[string]string{"upload_token": token}); w.Code != http.StatusForbidden {
+		t.Fatalf("token upload over the owner's quota: got %d, want 403", w.Code)
+	}
+}
+
+func TestAccountQuotaCapsExpiry(t *testing.T) {
+	app := newTestApp(t, func(c *Config) { c.Quotas = quotaConfig{MaxExpiryDays: 1} })
+	client := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeUser))
+	latest := time.Now().AddDate(0, 0, 1).Add(time.Minute)
+
+	for name, fields := range map[string]map[string]string{
+		"no expiry":   nil,
+		"long expiry": {"expiry_date": time.Now().AddDate(0, 0, 30).Format("2006-01-02")},
+	} {
+		file := uploadedFile(t, app, uploadForm(client, "a.txt", []byte("hello"), fields))
+		if file.ExpiryDate.IsZero() || file.ExpiryDate.After(latest) {
+			t.Errorf("%s: expires %s, want within a day", name, file.ExpiryDate)
+		}
+	}
+}
+
+func TestAdminSetQuota(t *testing.T) {
+	app := newTestApp(t, func(c *Config) { c.Quotas = quotaConfig{Files: 1} })
+	admin := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeAdmin))
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	client := newSessionClient(t, app, account)
+
+	if w := uploadForm(client, "a.txt", []byte("hello"), nil); w.Code != http.StatusOK {
+		t.Fatalf("first file: got %d", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", []byte("hello"), nil); w.Code != http.StatusForbidden {
+		t.Fatalf("file over the default quota: got %d, want 403", w.Code)
+	}
+
+	// 0 is unlimited rather than the default
+	if code := setQuota(admin, account.ID, map[string]string{"files": "0", "max_file_size": "1KB"}); code != http.StatusOK {
+		t.Fatalf("setting the quota: got %d, want 200", code)
+	}
+	if w := uploadForm(client, "a.txt", []byte("hello"), nil); w.Code != http.StatusOK {
+		t.Fatalf("unlimited files: got %d", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", bytes.Repeat([]byte("a"), 1001), nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("file over the account's max_file_size: got %d, want 413", w.Code)
+	}
+
+	if code := setQuota(admin, account.ID, nil); code != http.StatusOK {
+		t.Fatalf("resetting the quota: got %d, want 200", code)
+	}
+	if w := uploadForm(client, "a.txt", []byte("hello"), nil); w.Code != http.StatusForbidden {
+		t.Fatalf("after the reset: got %d, want 403", w.Code)
+	}
+
+	for _, fields := range []map[string]string{{"files": "-1"}, {"bytes": "lots"}, {"max_expiry_days": "100000"}} {
+		if code := setQuota(admin, account.ID, fields); code != http.StatusBadRequest {
+			t.Errorf("%v: got %d, want 400", fields, code)
+		}
+	}
+	if code := setQuota(admin, 9999, nil); code != http.StatusNotFound {
+		t.Errorf("missing account: got %d, want 404", code)
+	}
+	if code := setQuota(client, account.ID, map[string]string{"files": "0"}); code != http.StatusForbidden {
+		t.Fatalf("user raising their own quota: got %d, want 403", code)
+	}
+}
+
+// Uploads racing each other can't all squeeze into the last free slot
+func TestAccountQuotaConcurrent(t *testing.T) {
+	app := newTestApp(t, func(c *Config) { c.Quotas = quotaConfig{Files: 1} })
+	account := newTestAccount(t, app, db.AccountTypeUser)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		client := newSessionClient(t, app, account)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			uploadForm(client, "a.txt", []byte("hello"), nil)
+		}()
+	}
+	wg.Wait()
+
+	if _, files, err := app.db.GetQuotaUsage(account.ID); err != nil || files != 1 {
+		t.Fatalf("%d files stored, want 1: %v", files, err)
+	}
+}
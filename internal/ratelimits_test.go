@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func TestAccountRateLimit(t *testing.T) {
+	app := newTestApp(t, func(c *Config) {
+		c.RateLimits.Account = rateLimitConfig{RequestsPerMinute: 2}
+	})
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	client := newSessionClient(t, app, account)
+
+	w := client.do(http.MethodGet, "/api/account/files", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first request: got %d", w.Code)
+	}
+	if w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("headers: limit %q remaining %q, want 2 and 1",
+			w.Header().Get("RateLimit-Limit"), w.Header().Get("RateLimit-Remaining"))
+	}
+
+	// Every session of the account shares the limit
+	if w := newSessionClient(t, app, account).do(http.MethodGet, "/api/account/files", nil); w.Code != http.StatusOK {
+		t.Fatalf("second request: got %d", w.Code)
+	}
+	w = client.do(http.MethodGet, "/api/account/files", nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit: got %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+
+	// Other accounts and route groups have their own
+	if w := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeUser)).do(http.MethodGet, "/api/account/files", nil); w.Code != http.StatusOK {
+		t.Fatalf("another account: got %d", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", []byte("hello"), nil); w.Code != http.StatusOK {
+		t.Fatalf("upload with only account routes limited: got %d", w.Code)
+	}
+}
+
+// Tokens count on their own and towards the account they belong to
+func TestTokenRateLimit(t *testing.T) {
+	app := newTestApp(t, func(c *Config) {
+		c.RateLimits.Upload = rateLimitConfig{RequestsPerMinute: 3, TokenRequestsPerMinute: 1}
+	})
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	client := newTestClient(app)
+	first := newUploadToken(t, app, db.CreateUploadTokenInput{AccountID: account.ID})
+	second := newUploadToken(t, app, db.CreateUploadTokenInput{AccountID: account.ID})
+
+	if w := uploadForm(client, "a.txt", []byte("hello"), map[string]string{"upload_token": first}); w.Code != http.StatusOK {
+		t.Fatalf("first token: got %d", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", []byte("hello"), map[string]string{"upload_token": first}); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("first token over its limit: got %d, want 429", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", []byte("hello"), map[string]string{"upload_token": second}); w.Code != http.StatusOK {
+		t.Fatalf("second token: got %d", w.Code)
+	}
+
+	// Both tokens' requests count for the account, even the refused one
+	if w := uploadForm(newSessionClient(t, app, account), "a.txt", []byte("hello"), nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("session over the account limit: got %d, want 429", w.Code)
+	}
+}
+
+// The size isn't known up front, so the upload that goes over still gets in
+// and the next one is refused
+func TestUploadBytesRateLimit(t *testing.T) {
+	app := newTestApp(t, func(c *Config) {
+		c.RateLimits.Upload = rateLimitConfig{BytesPerHour: 10}
+	})
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	client := newSessionClient(t, app, account)
+
+	if w := uploadForm(client, "a.txt", bytes.Repeat([]byte("a"), 6), nil); w.Code != http.StatusOK {
+		t.Fatalf("first upload: got %d", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", bytes.Repeat([]byte("a"), 6), nil); w.Code != http.StatusOK {
+		t.Fatalf("upload going over: got %d", w.Code)
+	}
+	w := uploadForm(client, "a.txt", []byte("a"), nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("upload after the limit: got %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+
+	// Refused uploads don't use anything up
+	if _, files, err := app.db.GetQuotaUsage(account.ID); err != nil || files != 2 {
+		t.Fatalf("%d files stored, want 2: %v", files, err)
+	}
+}
@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/markbates/goth"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+type registrationMode string
+
+const (
+	registrationInviteOnly registrationMode = "invite_only" // Only invite codes and auto_provision make accounts
+	registrationOpen       registrationMode = "open"        // Anyone who can log in to a provider gets an account
+	registrationApproval   registrationMode = "approval"    // Anyone gets an account an admin has to approve first
+	registrationDomain     registrationMode = "domain"      // Anyone with an email on one of the domains gets an account
+)
+
+// Who gets an account when logging in through a login provider without one.
+// Providers with auto_provision make accounts whatever the mode is.
+type registrationConfig struct {
+	Mode         registrationMode `toml:"mode"`          // Defaults to invite_only
+	EmailDomains []string         `toml:"email_domains"` // Who can register in domain mode, or ask to in approval mode
+	AccountType  string           `toml:"account_type"`  // What new accounts get, defaults to USER
+}
+
+var (
+	ErrEmailDomainNotAllowed = errors.New("your email address can't register on this instance")
+	ErrEmailNotVerified      = errors.New("your email address isn't verified with your login provider")
+	ErrAccountPending        = errors.New("your account is waiting for an admin to approve it")
+)
+
+func (c registrationConfig) validate(providers loginProvidersConfig) error {
+	switch c.mode() {
+	case registrationInviteOnly, registrationOpen:
+		if len(c.EmailDomains) > 0 {
+			return errors.New("registration.email_domains only works with the domain and approval modes")
+		}
+	case registrationDomain:
+		if len(c.EmailDomains) == 0 {
+			return errors.New("registration.mode domain needs email_domains")
+		}
+	case registrationApproval:
+	default:
+		return fmt.Errorf("unknown registration.mode %q", c.Mode)
+	}
+
+	for _, domain := range c.EmailDomains {
+		if domain == "" || strings.Contains(domain, "@") {
+			return fmt.Errorf("invalid domain %q in registration.email_domains, leave out the @", domain)
+		}
+	}
+	if c.checksEmail() {
+		for name, p := range providers {
+			if p.enabled() && p.kind().emailVerifiedClaim == "" {
+				return fmt.Errorf("login provider %q doesn't say whether emails are verified, it can't be used with registration.email_domains", name)
+			}
+		}
+	}
+
+	if c.AccountType != "" && !db.ValidAccountType(c.AccountType) {
+		return db.ErrInvalidAccountType
+	}
+	if c.AccountType == db.AccountTypeAdmin {
+		return errors.New("registration.account_type can't be ADMIN")
+	}
+
+	return nil
+}
+
+func (c registrationConfig) mode() registrationMode {
+	if c.Mode == "" {
+		return registrationInviteOnly
+	}
+
+	return c.Mode
+}
+
+// Whether registering needs a verified email on one of email_domains
+func (c registrationConfig) checksEmail() bool {
+	return len(c.EmailDomains) > 0
+}
+
+func (c registrationConfig) accountType() string {
+	if c.AccountType == "" {
+		return db.AccountTypeUser
+	}
+
+	return c.AccountType
+}
+
+// Checks the email the provider gave against email_domains, the provider has
+// to say it's verified under verifiedClaim
+func (c registrationConfig) checkEmail(user goth.User, verifiedClaim string) (email string, err error) {
+	email = strings.TrimSpace(user.Email)
+	if !c.checksEmail() {
+		return
+	}
+
+	// Some providers send the claim as a string
+	switch verified := user.RawData[verifiedClaim]; verified {
+	case true, "true":
+	default:
+		err = ErrEmailNotVerified
+
+		return
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		err = ErrEmailDomainNotAllowed
+
+		return
+	}
+	domain := email[at+1:]
+	if !slices.ContainsFunc(c.EmailDomains, func(d string) bool { return strings.EqualFold(d, domain) }) {
+		err = ErrEmailDomainNotAllowed
+	}
+
+	return
+}
+
+// Creates an account for someone logging in through a provider for the first
+// time, gorm.ErrRecordNotFound when the registration mode doesn't let them
+func (app *Application) registerAccount(
+	c *gin.Context,
+	mapping groupMapping,
+	groups []string,
+	provider string,
+	user goth.User,
+) (account db.Accounts, err error) {
+	reg := app.config.Registration
+	if reg.mode() == registrationInviteOnly || user.UserID == "" {
+		return account, gorm.ErrRecordNotFound
+	}
+
+	email, err := reg.checkEmail(user, app.config.LoginProviders[provider].kind().emailVerifiedClaim)
+	if err != nil {
+		return
+	}
+
+	accountType := reg.accountType()
+	if mapping.enabled() {
+		var ok bool
+		if accountType, ok = mapping.accountType(groups); !ok {
+			return account, ErrNotInAllowedGroup
+		}
+	}
+
+	pending := reg.mode() == registrationApproval
+	username := providerUsername(user)
+	account, err = app.db.ProvisionAccount(db.ProvisionAccountInput{
+		AccountType:     accountType,
+		Provider:        provider,
+		Subject:         user.UserID,
+		Username:        username,
+		Email:           email,
+		PendingApproval: pending,
+	})
+	if err != nil {
+		return
+	}
+	log.Info().
+		Uint("account_id", account.ID).
+		Str("provider", provider).
+		Str("account_type", accountType).
+		Bool("pending_approval", pending).
+		Msg("Registered account on first login")
+
+	detail := "Registered through " + provider + " as " + username
+	if pending {
+		detail += ", waiting for approval"
+	}
+	app.audit(c, db.AuditLogs{
+		ActorID:  account.ID,
+		TargetID: account.ID,
+		Action:   auditRegister,
+		Detail:   detail,
+	})
+
+	return
+}
+
+// Writes the error response for accounts an admin hasn't approved yet,
+// reports whether it turned the account away
+func refusePending(c *gin.Context, account db.Accounts) bool {
+	if !account.PendingApproval {
+		return false
+	}
+
+	c.String(http.StatusForbidden, ErrAccountPending.Error())
+	c.Abort()
+
+	return true
+}
+
+type adminApprovalInput struct {
+	ID uint `form:"id" binding:"required"`
+}
+
+func (app *Application) adminApproveAccount(c *gin.Context) {
+	var input adminApprovalInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	err := app.db.ApproveAccount(input.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "No account waiting for approval")
+
+		return
+	} else if err != nil {
+		log.Err(err).Uint("account_id", input.ID).Msg("Failed to approve account")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	app.audit(c, db.AuditLogs{Action: auditAdminApproveAccount, TargetID: input.ID})
+
+	c.String(http.StatusOK, fmt.Sprintf("Account %d approved", input.ID))
+}
+
+// Deletes an account that's waiting for approval, it can ask again by
+// logging in
+func (app *Application) adminRejectAccount(c *gin.Context) {
+	var input adminApprovalInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	account, err := app.db.GetAccountByID(input.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !account.PendingApproval) {
+		c.String(http.StatusNotFound, "No account waiting for approval")
+
+		return
+	} else if err != nil {
+		log.Err(err).Uint("account_id", input.ID).Msg("Failed to get account")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	if err = app.deleteAccount(c.Request.Context(), account.ID); err != nil {
+		log.Err(err).Uint("account_id", account.ID).Msg("Failed to delete rejected account")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	app.audit(c, db.AuditLogs{Action: auditAdminRejectAccount, TargetID: account.ID, Detail: account.RegistrationEmail})
+
+	c.String(http.StatusOK, fmt.Sprintf("Account %d rejected", account.ID))
+}
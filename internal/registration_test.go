@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	"gorm.io/gorm"
+)
+
+func newRegistrationTestApp(t *testing.T, reg registrationConfig) *Application {
+	t.Helper()
+
+	return newTestApp(t, func(c *Config) {
+		c.Registration = reg
+		c.LoginProviders = loginProvidersConfig{
+			"sso":     {Type: providerOIDC, ClientID: "id", ClientSecret: "secret", DiscoveryURL: "https://sso.example.com"},
+			"discord": {Type: providerDiscord, ClientID: "id", ClientSecret: "secret"},
+		}
+	})
+}
+
+func register(app *Application, provider string, user goth.User) (db.Accounts, error) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	return app.registerAccount(c, groupMapping{}, nil, provider, user)
+}
+
+func ssoUser(subject string, email string, verified any) goth.User {
+	return goth.User{
+		UserID:   subject,
+		NickName: subject,
+		Email:    email,
+		RawData:  map[string]any{"email_verified": verified},
+	}
+}
+
+func TestRegistrationInviteOnly(t *testing.T) {
+	app := newRegistrationTestApp(t, registrationConfig{})
+
+	if _, err := register(app, "sso", ssoUser("alice", "alice@example.com", true)); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("got %v, want no account", err)
+	}
+}
+
+func TestRegistrationOpen(t *testing.T) {
+	app := newRegistrationTestApp(t, registrationConfig{Mode: registrationOpen})
+
+	// Nothing about the email is checked without email_domains
+	account, err := register(app, "sso", ssoUser("alice", "", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.PendingApproval || account.AccountType != db.AccountTypeUser {
+		t.Fatalf("got a %s account waiting for approval: %t", account.AccountType, account.PendingApproval)
+	}
+
+	if _, err = register(app, "sso", goth.User{}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("user without an ID: got %v, want no account", err)
+	}
+}
+
+func TestRegistrationApproval(t *testing.T) {
+	app := newRegistrationTestApp(t, registrationConfig{Mode: registrationApproval})
+	admin := newTestAccount(t, app, db.AccountTypeAdmin)
+
+	account, err := register(app, "sso", ssoUser("alice", "alice@example.com", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !account.PendingApproval {
+		t.Fatal("account isn't waiting for approval")
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err = app.createLoginSession(c, account, "sso"); !errors.Is(err, ErrAccountPending) {
+		t.Fatalf("login while waiting for approval: got %v", err)
+	}
+
+	w := newSessionClient(t, app, admin).do(http.MethodPost, "/api/admin/approval", map[string]string{"id": strconv.Itoa(int(account.ID))})
+	if w.Code != http.StatusOK {
+		t.Fatalf("approve: got %d, want 200", w.Code)
+	}
+	if account, err = app.db.GetAccountByID(account.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = app.createLoginSession(c, account, "sso"); err != nil {
+		t.Fatalf("login after approval: %v", err)
+	}
+}
+
+func TestRegistrationDomain(t *testing.T) {
+	app := newRegistrationTestApp(t, registrationConfig{Mode: registrationDomain, EmailDomains: []string{"example.com"}})
+
+	for _, tt := range []struct {
+		name string
+		user goth.User
+		want error
+	}{
+		{"verified", ssoUser("a", "a@Example.com", true), nil},
+		{"verified as a string", ssoUser("b", "b@example.com", "true"), nil},
+		{"unverified", ssoUser("c", "c@example.com", false), ErrEmailNotVerified},
+		{"no verified claim", ssoUser("d", "d@example.com", nil), ErrEmailNotVerified},
+		{"other domain", ssoUser("e", "e@example.org", true), ErrEmailDomainNotAllowed},
+		{"subdomain", ssoUser("f", "f@evil.example.com", true), ErrEmailDomainNotAllowed},
+		{"no email", ssoUser("g", "", true), ErrEmailDomainNotAllowed},
+	} {
+		account, err := register(app, "sso", tt.user)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		} else if err == nil && account.PendingApproval {
+			t.Errorf("%s: account is waiting for approval", tt.name)
+		}
+	}
+
+	// Discord says it under another name
+	discordUser := goth.User{UserID: "1", Email: "h@example.com", RawData: map[string]any{"verified": true}}
+	if _, err := register(app, "discord", discordUser); err != nil {
+		t.Fatalf("verified discord user: %v", err)
+	}
+	discordUser = goth.User{UserID: "2", Email: "i@example.com", RawData: map[string]any{"email_verified": true}}
+	if _, err := register(app, "discord", discordUser); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("discord user with another provider's claim: got %v", err)
+	}
+}
+
+func TestRegistrationApprovalWithDomains(t *testing.T) {
+	app := newRegistrationTestApp(t, registrationConfig{Mode: registrationApproval, EmailDomains: []string{"example.com"}})
+
+	account, err := register(app, "sso", ssoUser("alice", "alice@example.com", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !account.PendingApproval {
+		t.Fatal("account isn't waiting for approval")
+	}
+	if _, err = register(app, "sso", ssoUser("bob", "bob@example.org", true)); !errors.Is(err, ErrEmailDomainNotAllowed) {
+		t.Fatalf("other domain: got %v", err)
+	}
+}
+
+func TestRegistrationValidateProviders(t *testing.T) {
+	reg := registrationConfig{Mode: registrationDomain, EmailDomains: []string{"example.com"}}
+	for providerType, ok := range map[providerType]bool{
+		providerOIDC:    true,
+		providerGoogle:  true,
+		providerDiscord: true,
+		providerGithub:  false,
+		providerGitlab:  false,
+		providerGitea:   false,
+	} {
+		providers := loginProvidersConfig{"p": {Type: providerType, ClientID: "id", ClientSecret: "secret", DiscoveryURL: "https://sso.example.com"}}
+		if err := reg.validate(providers); (err == nil) != ok {
+			t.Errorf("%s: got %v", providerType, err)
+		}
+
+		open := registrationConfig{Mode: registrationOpen}
+		if err := open.validate(providers); err != nil {
+			t.Errorf("%s in open mode: %v", providerType, err)
+		}
+	}
+}
+
+func TestEmailScopeRequested(t *testing.T) {
+	for _, providerType := range []providerType{providerGoogle, providerDiscord} {
+		p := loginProvidersConfig{"p": {Type: providerType, ClientID: "id", ClientSecret: "secret"}}.withEnv()["p"]
+		for _, needEmail := range []bool{false, true} {
+			provider, err := p.kind().new(p, "https://hostling.example.com/callback", needEmail)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session, err := provider.BeginAuth("state")
+			if err != nil {
+				t.Fatal(err)
+			}
+			authURL, err := session.GetAuthURL()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := url.Parse(authURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			scopes := strings.Fields(parsed.Query().Get("scope"))
+			hasEmail := false
+			for _, scope := range scopes {
+				hasEmail = hasEmail || scope == "email"
+			}
+			if hasEmail != needEmail {
+				t.Errorf("%s with needEmail %t asked for %v", providerType, needEmail, scopes)
+			}
+		}
+	}
+}
@@ -12,13 +12,17 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func setupRatelimiting(c Config) *limiter.Limiter {
-	rate := c.RateLimit
-	if rate <= 0 {
-		rate = 10
+// Requests/sec per client IP
+func ipRateLimit(c Config) float64 {
+	if c.RateLimit <= 0 {
+		return 10
 	}
 
-	return tollbooth.NewLimiter(rate, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
+	return c.RateLimit
+}
+
+func setupRatelimiting(c Config) *limiter.Limiter {
+	return tollbooth.NewLimiter(ipRateLimit(c), &limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
 }
 
 func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *Application) {
@@ -33,6 +37,12 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 	}
 	app.appSecret = secret
 
+	app.db.SetTokenKey(deriveKey(app.appSecret, "token-hash"))
+	app.db.SetSessionLifetime(c.Sessions.idleTimeout(), c.Sessions.lifetime())
+	if err := app.db.RehashLegacyTokens(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to hash legacy tokens")
+	}
+
 	app.Router = gin.Default()
 	app.Router.ForwardedByClientIP = c.BehindReverseProxy
 	if c.BehindReverseProxy {
@@ -52,6 +62,7 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 		"mimeIsImage":    mimeIsImage,
 		"mimeIsVideo":    mimeIsVideo,
 		"mimeIsAudio":    mimeIsAudio,
+		"tokenLimits":    uploadTokenRestrictionSummary,
 	})
 
 	app.Router.SetHTMLTemplate(template.Must(template.
@@ -71,14 +82,18 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 	app.setupAuth(api)
 
 	// Upload token should only have access to upload endpoint!
+	// Access tokens are checked against accessTokenRouteScopes instead.
 	fileAPI := api.Group("/file")
 	fileAPI.Use(
 		app.ratelimitMiddleware(),
-		app.apiMiddleware(),
-		app.hasUploadOrSessionTokenMiddleware(),
+		app.hasUploadOrSessionTokenMiddleware(), // Before the body is parsed
+		app.verifyCSRF(),
+		app.requirePermission(permUpload),
+		app.accountRatelimitMiddleware(rateLimitUpload, c.RateLimits.Upload),
 	)
 
-	fileAPI.POST("/upload", app.uploadFileAPI)
+	fileAPI.POST("/upload", app.apiMiddleware(), app.uploadFileAPI)
+	fileAPI.PUT("/upload/:name", app.uploadRawFileAPI) // Body is the file itself, never parse it as a form
 	// ---
 
 	// Accounts for managing your user
@@ -87,14 +102,39 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 		app.ratelimitMiddleware(),
 		app.apiMiddleware(),
 		app.verifySessionAuthentication(),
+		app.verifyCSRF(),
+		app.accountRatelimitMiddleware(rateLimitAccount, c.RateLimits.Account),
 	)
 
 	accountAPI.DELETE("/", app.accountDeleteAPI)
 
 	// Manage upload tokens
-	accountAPI.POST("/upload_token", app.newUploadTokenApi)
+	accountAPI.POST("/upload_token", app.requirePermission(permUpload), app.newUploadTokenApi)
 	accountAPI.DELETE("/upload_token", app.deleteUploadTokenAPI)
 
+	// Manage personal access tokens
+	accountAPI.POST("/access_token", app.newAccessTokenAPI)
+	accountAPI.DELETE("/access_token", app.deleteAccessTokenAPI)
+
+	// Two-factor authentication
+	accountAPI.POST("/totp/setup", app.totpSetupAPI)
+	accountAPI.POST("/totp/enable", app.totpEnableAPI)
+	accountAPI.POST("/totp/disable", app.totpDisableAPI)
+	accountAPI.POST("/totp/recovery_codes", app.totpRecoveryCodesAPI)
+
+	// Local login
+	accountAPI.POST("/password", app.setPasswordAPI)
+	accountAPI.DELETE("/password", app.removePasswordAPI)
+
+	// Passkeys
+	accountAPI.POST("/passkey/begin", app.passkeyRegisterBeginAPI)
+	accountAPI.POST("/passkey/finish", app.passkeyRegisterFinishAPI)
+	accountAPI.DELETE("/passkey", app.deletePasskeyAPI)
+
+	// Signed in devices
+	accountAPI.DELETE("/session", app.revokeSessionAPI)
+	accountAPI.DELETE("/sessions", app.revokeOtherSessionsAPI)
+
 	// Delete invite codes, only admins can create them
 	accountAPI.DELETE("/invite_code", app.deleteInviteCodeAPI)
 
@@ -117,7 +157,9 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 		app.ratelimitMiddleware(),
 		app.apiMiddleware(),
 		app.verifySessionAuthentication(),
-		app.isAdmin(),
+		app.verifyCSRF(),
+		app.requirePermission(permManageAccounts),
+		app.accountRatelimitMiddleware(rateLimitAdmin, c.RateLimits.Admin),
 	)
 
 	adminAPI.DELETE("/user", app.adminDeleteAccount)
@@ -125,15 +167,40 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 	adminAPI.DELETE("/sessions", app.adminDeleteSessions)
 	adminAPI.DELETE("/upload_tokens", app.adminDeleteUploadTokens)
 	adminAPI.POST("/give_invite_code", app.adminGiveInviteCode)
+	adminAPI.POST("/quota", app.adminSetQuota)
+	adminAPI.DELETE("/totp", app.adminResetTOTP)
+	adminAPI.GET("/audit_log", app.adminAuditLogAPI)
+	adminAPI.POST("/suspension", app.adminSuspendAccount)
+	adminAPI.DELETE("/suspension", app.adminLiftSuspension)
+	adminAPI.POST("/account_type", app.adminSetAccountType)
+	adminAPI.POST("/approval", app.adminApproveAccount)
+	adminAPI.DELETE("/approval", app.adminRejectAccount)
+
+	// Moderation apis, for reviewing everyone's public files
+	moderationAPI := api.Group("/moderation")
+	moderationAPI.Use(
+		app.ratelimitMiddleware(),
+		app.apiMiddleware(),
+		app.verifySessionAuthentication(),
+		app.verifyCSRF(),
+		app.requirePermission(permModerateFiles),
+		app.accountRatelimitMiddleware(rateLimitAdmin, c.RateLimits.Admin),
+	)
+
+	moderationAPI.GET("/files", app.moderationFilesAPI)
+	moderationAPI.DELETE("/file", app.moderationDeleteFileAPI)
 
 	// Pages
 	app.Router.GET("/login", app.loginPage)
+	app.Router.GET("/login/2fa", app.twoFactorPage)
 	app.Router.GET("/register", app.registerPage)
-	app.Router.POST("/logout", app.logoutHandler)
+	app.Router.POST("/logout", app.verifyCSRF(), app.logoutHandler)
 	app.Router.GET("/gallery", app.galleryPage)
 	app.Router.GET("/settings", app.settingsPage)
 	app.Router.GET("/tokens", app.tokensPage)
 	app.Router.GET("/admin", app.adminPage)
+	app.Router.GET("/admin/audit", app.auditLogPage)
+	app.Router.GET("/moderation", app.moderationPage)
 	app.Router.GET("/", app.indexPage)
 
 	app.Router.NoRoute(app.ratelimitMiddleware(), app.indexFiles)
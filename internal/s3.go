@@ -18,19 +18,41 @@ func (app *Application) uploadFileS3(
 	size int64,
 	fileName string,
 ) (written int64, err error) {
+	// Never overwrite an existing object, short names can collide
+	opts := minio.PutObjectOptions{}
+	opts.SetMatchETagExcept("*")
+
 	info, err := app.s3client.PutObject(
 		ctx,
 		app.config.S3.Bucket,
 		fileName,
 		r,
 		size,
-		minio.PutObjectOptions{},
+		opts,
 	)
+	if minio.ToErrorResponse(err).Code == minio.PreconditionFailed {
+		err = ErrFileNameTaken
+	}
 	written = info.Size
 
 	return
 }
 
+// Lets uploads pick another name before the body is sent
+func (app *Application) s3FileExists(ctx context.Context, fileName string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s3Timeout)
+	defer cancel()
+
+	_, err := app.s3client.StatObject(ctx, app.config.S3.Bucket, fileName, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == minio.NoSuchKey {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (app *Application) deleteFileS3(ctx context.Context, fileName string) error {
 	ctx, cancel := context.WithTimeout(ctx, s3Timeout)
 	defer cancel()
@@ -0,0 +1,280 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Login methods recorded on sessions besides the login provider names
+const (
+	loginMethodPassword = "password"
+	loginMethodPasskey  = "passkey"
+	loginMethodInvite   = "invite"
+)
+
+const (
+	defaultSessionIdleTimeoutHours = 7 * 24
+	defaultSessionLifetimeHours    = 30 * 24
+)
+
+// How long people stay logged in. Using a session pushes its expiry forward
+// by the idle timeout, up to the lifetime after logging in.
+type sessionConfig struct {
+	IdleTimeoutHours int64 `toml:"idle_timeout_hours"` // Sessions unused this long expire, defaults to 7 days or the lifetime if shorter
+	LifetimeHours    int64 `toml:"lifetime_hours"`     // Longest a session lasts however much it's used, defaults to 30 days
+}
+
+func (c sessionConfig) validate() error {
+	if c.IdleTimeoutHours < 0 || c.LifetimeHours < 0 {
+		return errors.New("session lifetimes can't be negative")
+	}
+	if c.idleTimeout() > c.lifetime() {
+		return errors.New("sessions.idle_timeout_hours can't be longer than sessions.lifetime_hours")
+	}
+	if c.lifetime() > maxExpiryDuration {
+		return errors.New("sessions.lifetime_hours is too far in the future")
+	}
+
+	return nil
+}
+
+func (c sessionConfig) idleTimeout() time.Duration {
+	if c.IdleTimeoutHours == 0 {
+		return min(defaultSessionIdleTimeoutHours*time.Hour, c.lifetime())
+	}
+
+	return time.Duration(c.IdleTimeoutHours) * time.Hour
+}
+
+func (c sessionConfig) lifetime() time.Duration {
+	if c.LifetimeHours == 0 {
+		return defaultSessionLifetimeHours * time.Hour
+	}
+
+	return time.Duration(c.LifetimeHours) * time.Hour
+}
+
+// Expiry of a session that was just created
+func (c sessionConfig) firstExpiry() time.Duration {
+	return min(c.idleTimeout(), c.lifetime())
+}
+
+const (
+	maxSessionUserAgent = 512
+	sessionIPHashLength = 8 // Hex characters shown, enough to tell IPs apart
+)
+
+// Lets entries from the same IP be matched up without storing the IP
+func (app *Application) ipHash(c *gin.Context) string {
+	mac := hmac.New(sha256.New, deriveKey(app.appSecret, "session-ip-hash"))
+	mac.Write([]byte(c.ClientIP()))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (app *Application) sessionInfo(c *gin.Context, loginMethod string) db.SessionInfo {
+	userAgent := strings.ToValidUTF8(c.Request.UserAgent(), "")
+	if len(userAgent) > maxSessionUserAgent {
+		userAgent = strings.ToValidUTF8(userAgent[:maxSessionUserAgent], "")
+	}
+
+	return db.SessionInfo{
+		UserAgent:     userAgent,
+		IPHash:        app.ipHash(c),
+		LoginProvider: loginMethod,
+	}
+}
+
+func (app *Application) loginMethodName(method string) string {
+	switch method {
+	case "":
+		return "Unknown"
+	case loginMethodPassword:
+		return "Password"
+	case loginMethodPasskey:
+		return "Passkey"
+	case loginMethodInvite:
+		return "Invite code"
+	}
+
+	return app.loginProvider(method).DisplayName
+}
+
+// Rough browser and OS, good enough to recognize your own devices
+func describeUserAgent(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browsers := []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	systems := []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+
+	var browser, system string
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+
+			break
+		}
+	}
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+
+	// Scripts and apps usually start with their name
+	name, _, _ := strings.Cut(userAgent, " ")
+
+	return name
+}
+
+// A session as shown on the settings page
+type AccountSession struct {
+	ID          uint
+	Device      string
+	UserAgent   string
+	IPHash      string
+	LoginMethod string
+	CreatedAt   time.Time
+	LastUsed    time.Time
+	Current     bool // The session viewing the page
+}
+
+func (app *Application) accountSessions(c *gin.Context, accountID uint) (sessions []AccountSession, err error) {
+	rows, err := app.db.GetSessions(accountID)
+	if err != nil {
+		return
+	}
+
+	current, hasCurrent := getSessionToken(c)
+	for _, row := range rows {
+		ipHash := row.IPHash
+		if len(ipHash) > sessionIPHashLength {
+			ipHash = ipHash[:sessionIPHashLength]
+		}
+
+		sessions = append(sessions, AccountSession{
+			ID:          row.ID,
+			Device:      describeUserAgent(row.UserAgent),
+			UserAgent:   row.UserAgent,
+			IPHash:      ipHash,
+			LoginMethod: app.loginMethodName(row.LoginProvider),
+			CreatedAt:   row.CreatedAt,
+			LastUsed:    row.LastUsed,
+			Current:     hasCurrent && app.db.IsSessionToken(row, current),
+		})
+	}
+
+	return
+}
+
+type revokeSessionInput struct {
+	ID uint `form:"id" binding:"required"`
+}
+
+// Signs out one session of the account, the current one included
+func (app *Application) revokeSessionAPI(c *gin.Context) {
+	account, ok := getAccount(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	var input revokeSessionInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	err := app.db.DeleteSessionByID(account.ID, input.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Session not found")
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to revoke session")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	app.audit(c, db.AuditLogs{
+		Action:   auditRevokeSession,
+		TargetID: account.ID,
+		Detail:   fmt.Sprintf("Session %d", input.ID),
+	})
+
+	c.String(http.StatusOK, "Session revoked")
+}
+
+// Signs out every session of the account except the one making the request
+func (app *Application) revokeOtherSessionsAPI(c *gin.Context) {
+	account, ok := getAccount(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	sessionToken, ok := getSessionToken(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	revoked, err := app.db.DeleteOtherSessions(account.ID, sessionToken)
+	if err != nil {
+		log.Err(err).Msg("Failed to revoke other sessions")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	app.audit(c, db.AuditLogs{
+		Action:   auditRevokeOtherSessions,
+		TargetID: account.ID,
+		Detail:   fmt.Sprintf("%d sessions", revoked),
+	})
+
+	c.String(http.StatusOK, "Other sessions revoked")
+}
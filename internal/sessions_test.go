@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/google/uuid"
+)
+
+func TestSessionConfigDefaults(t *testing.T) {
+	for _, tt := range []struct {
+		config      sessionConfig
+		idleTimeout time.Duration
+		lifetime    time.Duration
+		valid       bool
+	}{
+		{sessionConfig{}, 7 * 24 * time.Hour, 30 * 24 * time.Hour, true},
+		{sessionConfig{LifetimeHours: 24}, 24 * time.Hour, 24 * time.Hour, true},
+		{sessionConfig{LifetimeHours: 24 * 90}, 7 * 24 * time.Hour, 90 * 24 * time.Hour, true},
+		{sessionConfig{IdleTimeoutHours: 1, LifetimeHours: 24}, time.Hour, 24 * time.Hour, true},
+		{sessionConfig{IdleTimeoutHours: 48, LifetimeHours: 24}, 48 * time.Hour, 24 * time.Hour, false},
+		{sessionConfig{IdleTimeoutHours: 24 * 60}, 60 * 24 * time.Hour, 30 * 24 * time.Hour, false},
+		{sessionConfig{LifetimeHours: -1}, 0, 0, false},
+	} {
+		err := tt.config.validate()
+		if tt.valid != (err == nil) {
+			t.Errorf("%+v: validate returned %v", tt.config, err)
+		}
+		if !tt.valid {
+			continue
+		}
+		if got := tt.config.idleTimeout(); got != tt.idleTimeout {
+			t.Errorf("%+v: idle timeout %s, want %s", tt.config, got, tt.idleTimeout)
+		}
+		if got := tt.config.lifetime(); got != tt.lifetime {
+			t.Errorf("%+v: lifetime %s, want %s", tt.config, got, tt.lifetime)
+		}
+	}
+}
+
+// ID of the session the client is logged in with
+func clientSessionID(t *testing.T, app *Application, account db.Accounts, client *testClient) uint {
+	t.Helper()
+
+	token, err := uuid.Parse(client.cookies[AUTH_COOKIE].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := app.db.GetSessions(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, session := range sessions {
+		if app.db.IsSessionToken(session, token) {
+			return session.ID
+		}
+	}
+	t.Fatal("client's session isn't in the list")
+
+	return 0
+}
+
+func TestRevokeSession(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	laptop := newSessionClient(t, app, account)
+	phone := newSessionClient(t, app, account)
+	phoneID := strconv.Itoa(int(clientSessionID(t, app, account, phone)))
+
+	w := laptop.do(http.MethodDelete, "/api/account/session", map[string]string{"id": phoneID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", w.Code)
+	}
+	entries := auditEntries(t, app, auditRevokeSession)
+	if len(entries) != 1 || entries[0].ActorID != account.ID || entries[0].Detail != "Session "+phoneID {
+		t.Fatalf("audit entries %+v, want one for session %s", entries, phoneID)
+	}
+	if loggedIn(phone) {
+		t.Fatal("revoked session still works")
+	}
+	if !loggedIn(laptop) {
+		t.Fatal("revoking another session logged this one out")
+	}
+}
+
+func TestRevokeSessionOfAnotherAccount(t *testing.T) {
+	app := newTestApp(t)
+	attacker := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeUser))
+	victimAccount := newTestAccount(t, app, db.AccountTypeUser)
+	victim := newSessionClient(t, app, victimAccount)
+
+	w := attacker.do(http.MethodDelete, "/api/account/session", map[string]string{"id": strconv.Itoa(int(clientSessionID(t, app, victimAccount, victim)))})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got %d, want 404", w.Code)
+	}
+	if !loggedIn(victim) {
+		t.Fatal("another account's session was revoked")
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	current := newSessionClient(t, app, account)
+	others := []*testClient{newSessionClient(t, app, account), newSessionClient(t, app, account)}
+	bystander := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeUser))
+
+	if w := current.do(http.MethodDelete, "/api/account/sessions", nil); w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", w.Code)
+	}
+	entries := auditEntries(t, app, auditRevokeOtherSessions)
+	if len(entries) != 1 || entries[0].ActorID != account.ID || entries[0].Detail != "2 sessions" {
+		t.Fatalf("audit entries %+v, want one for 2 sessions", entries)
+	}
+	if !loggedIn(current) {
+		t.Fatal("the session making the request was revoked")
+	}
+	for i, other := range others {
+		if loggedIn(other) {
+			t.Errorf("other session %d still works", i)
+		}
+	}
+	if !loggedIn(bystander) {
+		t.Fatal("another account's session was revoked")
+	}
+}
+
+// A renewed session hands out a new cookie but stays the same session, so the
+// CSRF token the page already has keeps working
+func TestSessionRenewalOverHTTP(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	client := newSessionClient(t, app, account)
+	before := client.cookies[AUTH_COOKIE].Value
+	sessionID := clientSessionID(t, app, account, client)
+
+	if err := app.db.Model(&db.SessionTokens{}).Where("id = ?", sessionID).
+		Update("last_used", time.Now().Add(-time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !loggedIn(client) {
+		t.Fatal("session wasn't renewed")
+	}
+	if client.cookies[AUTH_COOKIE].Value == before {
+		t.Fatal("renewal didn't set a new cookie")
+	}
+	if id := clientSessionID(t, app, account, client); id != sessionID {
+		t.Fatalf("renewed into session %d, want %d", id, sessionID)
+	}
+
+	if w := client.do(http.MethodPost, "/api/account/access_token", map[string]string{"scope": "files:read"}); w.Code != http.StatusOK {
+		t.Fatalf("CSRF token after the renewal: got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const maxSuspensionReason = 500
+
+var (
+	ErrAccountSuspended = errors.New("this account is suspended")
+	ErrCantSuspendSelf  = errors.New("you can't suspend yourself")
+)
+
+// Told to suspended people when they try to log in
+func suspensionMessage(account db.Accounts) string {
+	message := "This account is suspended"
+	if account.SuspendedUntil != nil {
+		message += " until " + formatTimeDate(*account.SuspendedUntil)
+	}
+	if account.SuspensionReason != "" {
+		message += ": " + account.SuspensionReason
+	}
+
+	return message
+}
+
+// Writes the error response for suspended accounts, reports whether it
+// turned the account away
+func refuseSuspended(c *gin.Context, account db.Accounts) bool {
+	if !account.Suspended() {
+		return false
+	}
+
+	c.String(http.StatusForbidden, suspensionMessage(account))
+	c.Abort()
+
+	return true
+}
+
+type adminSuspendInput struct {
+	ID        uint   `form:"id"         binding:"required"`
+	Reason    string `form:"reason"`
+	Until     string `form:"until"` // YYYY-MM-DD, empty lasts until lifted
+	HideFiles bool   `form:"hide_files"`
+}
+
+func (app *Application) adminSuspendAccount(c *gin.Context) {
+	var input adminSuspendInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	account, _ := getAccount(c)
+	if account.ID == input.ID {
+		c.String(http.StatusBadRequest, ErrCantSuspendSelf.Error())
+
+		return
+	}
+
+	if len(input.Reason) > maxSuspensionReason {
+		c.String(http.StatusBadRequest, "Reason too long")
+
+		return
+	}
+
+	suspension := db.SuspendAccountInput{
+		Reason:    input.Reason,
+		HideFiles: input.HideFiles,
+	}
+	if input.Until != "" {
+		until, err := time.Parse(time.DateOnly, input.Until)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid until (want YYYY-MM-DD)")
+
+			return
+		}
+		if !until.After(time.Now()) {
+			c.String(http.StatusBadRequest, "Can't end a suspension in the past")
+
+			return
+		}
+		if time.Until(until) > maxExpiryDuration {
+			c.String(http.StatusBadRequest, "until too far in the future")
+
+			return
+		}
+		suspension.Until = &until
+	}
+
+	err := app.db.SuspendAccount(input.ID, suspension)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Account not found")
+
+		return
+	} else if errors.Is(err, db.ErrCantSuspendAdmin) {
+		c.String(http.StatusConflict, err.Error())
+
+		return
+	} else if err != nil {
+		log.Err(err).Uint("account_id", input.ID).Msg("Failed to suspend account")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	detail := "Indefinitely"
+	if suspension.Until != nil {
+		detail = "Until " + input.Until
+	}
+	if input.HideFiles {
+		detail += ", files hidden"
+	}
+	if input.Reason != "" {
+		detail += ": " + input.Reason
+	}
+	app.audit(c, db.AuditLogs{Action: auditAdminSuspend, TargetID: input.ID, Detail: detail})
+
+	c.String(http.StatusOK, fmt.Sprintf("Account %d suspended", input.ID))
+}
+
+type adminLiftSuspensionInput struct {
+	ID uint `form:"id" binding:"required"`
+}
+
+func (app *Application) adminLiftSuspension(c *gin.Context) {
+	var input adminLiftSuspensionInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	err := app.db.LiftSuspension(input.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Account not found")
+
+		return
+	} else if err != nil {
+		log.Err(err).Uint("account_id", input.ID).Msg("Failed to lift suspension")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	app.audit(c, db.AuditLogs{Action: auditAdminLiftSuspension, TargetID: input.ID})
+
+	c.String(http.StatusOK, fmt.Sprintf("Suspension of account %d lifted", input.ID))
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+)
+
+func newTestFile(t *testing.T, app *Application, account db.Accounts, fileName string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(app.config.DataFolder, fileName), []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.db.Create(&db.Files{
+		FileName:   fileName,
+		FileSize:   5,
+		MimeType:   "text/plain",
+		Public:     true,
+		UploaderID: account.ID,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func suspend(client *testClient, id uint, hideFiles bool) int {
+	form := map[string]string{"id": fmt.Sprint(id), "reason": "spam"}
+	if hideFiles {
+		form["hide_files"] = "true"
+	}
+
+	return client.do(http.MethodPost, "/api/admin/suspension", form).Code
+}
+
+func TestSuspendedAccountIsLoggedOut(t *testing.T) {
+	app := newTestApp(t)
+	admin := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeAdmin))
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	user := newSessionClient(t, app, account)
+
+	if !loggedIn(user) {
+		t.Fatal("user wasn't logged in before the suspension")
+	}
+	if code := suspend(admin, account.ID, false); code != http.StatusOK {
+		t.Fatalf("suspend: got %d, want 200", code)
+	}
+	if loggedIn(user) {
+		t.Fatal("suspended user kept their session")
+	}
+
+	if code := admin.do(http.MethodDelete, "/api/admin/suspension", map[string]string{"id": fmt.Sprint(account.ID)}).Code; code != http.StatusOK {
+		t.Fatalf("lift: got %d, want 200", code)
+	}
+	if !loggedIn(newSessionClient(t, app, account)) {
+		t.Fatal("lifted account can't use a new session")
+	}
+}
+
+func TestSuspensionHidesFiles(t *testing.T) {
+	app := newTestApp(t)
+	admin := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeAdmin))
+	moderator := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeModerator))
+	uploader := newTestAccount(t, app, db.AccountTypeUser)
+	newTestFile(t, app, uploader, "hello.txt")
+
+	if code := newTestClient(app).do(http.MethodGet, "/hello.txt", nil).Code; code != http.StatusOK {
+		t.Fatalf("before the suspension: got %d, want 200", code)
+	}
+
+	if code := suspend(admin, uploader.ID, false); code != http.StatusOK {
+		t.Fatalf("suspend: got %d, want 200", code)
+	}
+	if code := newTestClient(app).do(http.MethodGet, "/hello.txt", nil).Code; code != http.StatusOK {
+		t.Fatalf("suspended without hiding files: got %d, want 200", code)
+	}
+
+	if code := suspend(admin, uploader.ID, true); code != http.StatusOK {
+		t.Fatalf("suspend hiding files: got %d, want 200", code)
+	}
+	if code := newTestClient(app).do(http.MethodGet, "/hello.txt", nil).Code; code != http.StatusTemporaryRedirect {
+		t.Fatalf("hidden file: got %d, want 307", code)
+	}
+	if code := moderator.do(http.MethodGet, "/hello.txt", nil).Code; code != http.StatusOK {
+		t.Fatalf("hidden file for a moderator: got %d, want 200", code)
+	}
+}
+
+func TestAdminsCantBeSuspended(t *testing.T) {
+	app := newTestApp(t)
+	self := newTestAccount(t, app, db.AccountTypeAdmin)
+	other := newTestAccount(t, app, db.AccountTypeAdmin)
+	admin := newSessionClient(t, app, self)
+
+	if code := suspend(admin, self.ID, false); code != http.StatusBadRequest {
+		t.Fatalf("suspending yourself: got %d, want 400", code)
+	}
+	if code := suspend(admin, other.ID, false); code != http.StatusConflict {
+		t.Fatalf("suspending another admin: got %d, want 409", code)
+	}
+	if account, err := app.db.GetAccountByID(other.ID); err != nil || account.Suspended() {
+		t.Fatalf("other admin got suspended: %v", err)
+	}
+
+	moderator := newSessionClient(t, app, newTestAccount(t, app, db.AccountTypeModerator))
+	if code := suspend(moderator, other.ID, false); code != http.StatusForbidden {
+		t.Fatalf("moderator suspending: got %d, want 403", code)
+	}
+}
@@ -0,0 +1,576 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/png"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	totpPeriod = 30 // seconds
+	totpSkew   = 1  // Steps accepted either side of now for clock drift
+
+	recoveryCodeCount = 10
+
+	// Codes allowed per account from one IP before it has to wait, and from
+	// every IP together. The second limit only keeps a spread out guesser
+	// from going through the codes, the first one is hit long before it.
+	twoFactorMaxAttempts        = 5
+	twoFactorMaxAccountAttempts = 50
+	twoFactorAttemptWindow      = 15 * time.Minute
+)
+
+var (
+	ErrTOTPNotPending       = errors.New("two-factor authentication isn't being set up")
+	ErrTOTPAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled       = errors.New("two-factor authentication isn't enabled")
+	ErrInvalidTOTPCode      = errors.New("invalid code")
+	ErrTooManyTOTPAttempts  = errors.New("too many wrong codes, try again later")
+	ErrInvalidTwoFactorStep = errors.New("login expired, please log in again")
+)
+
+// The otpauth key is stored encrypted so a database dump alone can't be used
+// to generate codes.
+func (app *Application) sealTOTPKey(key string) (string, error) {
+	return sealValue(deriveKey(app.appSecret, "totp-key"), []byte(key))
+}
+
+func (app *Application) openTOTPKey(sealed string) (key *otp.Key, err error) {
+	plain, err := openValue(deriveKey(app.appSecret, "totp-key"), sealed)
+	if err != nil {
+		return
+	}
+
+	return otp.NewKeyFromURL(string(plain))
+}
+
+// Returns the time step the code belongs to, checking a step either side of now
+func matchTOTPStep(secret string, code string, now time.Time) (step int64, ok bool) {
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		at := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, at, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return at.Unix() / totpPeriod, true
+		}
+	}
+
+	return 0, false
+}
+
+// Formatted as "xxxxx-xxxxx"
+func generateRecoveryCodes() (codes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for range recoveryCodeCount {
+		raw := make([]byte, 6)
+		if _, err = rand.Read(raw); err != nil {
+			return
+		}
+		code := strings.ToLower(encoding.EncodeToString(raw))
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return
+}
+
+// Recovery codes are stored without the dash and case insensitive
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// Counts every attempt, so guessing codes gets cut off quickly. Attempts are
+// counted per IP first, so someone who knows the password can't lock the
+// owner out from somewhere else.
+func (app *Application) countTwoFactorAttempt(c *gin.Context, accountID uint) (retryAfter time.Time, err error) {
+	ctx := c.Request.Context()
+
+	count, reset, err := app.limiterStore.add(
+		ctx, fmt.Sprintf("2fa:account:%d:ip:%s", accountID, c.ClientIP()), twoFactorAttemptWindow, 1,
+	)
+	if err != nil {
+		log.Err(err).Msg("Failed to count two-factor attempt")
+
+		return time.Time{}, nil
+	}
+	if count > twoFactorMaxAttempts {
+		return reset, ErrTooManyTOTPAttempts
+	}
+
+	count, reset, err = app.limiterStore.add(
+		ctx, fmt.Sprintf("2fa:account:%d", accountID), twoFactorAttemptWindow, 1,
+	)
+	if err != nil {
+		log.Err(err).Msg("Failed to count two-factor attempt")
+
+		return time.Time{}, nil
+	}
+	if count > twoFactorMaxAccountAttempts {
+		return reset, ErrTooManyTOTPAttempts
+	}
+
+	return
+}
+
+// Checks a code from the authenticator, or a recovery code, against an
+// account with two-factor authentication enabled. Used codes are burned.
+func (app *Application) verifySecondFactor(account db.Accounts, code string) (ok bool, err error) {
+	if !account.TOTPEnabled {
+		return false, ErrTOTPNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == int(otp.DigitsSix) {
+		key, openErr := app.openTOTPKey(account.TOTPSecret)
+		if openErr != nil {
+			return false, openErr
+		}
+
+		step, matched := matchTOTPStep(key.Secret(), code, time.Now())
+		if !matched || step <= account.TOTPLastStep {
+			return false, nil
+		}
+
+		return app.db.UseTOTPStep(account.ID, step)
+	}
+
+	return app.db.UseRecoveryCode(account.ID, normalizeRecoveryCode(code))
+}
+
+// Writes the error response for verifySecondFactor and countTwoFactorAttempt errors
+func writeTwoFactorError(c *gin.Context, err error, retryAfter time.Time) {
+	switch {
+	case errors.Is(err, ErrTooManyTOTPAttempts):
+		c.Header("Retry-After", retryAfterSeconds(retryAfter))
+		c.String(http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, ErrTOTPNotEnabled):
+		c.String(http.StatusBadRequest, err.Error())
+	default:
+		log.Err(err).Msg("Failed to verify two-factor code")
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+// Logs the account in, or sends it to the code prompt first when it has
+// two-factor authentication enabled. The login method is shown on the session.
+func (app *Application) completeLogin(c *gin.Context, account db.Accounts, loginMethod string) {
+	if refuseSuspended(c, account) || refusePending(c, account) {
+		return
+	}
+
+	if account.TOTPEnabled {
+		app.setTwoFactorCookie(c, account.ID, loginMethod)
+		c.Redirect(http.StatusSeeOther, "/login/2fa")
+
+		return
+	}
+
+	app.startSession(c, account, loginMethod)
+}
+
+func (app *Application) startSession(c *gin.Context, account db.Accounts, loginMethod string) {
+	// Could have been suspended between the two login steps
+	if refuseSuspended(c, account) || refusePending(c, account) {
+		return
+	}
+
+	if prev, parseErr := app.parseAuthCookie(c); parseErr == nil {
+		if delErr := app.db.DeleteSessionForAccount(prev, account.ID); delErr != nil {
+			log.Warn().Err(delErr).Msg("Failed to delete prior session on re-login")
+		}
+	}
+
+	if _, err := app.createLoginSession(c, account, loginMethod); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/gallery")
+}
+
+// Every way of logging in gets its session from here, so suspended accounts
+// and ones waiting for approval never get one. Sets the session cookie.
+func (app *Application) createLoginSession(
+	c *gin.Context,
+	account db.Accounts,
+	loginMethod string,
+) (sessionToken uuid.UUID, err error) {
+	if account.Suspended() {
+		return sessionToken, ErrAccountSuspended
+	}
+	if account.PendingApproval {
+		return sessionToken, ErrAccountPending
+	}
+
+	sessionToken, sessionID, err := app.db.CreateSessionToken(account.ID, app.sessionInfo(c, loginMethod))
+	if err != nil {
+		return
+	}
+	c.Set("sessionID", sessionID)
+	app.audit(c, db.AuditLogs{ActorID: account.ID, TargetID: account.ID, Action: auditLogin, Detail: loginMethod})
+	app.setAuthCookie(sessionToken, c)
+
+	return
+}
+
+type totpCodeInput struct {
+	Code string `form:"code" binding:"required"`
+}
+
+// Second step of logging in, the first one left a two_factor cookie behind
+func (app *Application) twoFactorLoginApi(c *gin.Context) {
+	accountID, loginMethod, err := app.parseTwoFactorCookie(c)
+	if err != nil {
+		app.clearTwoFactorCookie(c)
+		c.String(http.StatusUnauthorized, ErrInvalidTwoFactorStep.Error())
+
+		return
+	}
+
+	var input totpCodeInput
+	if err = c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request")
+
+		return
+	}
+
+	if retryAfter, limitErr := app.countTwoFactorAttempt(c, accountID); limitErr != nil {
+		writeTwoFactorError(c, limitErr, retryAfter)
+
+		return
+	}
+
+	account, err := app.db.GetAccountByID(accountID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		app.clearTwoFactorCookie(c)
+		c.String(http.StatusUnauthorized, ErrInvalidTwoFactorStep.Error())
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to look up account for two-factor login")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	// Reset by an admin in the meantime, nothing left to check
+	if !account.TOTPEnabled {
+		app.clearTwoFactorCookie(c)
+		app.startSession(c, account, loginMethod)
+
+		return
+	}
+
+	ok, err := app.verifySecondFactor(account, input.Code)
+	if err != nil {
+		writeTwoFactorError(c, err, time.Time{})
+
+		return
+	} else if !ok {
+		c.String(http.StatusUnauthorized, ErrInvalidTOTPCode.Error())
+
+		return
+	}
+
+	app.clearTwoFactorCookie(c)
+	app.startSession(c, account, loginMethod)
+}
+
+// Creates a new pending secret, shown on the settings page until confirmed
+func (app *Application) totpSetupAPI(c *gin.Context) {
+	account, ok := getAccount(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+	if account.TOTPEnabled {
+		c.String(http.StatusConflict, ErrTOTPAlreadyEnabled.Error())
+
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      app.config.Branding,
+		AccountName: app.accountLabel(account),
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		log.Err(err).Msg("Failed to generate totp key")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	sealed, err := app.sealTOTPKey(key.String())
+	if err != nil {
+		log.Err(err).Msg("Failed to seal totp key")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	if err = app.db.SetPendingTOTPSecret(account.ID, sealed); errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusConflict, ErrTOTPAlreadyEnabled.Error())
+
+		return
+	} else if err != nil {
+		log.Err(err).Msg("Failed to store totp key")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings#two-factor")
+}
+
+// Confirms the pending secret with a code, responds with the recovery codes
+func (app *Application) totpEnableAPI(c *gin.Context) {
+	account, ok := getAccount(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	var input totpCodeInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request")
+
+		return
+	}
+
+	if account.TOTPEnabled {
+		c.String(http.StatusConflict, ErrTOTPAlreadyEnabled.Error())
+
+		return
+	} else if account.TOTPSecret == "" {
+		c.String(http.StatusBadRequest, ErrTOTPNotPending.Error())
+
+		return
+	}
+
+	if retryAfter, err := app.countTwoFactorAttempt(c, account.ID); err != nil {
+		writeTwoFactorError(c, err, retryAfter)
+
+		return
+	}
+
+	key, err := app.openTOTPKey(account.TOTPSecret)
+	if err != nil {
+		log.Err(err).Msg("Failed to open totp key")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	step, matched := matchTOTPStep(key.Secret(), strings.TrimSpace(input.Code), time.Now())
+	if !matched {
+		c.String(http.StatusUnauthorized, ErrInvalidTOTPCode.Error())
+
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Err(err).Msg("Failed to generate recovery codes")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		normalized = append(normalized, normalizeRecoveryCode(code))
+	}
+
+	if err = app.db.EnableTOTP(account.ID, step, normalized); err != nil {
+		log.Err(err).Msg("Failed to enable totp")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.String(http.StatusOK, strings.Join(codes, "\n"))
+}
+
+// Needs a current code, so a stolen session alone can't turn it off
+func (app *Application) totpDisableAPI(c *gin.Context) {
+	account, ok := app.requireSecondFactor(c)
+	if !ok {
+		return
+	}
+
+	if err := app.db.DisableTOTP(account.ID); err != nil {
+		log.Err(err).Msg("Failed to disable totp")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.String(http.StatusOK, "Two-factor authentication disabled")
+}
+
+// Replaces all recovery codes, responds with the new ones
+func (app *Application) totpRecoveryCodesAPI(c *gin.Context) {
+	account, ok := app.requireSecondFactor(c)
+	if !ok {
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Err(err).Msg("Failed to generate recovery codes")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		normalized = append(normalized, normalizeRecoveryCode(code))
+	}
+
+	if err = app.db.ReplaceRecoveryCodes(account.ID, normalized); err != nil {
+		log.Err(err).Msg("Failed to replace recovery codes")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.String(http.StatusOK, strings.Join(codes, "\n"))
+}
+
+// Binds the code form field and checks it, writes the error response itself
+func (app *Application) requireSecondFactor(c *gin.Context) (account db.Accounts, ok bool) {
+	account, ok = getAccount(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	var input totpCodeInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request")
+
+		return account, false
+	}
+
+	if retryAfter, err := app.countTwoFactorAttempt(c, account.ID); err != nil {
+		writeTwoFactorError(c, err, retryAfter)
+
+		return account, false
+	}
+
+	valid, err := app.verifySecondFactor(account, input.Code)
+	if err != nil {
+		writeTwoFactorError(c, err, time.Time{})
+
+		return account, false
+	} else if !valid {
+		c.String(http.StatusUnauthorized, ErrInvalidTOTPCode.Error())
+
+		return account, false
+	}
+
+	return account, true
+}
+
+type adminResetTOTPInput struct {
+	ID uint `form:"id" binding:"required"`
+}
+
+// For users that lost both their authenticator and recovery codes
+func (app *Application) adminResetTOTP(c *gin.Context) {
+	var input adminResetTOTPInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	err := app.db.DisableTOTP(input.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Account not found")
+
+		return
+	} else if err != nil {
+		log.Err(err).Uint("account_id", input.ID).Msg("Failed to reset two-factor authentication")
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+	app.audit(c, db.AuditLogs{Action: auditAdminResetTOTP, TargetID: input.ID})
+
+	c.String(http.StatusOK, "Two-factor authentication reset")
+}
+
+// Shown in the two-factor section of the settings page
+type totpSettings struct {
+	Enabled           bool
+	Pending           bool
+	Secret            string
+	URI               string
+	QRCode            template.URL // data: URI of the PNG
+	RecoveryCodesLeft int64
+}
+
+func (app *Application) totpSettingsFor(account db.Accounts) (settings totpSettings, err error) {
+	settings.Enabled = account.TOTPEnabled
+	if account.TOTPEnabled {
+		settings.RecoveryCodesLeft, err = app.db.UnusedRecoveryCodeCount(account.ID)
+
+		return
+	}
+	if account.TOTPSecret == "" {
+		return
+	}
+
+	key, err := app.openTOTPKey(account.TOTPSecret)
+	if err != nil {
+		return
+	}
+
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return
+	}
+
+	settings.Pending = true
+	settings.Secret = key.Secret()
+	settings.URI = key.URL()
+	settings.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()))
+
+	return
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+func enableTestTOTP(t *testing.T, app *Application, account db.Accounts) *otp.Key {
+	t.Helper()
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "hostling", AccountName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := app.sealTOTPKey(key.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = app.db.SetPendingTOTPSecret(account.ID, sealed); err != nil {
+		t.Fatal(err)
+	}
+	if err = app.db.EnableTOTP(account.ID, 0, []string{"aaaaabbbbb"}); err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// Client that got through the first login step from the given IP
+func newTwoFactorClient(app *Application, account db.Accounts, ip string) *testClient {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	app.setTwoFactorCookie(c, account.ID, loginMethodPassword)
+
+	client := newTestClient(app)
+	client.remoteAddr = ip + ":1234"
+	for _, cookie := range w.Result().Cookies() {
+		client.cookies[cookie.Name] = cookie
+	}
+
+	return client
+}
+
+func currentCode(t *testing.T, key *otp.Key) string {
+	t.Helper()
+
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	key := enableTestTOTP(t, app, account)
+
+	client := newTwoFactorClient(app, account, "192.0.2.1")
+	if w := client.do(http.MethodPost, "/api/auth/2fa", map[string]string{"code": "000000"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code: got %d, want 401", w.Code)
+	}
+
+	code := currentCode(t, key)
+	if w := client.do(http.MethodPost, "/api/auth/2fa", map[string]string{"code": code}); w.Code != http.StatusSeeOther {
+		t.Fatalf("right code: got %d, want 303", w.Code)
+	}
+	if _, ok := client.cookies[AUTH_COOKIE]; !ok {
+		t.Fatal("no session cookie after the code was accepted")
+	}
+
+	again := newTwoFactorClient(app, account, "192.0.2.1")
+	if w := again.do(http.MethodPost, "/api/auth/2fa", map[string]string{"code": code}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("reused code: got %d, want 401", w.Code)
+	}
+
+	recovery := newTwoFactorClient(app, account, "192.0.2.1")
+	if w := recovery.do(http.MethodPost, "/api/auth/2fa", map[string]string{"code": "AAAAA-bbbbb"}); w.Code != http.StatusSeeOther {
+		t.Fatalf("recovery code: got %d, want 303", w.Code)
+	}
+}
+
+func TestTwoFactorLoginWithoutCookie(t *testing.T) {
+	app := newTestApp(t)
+
+	w := newTestClient(app).do(http.MethodPost, "/api/auth/2fa", map[string]string{"code": "000000"})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("got %d, want 401", w.Code)
+	}
+}
+
+// Someone who knows the password guessing codes from their own IP mustn't
+// lock the owner out
+func TestTwoFactorAttemptsCountedPerIP(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	key := enableTestTOTP(t, app, account)
+
+	attacker := newTwoFactorClient(app, account, "198.51.100.7")
+	for i := range twoFactorMaxAttempts {
+		if w := attacker.do(http.MethodPost, "/api/auth/2fa", map[string]string{"code": "000000"}); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got %d, want 401", i+1, w.Code)
+		}
+	}
+	w := attacker.do(http.MethodPost, "/api/auth/2fa", map[string]string{"code": currentCode(t, key)})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("attempt over the limit: got %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+
+	owner := newTwoFactorClient(app, account, "192.0.2.1")
+	if w = owner.do(http.MethodPost, "/api/auth/2fa", map[string]string{"code": currentCode(t, key)}); w.Code != http.StatusSeeOther {
+		t.Fatalf("owner from another IP: got %d, want 303", w.Code)
+	}
+}
+
+func TestTwoFactorAttemptsCappedPerAccount(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	key := enableTestTOTP(t, app, account)
+
+	for i := range twoFactorMaxAccountAttempts {
+		client := newTwoFactorClient(app, account, fmt.Sprintf("198.51.100.%d", i))
+		if w := client.do(http.MethodPost, "/api/auth/2fa", map[string]string{"code": "000000"}); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got %d, want 401", i+1, w.Code)
+		}
+	}
+
+	client := newTwoFactorClient(app, account, "203.0.113.1")
+	if w := client.do(http.MethodPost, "/api/auth/2fa", map[string]string{"code": currentCode(t, key)}); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want 429", w.Code)
+	}
+}
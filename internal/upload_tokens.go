@@ -0,0 +1,260 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/dustin/go-humanize"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+const (
+	maxUploadTokenMimeTypes  = 20
+	maxUploadTokenForcedTags = 10
+)
+
+var (
+	ErrUploadTokenFileTooBig = errors.New("file is bigger than this upload token allows")
+	ErrUploadTokenMimeType   = errors.New("file type isn't allowed for this upload token")
+	ErrUploadTokenQuota      = db.ErrUploadTokenQuota
+)
+
+// Restrictions from the upload token creation form, every field is optional
+type uploadTokenRestrictionsInput struct {
+	ExpiryDate       string `form:"expiry_date"`        // YYYY-MM-DD
+	MaxFileSize      string `form:"max_file_size"`      // Human readable size, e.g "10MB"
+	AllowedMimeTypes string `form:"allowed_mime_types"` // Comma separated, e.g "image/*, application/pdf"
+	DailyByteQuota   string `form:"daily_byte_quota"`   // Human readable size
+	DailyFileQuota   string `form:"daily_file_quota"`
+	ForcedTags       string `form:"forced_tags"` // Comma separated
+	ForcedExpiryDays string `form:"forced_expiry_days"`
+	ForcePrivate     bool   `form:"force_private"`
+}
+
+func parseOptionalSize(field, raw string) (size int64, err error) {
+	if raw = strings.TrimSpace(raw); raw == "" {
+		return
+	}
+
+	parsed, err := humanize.ParseBytes(raw)
+	if err != nil || parsed == 0 || parsed > uint64(1<<62) {
+		return 0, fmt.Errorf("invalid %s", field)
+	}
+
+	return int64(parsed), nil
+}
+
+func parseOptionalCount(field, raw string) (count int64, err error) {
+	if raw = strings.TrimSpace(raw); raw == "" {
+		return
+	}
+
+	count, err = strconv.ParseInt(raw, 10, 64)
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("invalid %s", field)
+	}
+
+	return
+}
+
+// Accepts "type/subtype" and "type/*"
+func parseMimePatterns(raw string) (patterns []string, err error) {
+	for entry := range strings.SplitSeq(raw, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		mediaType, subType, found := strings.Cut(entry, "/")
+		if !found || mediaType == "" || mediaType == "*" || subType == "" || strings.ContainsAny(entry, " ;,") {
+			return nil, fmt.Errorf("invalid mime type %q", entry)
+		}
+		patterns = append(patterns, entry)
+	}
+	slices.Sort(patterns)
+	patterns = slices.Compact(patterns)
+
+	return
+}
+
+func parseTagList(raw string) (tags []string, err error) {
+	for tag := range strings.SplitSeq(raw, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if len(tag) > db.TagMaxLength {
+			return nil, db.ErrTagTooLong
+		}
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	tags = slices.Compact(tags)
+
+	return
+}
+
+func (input uploadTokenRestrictionsInput) apply(token *db.CreateUploadTokenInput) (err error) {
+	if input.ExpiryDate != "" {
+		parsed, parseErr := time.Parse("2006-01-02", input.ExpiryDate)
+		if parseErr != nil {
+			return errors.New("invalid expiry_date (want YYYY-MM-DD)")
+		}
+		parsed = parsed.Add(24*time.Hour - time.Second)
+		if parsed.Before(time.Now()) {
+			return errors.New("can't specify expiry in the past, sorry")
+		}
+		token.ExpiryDate = &parsed
+	}
+
+	if token.MaxFileSize, err = parseOptionalSize("max_file_size", input.MaxFileSize); err != nil {
+		return
+	}
+	if token.DailyByteQuota, err = parseOptionalSize("daily_byte_quota", input.DailyByteQuota); err != nil {
+		return
+	}
+	if token.DailyFileQuota, err = parseOptionalCount("daily_file_quota", input.DailyFileQuota); err != nil {
+		return
+	}
+
+	forcedExpiryDays, err := parseOptionalCount("forced_expiry_days", input.ForcedExpiryDays)
+	if err != nil {
+		return
+	}
+	if forcedExpiryDays > int64(maxExpiryDuration/(24*time.Hour)) {
+		return errors.New("forced_expiry_days too far in the future")
+	}
+	token.ForcedExpiryDays = int(forcedExpiryDays)
+
+	if token.AllowedMimeTypes, err = parseMimePatterns(input.AllowedMimeTypes); err != nil {
+		return
+	}
+	if len(token.AllowedMimeTypes) > maxUploadTokenMimeTypes {
+		return errors.New("too many allowed mime types")
+	}
+
+	if token.ForcedTags, err = parseTagList(input.ForcedTags); err != nil {
+		return
+	}
+	if len(token.ForcedTags) > maxUploadTokenForcedTags {
+		return db.ErrTooManyTags
+	}
+
+	token.ForcePrivate = input.ForcePrivate
+
+	return
+}
+
+func mimeAllowed(patterns []string, mime *mimetype.MIME) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(mime.String(), prefix) {
+				return true
+			}
+		} else if mime.Is(pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks an upload against the token restrictions, must run before the
+// blob is written so rejected uploads never touch storage. The daily quota is
+// checked again by CreateFileEntry while holding a lock.
+func (app *Application) checkUploadTokenRestrictions(token db.UploadTokens, size int64, mime *mimetype.MIME) error {
+	if token.MaxFileSize > 0 && size > token.MaxFileSize {
+		return ErrUploadTokenFileTooBig
+	}
+
+	if patterns := token.AllowedMimeTypeList(); len(patterns) > 0 && !mimeAllowed(patterns, mime) {
+		return ErrUploadTokenMimeType
+	}
+
+	if token.DailyByteQuota > 0 || token.DailyFileQuota > 0 {
+		bytes, files, err := app.db.GetUploadTokenUsage(token.ID)
+		if err != nil {
+			return err
+		}
+		if err = token.CheckDailyQuota(bytes, files, size); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Status code for errors from checkUploadTokenRestrictions
+func uploadTokenRestrictionStatus(err error) (status int, ok bool) {
+	switch {
+	case errors.Is(err, ErrUploadTokenFileTooBig):
+		return http.StatusRequestEntityTooLarge, true
+	case errors.Is(err, ErrUploadTokenMimeType):
+		return http.StatusUnsupportedMediaType, true
+	case errors.Is(err, ErrUploadTokenQuota):
+		return http.StatusTooManyRequests, true
+	default:
+		return 0, false
+	}
+}
+
+// Applies the forced tags, expiry and visibility of the token on top of
+// what the uploader asked for. A forced expiry only ever shortens it.
+func applyUploadTokenDefaults(
+	token db.UploadTokens,
+	tags []string,
+	expiryDate time.Time,
+	public bool,
+) ([]string, time.Time, bool) {
+	for _, tag := range token.ForcedTagList() {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	if token.ForcedExpiryDays > 0 {
+		latest := time.Now().AddDate(0, 0, token.ForcedExpiryDays)
+		if expiryDate.IsZero() || expiryDate.After(latest) {
+			expiryDate = latest
+		}
+	}
+
+	if token.ForcePrivate {
+		public = false
+	}
+
+	return tags, expiryDate, public
+}
+
+// Human readable summary of the token restrictions for the tokens page
+func uploadTokenRestrictionSummary(token db.UploadTokens) (summary []string) {
+	if token.MaxFileSize > 0 {
+		summary = append(summary, "Max size: "+humanize.Bytes(uint64(token.MaxFileSize)))
+	}
+	if token.AllowedMimeTypes != "" {
+		summary = append(summary, "Types: "+strings.Join(token.AllowedMimeTypeList(), ", "))
+	}
+	if token.DailyByteQuota > 0 {
+		summary = append(summary, "Daily bytes: "+humanize.Bytes(uint64(token.DailyByteQuota)))
+	}
+	if token.DailyFileQuota > 0 {
+		summary = append(summary, "Daily files: "+strconv.FormatInt(token.DailyFileQuota, 10))
+	}
+	if token.ForcedTags != "" {
+		summary = append(summary, "Tags: "+strings.Join(token.ForcedTagList(), ", "))
+	}
+	if token.ForcedExpiryDays > 0 {
+		summary = append(summary, "Expires files after "+strconv.Itoa(token.ForcedExpiryDays)+" days")
+	}
+	if token.ForcePrivate {
+		summary = append(summary, "Private")
+	}
+
+	return
+}
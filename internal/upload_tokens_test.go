@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BatteredBunny/hostling/internal/db"
+	"github.com/google/uuid"
+)
+
+// Enough of a PNG for the content sniffing
+var testPNG = append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), make([]byte, 32)...)
+
+func newUploadToken(t *testing.T, app *Application, input db.CreateUploadTokenInput) string {
+	t.Helper()
+
+	token, err := app.db.CreateUploadToken(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.String()
+}
+
+// Multipart upload the way the web form and the ShareX config send it
+func uploadForm(client *testClient, name string, content []byte, fields map[string]string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("plain", "true")
+	for k, v := range fields {
+		_ = writer.WriteField(k, v)
+	}
+	part, _ := writer.CreateFormFile("file", name)
+	_, _ = part.Write(content)
+	_ = writer.Close()
+
+	return client.send(http.MethodPost, "/api/file/upload", writer.FormDataContentType(), &body)
+}
+
+func uploadedFile(t *testing.T, app *Application, w *httptest.ResponseRecorder) (file db.Files) {
+	t.Helper()
+
+	if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+		t.Fatalf("upload: got %d: %s", w.Code, w.Body)
+	}
+	if err := app.db.Preload("Tags").
+		Where("file_name = ?", strings.TrimPrefix(w.Body.String(), "/")).
+		First(&file).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestUploadTokenRestrictions(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	client := newTestClient(app)
+
+	small := newUploadToken(t, app, db.CreateUploadTokenInput{AccountID: account.ID, MaxFileSize: 16})
+	if w := uploadForm(client, "a.txt", []byte("hello"), map[string]string{"upload_token": small}); w.Code != http.StatusOK {
+		t.Fatalf("small file: got %d", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", bytes.Repeat([]byte("a"), 17), map[string]string{"upload_token": small}); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("file over max_file_size: got %d, want 413", w.Code)
+	}
+
+	// The type comes from the content, not the name
+	images := newUploadToken(t, app, db.CreateUploadTokenInput{AccountID: account.ID, AllowedMimeTypes: []string{"image/*"}})
+	if w := uploadForm(client, "a.png", testPNG, map[string]string{"upload_token": images}); w.Code != http.StatusOK {
+		t.Fatalf("image: got %d", w.Code)
+	}
+	if w := uploadForm(client, "a.png", []byte("hello"), map[string]string{"upload_token": images}); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("text named .png: got %d, want 415", w.Code)
+	}
+
+	daily := newUploadToken(t, app, db.CreateUploadTokenInput{AccountID: account.ID, DailyFileQuota: 1})
+	if w := uploadForm(client, "a.txt", []byte("hello"), map[string]string{"upload_token": daily}); w.Code != http.StatusOK {
+		t.Fatalf("first upload: got %d", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", []byte("hello"), map[string]string{"upload_token": daily}); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("upload over the daily quota: got %d, want 429", w.Code)
+	}
+}
+
+func TestUploadTokenDefaults(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	token := newUploadToken(t, app, db.CreateUploadTokenInput{
+		AccountID:        account.ID,
+		ForcedTags:       []string{"screenshots"},
+		ForcedExpiryDays: 1,
+		ForcePrivate:     true,
+	})
+
+	file := uploadedFile(t, app, uploadForm(newTestClient(app), "a.txt", []byte("hello"), map[string]string{
+		"upload_token": token,
+		"tag":          "mine",
+		"expiry_date":  time.Now().AddDate(0, 0, 30).Format("2006-01-02"),
+	}))
+	if file.Public {
+		t.Error("file is public")
+	}
+	if file.ExpiryDate.IsZero() || file.ExpiryDate.After(time.Now().AddDate(0, 0, 1)) {
+		t.Errorf("expires %s, want within a day", file.ExpiryDate)
+	}
+	var tags []string
+	for _, tag := range file.Tags {
+		tags = append(tags, tag.Name)
+	}
+	slices.Sort(tags)
+	if !slices.Equal(tags, []string{"mine", "screenshots"}) {
+		t.Errorf("tags %v, want mine and screenshots", tags)
+	}
+}
+
+func TestUploadTokenOwnerChecked(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	newTestAccount(t, app, db.AccountTypeAdmin)
+	token := newUploadToken(t, app, db.CreateUploadTokenInput{AccountID: account.ID})
+	client := newTestClient(app)
+
+	if w := uploadForm(client, "a.txt", []byte("hello"), map[string]string{"upload_token": uuid.NewString()}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown token: got %d, want 401", w.Code)
+	}
+
+	if err := app.db.SetAccountType(account.ID, db.AccountTypeGuest); err != nil {
+		t.Fatal(err)
+	}
+	if w := uploadForm(client, "a.txt", []byte("hello"), map[string]string{"upload_token": token}); w.Code != http.StatusForbidden {
+		t.Fatalf("token of a guest: got %d, want 403", w.Code)
+	}
+
+	if err := app.db.SetAccountType(account.ID, db.AccountTypeUser); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.db.SuspendAccount(account.ID, db.SuspendAccountInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if w := uploadForm(client, "a.txt", []byte("hello"), map[string]string{"upload_token": token}); w.Code != http.StatusForbidden {
+		t.Fatalf("token of a suspended account: got %d, want 403", w.Code)
+	}
+}
+
+func TestUploadTokenHeaders(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	token := newUploadToken(t, app, db.CreateUploadTokenInput{AccountID: account.ID})
+
+	for header, value := range map[string]string{
+		"Authorization":   "Bearer " + token,
+		uploadTokenHeader: token,
+	} {
+		client := newTestClient(app)
+		client.header.Set(header, value)
+		file := uploadedFile(t, app, uploadForm(client, "a.txt", []byte("hello"), nil))
+		if file.UploaderID != account.ID {
+			t.Errorf("%s: uploaded as %d, want %d", header, file.UploaderID, account.ID)
+		}
+
+		client.header.Set(header, strings.Replace(value, token, uuid.NewString(), 1))
+		if w := uploadForm(client, "a.txt", []byte("hello"), nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s with an unknown token: got %d, want 401", header, w.Code)
+		}
+	}
+}
+
+// Upload tokens only upload, and a bearer token is never mixed up with the
+// session cookie sent along with it
+func TestUploadTokenOnlyUploads(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	token := newUploadToken(t, app, db.CreateUploadTokenInput{AccountID: account.ID})
+
+	client := newSessionClient(t, app, account)
+	client.header.Set("Authorization", "Bearer "+token)
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/api/account/files"},
+		{http.MethodDelete, "/api/account/files"},
+		{http.MethodPost, "/api/account/upload_token"},
+	} {
+		if w := client.do(route.method, route.path, map[string]string{}); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: got %d, want 401", route.method, route.path, w.Code)
+		}
+	}
+}
+
+// A token posted from a logged in browser still gets its restrictions and
+// defaults instead of uploading as the session
+func TestUploadTokenFormFieldBeatsSession(t *testing.T) {
+	app := newTestApp(t)
+	account := newTestAccount(t, app, db.AccountTypeUser)
+	token := newUploadToken(t, app, db.CreateUploadTokenInput{
+		AccountID:    account.ID,
+		ForcedTags:   []string{"screenshots"},
+		ForcePrivate: true,
+		MaxFileSize:  16,
+	})
+	client := newSessionClient(t, app, account)
+
+	file := uploadedFile(t, app, uploadForm(client, "a.txt", []byte("hello"), map[string]string{"upload_token": token}))
+	if file.UploadTokenID == nil {
+		t.Fatal("upload wasn't credited to the token")
+	}
+	if file.Public || len(file.Tags) != 1 || file.Tags[0].Name != "screenshots" {
+		t.Errorf("token defaults weren't applied: public %v, tags %v", file.Public, file.Tags)
+	}
+
+	if w := uploadForm(client, "a.txt", bytes.Repeat([]byte("a"), 17), map[string]string{"upload_token": token}); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("file over the token's max_file_size: got %d, want 413", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", []byte("hello"), map[string]string{"upload_token": uuid.NewString()}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown token with a session: got %d, want 401", w.Code)
+	}
+	if w := uploadForm(client, "a.txt", bytes.Repeat([]byte("a"), 17), nil); w.Code != http.StatusOK {
+		t.Fatalf("session upload without a token: got %d", w.Code)
+	}
+}
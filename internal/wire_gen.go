@@ -13,11 +13,13 @@ func InitializeApplication() *Application {
 	database := prepareDB(config)
 	client := prepareStorage(config)
 	limiter := setupRatelimiting(config)
+	internalLimiterStore := setupLimiterStore(config, database)
 	internalUninitializedApplication := &uninitializedApplication{
-		config:      config,
-		db:          database,
-		s3client:    client,
-		RateLimiter: limiter,
+		config:       config,
+		db:           database,
+		s3client:     client,
+		RateLimiter:  limiter,
+		limiterStore: internalLimiterStore,
 	}
 	application := setupRouter(internalUninitializedApplication, config)
 	return application
@@ -0,0 +1,2 @@
+-- Modify "upload_tokens" table
+ALTER TABLE "upload_tokens" ADD COLUMN "name_strategy" text NULL;
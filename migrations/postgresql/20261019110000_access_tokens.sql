@@ -0,0 +1,21 @@
+-- Create "access_tokens" table
+CREATE TABLE "access_tokens" (
+  "id" bigserial NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "last_used" timestamptz NULL,
+  "nickname" text NULL,
+  "token" text NULL,
+  "scopes" text NULL,
+  "expiry_date" timestamptz NULL,
+  "allowed_ips" text NULL,
+  "account_id" bigint NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_access_tokens_account" FOREIGN KEY ("account_id") REFERENCES "accounts" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_access_tokens_account_id" to table: "access_tokens"
+CREATE INDEX "idx_access_tokens_account_id" ON "access_tokens" ("account_id");
+-- Create index "idx_access_tokens_expiry_date" to table: "access_tokens"
+CREATE INDEX "idx_access_tokens_expiry_date" ON "access_tokens" ("expiry_date");
+-- Create index "idx_access_tokens_token" to table: "access_tokens"
+CREATE UNIQUE INDEX "idx_access_tokens_token" ON "access_tokens" ("token");
@@ -0,0 +1,16 @@
+-- Tokens are stored as a keyed hash now, existing raw tokens get hashed on startup (see RehashLegacyTokens)
+-- Modify "session_tokens" table
+ALTER TABLE "session_tokens" RENAME COLUMN "token" TO "token_hash";
+ALTER TABLE "session_tokens" ADD COLUMN "token_prefix" text NULL;
+-- Rename index "idx_session_tokens_token" to "idx_session_tokens_token_hash"
+ALTER INDEX "idx_session_tokens_token" RENAME TO "idx_session_tokens_token_hash";
+-- Modify "upload_tokens" table
+ALTER TABLE "upload_tokens" RENAME COLUMN "token" TO "token_hash";
+ALTER TABLE "upload_tokens" ADD COLUMN "token_prefix" text NULL;
+-- Rename index "idx_upload_tokens_token" to "idx_upload_tokens_token_hash"
+ALTER INDEX "idx_upload_tokens_token" RENAME TO "idx_upload_tokens_token_hash";
+-- Modify "access_tokens" table
+ALTER TABLE "access_tokens" RENAME COLUMN "token" TO "token_hash";
+ALTER TABLE "access_tokens" ADD COLUMN "token_prefix" text NULL;
+-- Rename index "idx_access_tokens_token" to "idx_access_tokens_token_hash"
+ALTER INDEX "idx_access_tokens_token" RENAME TO "idx_access_tokens_token_hash";
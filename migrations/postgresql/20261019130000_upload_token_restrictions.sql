@@ -0,0 +1,8 @@
+-- Modify "upload_tokens" table
+ALTER TABLE "upload_tokens" ADD COLUMN "expiry_date" timestamptz NULL, ADD COLUMN "max_file_size" bigint NULL, ADD COLUMN "allowed_mime_types" text NULL, ADD COLUMN "daily_byte_quota" bigint NULL, ADD COLUMN "daily_file_quota" bigint NULL, ADD COLUMN "forced_tags" text NULL, ADD COLUMN "forced_expiry_days" bigint NULL, ADD COLUMN "force_private" boolean NULL;
+-- Create index "idx_upload_tokens_expiry_date" to table: "upload_tokens"
+CREATE INDEX "idx_upload_tokens_expiry_date" ON "upload_tokens" ("expiry_date");
+-- Modify "files" table
+ALTER TABLE "files" ADD COLUMN "upload_token_id" bigint NULL;
+-- Create index "idx_files_upload_token_id" to table: "files"
+CREATE INDEX "idx_files_upload_token_id" ON "files" ("upload_token_id");
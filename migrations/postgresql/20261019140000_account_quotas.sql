@@ -0,0 +1,2 @@
+-- Modify "accounts" table
+ALTER TABLE "accounts" ADD COLUMN "quota_bytes" bigint NULL, ADD COLUMN "quota_files" bigint NULL, ADD COLUMN "quota_max_file_size" bigint NULL, ADD COLUMN "quota_max_expiry_days" bigint NULL;
@@ -0,0 +1,16 @@
+-- Modify "accounts" table
+ALTER TABLE "accounts" ADD COLUMN "totp_secret" text NULL, ADD COLUMN "totp_enabled" boolean NULL, ADD COLUMN "totp_last_step" bigint NULL;
+-- Create "recovery_codes" table
+CREATE TABLE "recovery_codes" (
+  "id" bigserial NOT NULL,
+  "created_at" timestamptz NULL,
+  "code_hash" text NULL,
+  "used_at" timestamptz NULL,
+  "account_id" bigint NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_recovery_codes_account" FOREIGN KEY ("account_id") REFERENCES "accounts" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_recovery_codes_account_id" to table: "recovery_codes"
+CREATE INDEX "idx_recovery_codes_account_id" ON "recovery_codes" ("account_id");
+-- Create index "idx_recovery_codes_code_hash" to table: "recovery_codes"
+CREATE INDEX "idx_recovery_codes_code_hash" ON "recovery_codes" ("code_hash");
@@ -0,0 +1,17 @@
+-- Create "passkeys" table
+CREATE TABLE "passkeys" (
+  "id" bigserial NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "last_used" timestamptz NULL,
+  "nickname" text NULL,
+  "credential_id" text NULL,
+  "credential" text NULL,
+  "account_id" bigint NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_passkeys_account" FOREIGN KEY ("account_id") REFERENCES "accounts" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_passkeys_account_id" to table: "passkeys"
+CREATE INDEX "idx_passkeys_account_id" ON "passkeys" ("account_id");
+-- Create index "idx_passkeys_credential_id" to table: "passkeys"
+CREATE UNIQUE INDEX "idx_passkeys_credential_id" ON "passkeys" ("credential_id");
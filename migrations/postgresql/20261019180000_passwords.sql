@@ -0,0 +1,4 @@
+-- Modify "accounts" table
+ALTER TABLE "accounts" ADD COLUMN "username" text NULL, ADD COLUMN "password_hash" text NULL;
+-- Create partial unique index "idx_accounts_username" to table: "accounts"
+CREATE UNIQUE INDEX "idx_accounts_username" ON "accounts" ("username") WHERE "username" <> '';
@@ -0,0 +1,21 @@
+-- Create "linked_identities" table
+CREATE TABLE "linked_identities" (
+  "id" bigserial NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "provider" text NULL,
+  "subject" text NULL,
+  "username" text NULL,
+  "account_id" bigint NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_linked_identities_account" FOREIGN KEY ("account_id") REFERENCES "accounts" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_linked_identities_account_provider" to table: "linked_identities"
+CREATE UNIQUE INDEX "idx_linked_identities_account_provider" ON "linked_identities" ("account_id", "provider");
+-- Create index "idx_linked_identities_provider_subject" to table: "linked_identities"
+CREATE UNIQUE INDEX "idx_linked_identities_provider_subject" ON "linked_identities" ("provider", "subject");
+-- Move GitHub and OpenID Connect identities over from "accounts"
+INSERT INTO "linked_identities" ("created_at", "updated_at", "provider", "subject", "username", "account_id") SELECT "updated_at", "updated_at", 'github', "github_id"::text, "github_username", "id" FROM "accounts" WHERE "github_id" <> 0;
+INSERT INTO "linked_identities" ("created_at", "updated_at", "provider", "subject", "username", "account_id") SELECT "updated_at", "updated_at", 'openid-connect', "oidc_id", "oidc_username", "id" FROM "accounts" WHERE "oidc_id" <> '';
+-- Modify "accounts" table
+ALTER TABLE "accounts" DROP COLUMN "github_id", DROP COLUMN "github_username", DROP COLUMN "oidc_id", DROP COLUMN "oidc_username";
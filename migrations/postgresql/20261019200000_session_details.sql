@@ -0,0 +1,2 @@
+-- Modify "session_tokens" table
+ALTER TABLE "session_tokens" ADD COLUMN "user_agent" text NULL, ADD COLUMN "ip_hash" text NULL, ADD COLUMN "login_provider" text NULL;
@@ -0,0 +1,4 @@
+-- Modify "session_tokens" table
+ALTER TABLE "session_tokens" ADD COLUMN "previous_token_hash" text NULL, ADD COLUMN "rotated_at" timestamptz NULL;
+-- Create index "idx_session_tokens_previous_token_hash" to table: "session_tokens"
+CREATE INDEX "idx_session_tokens_previous_token_hash" ON "session_tokens" ("previous_token_hash");
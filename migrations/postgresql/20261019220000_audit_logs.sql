@@ -0,0 +1,19 @@
+-- Create "audit_logs" table
+CREATE TABLE "audit_logs" (
+  "id" bigserial NOT NULL,
+  "created_at" timestamptz NULL,
+  "actor_id" bigint NULL,
+  "target_id" bigint NULL,
+  "action" text NULL,
+  "detail" text NULL,
+  "ip_hash" text NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_audit_logs_created_at" to table: "audit_logs"
+CREATE INDEX "idx_audit_logs_created_at" ON "audit_logs" ("created_at");
+-- Create index "idx_audit_logs_actor_id" to table: "audit_logs"
+CREATE INDEX "idx_audit_logs_actor_id" ON "audit_logs" ("actor_id");
+-- Create index "idx_audit_logs_target_id" to table: "audit_logs"
+CREATE INDEX "idx_audit_logs_target_id" ON "audit_logs" ("target_id");
+-- Create index "idx_audit_logs_action" to table: "audit_logs"
+CREATE INDEX "idx_audit_logs_action" ON "audit_logs" ("action");
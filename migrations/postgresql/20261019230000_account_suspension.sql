@@ -0,0 +1,2 @@
+-- Modify "accounts" table
+ALTER TABLE "accounts" ADD COLUMN "suspended_at" timestamptz NULL, ADD COLUMN "suspended_until" timestamptz NULL, ADD COLUMN "suspension_reason" text NULL, ADD COLUMN "suspension_hides_files" boolean NULL;
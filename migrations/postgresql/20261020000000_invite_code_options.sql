@@ -0,0 +1,15 @@
+-- Modify "invite_codes" table
+ALTER TABLE "invite_codes" ADD COLUMN "note" text NULL, ADD COLUMN "quota_preset" text NULL, ADD COLUMN "quota_bytes" bigint NULL, ADD COLUMN "quota_files" bigint NULL, ADD COLUMN "quota_max_file_size" bigint NULL, ADD COLUMN "quota_max_expiry_days" bigint NULL;
+-- Create "invite_code_redemptions" table
+CREATE TABLE "invite_code_redemptions" (
+  "id" bigserial NOT NULL,
+  "created_at" timestamptz NULL,
+  "invite_code_id" bigint NULL,
+  "account_id" bigint NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_invite_codes_redemptions" FOREIGN KEY ("invite_code_id") REFERENCES "invite_codes" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_invite_code_redemptions_invite_code_id" to table: "invite_code_redemptions"
+CREATE INDEX "idx_invite_code_redemptions_invite_code_id" ON "invite_code_redemptions" ("invite_code_id");
+-- Create index "idx_invite_code_redemptions_account_id" to table: "invite_code_redemptions"
+CREATE INDEX "idx_invite_code_redemptions_account_id" ON "invite_code_redemptions" ("account_id");
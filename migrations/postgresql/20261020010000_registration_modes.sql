@@ -0,0 +1,2 @@
+-- Modify "accounts" table
+ALTER TABLE "accounts" ADD COLUMN "pending_approval" boolean NULL, ADD COLUMN "registration_email" text NULL;
@@ -0,0 +1,2 @@
+-- Modify "invite_codes" table
+ALTER TABLE "invite_codes" DROP CONSTRAINT "fk_invite_codes_invite_creator", ADD CONSTRAINT "fk_invite_codes_invite_creator" FOREIGN KEY ("invite_creator_id") REFERENCES "accounts" ("id") ON UPDATE NO ACTION ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+-- Add column "name_strategy" to table: "upload_tokens"
+ALTER TABLE `upload_tokens` ADD COLUMN `name_strategy` text NULL;
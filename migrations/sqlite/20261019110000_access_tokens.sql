@@ -0,0 +1,20 @@
+-- Create "access_tokens" table
+CREATE TABLE `access_tokens` (
+  `id` integer NULL PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime NULL,
+  `updated_at` datetime NULL,
+  `last_used` datetime NULL,
+  `nickname` text NULL,
+  `token` text NULL,
+  `scopes` text NULL,
+  `expiry_date` datetime NULL DEFAULT (null),
+  `allowed_ips` text NULL,
+  `account_id` integer NULL,
+  CONSTRAINT `fk_access_tokens_account` FOREIGN KEY (`account_id`) REFERENCES `accounts` (`id`) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_access_tokens_account_id" to table: "access_tokens"
+CREATE INDEX `idx_access_tokens_account_id` ON `access_tokens` (`account_id`);
+-- Create index "idx_access_tokens_expiry_date" to table: "access_tokens"
+CREATE INDEX `idx_access_tokens_expiry_date` ON `access_tokens` (`expiry_date`);
+-- Create index "idx_access_tokens_token" to table: "access_tokens"
+CREATE UNIQUE INDEX `idx_access_tokens_token` ON `access_tokens` (`token`);
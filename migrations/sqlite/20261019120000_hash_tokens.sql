@@ -0,0 +1,22 @@
+-- Tokens are stored as a keyed hash now, existing raw tokens get hashed on startup (see RehashLegacyTokens)
+-- Modify "session_tokens" table
+ALTER TABLE `session_tokens` RENAME COLUMN `token` TO `token_hash`;
+ALTER TABLE `session_tokens` ADD COLUMN `token_prefix` text NULL;
+-- Drop index "idx_session_tokens_token" from table: "session_tokens"
+DROP INDEX `idx_session_tokens_token`;
+-- Create index "idx_session_tokens_token_hash" to table: "session_tokens"
+CREATE UNIQUE INDEX `idx_session_tokens_token_hash` ON `session_tokens` (`token_hash`);
+-- Modify "upload_tokens" table
+ALTER TABLE `upload_tokens` RENAME COLUMN `token` TO `token_hash`;
+ALTER TABLE `upload_tokens` ADD COLUMN `token_prefix` text NULL;
+-- Drop index "idx_upload_tokens_token" from table: "upload_tokens"
+DROP INDEX `idx_upload_tokens_token`;
+-- Create index "idx_upload_tokens_token_hash" to table: "upload_tokens"
+CREATE UNIQUE INDEX `idx_upload_tokens_token_hash` ON `upload_tokens` (`token_hash`);
+-- Modify "access_tokens" table
+ALTER TABLE `access_tokens` RENAME COLUMN `token` TO `token_hash`;
+ALTER TABLE `access_tokens` ADD COLUMN `token_prefix` text NULL;
+-- Drop index "idx_access_tokens_token" from table: "access_tokens"
+DROP INDEX `idx_access_tokens_token`;
+-- Create index "idx_access_tokens_token_hash" to table: "access_tokens"
+CREATE UNIQUE INDEX `idx_access_tokens_token_hash` ON `access_tokens` (`token_hash`);
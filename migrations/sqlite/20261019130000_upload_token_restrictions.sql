@@ -0,0 +1,15 @@
+-- Add restriction columns to table: "upload_tokens"
+ALTER TABLE `upload_tokens` ADD COLUMN `expiry_date` datetime NULL DEFAULT (null);
+ALTER TABLE `upload_tokens` ADD COLUMN `max_file_size` integer NULL;
+ALTER TABLE `upload_tokens` ADD COLUMN `allowed_mime_types` text NULL;
+ALTER TABLE `upload_tokens` ADD COLUMN `daily_byte_quota` integer NULL;
+ALTER TABLE `upload_tokens` ADD COLUMN `daily_file_quota` integer NULL;
+ALTER TABLE `upload_tokens` ADD COLUMN `forced_tags` text NULL;
+ALTER TABLE `upload_tokens` ADD COLUMN `forced_expiry_days` integer NULL;
+ALTER TABLE `upload_tokens` ADD COLUMN `force_private` numeric NULL;
+-- Create index "idx_upload_tokens_expiry_date" to table: "upload_tokens"
+CREATE INDEX `idx_upload_tokens_expiry_date` ON `upload_tokens` (`expiry_date`);
+-- Add column "upload_token_id" to table: "files"
+ALTER TABLE `files` ADD COLUMN `upload_token_id` integer NULL;
+-- Create index "idx_files_upload_token_id" to table: "files"
+CREATE INDEX `idx_files_upload_token_id` ON `files` (`upload_token_id`);
@@ -0,0 +1,5 @@
+-- Add quota columns to table: "accounts"
+ALTER TABLE `accounts` ADD COLUMN `quota_bytes` integer NULL;
+ALTER TABLE `accounts` ADD COLUMN `quota_files` integer NULL;
+ALTER TABLE `accounts` ADD COLUMN `quota_max_file_size` integer NULL;
+ALTER TABLE `accounts` ADD COLUMN `quota_max_expiry_days` integer NULL;
@@ -0,0 +1,9 @@
+-- Create "rate_limits" table
+CREATE TABLE `rate_limits` (
+  `bucket` text NULL,
+  `count` integer NULL,
+  `reset_at` integer NULL,
+  PRIMARY KEY (`bucket`)
+);
+-- Create index "idx_rate_limits_reset_at" to table: "rate_limits"
+CREATE INDEX `idx_rate_limits_reset_at` ON `rate_limits` (`reset_at`);
@@ -0,0 +1,17 @@
+-- Add two-factor columns to table: "accounts"
+ALTER TABLE `accounts` ADD COLUMN `totp_secret` text NULL;
+ALTER TABLE `accounts` ADD COLUMN `totp_enabled` numeric NULL;
+ALTER TABLE `accounts` ADD COLUMN `totp_last_step` integer NULL;
+-- Create "recovery_codes" table
+CREATE TABLE `recovery_codes` (
+  `id` integer NULL PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime NULL,
+  `code_hash` text NULL,
+  `used_at` datetime NULL,
+  `account_id` integer NULL,
+  CONSTRAINT `fk_recovery_codes_account` FOREIGN KEY (`account_id`) REFERENCES `accounts` (`id`) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_recovery_codes_account_id" to table: "recovery_codes"
+CREATE INDEX `idx_recovery_codes_account_id` ON `recovery_codes` (`account_id`);
+-- Create index "idx_recovery_codes_code_hash" to table: "recovery_codes"
+CREATE INDEX `idx_recovery_codes_code_hash` ON `recovery_codes` (`code_hash`);
@@ -0,0 +1,16 @@
+-- Create "passkeys" table
+CREATE TABLE `passkeys` (
+  `id` integer NULL PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime NULL,
+  `updated_at` datetime NULL,
+  `last_used` datetime NULL,
+  `nickname` text NULL,
+  `credential_id` text NULL,
+  `credential` text NULL,
+  `account_id` integer NULL,
+  CONSTRAINT `fk_passkeys_account` FOREIGN KEY (`account_id`) REFERENCES `accounts` (`id`) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_passkeys_account_id" to table: "passkeys"
+CREATE INDEX `idx_passkeys_account_id` ON `passkeys` (`account_id`);
+-- Create index "idx_passkeys_credential_id" to table: "passkeys"
+CREATE UNIQUE INDEX `idx_passkeys_credential_id` ON `passkeys` (`credential_id`);
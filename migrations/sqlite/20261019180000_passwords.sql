@@ -0,0 +1,5 @@
+-- Add local login columns to table: "accounts"
+ALTER TABLE `accounts` ADD COLUMN `username` text NULL;
+ALTER TABLE `accounts` ADD COLUMN `password_hash` text NULL;
+-- Create partial unique index "idx_accounts_username" to table: "accounts"
+CREATE UNIQUE INDEX `idx_accounts_username` ON `accounts` (`username`) WHERE `username` <> '';
@@ -0,0 +1,6 @@
+-- Add column "user_agent" to table: "session_tokens"
+ALTER TABLE `session_tokens` ADD COLUMN `user_agent` text NULL;
+-- Add column "ip_hash" to table: "session_tokens"
+ALTER TABLE `session_tokens` ADD COLUMN `ip_hash` text NULL;
+-- Add column "login_provider" to table: "session_tokens"
+ALTER TABLE `session_tokens` ADD COLUMN `login_provider` text NULL;
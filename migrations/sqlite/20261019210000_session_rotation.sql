@@ -0,0 +1,6 @@
+-- Add column "previous_token_hash" to table: "session_tokens"
+ALTER TABLE `session_tokens` ADD COLUMN `previous_token_hash` text NULL;
+-- Add column "rotated_at" to table: "session_tokens"
+ALTER TABLE `session_tokens` ADD COLUMN `rotated_at` datetime NULL;
+-- Create index "idx_session_tokens_previous_token_hash" to table: "session_tokens"
+CREATE INDEX `idx_session_tokens_previous_token_hash` ON `session_tokens` (`previous_token_hash`);
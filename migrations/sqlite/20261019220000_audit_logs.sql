@@ -0,0 +1,18 @@
+-- Create "audit_logs" table
+CREATE TABLE `audit_logs` (
+  `id` integer NULL PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime NULL,
+  `actor_id` integer NULL,
+  `target_id` integer NULL,
+  `action` text NULL,
+  `detail` text NULL,
+  `ip_hash` text NULL
+);
+-- Create index "idx_audit_logs_created_at" to table: "audit_logs"
+CREATE INDEX `idx_audit_logs_created_at` ON `audit_logs` (`created_at`);
+-- Create index "idx_audit_logs_actor_id" to table: "audit_logs"
+CREATE INDEX `idx_audit_logs_actor_id` ON `audit_logs` (`actor_id`);
+-- Create index "idx_audit_logs_target_id" to table: "audit_logs"
+CREATE INDEX `idx_audit_logs_target_id` ON `audit_logs` (`target_id`);
+-- Create index "idx_audit_logs_action" to table: "audit_logs"
+CREATE INDEX `idx_audit_logs_action` ON `audit_logs` (`action`);
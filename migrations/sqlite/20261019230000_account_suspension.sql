@@ -0,0 +1,8 @@
+-- Add column "suspended_at" to table: "accounts"
+ALTER TABLE `accounts` ADD COLUMN `suspended_at` datetime NULL;
+-- Add column "suspended_until" to table: "accounts"
+ALTER TABLE `accounts` ADD COLUMN `suspended_until` datetime NULL;
+-- Add column "suspension_reason" to table: "accounts"
+ALTER TABLE `accounts` ADD COLUMN `suspension_reason` text NULL;
+-- Add column "suspension_hides_files" to table: "accounts"
+ALTER TABLE `accounts` ADD COLUMN `suspension_hides_files` numeric NULL;
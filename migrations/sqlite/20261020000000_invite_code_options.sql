@@ -0,0 +1,24 @@
+-- Add column "note" to table: "invite_codes"
+ALTER TABLE `invite_codes` ADD COLUMN `note` text NULL;
+-- Add column "quota_preset" to table: "invite_codes"
+ALTER TABLE `invite_codes` ADD COLUMN `quota_preset` text NULL;
+-- Add column "quota_bytes" to table: "invite_codes"
+ALTER TABLE `invite_codes` ADD COLUMN `quota_bytes` integer NULL;
+-- Add column "quota_files" to table: "invite_codes"
+ALTER TABLE `invite_codes` ADD COLUMN `quota_files` integer NULL;
+-- Add column "quota_max_file_size" to table: "invite_codes"
+ALTER TABLE `invite_codes` ADD COLUMN `quota_max_file_size` integer NULL;
+-- Add column "quota_max_expiry_days" to table: "invite_codes"
+ALTER TABLE `invite_codes` ADD COLUMN `quota_max_expiry_days` integer NULL;
+-- Create "invite_code_redemptions" table
+CREATE TABLE `invite_code_redemptions` (
+  `id` integer NULL PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime NULL,
+  `invite_code_id` integer NULL,
+  `account_id` integer NULL,
+  CONSTRAINT `fk_invite_codes_redemptions` FOREIGN KEY (`invite_code_id`) REFERENCES `invite_codes` (`id`) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_invite_code_redemptions_invite_code_id" to table: "invite_code_redemptions"
+CREATE INDEX `idx_invite_code_redemptions_invite_code_id` ON `invite_code_redemptions` (`invite_code_id`);
+-- Create index "idx_invite_code_redemptions_account_id" to table: "invite_code_redemptions"
+CREATE INDEX `idx_invite_code_redemptions_account_id` ON `invite_code_redemptions` (`account_id`);
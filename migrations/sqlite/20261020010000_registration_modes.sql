@@ -0,0 +1,4 @@
+-- Add column "pending_approval" to table: "accounts"
+ALTER TABLE `accounts` ADD COLUMN `pending_approval` numeric NULL;
+-- Add column "registration_email" to table: "accounts"
+ALTER TABLE `accounts` ADD COLUMN `registration_email` text NULL;
@@ -0,0 +1,12 @@
+-- Create "upload_token_uses" table
+CREATE TABLE `upload_token_uses` (
+  `id` integer NULL PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime NULL,
+  `file_size` integer NULL,
+  `upload_token_id` integer NULL,
+  CONSTRAINT `fk_upload_token_uses_upload_token` FOREIGN KEY (`upload_token_id`) REFERENCES `upload_tokens` (`id`) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_upload_token_uses_created_at" to table: "upload_token_uses"
+CREATE INDEX `idx_upload_token_uses_created_at` ON `upload_token_uses` (`created_at`);
+-- Create index "idx_upload_token_uses_upload_token_id" to table: "upload_token_uses"
+CREATE INDEX `idx_upload_token_uses_upload_token_id` ON `upload_token_uses` (`upload_token_id`);
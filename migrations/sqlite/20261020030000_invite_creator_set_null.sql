@@ -0,0 +1,32 @@
+-- Disable the enforcement of foreign-keys constraints
+PRAGMA foreign_keys = off;
+-- Create "new_invite_codes" table
+CREATE TABLE `new_invite_codes` (
+  `id` integer NULL PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime NULL,
+  `updated_at` datetime NULL,
+  `code` text NULL,
+  `uses` integer NULL,
+  `expiry_date` datetime NULL,
+  `account_type` text NULL,
+  `note` text NULL,
+  `quota_preset` text NULL,
+  `quota_bytes` integer NULL,
+  `quota_files` integer NULL,
+  `quota_max_file_size` integer NULL,
+  `quota_max_expiry_days` integer NULL,
+  `invite_creator_id` integer NULL DEFAULT (null),
+  CONSTRAINT `fk_invite_codes_invite_creator` FOREIGN KEY (`invite_creator_id`) REFERENCES `accounts` (`id`) ON UPDATE NO ACTION ON DELETE SET NULL
+);
+-- Copy rows from old table "invite_codes" to new temporary table "new_invite_codes"
+INSERT INTO `new_invite_codes` (`id`, `created_at`, `updated_at`, `code`, `uses`, `expiry_date`, `account_type`, `note`, `quota_preset`, `quota_bytes`, `quota_files`, `quota_max_file_size`, `quota_max_expiry_days`, `invite_creator_id`) SELECT `id`, `created_at`, `updated_at`, `code`, `uses`, `expiry_date`, `account_type`, `note`, `quota_preset`, `quota_bytes`, `quota_files`, `quota_max_file_size`, `quota_max_expiry_days`, `invite_creator_id` FROM `invite_codes`;
+-- Drop "invite_codes" table after copying rows
+DROP TABLE `invite_codes`;
+-- Rename temporary table "new_invite_codes" to "invite_codes"
+ALTER TABLE `new_invite_codes` RENAME TO `invite_codes`;
+-- Create index "idx_invite_codes_code" to table: "invite_codes"
+CREATE UNIQUE INDEX `idx_invite_codes_code` ON `invite_codes` (`code`);
+-- Create index "idx_invite_codes_invite_creator_id" to table: "invite_codes"
+CREATE INDEX `idx_invite_codes_invite_creator_id` ON `invite_codes` (`invite_creator_id`);
+-- Enable back the enforcement of foreign-keys constraints
+PRAGMA foreign_keys = on;
@@ -33,6 +33,8 @@ in
         Environment file for specifying secrets. Supported variables:
         - GITHUB_CLIENT_ID: GitHub OAuth app ID
         - GITHUB_SECRET: GitHub OAuth app secret
+        - <NAME>_CLIENT_ID, <NAME>_CLIENT_SECRET: Credentials of the login provider configured as login_providers.<name>
+        - LDAP_BIND_PASSWORD: Password of the LDAP service account
         - S3_ACCESS_KEY_ID: S3 access key ID (overrides config)
         - S3_SECRET_ACCESS_KEY: S3 secret access key (overrides config)
         - INITIAL_REGISTER_TOKEN: If set, uses this value as the initial admin registration token on first run instead of generating a random one. Useful for automated deployments and testing.
@@ -1,5 +1,6 @@
 #!/usr/bin/env python3
 import os
+import re
 import shutil
 import subprocess
 import time
@@ -30,11 +31,14 @@ def curl(*args: str) -> str:
 curl("-c", COOKIES, "-L", "--data-urlencode", f"code={TOKEN}", f"{BASE}/api/auth/register")
 print("Registered")
 
+# Uploading with the session cookie needs the page's CSRF token
+csrf = re.search(r'name="csrf-token" content="([^"]+)"', curl("-b", COOKIES, f"{BASE}/settings")).group(1)
+
 # Upload the example images
 for path, name in [(MASCOT, "mascot.png"), (MASCOT, "mascot.png")]:
     if not path:
         continue
-    curl("-b", COOKIES, "-F", f"file=@{path}", f"{BASE}/api/file/upload")
+    curl("-b", COOKIES, "-H", f"X-CSRF-Token: {csrf}", "-F", f"file=@{path}", f"{BASE}/api/file/upload")
     print(f"Uploaded {name}")
 
 auth = ""
@@ -20,6 +20,12 @@ let
   dexUserEmail = "alice@example.com";
   dexUserPassword = "testpass";
   dexUserHash = "$2b$12$CHdVQpLOYn3blAhuVrSVHOus2ARHU0Tny8cvMYjuSmtSmQXcbnl5K"; # hash for the password above
+
+  ldapPort = 3890;
+  ldapSuffix = "dc=example,dc=com";
+  ldapAdminPassword = "ldap-admin-secret";
+  ldapUser = "bob";
+  ldapUserPassword = "bobpass";
 in
 testers.nixosTest {
   name = "hostling-advanced";
@@ -81,6 +87,12 @@ testers.nixosTest {
             insecure = true;
             proxyfiles = true;
           };
+
+          ldap = {
+            url = "ldap://127.0.0.1:${toString ldapPort}";
+            bind_dn = "cn=admin,${ldapSuffix}";
+            base_dn = "ou=people,${ldapSuffix}";
+          };
         };
       };
 
@@ -139,14 +151,55 @@ testers.nixosTest {
         };
       };
 
+      services.openldap = {
+        enable = true;
+        urlList = [ "ldap://127.0.0.1:${toString ldapPort}/" ];
+        settings.children = {
+          "cn=schema".includes = [
+            "${pkgs.openldap}/etc/schema/core.ldif"
+            "${pkgs.openldap}/etc/schema/cosine.ldif"
+            "${pkgs.openldap}/etc/schema/inetorgperson.ldif"
+          ];
+          "olcDatabase={1}mdb".attrs = {
+            objectClass = [
+              "olcDatabaseConfig"
+              "olcMdbConfig"
+            ];
+            olcDatabase = "{1}mdb";
+            olcDbDirectory = "/var/lib/openldap/db";
+            olcSuffix = ldapSuffix;
+            olcRootDN = "cn=admin,${ldapSuffix}";
+            olcRootPW = ldapAdminPassword;
+          };
+        };
+        declarativeContents.${ldapSuffix} = ''
+          dn: ${ldapSuffix}
+          objectClass: domain
+          dc: example
+
+          dn: ou=people,${ldapSuffix}
+          objectClass: organizationalUnit
+          ou: people
+
+          dn: uid=${ldapUser},ou=people,${ldapSuffix}
+          objectClass: inetOrgPerson
+          uid: ${ldapUser}
+          cn: Bob
+          sn: Bob
+          userPassword: ${ldapUserPassword}
+        '';
+      };
+
       systemd.services.hostling = {
         after = [
           "garage-init.service"
           "dex.service"
+          "openldap.service"
         ];
         requires = [
           "garage-init.service"
           "dex.service"
+          "openldap.service"
         ];
         environment = {
           # in real world scenariou this would be in environmentFile
@@ -154,6 +207,7 @@ testers.nixosTest {
           OPENID_CONNECT_CLIENT_ID = dexClientID;
           OPENID_CONNECT_CLIENT_SECRET = dexClientSecret;
           OPENID_CONNECT_DISCOVERY_URL = "http://localhost:${toString dexPort}/dex/.well-known/openid-configuration";
+          LDAP_BIND_PASSWORD = ldapAdminPassword;
         };
       };
     };
@@ -166,6 +220,8 @@ testers.nixosTest {
       DEX_PORT = "${toString dexPort}"
       DEX_EMAIL = "${dexUserEmail}"
       DEX_PASSWORD = "${dexUserPassword}"
+      LDAP_USER = "${ldapUser}"
+      LDAP_PASSWORD = "${ldapUserPassword}"
       exec(open("${./test_script_advanced.py}").read())
     '';
 }
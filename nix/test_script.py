@@ -1,6 +1,7 @@
 # pyright: reportUndefinedVariable=false
 # ruff: noqa: F821
 import json
+import re
 import time
 
 COOKIES_A = "/tmp/cookies_a.txt"
@@ -16,10 +17,22 @@ def _form_args(fields):
     return " ".join(f"-F '{k}={v}'" for k, v in fields)
 
 
+def csrf_token(cookies=COOKIES_A):
+    """Token from the csrf-token meta tag, empty when logged out."""
+    page = machine.succeed(
+        f"curl -s -b {cookies} -c {cookies} 'http://localhost:{PORT}/settings'"
+    )
+    m = re.search(r'name="csrf-token" content="([^"]+)"', page)
+    return m.group(1) if m else ""
+
+
 def _curl_args(path, *, method=None, fields=None, cookies=COOKIES_A):
     parts = []
     if cookies:
-        parts.append(f"-b {cookies}")
+        # Sessions rotate their token as they're used, keep the jar up to date
+        parts.append(f"-b {cookies} -c {cookies}")
+        if (method and method != "GET") or fields:
+            parts.append(f"-H 'X-CSRF-Token: {csrf_token(cookies)}'")
     if method and method != "GET":
         parts.append(f"-X {method}")
     if fields:
@@ -759,7 +772,8 @@ def verify_account_self_delete():
 def verify_logout_invalidates_session():
     # -c overwrites the cookie jar with whatever the logout response sets
     machine.succeed(
-        f"curl -f -b {COOKIES_A} -c {COOKIES_A} -X POST 'http://localhost:{PORT}/logout'"
+        f"curl -f -b {COOKIES_A} -c {COOKIES_A} -H 'X-CSRF-Token: {csrf_token()}' "
+        f"-X POST 'http://localhost:{PORT}/logout'"
     )
     status = api_status("/api/account/files")
     assert status == "401", f"Expected 401 after logout, got {status}"
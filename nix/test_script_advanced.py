@@ -16,11 +16,21 @@ def status(args):
     return machine.succeed(f"curl -s -o /dev/null -w '%{{http_code}}' {args}").strip()
 
 
+def csrf(cookies=COOKIES):
+    """Header with the token requests using the session cookie need."""
+    page = machine.succeed(
+        f"curl -s -b {cookies} -c {cookies} 'http://localhost:{PORT}/settings'"
+    )
+    m = re.search(r'name="csrf-token" content="([^"]+)"', page)
+    assert m, "no csrf-token meta tag on the settings page"
+    return f"-H 'X-CSRF-Token: {m.group(1)}'"
+
+
 def bucket_roundtrip():
     machine.succeed(f"printf %s {DUMMY_CONTENT!r} > {DUMMY_FILE}")
 
     uploaded = curl(
-        f"-b {COOKIES} -F 'file=@{DUMMY_FILE}' -F 'plain=true' "
+        f"-b {COOKIES} {csrf()} -F 'file=@{DUMMY_FILE}' -F 'plain=true' "
         f"'http://localhost:{PORT}/api/file/upload'"
     ).lstrip("/")
     assert uploaded, "expected upload to return a file name"
@@ -31,7 +41,7 @@ def bucket_roundtrip():
     )
 
     curl(
-        f"-b {COOKIES} -X DELETE -F 'file_name={uploaded}' "
+        f"-b {COOKIES} {csrf()} -X DELETE -F 'file_name={uploaded}' "
         f"'http://localhost:{PORT}/api/account/file'"
     )
 
@@ -56,7 +66,7 @@ def submit_dex_login(page):
 
 def oidc_link_flow():
     page = machine.succeed(
-        f"curl -fsSL -b {COOKIES} -c {COOKIES} -d '' "
+        f"curl -fsSL -b {COOKIES} -c {COOKIES} {csrf()} -d '' "
         f"'http://localhost:{PORT}/api/auth/link/openid-connect'"
     )
     submit_dex_login(page)
@@ -74,7 +84,7 @@ def garage_object_count():
 
 def verify_account_deletion_purges_bucket():
     invite = machine.succeed(
-        f"curl -fsS -b {COOKIES} -F 'id=1' -F 'uses=1' "
+        f"curl -fsS -b {COOKIES} {csrf()} -F 'id=1' -F 'uses=1' "
         f"'http://localhost:{PORT}/api/admin/give_invite_code'"
     ).strip()
 
@@ -89,11 +99,11 @@ def verify_account_deletion_purges_bucket():
     machine.succeed("head -c 256 /dev/urandom > /tmp/b1.bin")
     machine.succeed("head -c 512 /dev/urandom > /tmp/b2.bin")
     curl(
-        f"-b {cookies_b} -F 'file=@/tmp/b1.bin' -F 'plain=true' "
+        f"-b {cookies_b} {csrf(cookies_b)} -F 'file=@/tmp/b1.bin' -F 'plain=true' "
         f"'http://localhost:{PORT}/api/file/upload'"
     )
     curl(
-        f"-b {cookies_b} -F 'file=@/tmp/b2.bin' -F 'plain=true' "
+        f"-b {cookies_b} {csrf(cookies_b)} -F 'file=@/tmp/b2.bin' -F 'plain=true' "
         f"'http://localhost:{PORT}/api/file/upload'"
     )
 
@@ -103,7 +113,7 @@ def verify_account_deletion_purges_bucket():
     )
 
     machine.succeed(
-        f"curl -f -b {cookies_b} -X DELETE 'http://localhost:{PORT}/api/account/'"
+        f"curl -f -b {cookies_b} {csrf(cookies_b)} -X DELETE 'http://localhost:{PORT}/api/account/'"
     )
 
     after_delete = garage_object_count()
@@ -114,7 +124,7 @@ def verify_account_deletion_purges_bucket():
 
 def verify_unlink_last_identity_blocked():
     code = status(
-        f"-b {COOKIES} -d '' "
+        f"-b {COOKIES} {csrf()} -d '' "
         f"'http://localhost:{PORT}/api/auth/unlink/openid-connect'"
     )
     assert code == "409", f"expected 409 unlinking last identity, got {code}"
@@ -123,7 +133,7 @@ def verify_unlink_last_identity_blocked():
 def oidc_login_flow():
     # try login logout
     machine.succeed(
-        f"curl -f -b {COOKIES} -c {COOKIES} -X POST 'http://localhost:{PORT}/logout'"
+        f"curl -f -b {COOKIES} -c {COOKIES} {csrf()} -X POST 'http://localhost:{PORT}/logout'"
     )
     code = status(f"-b {COOKIES} 'http://localhost:{PORT}/api/account/files'")
     assert code == "401", f"expected 401 after logout, got {code}"
@@ -138,10 +148,39 @@ def oidc_login_flow():
     assert code == "200", f"expected 200 after OIDC login, got {code}"
 
 
+def ldap_flow():
+    code = status(
+        f"-b {COOKIES} {csrf()} --data-urlencode 'username={LDAP_USER}' "
+        f"--data-urlencode 'password=wrong-password' "
+        f"'http://localhost:{PORT}/api/auth/link/ldap'"
+    )
+    assert code == "401", f"expected 401 linking with a wrong password, got {code}"
+
+    code = status(
+        f"-b {COOKIES} {csrf()} --data-urlencode 'username={LDAP_USER}' "
+        f"--data-urlencode 'password={LDAP_PASSWORD}' "
+        f"'http://localhost:{PORT}/api/auth/link/ldap'"
+    )
+    assert code == "303", f"expected 303 after LDAP link, got {code}"
+
+    machine.succeed(
+        f"curl -f -b {COOKIES} -c {COOKIES} {csrf()} -X POST 'http://localhost:{PORT}/logout'"
+    )
+    machine.succeed(
+        f"curl -f -c {COOKIES} --data-urlencode 'username={LDAP_USER}' "
+        f"--data-urlencode 'password={LDAP_PASSWORD}' "
+        f"'http://localhost:{PORT}/api/auth/ldap'"
+    )
+
+    code = status(f"-b {COOKIES} 'http://localhost:{PORT}/api/account/files'")
+    assert code == "200", f"expected 200 after LDAP login, got {code}"
+
+
 def main():
     start_all()
     machine.wait_for_unit("garage-init.service")
     machine.wait_for_unit("dex.service")
+    machine.wait_for_unit("openldap.service")
     machine.wait_for_unit("hostling.service")
     machine.wait_for_open_port(int(PORT))
     machine.wait_for_open_port(int(DEX_PORT))
@@ -156,6 +195,7 @@ def main():
     oidc_link_flow()
     verify_unlink_last_identity_blocked()
     oidc_login_flow()
+    ldap_flow()
     verify_account_deletion_purges_bucket()
 
 
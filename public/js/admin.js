@@ -1,3 +1,5 @@
+import { csrfHeaders } from './csrf.js';
+
 function confirmDeleteUploadTokens(id) {
     if (confirm("Are you sure you want to delete all upload tokens for this user?")) {
         const formData  = new FormData();
@@ -5,6 +7,7 @@ function confirmDeleteUploadTokens(id) {
 
         fetch('/api/admin/upload_tokens', {
             method: 'DELETE',
+            headers: csrfHeaders(),
             body: formData,
         }).then(response => {
             if (response.ok) {
@@ -26,6 +29,7 @@ function confirmDeleteSessions(id) {
 
         fetch('/api/admin/sessions', {
             method: 'DELETE',
+            headers: csrfHeaders(),
             body: formData,
         }).then(response => {
             if (response.ok) {
@@ -40,6 +44,28 @@ function confirmDeleteSessions(id) {
 
 window.confirmDeleteSessions = confirmDeleteSessions;
 
+function confirmResetTOTP(id) {
+    if (confirm("Are you sure you want to reset two-factor authentication for this user? They will be able to log in without it.")) {
+        const formData  = new FormData();
+        formData.append('id', id);
+
+        fetch('/api/admin/totp', {
+            method: 'DELETE',
+            headers: csrfHeaders(),
+            body: formData,
+        }).then(response => {
+            if (response.ok) {
+                alert('Two-factor authentication has been reset for this user.');
+                window.location.reload();
+            } else {
+                alert('Failed to reset two-factor authentication for this user.');
+            }
+        });
+    }
+}
+
+window.confirmResetTOTP = confirmResetTOTP;
+
 function confirmDeleteFiles(id) {
     if (confirm("Are you sure you want to delete all files for this user? This action cannot be undone.")) {
         const formData  = new FormData();
@@ -47,6 +73,7 @@ function confirmDeleteFiles(id) {
 
         fetch('/api/admin/files', {
             method: 'DELETE',
+            headers: csrfHeaders(),
             body: formData,
         }).then(response => {
             if (response.ok) {
@@ -68,6 +95,7 @@ function confirmDeleteUser(id) {
 
         fetch('/api/admin/user', {
             method: 'DELETE',
+            headers: csrfHeaders(),
             body: formData,
         }).then(response => {
             if (response.ok) {
@@ -82,21 +110,164 @@ function confirmDeleteUser(id) {
 
 window.confirmDeleteUser = confirmDeleteUser;
 
-function giveInvite(id) {
+function giveInvite(event) {
+    event.preventDefault();
+
+    const formData = new FormData(event.target);
+    if (formData.get('account_type') === 'ADMIN' && !confirm("Everyone registering with this invite code will be an admin. Continue?")) {
+        return;
+    }
+
+    fetch('/api/admin/give_invite_code', {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: formData,
+    }).then(async response => {
+        if (response.ok) {
+            alert('An invite code has been given to the user: ' + await response.text());
+            window.location.reload();
+        } else {
+            alert('Failed to give invite code to user: ' + await response.text());
+        }
+    });
+}
+
+window.giveInvite = giveInvite;
+function setQuota(event) {
+    event.preventDefault();
+
+    fetch('/api/admin/quota', {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: new FormData(event.target),
+    }).then(async response => {
+        if (response.ok) {
+            alert('Quota has been updated.');
+            window.location.reload();
+        } else {
+            alert('Failed to update quota: ' + await response.text());
+        }
+    });
+}
+
+window.setQuota = setQuota;
+
+function suspendAccount(event) {
+    event.preventDefault();
+
+    if (!confirm("Are you sure you want to suspend this user? They will be logged out immediately.")) {
+        return;
+    }
+
+    fetch('/api/admin/suspension', {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: new FormData(event.target),
+    }).then(async response => {
+        if (response.ok) {
+            alert('The user has been suspended.');
+            window.location.reload();
+        } else {
+            alert('Failed to suspend user: ' + await response.text());
+        }
+    });
+}
+
+window.suspendAccount = suspendAccount;
+
+function liftSuspension(id) {
     const formData  = new FormData();
     formData.append('id', id);
 
-    fetch('/api/admin/give_invite_code', {
+    fetch('/api/admin/suspension', {
+        method: 'DELETE',
+        headers: csrfHeaders(),
+        body: formData,
+    }).then(response => {
+        if (response.ok) {
+            alert('The suspension has been lifted.');
+            window.location.reload();
+        } else {
+            alert('Failed to lift suspension.');
+        }
+    });
+}
+
+window.liftSuspension = liftSuspension;
+
+function approveAccount(id) {
+    const formData  = new FormData();
+    formData.append('id', id);
+
+    fetch('/api/admin/approval', {
         method: 'POST',
+        headers: csrfHeaders(),
         body: formData,
     }).then(response => {
         if (response.ok) {
-            alert('An invite code has been given to the user.');
+            alert('The account has been approved, they can log in now.');
+            window.location.reload();
+        } else {
+            alert('Failed to approve account.');
+        }
+    });
+}
+
+window.approveAccount = approveAccount;
+
+function confirmRejectAccount(id) {
+    if (confirm("Are you sure you want to reject this account? It will be deleted, logging in again asks for approval again.")) {
+        const formData  = new FormData();
+        formData.append('id', id);
+
+        fetch('/api/admin/approval', {
+            method: 'DELETE',
+            headers: csrfHeaders(),
+            body: formData,
+        }).then(response => {
+            if (response.ok) {
+                alert('The account has been rejected.');
+                window.location.reload();
+            } else {
+                alert('Failed to reject account.');
+            }
+        });
+    }
+}
+
+window.confirmRejectAccount = confirmRejectAccount;
+
+const accountTypeDescriptions = {
+    ADMIN: "an admin? They will be able to manage every account.",
+    MODERATOR: "a moderator? They will be able to remove anyone's public files.",
+    USER: "a user? They will be able to upload but not manage anything else.",
+    GUEST: "a guest? They will only be able to view files, not upload.",
+};
+
+function setAccountType(id, select) {
+    const accountType = select.value;
+    if (!confirm("Are you sure you want to make this account " + accountTypeDescriptions[accountType])) {
+        select.value = select.dataset.current;
+        return;
+    }
+
+    const formData  = new FormData();
+    formData.append('id', id);
+    formData.append('account_type', accountType);
+
+    fetch('/api/admin/account_type', {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: formData,
+    }).then(async response => {
+        if (response.ok) {
+            alert('The account type has been changed.');
             window.location.reload();
         } else {
-            alert('Failed to give invite code to user.');
+            alert('Failed to change account type: ' + await response.text());
+            select.value = select.dataset.current;
         }
     });
 }
 
-window.giveInvite = giveInvite;
\ No newline at end of file
+window.setAccountType = setAccountType;
@@ -0,0 +1,7 @@
+// Requests that change something need the token of the session, the server
+// puts it in the csrf-token meta tag of every page you're logged in on
+const meta = document.querySelector('meta[name="csrf-token"]');
+
+export function csrfHeaders() {
+    return meta ? { 'X-CSRF-Token': meta.content } : {};
+}
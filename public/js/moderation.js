@@ -0,0 +1,24 @@
+import { csrfHeaders } from './csrf.js';
+
+function removeFile(fileName) {
+    if (!confirm("Are you sure you want to remove this file? It will be deleted for its uploader too.")) {
+        return;
+    }
+
+    const formData  = new FormData();
+    formData.append('file_name', fileName);
+
+    fetch('/api/moderation/file', {
+        method: 'DELETE',
+        headers: csrfHeaders(),
+        body: formData,
+    }).then(async response => {
+        if (response.ok) {
+            window.location.reload();
+        } else {
+            alert('Failed to remove file: ' + await response.text());
+        }
+    });
+}
+
+window.removeFile = removeFile;
@@ -0,0 +1,91 @@
+import { csrfHeaders } from './csrf.js';
+
+// WebAuthn wants ArrayBuffers where the server speaks base64url
+
+function decode(value) {
+    const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+    return Uint8Array.from(atob(base64), c => c.charCodeAt(0)).buffer;
+}
+
+function encode(buffer) {
+    return btoa(String.fromCharCode(...new Uint8Array(buffer)))
+        .replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+function credentialToJSON(credential) {
+    const response = {
+        clientDataJSON: encode(credential.response.clientDataJSON),
+    };
+
+    if (credential.response.attestationObject) {
+        response.attestationObject = encode(credential.response.attestationObject);
+        if (credential.response.getTransports) {
+            response.transports = credential.response.getTransports();
+        }
+    } else {
+        response.authenticatorData = encode(credential.response.authenticatorData);
+        response.signature = encode(credential.response.signature);
+        if (credential.response.userHandle) {
+            response.userHandle = encode(credential.response.userHandle);
+        }
+    }
+
+    return JSON.stringify({
+        id: credential.id,
+        rawId: encode(credential.rawId),
+        type: credential.type,
+        response,
+        clientExtensionResults: credential.getClientExtensionResults(),
+    });
+}
+
+async function begin(url) {
+    const response = await fetch(url, { method: 'POST', headers: csrfHeaders() });
+    if (!response.ok) {
+        throw new Error(await response.text());
+    }
+
+    return (await response.json()).publicKey;
+}
+
+async function finish(url, credential) {
+    const response = await fetch(url, {
+        method: 'POST',
+        headers: { ...csrfHeaders(), 'Content-Type': 'application/json' },
+        body: credentialToJSON(credential),
+    });
+    if (!response.ok) {
+        throw new Error(await response.text());
+    }
+
+    return response;
+}
+
+export async function registerPasskey(nickname) {
+    const options = await begin('/api/account/passkey/begin');
+    options.challenge = decode(options.challenge);
+    options.user.id = decode(options.user.id);
+    for (const excluded of options.excludeCredentials ?? []) {
+        excluded.id = decode(excluded.id);
+    }
+
+    const credential = await navigator.credentials.create({ publicKey: options });
+    await finish('/api/account/passkey/finish?nickname=' + encodeURIComponent(nickname), credential);
+}
+
+export async function loginWithPasskey() {
+    const options = await begin('/api/auth/passkey/begin');
+    options.challenge = decode(options.challenge);
+
+    const credential = await navigator.credentials.get({ publicKey: options });
+    const response = await finish('/api/auth/passkey/finish', credential);
+
+    // Ends up on the gallery, or the two-factor step when it's enabled
+    window.location.href = response.url;
+}
+
+function passkeyLogin() {
+    loginWithPasskey().catch(err => alert('Passkey login failed: ' + err.message));
+}
+
+window.passkeyLogin = passkeyLogin;
@@ -1,9 +1,13 @@
+import { csrfHeaders } from './csrf.js';
+import { registerPasskey } from './passkeys.js';
+
 function confirmDeleteAllFiles() {
     const confirmMessage = "Are you sure you want to delete ALL your files? This action cannot be undone.";
 
     if (confirm(confirmMessage)) {
         fetch('/api/account/files', {
             method: 'DELETE',
+            headers: csrfHeaders(),
         }).then(response => {
             if (response.ok) {
                 alert('All files have been deleted.');
@@ -23,6 +27,7 @@ function confirmDeleteAccount() {
     if (confirm(confirmMessage)) {
         fetch('/api/account', {
             method: 'DELETE',
+            headers: csrfHeaders(),
         }).then(response => {
             if (response.ok) {
                 alert('Your account has been deleted.');
@@ -44,4 +49,186 @@ function confirmUnlink(event) {
     return true;
 }
 
-window.confirmUnlink = confirmUnlink;
\ No newline at end of file
+window.confirmUnlink = confirmUnlink;
+
+function postTOTPForm(event, url) {
+    event.preventDefault();
+
+    return fetch(url, {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: new FormData(event.target),
+    });
+}
+
+function showRecoveryCodes(codes) {
+    document.querySelectorAll('#two-factor .totp-form').forEach(form => form.hidden = true);
+
+    const container = document.getElementById('recovery-codes');
+    container.querySelector('pre').textContent = codes;
+    container.hidden = false;
+}
+
+function enableTOTP(event) {
+    postTOTPForm(event, '/api/account/totp/enable').then(async response => {
+        if (response.ok) {
+            showRecoveryCodes(await response.text());
+        } else {
+            alert('Failed to enable two-factor authentication: ' + await response.text());
+        }
+    });
+}
+
+window.enableTOTP = enableTOTP;
+
+function newRecoveryCodes(event) {
+    if (!confirm("Generate new recovery codes? Your old ones will stop working.")) {
+        event.preventDefault();
+        return;
+    }
+
+    postTOTPForm(event, '/api/account/totp/recovery_codes').then(async response => {
+        if (response.ok) {
+            showRecoveryCodes(await response.text());
+        } else {
+            alert('Failed to generate recovery codes: ' + await response.text());
+        }
+    });
+}
+
+window.newRecoveryCodes = newRecoveryCodes;
+
+function disableTOTP(event) {
+    postTOTPForm(event, '/api/account/totp/disable').then(async response => {
+        if (response.ok) {
+            alert('Two-factor authentication has been disabled.');
+            window.location.reload();
+        } else {
+            alert('Failed to disable two-factor authentication: ' + await response.text());
+        }
+    });
+}
+
+window.disableTOTP = disableTOTP;
+
+function addPasskey(event) {
+    event.preventDefault();
+
+    const nickname = new FormData(event.target).get('nickname');
+    registerPasskey(nickname).then(() => {
+        window.location.reload();
+    }).catch(err => {
+        alert('Failed to add passkey: ' + err.message);
+    });
+}
+
+window.addPasskey = addPasskey;
+
+function deletePasskey(id) {
+    if (!confirm("Remove this passkey? You won't be able to log in with it anymore.")) {
+        return;
+    }
+
+    const body = new FormData();
+    body.append('id', id);
+
+    fetch('/api/account/passkey', {
+        method: 'DELETE',
+        headers: csrfHeaders(),
+        body,
+    }).then(async response => {
+        if (response.ok) {
+            window.location.reload();
+        } else {
+            alert('Failed to remove passkey: ' + await response.text());
+        }
+    });
+}
+
+window.deletePasskey = deletePasskey;
+
+function revokeSession(id, current) {
+    const question = current
+        ? 'Sign out this device?'
+        : 'Sign out this session? That device will have to log in again.';
+    if (!confirm(question)) {
+        return;
+    }
+
+    const body = new FormData();
+    body.append('id', id);
+
+    fetch('/api/account/session', {
+        method: 'DELETE',
+        headers: csrfHeaders(),
+        body,
+    }).then(async response => {
+        if (response.ok) {
+            window.location.reload();
+        } else {
+            alert('Failed to sign out session: ' + await response.text());
+        }
+    });
+}
+
+window.revokeSession = revokeSession;
+
+function revokeOtherSessions() {
+    if (!confirm('Sign out every other device? They will have to log in again.')) {
+        return;
+    }
+
+    fetch('/api/account/sessions', {
+        method: 'DELETE',
+        headers: csrfHeaders(),
+    }).then(async response => {
+        if (response.ok) {
+            window.location.reload();
+        } else {
+            alert('Failed to sign out other sessions: ' + await response.text());
+        }
+    });
+}
+
+window.revokeOtherSessions = revokeOtherSessions;
+
+function setPassword(event) {
+    event.preventDefault();
+
+    fetch('/api/account/password', {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: new FormData(event.target),
+    }).then(async response => {
+        if (response.ok) {
+            alert('Your password has been saved.');
+            window.location.reload();
+        } else {
+            alert('Failed to save password: ' + await response.text());
+        }
+    });
+}
+
+window.setPassword = setPassword;
+
+function removePassword(event) {
+    event.preventDefault();
+
+    if (!confirm("Remove your password? You won't be able to log in with it anymore.")) {
+        return;
+    }
+
+    fetch('/api/account/password', {
+        method: 'DELETE',
+        headers: csrfHeaders(),
+        body: new FormData(event.target),
+    }).then(async response => {
+        if (response.ok) {
+            window.location.reload();
+        } else {
+            alert('Failed to remove password: ' + await response.text());
+        }
+    });
+}
+
+window.removePassword = removePassword;
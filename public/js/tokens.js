@@ -1,3 +1,5 @@
+import { csrfHeaders } from './csrf.js';
+
 const deleteButtons = document.querySelectorAll('.delete-button');
 
 for (let button of deleteButtons) {
@@ -23,6 +25,7 @@ function confirmDeleteInvite(code) {
 
         fetch('/api/account/invite_code', {
             method: 'DELETE',
+            headers: csrfHeaders(),
             body: formData,
         }).then(response => {
             if (response.ok) {
@@ -37,15 +40,16 @@ function confirmDeleteInvite(code) {
 
 window.confirmDeleteInvite = confirmDeleteInvite;
 
-function confirmDeleteUploadToken(token) {
+function confirmDeleteUploadToken(id) {
     const confirmMessage = "Are you sure you want to delete this upload token?";
 
     if (confirm(confirmMessage)) {
         const formData  = new FormData();
-        formData.append('upload_token', token);
+        formData.append('id', id);
 
         fetch('/api/account/upload_token', {
             method: 'DELETE',
+            headers: csrfHeaders(),
             body: formData,
         }).then(response => {
             if (response.ok) {
@@ -58,4 +62,28 @@ function confirmDeleteUploadToken(token) {
     }
 }
 
-window.confirmDeleteUploadToken = confirmDeleteUploadToken;
\ No newline at end of file
+window.confirmDeleteUploadToken = confirmDeleteUploadToken;
+
+function confirmDeleteAccessToken(id) {
+    const confirmMessage = "Are you sure you want to delete this access token?";
+
+    if (confirm(confirmMessage)) {
+        const formData  = new FormData();
+        formData.append('id', id);
+
+        fetch('/api/account/access_token', {
+            method: 'DELETE',
+            headers: csrfHeaders(),
+            body: formData,
+        }).then(response => {
+            if (response.ok) {
+                alert('Access token has been deleted.');
+                window.location.reload();
+            } else {
+                alert('Failed to delete access token.');
+            }
+        });
+    }
+}
+
+window.confirmDeleteAccessToken = confirmDeleteAccessToken;
\ No newline at end of file